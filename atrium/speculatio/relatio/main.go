@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	tccore "github.com/trimble-oss/tierceron-core/v2/core"
+	"github.com/trimble-oss/tierceron/atrium/speculatio/relatio/report"
+	flowcore "github.com/trimble-oss/tierceron/atrium/trcflow/core"
+	"github.com/trimble-oss/tierceron/buildopts"
+	"github.com/trimble-oss/tierceron/buildopts/coreopts"
+	"github.com/trimble-oss/tierceron/pkg/core"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// This executable writes static reports of argosy fleet data flow
+// statistics, the data spiralis and fenestra render, without a desktop.
+// Reports are written as html with svg timelines and as json, which the
+// next run compares against for regressions.
+func main() {
+	buildopts.NewOptionsBuilder(buildopts.LoadOptions())
+	coreopts.NewOptionsBuilder(coreopts.LoadOptions())
+
+	envPtr := flag.String("env", "dev", "Environment of the statistics")
+	insecurePtr := flag.Bool("insecure", false, "By default, every ssl connection this tool makes is verified secure.  This option allows to tool to continue with server connections considered insecure.")
+	logFilePtr := flag.String("log", "./relatio.log", "Output path for log file")
+	directPtr := flag.Bool("direct", false, "Read statistics directly from trcdb rather than the selected statistics store")
+	statsPathPtr := flag.String("statsPath", "", "Read statistics from a local statistics store directory rather than vault")
+	defaultIndexPath, defaultIdName := coreopts.BuildOptions.GetDFSPathName()
+	indexPathPtr := flag.String("indexPath", defaultIndexPath, "Tenant index path of the statistics")
+	idNamePtr := flag.String("idName", defaultIdName, "Tenant id name of the statistics, for statsPath")
+	outPtr := flag.String("out", "./argosyreport", "Directory reports are written to")
+	previousPtr := flag.String("previous", "", "Report to find regressions against (default the last report in out)")
+	slowdownPtr := flag.Float64("slowdown", report.DefaultSlowdown, "Increase in mean time in a state, as a fraction, that is a regression")
+	minimumSlowdownPtr := flag.Float64("minimumSlowdown", report.DefaultMinimumSlowdown, "Increases in mean time in a state of fewer seconds are ignored")
+	failOnRegressionPtr := flag.Bool("failOnRegression", false, "Exit with failure if there are regressions, for CI")
+	flag.Parse()
+
+	if len(*indexPathPtr) == 0 {
+		fmt.Println("indexPath is required")
+		os.Exit(1)
+	}
+
+	f, err := os.OpenFile(*logFilePtr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Log init failure")
+		os.Exit(1)
+	}
+	logger := log.New(f, "[relatio]", log.LstdFlags)
+
+	var fleet *tccore.TTDINode
+	if len(*statsPathPtr) > 0 {
+		fleet, err = localFleet(*statsPathPtr, *indexPathPtr, *idNamePtr, logger)
+	} else {
+		fleet, err = vaultFleet(*envPtr, *insecurePtr, *directPtr, *indexPathPtr, logger)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		logger.Println(err.Error())
+		os.Exit(1)
+	}
+
+	previousPath := *previousPtr
+	if len(previousPath) == 0 {
+		previousPath = filepath.Join(*outPtr, "report.json")
+	}
+	previous, err := report.ReadReport(previousPath)
+	if err != nil {
+		// Report anyway, without regressions.
+		fmt.Println(err.Error())
+		logger.Println(err.Error())
+	}
+
+	argosyReport := report.BuildReport(fleet, *envPtr, previous, report.Options{Slowdown: *slowdownPtr, MinimumSlowdown: *minimumSlowdownPtr})
+	for _, write := range []struct {
+		name  string
+		write func(*report.Report, string) error
+	}{{"report.json", report.WriteJSON}, {"report.html", report.WriteHTML}} {
+		if err := write.write(argosyReport, filepath.Join(*outPtr, write.name)); err != nil {
+			fmt.Println(err.Error())
+			logger.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("Reported %d argosies with %d failure hotspots to %s.\n", len(argosyReport.Argosies), len(argosyReport.Hotspots), *outPtr)
+
+	for _, regression := range argosyReport.Regressions {
+		fmt.Printf("Regression: %s\n", regression)
+	}
+	if *failOnRegressionPtr && len(argosyReport.Regressions) > 0 {
+		os.Exit(1)
+	}
+}
+
+// vaultFleet loads the fleet as spiralis does, from the statistics store
+// selected for env or, if direct, trcdb.
+func vaultFleet(env string, insecure bool, direct bool, indexPath string, logger *log.Logger) (*tccore.TTDINode, error) {
+	driverConfig := &config.DriverConfig{
+		CoreConfig: &core.CoreConfig{
+			ExitOnFailure: true,
+			Insecure:      insecure,
+			Log:           logger,
+		},
+	}
+	secretID := ""
+	appRoleID := ""
+	tokenPtr := new(string)
+	addressPtr := new(string)
+	approleconfig := new(string)
+	empty := ""
+
+	if autoErr := eUtils.AutoAuth(driverConfig, &secretID, &appRoleID, &empty, &tokenPtr, &env, addressPtr, nil, approleconfig, false); autoErr != nil {
+		return nil, autoErr
+	}
+
+	mod, modErr := helperkv.NewModifier(insecure, driverConfig.CoreConfig.TokenCache.GetToken(fmt.Sprintf("config_token_%s_protected", driverConfig.CoreConfig.EnvBasis)), addressPtr, env, nil, true, logger)
+	if modErr != nil {
+		return nil, modErr
+	}
+	defer mod.Release()
+	mod.Direct = direct
+	mod.Env = env
+	logger.Printf("Building fleet.\n")
+	return flowcore.InitArgosyFleet(mod, indexPath, logger)
+}
+
+// localFleet loads the fleet from a local statistics store directory, such
+// as one copied into a CI job.
+func localFleet(statsPath string, indexPath string, idName string, logger *log.Logger) (*tccore.TTDINode, error) {
+	store, err := flowcore.NewLocalStatisticsStore(statsPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close()
+	records, err := store.List(indexPath, idName)
+	if err != nil {
+		return nil, err
+	}
+	return flowcore.ArgosyFleetFromStatistics(indexPath, nil, records, logger), nil
+}
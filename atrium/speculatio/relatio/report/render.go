@@ -0,0 +1,156 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Timeline layout, in svg pixels.
+const (
+	timelineWidth     = 720
+	timelineLabel     = 240
+	timelineRowHeight = 18
+)
+
+var stateColors = []string{"#4e79a7", "#59a14f", "#edc948", "#b07aa1", "#76b7b2", "#ff9da7", "#9c755f", "#bab0ac"}
+
+const failureColor = "#e15759"
+
+// WriteJSON writes report as indented json to path.
+func WriteJSON(report *Report, path string) error {
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, encoded)
+}
+
+// WriteHTML writes report as a single html page, with svg timelines, to
+// path.  The page has no external resources so it can be attached to
+// tickets.
+func WriteHTML(report *Report, path string) error {
+	var rendered bytes.Buffer
+	if err := RenderHTML(report, &rendered); err != nil {
+		return err
+	}
+	return writeFile(path, rendered.Bytes())
+}
+
+// RenderHTML renders report as html to writer.
+func RenderHTML(report *Report, writer io.Writer) error {
+	return reportTemplate.Execute(writer, report)
+}
+
+func writeFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+type timelineBar struct {
+	X, Y, Width float64
+	Color       string
+	Title       string
+}
+
+type timelineRow struct {
+	Y     float64
+	Label string
+}
+
+type timelineSvg struct {
+	Width, Height float64
+	Rows          []timelineRow
+	Bars          []timelineBar
+}
+
+// timeline lays out the flows of an argosId as rows of state bars, on a
+// scale shared by every flow of the argosId.
+func timeline(argosy *ArgosyReport) timelineSvg {
+	maxElapsed := 0.0
+	for _, flow := range argosy.Flows {
+		if flow.Elapsed > maxElapsed {
+			maxElapsed = flow.Elapsed
+		}
+	}
+	scale := 0.0
+	if maxElapsed > 0 {
+		scale = (timelineWidth - timelineLabel) / maxElapsed
+	}
+	svg := timelineSvg{Width: timelineWidth, Height: float64(len(argosy.Flows)) * timelineRowHeight}
+	for i, flow := range argosy.Flows {
+		y := float64(i) * timelineRowHeight
+		svg.Rows = append(svg.Rows, timelineRow{Y: y + timelineRowHeight*0.7, Label: flow.FlowGroup + "/" + flow.FlowName})
+		for j, state := range flow.States {
+			color := stateColors[j%len(stateColors)]
+			if state.Failed {
+				color = failureColor
+			}
+			width := state.Duration * scale
+			if width < 2 {
+				// Keep the last and instant states visible.
+				width = 2
+			}
+			svg.Bars = append(svg.Bars, timelineBar{
+				X:     timelineLabel + state.Start*scale,
+				Y:     y + 2,
+				Width: width,
+				Color: color,
+				Title: fmt.Sprintf("%s (%s) at %.2fs for %.2fs", state.StateName, state.StateCode, state.Start, state.Duration),
+			})
+		}
+	}
+	return svg
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"timeline": timeline,
+	"seconds":  func(seconds float64) string { return fmt.Sprintf("%.2fs", seconds) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Argosy report {{.Project}} {{.Env}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.failed { color: #e15759; }
+svg text { font-size: 11px; }
+</style>
+</head>
+<body>
+<h1>Argosy report {{.Project}} {{.Env}}</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}{{if .Previous}}, compared with {{.Previous.Format "2006-01-02 15:04:05 MST"}}{{end}}.</p>
+
+<h2>Regressions</h2>
+{{if .Regressions}}<ul>{{range .Regressions}}<li class="failed">{{.String}}</li>{{end}}</ul>{{else}}<p>None.</p>{{end}}
+
+<h2>Failure hotspots</h2>
+{{if .Hotspots}}<table>
+<tr><th>Flow group</th><th>Flow</th><th>State</th><th>Failures</th><th>ArgosIds</th></tr>
+{{range .Hotspots}}<tr><td>{{.FlowGroup}}</td><td>{{.Flow}}</td><td>{{.StateName}}</td><td>{{.Failures}}</td><td>{{range $i, $id := .ArgosIds}}{{if $i}}, {{end}}{{$id}}{{end}}</td></tr>
+{{end}}</table>{{else}}<p>None.</p>{{end}}
+
+<h2>Mean time in state</h2>
+<table>
+<tr><th>Flow group</th><th>Flow</th><th>State</th><th>Mean</th><th>Samples</th></tr>
+{{range .StateTimes}}<tr><td>{{.FlowGroup}}</td><td>{{.Flow}}</td><td>{{.StateName}} ({{.StateCode}})</td><td>{{seconds .Mean}}</td><td>{{.Samples}}</td></tr>
+{{end}}</table>
+
+<h2>Timelines</h2>
+{{range .Argosies}}<h3>{{.ArgosId}}</h3>
+{{if .Flows}}{{with timeline .}}<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}">
+{{range .Rows}}<text x="0" y="{{.Y}}">{{.Label}}</text>
+{{end}}{{range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="14" fill="{{.Color}}"><title>{{.Title}}</title></rect>
+{{end}}</svg>{{end}}{{else}}<p>No statistics.</p>{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,370 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	tccore "github.com/trimble-oss/tierceron-core/v2/core"
+)
+
+// FAILURE_MODE is the statistic mode of a flow state reached on failure.
+const FAILURE_MODE = 2
+
+// DefaultSlowdown is the increase in mean time in a state, as a fraction,
+// reported as a regression.
+const DefaultSlowdown = 0.25
+
+// DefaultMinimumSlowdown ignores slowdowns of states taking less than this
+// many seconds more, which are noise.
+const DefaultMinimumSlowdown = 1.0
+
+// Report summarizes the data flow statistics of an argosy fleet.
+type Report struct {
+	Project     string          `json:"project"`
+	Env         string          `json:"env"`
+	Generated   time.Time       `json:"generated"`
+	Argosies    []*ArgosyReport `json:"argosies"`
+	Hotspots    []*Hotspot      `json:"hotspots"`
+	StateTimes  []*StateTime    `json:"stateTimes"`
+	Regressions []*Regression   `json:"regressions"`
+	Previous    *time.Time      `json:"previous,omitempty"`
+}
+
+// ArgosyReport holds the flow timelines of an argosId.
+type ArgosyReport struct {
+	ArgosId string          `json:"argosId"`
+	Flows   []*FlowTimeline `json:"flows"`
+}
+
+// FlowTimeline is the states a flow passed through, in order.
+type FlowTimeline struct {
+	FlowGroup      string       `json:"flowGroup"`
+	FlowName       string       `json:"flowName"`
+	LastTestedDate string       `json:"lastTestedDate"`
+	Elapsed        float64      `json:"elapsed"` // Seconds until the last state.
+	Failed         bool         `json:"failed"`
+	States         []*StateSpan `json:"states"`
+}
+
+// StateSpan is a flow state, starting Start seconds into the flow and
+// lasting Duration seconds until the next state.  The last state of a flow
+// has no duration.
+type StateSpan struct {
+	StateCode string  `json:"stateCode"`
+	StateName string  `json:"stateName"`
+	Mode      int     `json:"mode"`
+	Start     float64 `json:"start"`
+	Duration  float64 `json:"duration"`
+	Failed    bool    `json:"failed"`
+}
+
+// Hotspot counts the failures of a flow state across argosIds.
+type Hotspot struct {
+	FlowGroup string   `json:"flowGroup"`
+	Flow      string   `json:"flow"`
+	StateName string   `json:"stateName"`
+	Failures  int      `json:"failures"`
+	ArgosIds  []string `json:"argosIds"`
+}
+
+// StateTime is the mean time spent in a flow state across argosIds.
+type StateTime struct {
+	FlowGroup string  `json:"flowGroup"`
+	Flow      string  `json:"flow"`
+	StateCode string  `json:"stateCode"`
+	StateName string  `json:"stateName"`
+	Mean      float64 `json:"mean"`
+	Samples   int     `json:"samples"`
+}
+
+// Kinds of regressions.
+const (
+	REGRESSION_FAILURE  = "failure"  // A flow that didn't fail last run does.
+	REGRESSION_SLOWDOWN = "slowdown" // The mean time in a state went up.
+)
+
+// Regression is a change for the worse since the previous report.
+type Regression struct {
+	Kind      string  `json:"kind"`
+	ArgosId   string  `json:"argosId,omitempty"`
+	FlowGroup string  `json:"flowGroup"`
+	Flow      string  `json:"flow"`
+	StateName string  `json:"stateName"`
+	Previous  float64 `json:"previous,omitempty"`
+	Current   float64 `json:"current,omitempty"`
+}
+
+func (r *Regression) String() string {
+	if r.Kind == REGRESSION_FAILURE {
+		return fmt.Sprintf("%s/%s/%s now fails in %s", r.ArgosId, r.FlowGroup, r.Flow, r.StateName)
+	}
+	return fmt.Sprintf("%s/%s %s mean time went from %.2fs to %.2fs", r.FlowGroup, r.Flow, r.StateName, r.Previous, r.Current)
+}
+
+// Options tune which changes are regressions.
+type Options struct {
+	Slowdown        float64 // Fractional increase in mean time in a state.
+	MinimumSlowdown float64 // Seconds, smaller increases are ignored.
+}
+
+// FlowKind returns the kind of a flow, dropping the id of dashed flow names
+// such as login-<id>, so flows are compared across argosIds.
+func FlowKind(flowName string) string {
+	flowKind, _, _ := strings.Cut(flowName, "-")
+	return flowKind
+}
+
+// IsFailure returns true if a statistic records a failure.  Flows report
+// failures with mode 2 or a Failure state.
+func IsFailure(dsc *tccore.DeliverStatCtx) bool {
+	return dsc.GetModeInt() == FAILURE_MODE || strings.Contains(dsc.StateName, "Failure")
+}
+
+// BuildReport builds a report of fleet as loaded by InitArgosyFleet,
+// comparing it with previous, if any.
+func BuildReport(fleet *tccore.TTDINode, env string, previous *Report, options Options) *Report {
+	report := &Report{
+		Env:         env,
+		Generated:   time.Now().UTC(),
+		Argosies:    []*ArgosyReport{},
+		Hotspots:    []*Hotspot{},
+		StateTimes:  []*StateTime{},
+		Regressions: []*Regression{},
+	}
+	if fleet == nil {
+		return report
+	}
+	if fleet.MashupDetailedElement != nil {
+		report.Project = fleet.MashupDetailedElement.Name
+	}
+	for _, argosy := range fleet.ChildNodes {
+		argosyReport := &ArgosyReport{ArgosId: nodeName(argosy), Flows: []*FlowTimeline{}}
+		collectFlows(argosy, argosyReport)
+		sort.SliceStable(argosyReport.Flows, func(i, j int) bool {
+			a, b := argosyReport.Flows[i], argosyReport.Flows[j]
+			if a.FlowGroup != b.FlowGroup {
+				return a.FlowGroup < b.FlowGroup
+			}
+			return a.FlowName < b.FlowName
+		})
+		report.Argosies = append(report.Argosies, argosyReport)
+	}
+	report.Hotspots = hotspots(report.Argosies)
+	report.StateTimes = stateTimes(report.Argosies)
+	if previous != nil {
+		previousGenerated := previous.Generated
+		report.Previous = &previousGenerated
+		report.Regressions = regressions(previous, report, options)
+	}
+	return report
+}
+
+func nodeName(node *tccore.TTDINode) string {
+	if node.MashupDetailedElement == nil {
+		return ""
+	}
+	return node.MashupDetailedElement.Name
+}
+
+// collectFlows adds a timeline for every node under node holding
+// statistics.
+func collectFlows(node *tccore.TTDINode, argosyReport *ArgosyReport) {
+	var statistics []*tccore.DeliverStatCtx
+	for _, child := range node.ChildNodes {
+		if len(child.ChildNodes) > 0 {
+			collectFlows(child, argosyReport)
+			continue
+		}
+		if child.MashupDetailedElement == nil || len(child.MashupDetailedElement.Data) == 0 {
+			continue
+		}
+		dsc, _, err := child.GetDeliverStatCtx()
+		if err != nil || len(dsc.FlowName) == 0 || len(dsc.StateCode) == 0 {
+			// Not a statistic, such as a flow without any yet.
+			continue
+		}
+		statistics = append(statistics, dsc)
+	}
+	if len(statistics) > 0 {
+		argosyReport.Flows = append(argosyReport.Flows, newFlowTimeline(statistics))
+	}
+}
+
+// timeSplit returns the seconds into the flow a state was reached.  Loaded
+// statistics hold a time.Duration, which decodes as nanoseconds.
+func timeSplit(dsc *tccore.DeliverStatCtx) float64 {
+	switch split := dsc.TimeSplit.(type) {
+	case time.Duration:
+		return split.Seconds()
+	case float64:
+		return time.Duration(split).Seconds()
+	}
+	return 0
+}
+
+func newFlowTimeline(statistics []*tccore.DeliverStatCtx) *FlowTimeline {
+	sort.SliceStable(statistics, func(i, j int) bool {
+		if timeSplit(statistics[i]) != timeSplit(statistics[j]) {
+			return timeSplit(statistics[i]) < timeSplit(statistics[j])
+		}
+		return statistics[i].StateCode < statistics[j].StateCode
+	})
+	flow := &FlowTimeline{
+		FlowGroup: statistics[0].FlowGroup,
+		FlowName:  statistics[0].FlowName,
+		States:    []*StateSpan{},
+	}
+	for i, dsc := range statistics {
+		state := &StateSpan{
+			StateCode: dsc.StateCode,
+			StateName: dsc.StateName,
+			Mode:      dsc.GetModeInt(),
+			Start:     timeSplit(dsc),
+			Failed:    IsFailure(dsc),
+		}
+		if i < len(statistics)-1 {
+			state.Duration = timeSplit(statistics[i+1]) - state.Start
+		}
+		flow.Failed = flow.Failed || state.Failed
+		flow.Elapsed = state.Start
+		if lastTestedDate, ok := dsc.LastTestedDate.(string); ok && lastTestedDate > flow.LastTestedDate {
+			flow.LastTestedDate = lastTestedDate
+		}
+		flow.States = append(flow.States, state)
+	}
+	return flow
+}
+
+func hotspots(argosies []*ArgosyReport) []*Hotspot {
+	hotspotMap := map[string]*Hotspot{}
+	result := []*Hotspot{}
+	for _, argosy := range argosies {
+		for _, flow := range argosy.Flows {
+			for _, state := range flow.States {
+				if !state.Failed {
+					continue
+				}
+				key := strings.Join([]string{flow.FlowGroup, FlowKind(flow.FlowName), state.StateName}, "/")
+				hotspot, ok := hotspotMap[key]
+				if !ok {
+					hotspot = &Hotspot{FlowGroup: flow.FlowGroup, Flow: FlowKind(flow.FlowName), StateName: state.StateName, ArgosIds: []string{}}
+					hotspotMap[key] = hotspot
+					result = append(result, hotspot)
+				}
+				hotspot.Failures++
+				if len(hotspot.ArgosIds) == 0 || hotspot.ArgosIds[len(hotspot.ArgosIds)-1] != argosy.ArgosId {
+					hotspot.ArgosIds = append(hotspot.ArgosIds, argosy.ArgosId)
+				}
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Failures > result[j].Failures
+	})
+	return result
+}
+
+func stateTimeKey(flowGroup string, flow string, stateCode string) string {
+	return strings.Join([]string{flowGroup, flow, stateCode}, "/")
+}
+
+func stateTimes(argosies []*ArgosyReport) []*StateTime {
+	stateTimeMap := map[string]*StateTime{}
+	result := []*StateTime{}
+	for _, argosy := range argosies {
+		for _, flow := range argosy.Flows {
+			// The last state has no time spent in it.
+			for _, state := range flow.States[:len(flow.States)-1] {
+				key := stateTimeKey(flow.FlowGroup, FlowKind(flow.FlowName), state.StateCode)
+				stateTime, ok := stateTimeMap[key]
+				if !ok {
+					stateTime = &StateTime{FlowGroup: flow.FlowGroup, Flow: FlowKind(flow.FlowName), StateCode: state.StateCode, StateName: state.StateName}
+					stateTimeMap[key] = stateTime
+					result = append(result, stateTime)
+				}
+				// Running mean.
+				stateTime.Samples++
+				stateTime.Mean += (state.Duration - stateTime.Mean) / float64(stateTime.Samples)
+			}
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return stateTimeKey(result[i].FlowGroup, result[i].Flow, result[i].StateCode) < stateTimeKey(result[j].FlowGroup, result[j].Flow, result[j].StateCode)
+	})
+	return result
+}
+
+func regressions(previous *Report, current *Report, options Options) []*Regression {
+	if options.Slowdown <= 0 {
+		options.Slowdown = DefaultSlowdown
+	}
+	result := []*Regression{}
+
+	previouslyFailed := map[string]bool{}
+	for _, argosy := range previous.Argosies {
+		for _, flow := range argosy.Flows {
+			previouslyFailed[strings.Join([]string{argosy.ArgosId, flow.FlowGroup, flow.FlowName}, "/")] = flow.Failed
+		}
+	}
+	for _, argosy := range current.Argosies {
+		for _, flow := range argosy.Flows {
+			if !flow.Failed {
+				continue
+			}
+			if failed, ok := previouslyFailed[strings.Join([]string{argosy.ArgosId, flow.FlowGroup, flow.FlowName}, "/")]; ok && !failed {
+				regression := &Regression{Kind: REGRESSION_FAILURE, ArgosId: argosy.ArgosId, FlowGroup: flow.FlowGroup, Flow: flow.FlowName}
+				for _, state := range flow.States {
+					if state.Failed {
+						regression.StateName = state.StateName
+						break
+					}
+				}
+				result = append(result, regression)
+			}
+		}
+	}
+
+	previousStateTimes := map[string]*StateTime{}
+	for _, stateTime := range previous.StateTimes {
+		previousStateTimes[stateTimeKey(stateTime.FlowGroup, stateTime.Flow, stateTime.StateCode)] = stateTime
+	}
+	for _, stateTime := range current.StateTimes {
+		previousStateTime, ok := previousStateTimes[stateTimeKey(stateTime.FlowGroup, stateTime.Flow, stateTime.StateCode)]
+		if !ok {
+			continue
+		}
+		increase := stateTime.Mean - previousStateTime.Mean
+		if increase > options.MinimumSlowdown && increase > previousStateTime.Mean*options.Slowdown {
+			result = append(result, &Regression{
+				Kind:      REGRESSION_SLOWDOWN,
+				FlowGroup: stateTime.FlowGroup,
+				Flow:      stateTime.Flow,
+				StateName: stateTime.StateName,
+				Previous:  previousStateTime.Mean,
+				Current:   stateTime.Mean,
+			})
+		}
+	}
+	return result
+}
+
+// ReadReport reads a json report written by WriteJSON.  A missing report,
+// as on a first run, is not an error and returns nil.
+func ReadReport(path string) (*Report, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var report Report
+	if err := json.Unmarshal(encoded, &report); err != nil {
+		return nil, fmt.Errorf("invalid report %s: %v", path, err)
+	}
+	return &report, nil
+}
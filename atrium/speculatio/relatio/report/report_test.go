@@ -0,0 +1,84 @@
+package report
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tccore "github.com/trimble-oss/tierceron-core/v2/core"
+	"github.com/trimble-oss/tierceron-nute/mashupsdk"
+)
+
+func testFleet(loadingTime string, failArgosId string) *tccore.TTDINode {
+	fleet := &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{Name: "Index"}}
+	for _, argosId := range []string{"argos1", "argos2"} {
+		flow := &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{Name: "login-" + argosId}}
+		states := []map[string]interface{}{
+			{"stateCode": "1", "stateName": "Start", "timeSplit": "0s", "mode": "1"},
+			{"stateCode": "2", "stateName": "Loading", "timeSplit": "1s", "mode": "1"},
+			{"stateCode": "3", "stateName": "Complete", "timeSplit": loadingTime, "mode": "1"},
+		}
+		if argosId == failArgosId {
+			states[2]["stateName"] = "Loading Failure"
+			states[2]["mode"] = "2"
+		}
+		for _, state := range states {
+			state["flowGroup"] = "System"
+			state["flowName"] = "login-" + argosId
+			state["lastTestedDate"] = "2024-01-02T03:04:05Z"
+			statistic := tccore.InitDataFlow(nil, "login-"+argosId, false)
+			statistic.MapStatistic(state, nil)
+			flow.ChildNodes = append(flow.ChildNodes, statistic)
+		}
+		// Reverse, as stored statistics are in no particular order.
+		flow.ChildNodes[0], flow.ChildNodes[2] = flow.ChildNodes[2], flow.ChildNodes[0]
+		flowGroup := &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{Name: "System"}, ChildNodes: []*tccore.TTDINode{
+			{MashupDetailedElement: &mashupsdk.MashupDetailedElement{Name: "login"}, ChildNodes: []*tccore.TTDINode{flow}},
+		}}
+		fleet.ChildNodes = append(fleet.ChildNodes, &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{Name: argosId}, ChildNodes: []*tccore.TTDINode{flowGroup}})
+	}
+	fleet.ChildNodes = append(fleet.ChildNodes, &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{Name: "argos3"}})
+	return fleet
+}
+
+func TestBuildReport(t *testing.T) {
+	previous := BuildReport(testFleet("3s", ""), "dev", nil, Options{})
+	if len(previous.Argosies) != 3 || len(previous.Argosies[2].Flows) != 0 || len(previous.Hotspots) != 0 {
+		t.Fatalf("Unexpected report %v", previous)
+	}
+	flow := previous.Argosies[0].Flows[0]
+	if flow.FlowName != "login-argos1" || len(flow.States) != 3 || flow.States[0].StateName != "Start" || flow.Elapsed != 3 || flow.Failed || flow.States[0].Mode != 1 || flow.LastTestedDate != "2024-01-02T03:04:05Z" {
+		t.Fatalf("Unexpected timeline %v", flow)
+	}
+	if len(previous.StateTimes) != 2 || previous.StateTimes[1].Flow != "login" || previous.StateTimes[1].Mean != 2 || previous.StateTimes[1].Samples != 2 {
+		t.Fatalf("Unexpected state times %v", previous.StateTimes)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := WriteJSON(previous, path); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := ReadReport(path)
+	if err != nil || previous == nil {
+		t.Fatalf("Expected to read the report back %v", err)
+	}
+
+	current := BuildReport(testFleet("9s", "argos2"), "dev", previous, Options{})
+	if len(current.Hotspots) != 1 || current.Hotspots[0].StateName != "Loading Failure" || current.Hotspots[0].ArgosIds[0] != "argos2" || current.Argosies[1].Flows[0].States[2].Mode != FAILURE_MODE {
+		t.Fatalf("Unexpected hotspots %v", current.Hotspots)
+	}
+	if len(current.Regressions) != 2 || current.Regressions[0].Kind != REGRESSION_FAILURE || current.Regressions[1].Kind != REGRESSION_SLOWDOWN || current.Regressions[1].Current != 8 {
+		t.Fatalf("Unexpected regressions %v", current.Regressions)
+	}
+
+	var rendered bytes.Buffer
+	if err := RenderHTML(current, &rendered); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"<svg", "login-argos2", "now fails in Loading Failure", failureColor} {
+		if !strings.Contains(rendered.String(), expected) {
+			t.Fatalf("Expected %s in html", expected)
+		}
+	}
+}
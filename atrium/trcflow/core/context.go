@@ -27,6 +27,7 @@ import (
 	trcengine "github.com/trimble-oss/tierceron/atrium/trcdb/engine"
 	trcdbutil "github.com/trimble-oss/tierceron/pkg/core/dbutil"
 	trcvutils "github.com/trimble-oss/tierceron/pkg/core/util"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
 	"github.com/trimble-oss/tierceron/pkg/trcx/extract"
 	sys "github.com/trimble-oss/tierceron/pkg/vaulthelper/system"
 
@@ -40,6 +41,7 @@ import (
 
 	sqlememory "github.com/dolthub/go-mysql-server/memory"
 	tccore "github.com/trimble-oss/tierceron-core/v2/core"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type FlowType int64
@@ -436,8 +438,9 @@ func (tfmContext *TrcFlowMachineContext) seedVaultCycle(tfContext *TrcFlowContex
 			eUtils.LogErrorMessage(tfmContext.DriverConfig.CoreConfig, "Receiving shutdown presumably from vault.", true)
 			os.Exit(0)
 		case <-flowChangedChannel.Ch:
-			tfmContext.vaultPersistPushRemoteChanges(
+			tfmContext.syncCycle(
 				tfContext,
+				"changed",
 				identityColumnName,
 				indexColumnNames,
 				mysqlPushEnabled,
@@ -446,8 +449,9 @@ func (tfmContext *TrcFlowMachineContext) seedVaultCycle(tfContext *TrcFlowContex
 			flowChangedChannel.Clear()
 		case <-tfContext.Context.Done():
 			tfmContext.Log(fmt.Sprintf("Flow shutdown: %s", tfContext.Flow), nil)
-			tfmContext.vaultPersistPushRemoteChanges(
+			tfmContext.syncCycle(
 				tfContext,
+				"shutdown",
 				identityColumnName,
 				indexColumnNames,
 				mysqlPushEnabled,
@@ -470,6 +474,29 @@ func (tfmContext *TrcFlowMachineContext) seedVaultCycle(tfContext *TrcFlowContex
 	}
 }
 
+// syncCycle pushes a flow's changes to vault and remote data sources,
+// tracing the cycle.
+func (tfmContext *TrcFlowMachineContext) syncCycle(tfContext *TrcFlowContext,
+	trigger string,
+	identityColumnName string,
+	indexColumnNames interface{},
+	mysqlPushEnabled bool,
+	getIndexedPathExt func(engine interface{}, rowDataMap map[string]interface{}, indexColumnNames interface{}, databaseName string, tableName string, dbCallBack func(interface{}, map[string]interface{}) (string, []string, [][]interface{}, error)) (string, error),
+	flowPushRemote func(*TrcFlowContext, map[string]interface{}, map[string]interface{}, []string) error) {
+	_, span := telemetry.Start(context.Background(), "flow.sync.cycle",
+		attribute.String("trc.flow", tfContext.Flow.TableName()),
+		attribute.String("trc.flow.trigger", trigger),
+		attribute.Bool("trc.flow.remotePush", flowPushRemote != nil))
+	err := tfmContext.vaultPersistPushRemoteChanges(
+		tfContext,
+		identityColumnName,
+		indexColumnNames,
+		mysqlPushEnabled,
+		getIndexedPathExt,
+		flowPushRemote)
+	telemetry.End(span, err)
+}
+
 // Seeds TrcDb from vault...  useful during init.
 func (tfmContext *TrcFlowMachineContext) seedTrcDbCycle(tfContext *TrcFlowContext,
 	identityColumnName string,
@@ -609,12 +636,16 @@ func (tfmContext *TrcFlowMachineContext) SyncTableCycle(tfContext *TrcFlowContex
 	if _, ok := tfContext.RemoteDataSource["connection"].(*sql.DB); !ok {
 		flowPushRemote = nil
 	}
+	_, seedSpan := telemetry.Start(context.Background(), "flow.sync.seed",
+		attribute.String("trc.flow", tfContext.Flow.TableName()),
+		attribute.Bool("trc.flow.restart", tfContext.Restart))
 	if !tfContext.Restart {
 		go tfmContext.seedTrcDbCycle(tfContext, identityColumnName, indexColumnNames, getIndexedPathExt, flowPushRemote, true, seedInitComplete)
 	} else {
 		seedInitComplete <- true
 	}
 	<-seedInitComplete
+	seedSpan.End()
 	if tfContext.Init && tfContext.Flow.TableName() != "TierceronFlow" {
 		if tfContext.FlowState.FlowAlias != "" {
 			df.UpdateDataFlowStatistic("Flows", tfContext.FlowState.FlowAlias, "Load complete", "2", 1, tfmContext.Log)
@@ -266,6 +266,10 @@ func (tfmContext *TrcFlowMachineContext) vaultPersistPushRemoteChanges(
 		}
 	}
 
+	if tfContext.GoMod != nil {
+		tfContext.GoMod.AuditReason = "flow " + tfContext.Flow.TableName()
+	}
+
 	for _, changedEntry := range matrixChangedEntries {
 		var changedTableQuery string
 		var changedId interface{}
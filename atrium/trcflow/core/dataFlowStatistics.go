@@ -1,12 +1,15 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 
 	//"os"
 
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/trimble-oss/tierceron/pkg/utils/config"
@@ -15,6 +18,7 @@ import (
 	"github.com/trimble-oss/tierceron/buildopts"
 	"github.com/trimble-oss/tierceron/buildopts/coreopts"
 	"github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
 	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
 
 	"time"
@@ -24,6 +28,7 @@ import (
 	dfssql "github.com/trimble-oss/tierceron/atrium/vestibulum/trcflow/flows/flowsql"
 
 	"github.com/trimble-oss/tierceron-nute/mashupsdk"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var PUBLIC_INDEX_BASIS_PATH string = "super-secrets/PublicIndex/%s"
@@ -37,6 +42,16 @@ func InitArgosyFleet(mod *kv.Modifier, project string, logger *log.Logger) (*tcc
 	aFleet.MashupDetailedElement = &mashupsdk.MashupDetailedElement{}
 	aFleet.MashupDetailedElement.Name = project
 	aFleet.ChildNodes = make([]*tccore.TTDINode, 0)
+	if !mod.Direct {
+		if store := GetStatisticsStore(mod, logger); store.Name() != STATISTICS_STORE_VAULT {
+			_, idName := coreopts.BuildOptions.GetDFSPathName()
+			records, err := store.List(project, idName)
+			if err != nil {
+				return &aFleet, err
+			}
+			return ArgosyFleetFromStatistics(project, nil, records, logger), nil
+		}
+	}
 	idNameListData, serviceListErr := mod.List(fmt.Sprintf(PUBLIC_INDEX_BASIS_PATH, project), logger)
 	if serviceListErr != nil || idNameListData == nil {
 		return &aFleet, serviceListErr
@@ -100,23 +115,15 @@ func InitArgosyFleet(mod *kv.Modifier, project string, logger *log.Logger) (*tcc
 					}
 					defer rows.Close()
 
-					argosyMap := map[string]*tccore.TTDINode{}
-
+					argosIds := []string{}
 					for _, idList := range idListData.Data {
 						for _, id := range idList.([]interface{}) {
-							argosId := strings.Trim(id.(string), "/")
-							argosNode := &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
-							argosNode.MashupDetailedElement = &mashupsdk.MashupDetailedElement{}
-							argosNode.MashupDetailedElement.Name = argosId
-							argosNode.ChildNodes = make([]*tccore.TTDINode, 0)
-							argosyMap[argosId] = argosNode
+							argosIds = append(argosIds, strings.Trim(id.(string), "/"))
 						}
 					}
-					i := 1
 
+					records := []*StatisticRecord{}
 					for rows.Next() {
-						fmt.Printf("%d\n", i)
-						i = i + 1
 						var flowName, argosId, flowGroup, mode, stateCode, stateName, timeSplit, lastTestedDate string
 						rows.Scan(&flowName, &argosId, &flowGroup, &mode, &stateCode, &stateName, &timeSplit, &lastTestedDate)
 
@@ -128,92 +135,9 @@ func InitArgosyFleet(mod *kv.Modifier, project string, logger *log.Logger) (*tcc
 						data["mode"] = mode
 						data["timeSplit"] = timeSplit
 						data["lastTestedDate"] = lastTestedDate
-
-						argosNode := argosyMap[argosId]
-						if argosNode == nil {
-							newArgosNode := tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
-							argosNode = &newArgosNode
-							newArgosNode.MashupDetailedElement.Name = argosId
-							argosyMap[argosId] = argosNode
-						}
-
-						var argosDfGroup *tccore.TTDINode
-						for i := 0; i < len(argosNode.ChildNodes); i++ {
-							if argosNode.ChildNodes[i].Name == flowGroup {
-								argosDfGroup = argosNode.ChildNodes[i]
-								break
-							}
-						}
-						if argosDfGroup == nil {
-							newArgosDfGroup := tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
-							newArgosDfGroup.MashupDetailedElement.Name = flowGroup
-							argosNode.ChildNodes = append(argosNode.ChildNodes, &newArgosDfGroup)
-							argosDfGroup = argosNode.ChildNodes[len(argosNode.ChildNodes)-1]
-						}
-
-						if strings.Contains(flowName, "-") {
-							dashNameSplit := strings.Split(flowName, "-")
-							statisticType := dashNameSplit[0] //login
-							//statisticID := dashNameSplit[1]   //audguasdfniuasfd-gnasdfkj
-							var dfStatTypeNode *tccore.TTDINode
-							for i := 0; i < len(argosDfGroup.ChildNodes); i++ {
-								if argosDfGroup.ChildNodes[i].Name == statisticType {
-									dfStatTypeNode = argosDfGroup.ChildNodes[i]
-									break
-								}
-							}
-							if dfStatTypeNode == nil {
-								newDfStatTypeNode := tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
-
-								newDfStatTypeNode.MashupDetailedElement.Name = statisticType
-								argosDfGroup.ChildNodes = append(argosDfGroup.ChildNodes, &newDfStatTypeNode)
-								dfStatTypeNode = argosDfGroup.ChildNodes[len(argosDfGroup.ChildNodes)-1]
-							}
-
-							var dfStatNameTypeNode *tccore.TTDINode
-							for i := 0; i < len(dfStatTypeNode.ChildNodes); i++ {
-								if (*dfStatTypeNode.ChildNodes[i]).Name == flowName {
-									dfStatNameTypeNode = dfStatTypeNode.ChildNodes[i]
-									break
-								}
-							}
-							if dfStatNameTypeNode == nil {
-								newDfStatNameTypeNode := tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
-								newDfStatNameTypeNode.MashupDetailedElement.Name = flowName
-								dfStatTypeNode.ChildNodes = append(dfStatTypeNode.ChildNodes, &newDfStatNameTypeNode)
-								dfStatNameTypeNode = dfStatTypeNode.ChildNodes[len(dfStatTypeNode.ChildNodes)-1]
-							}
-
-							// Always append this remaining flow...
-							dfStatisticNode := tccore.InitDataFlow(nil, flowName, false)
-							dfStatisticNode.MapStatistic(data, logger)
-
-							dfStatNameTypeNode.ChildNodes = append(dfStatNameTypeNode.ChildNodes, dfStatisticNode)
-
-						} else {
-							var dfStatTypeNode *tccore.TTDINode
-							for i := 0; i < len(argosDfGroup.ChildNodes); i++ {
-								if argosDfGroup.ChildNodes[i].Name == flowName {
-									dfStatTypeNode = argosDfGroup.ChildNodes[i]
-									break
-								}
-							}
-							if dfStatTypeNode == nil {
-								newDfStatTypeNode := tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
-								newDfStatTypeNode.MashupDetailedElement.Name = flowName
-								argosDfGroup.ChildNodes = append(argosDfGroup.ChildNodes, &newDfStatTypeNode)
-								dfStatTypeNode = argosDfGroup.ChildNodes[len(argosDfGroup.ChildNodes)-1]
-							}
-
-							dfStatisticNode := tccore.InitDataFlow(nil, flowName, false)
-							dfStatisticNode.MapStatistic(data, logger)
-							dfStatTypeNode.ChildNodes = append(dfStatTypeNode.ChildNodes, dfStatisticNode)
-						}
-					}
-					for _, aArgosy := range argosyMap {
-						aFleet.ChildNodes = append(aFleet.ChildNodes, aArgosy)
+						records = append(records, NewStatisticRecord(project, idName.(string), argosId, data))
 					}
-					return &aFleet, nil
+					return ArgosyFleetFromStatistics(project, argosIds, records, logger), nil
 				}
 			}
 
@@ -294,6 +218,65 @@ func InitArgosyFleet(mod *kv.Modifier, project string, logger *log.Logger) (*tcc
 	return &aFleet, nil
 }
 
+// ArgosyFleetFromStatistics builds an argosy fleet from statistics, grouped
+// by argosId, flow group, statistic type for dashed flow names and flow
+// name.  Every argosId in argosIds is included even without statistics.
+func ArgosyFleetFromStatistics(project string, argosIds []string, records []*StatisticRecord, logger *log.Logger) *tccore.TTDINode {
+	aFleet := &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
+	aFleet.MashupDetailedElement.Name = project
+	aFleet.ChildNodes = make([]*tccore.TTDINode, 0)
+
+	argosyMap := map[string]*tccore.TTDINode{}
+	argosNodeFor := func(argosId string) *tccore.TTDINode {
+		argosNode := argosyMap[argosId]
+		if argosNode == nil {
+			argosNode = &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
+			argosNode.MashupDetailedElement.Name = argosId
+			argosNode.ChildNodes = make([]*tccore.TTDINode, 0)
+			argosyMap[argosId] = argosNode
+			aFleet.ChildNodes = append(aFleet.ChildNodes, argosNode)
+		}
+		return argosNode
+	}
+	childNodeFor := func(parent *tccore.TTDINode, name string) *tccore.TTDINode {
+		for i := 0; i < len(parent.ChildNodes); i++ {
+			if parent.ChildNodes[i].Name == name {
+				return parent.ChildNodes[i]
+			}
+		}
+		child := &tccore.TTDINode{MashupDetailedElement: &mashupsdk.MashupDetailedElement{}}
+		child.MashupDetailedElement.Name = name
+		parent.ChildNodes = append(parent.ChildNodes, child)
+		return child
+	}
+
+	sortedIds := append([]string{}, argosIds...)
+	sort.Strings(sortedIds)
+	for _, argosId := range sortedIds {
+		argosNodeFor(argosId)
+	}
+
+	records = append([]*StatisticRecord{}, records...)
+	SortStatistics(records)
+	for _, record := range records {
+		argosDfGroup := childNodeFor(argosNodeFor(record.Id), record.FlowGroup)
+		dfStatTypeNode := argosDfGroup
+		if strings.Contains(record.FlowName, "-") {
+			statisticType := strings.Split(record.FlowName, "-")[0] //login
+			dfStatTypeNode = childNodeFor(argosDfGroup, statisticType)
+		}
+		dfStatNameTypeNode := childNodeFor(dfStatTypeNode, record.FlowName)
+
+		dfStatisticNode := tccore.InitDataFlow(nil, record.FlowName, false)
+		statMap := record.StatMap()
+		// MapStatistic reads mode as vault hands it back, a string.
+		statMap["mode"] = strconv.Itoa(record.Mode)
+		dfStatisticNode.MapStatistic(statMap, logger)
+		dfStatNameTypeNode.ChildNodes = append(dfStatNameTypeNode.ChildNodes, dfStatisticNode)
+	}
+	return aFleet
+}
+
 func DeliverStatistic(tfmContext *TrcFlowMachineContext, tfContext *TrcFlowContext, mod *kv.Modifier, dfs *tccore.TTDINode, id string, indexPath string, idName string, logger *log.Logger, vaultWriteBack bool) {
 	//TODO : Write Statistic to vault
 	dfs.FinishStatisticLog()
@@ -302,6 +285,11 @@ func DeliverStatistic(tfmContext *TrcFlowMachineContext, tfContext *TrcFlowConte
 		logger.Printf("Unable to access deliver statistic context for DeliverStatistic: %v\n", err)
 		return
 	}
+	ctx, span := telemetry.Start(context.Background(), "dataflow.statistic.deliver",
+		attribute.String("trc.dataflow.id", id),
+		attribute.String("trc.dataflow.name", dfs.Name),
+		attribute.Int("trc.dataflow.statistics", len(dfs.ChildNodes)))
+	defer span.End()
 	mod.SectionPath = ""
 	for _, dataFlowStatistic := range dfs.ChildNodes {
 		dfStatDeliveryCtx, _, deliverStatErr := dataFlowStatistic.GetDeliverStatCtx()
@@ -310,6 +298,7 @@ func DeliverStatistic(tfmContext *TrcFlowMachineContext, tfContext *TrcFlowConte
 		}
 
 		statMap := dataFlowStatistic.FinishStatistic(id, indexPath, idName, logger, vaultWriteBack, dsc)
+		telemetry.RecordDataFlowStatistic(ctx, statMap)
 
 		mod.SectionPath = ""
 		statPath := fmt.Sprintf(
@@ -323,10 +312,10 @@ func DeliverStatistic(tfmContext *TrcFlowMachineContext, tfContext *TrcFlowConte
 		)
 
 		if vaultWriteBack {
-			mod.SectionPath = ""
-			_, writeErr := mod.Write(statPath, statMap, logger)
+			store := GetStatisticsStore(mod, logger)
+			writeErr := store.Put(NewStatisticRecord(indexPath, idName, id, statMap))
 			if writeErr != nil && dsc.LogFunc != nil {
-				(*dsc.LogFunc)("Error writing out DataFlowStatistics to vault", writeErr)
+				(*dsc.LogFunc)(fmt.Sprintf("Error writing out DataFlowStatistics to %s statistics store", store.Name()), writeErr)
 			}
 		} else {
 			if tfmContext != nil && tfContext != nil {
@@ -0,0 +1,277 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tccore "github.com/trimble-oss/tierceron-core/v2/core"
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// Statistics store backends, set as store in STATISTICS_STORE_CONFIG_PATH.
+const (
+	STATISTICS_STORE_VAULT = "vault" // Tenant index paths in vault.
+	STATISTICS_STORE_TRCDB = "trcdb" // The DataFlowStatistics flume table.
+	STATISTICS_STORE_LOCAL = "local" // Append only segments on local disk.
+)
+
+// STATISTICS_STORE_CONFIG_PATH holds the statistics store selected for a
+// deployment along with its retention policy.
+const STATISTICS_STORE_CONFIG_PATH = "super-secrets/Restricted/StatisticsStore/config"
+
+// StatisticRecord is a single data flow statistic, one state of a flow for
+// an argosId.
+type StatisticRecord struct {
+	IndexPath      string `json:"indexPath"`
+	IdName         string `json:"idName"`
+	Id             string `json:"id"`
+	FlowGroup      string `json:"flowGroup"`
+	FlowName       string `json:"flowName"`
+	StateCode      string `json:"stateCode"`
+	StateName      string `json:"stateName"`
+	Mode           int    `json:"mode"`
+	TimeSplit      string `json:"timeSplit"`
+	LastTestedDate string `json:"lastTestedDate"`
+}
+
+// NewStatisticRecord builds a record from a statistic map as produced by
+// FinishStatistic or read back from vault.
+func NewStatisticRecord(indexPath string, idName string, id string, statMap map[string]interface{}) *StatisticRecord {
+	record := &StatisticRecord{
+		IndexPath: indexPath,
+		IdName:    idName,
+		Id:        strings.Trim(id, "/"),
+	}
+	record.FlowGroup, _ = statMap["flowGroup"].(string)
+	record.FlowName, _ = statMap["flowName"].(string)
+	record.StateCode, _ = statMap["stateCode"].(string)
+	record.StateName, _ = statMap["stateName"].(string)
+	record.TimeSplit, _ = statMap["timeSplit"].(string)
+	record.LastTestedDate, _ = statMap["lastTestedDate"].(string)
+	if mode, ok := statMap["mode"]; ok {
+		// Vault hands back json.Number, the flows hand back int.
+		record.Mode, _ = strconv.Atoi(fmt.Sprintf("%v", mode))
+	}
+	return record
+}
+
+// StatMap returns the record as a statistic map as used by MapStatistic.
+func (r *StatisticRecord) StatMap() map[string]interface{} {
+	return map[string]interface{}{
+		"flowGroup":      r.FlowGroup,
+		"flowName":       r.FlowName,
+		"stateCode":      r.StateCode,
+		"stateName":      r.StateName,
+		"mode":           r.Mode,
+		"timeSplit":      r.TimeSplit,
+		"lastTestedDate": r.LastTestedDate,
+	}
+}
+
+// Series identifies the flow state a record is a sample of.
+func (r *StatisticRecord) Series() string {
+	return strings.Join([]string{r.IndexPath, r.IdName, r.Id, r.FlowGroup, r.FlowName, r.StateCode}, "/")
+}
+
+// Time returns when the statistic was last tested, zero if unknown.
+func (r *StatisticRecord) Time() time.Time {
+	return ParseStatisticTime(r.LastTestedDate)
+}
+
+// ParseStatisticTime parses a lastTestedDate in any of the formats the
+// flows write it, returning zero if it can't.
+func ParseStatisticTime(lastTestedDate string) time.Time {
+	for _, layout := range []string{tccore.RFC_ISO_8601, time.RFC3339Nano, "2006-01-02 15:04:05.999999999 -0700 MST", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, strings.TrimSpace(lastTestedDate)); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// RetentionPolicy limits the statistics a store keeps.  Zero values keep
+// everything.  Stores don't apply it on their own, it is only applied by
+// trcctl stats compact, so schedule that (eg: a cron job per environment)
+// to enforce it.
+type RetentionPolicy struct {
+	MaxAge       time.Duration // Statistics last tested longer ago are dropped.
+	MaxPerSeries int           // Samples kept of each flow state, by stores keeping history.
+}
+
+// Expired returns true if a record falls outside the policy's age.
+func (p RetentionPolicy) Expired(record *StatisticRecord, now time.Time) bool {
+	if p.MaxAge <= 0 {
+		return false
+	}
+	t := record.Time()
+	return !t.IsZero() && now.Sub(t) > p.MaxAge
+}
+
+// StatisticsStore keeps data flow statistics for the tenants of an index.
+type StatisticsStore interface {
+	// Name returns the backend of the store.
+	Name() string
+	// Put stores a statistic, replacing the latest of its flow state.
+	Put(record *StatisticRecord) error
+	// List returns the latest statistic of every flow state under an index.
+	List(indexPath string, idName string) ([]*StatisticRecord, error)
+	// Compact applies a retention policy, returning how many statistics were dropped.
+	Compact(indexPath string, idName string, policy RetentionPolicy) (int, error)
+	// Close releases the store.
+	Close() error
+}
+
+// StatisticsStoreConfig selects a deployment's statistics store.
+type StatisticsStoreConfig struct {
+	Store     string
+	Path      string // Directory of the local store.
+	Retention RetentionPolicy
+}
+
+// NewStatisticsStoreConfig reads a statistics store config, defaulting to
+// vault.  Settings are store, path, retentionDays and maxPerSeries.
+func NewStatisticsStoreConfig(settings map[string]interface{}) (*StatisticsStoreConfig, error) {
+	storeConfig := &StatisticsStoreConfig{Store: STATISTICS_STORE_VAULT}
+	if store, ok := settings["store"].(string); ok && len(store) > 0 {
+		storeConfig.Store = store
+	}
+	storeConfig.Path, _ = settings["path"].(string)
+	switch storeConfig.Store {
+	case STATISTICS_STORE_VAULT, STATISTICS_STORE_TRCDB:
+	case STATISTICS_STORE_LOCAL:
+		if len(storeConfig.Path) == 0 {
+			return nil, errors.New("local statistics store requires path")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported statistics store: %s", storeConfig.Store)
+	}
+	if days, ok := settings["retentionDays"]; ok {
+		retentionDays, err := strconv.Atoi(fmt.Sprintf("%v", days))
+		if err != nil || retentionDays < 0 {
+			return nil, fmt.Errorf("invalid retentionDays: %v", days)
+		}
+		storeConfig.Retention.MaxAge = time.Duration(retentionDays) * 24 * time.Hour
+	}
+	if max, ok := settings["maxPerSeries"]; ok {
+		maxPerSeries, err := strconv.Atoi(fmt.Sprintf("%v", max))
+		if err != nil || maxPerSeries < 0 {
+			return nil, fmt.Errorf("invalid maxPerSeries: %v", max)
+		}
+		storeConfig.Retention.MaxPerSeries = maxPerSeries
+	}
+	return storeConfig, nil
+}
+
+// NewStatisticsStore opens the store a config selects.  The vault store
+// writes through mod.
+func NewStatisticsStore(mod *kv.Modifier, storeConfig *StatisticsStoreConfig, logger *log.Logger) (StatisticsStore, error) {
+	switch storeConfig.Store {
+	case STATISTICS_STORE_VAULT:
+		return NewVaultStatisticsStore(mod, logger), nil
+	case STATISTICS_STORE_TRCDB:
+		return NewTrcdbStatisticsStore(mod, logger)
+	case STATISTICS_STORE_LOCAL:
+		return NewLocalStatisticsStore(storeConfig.Path, logger)
+	}
+	return nil, fmt.Errorf("unsupported statistics store: %s", storeConfig.Store)
+}
+
+var statisticsStoreLock sync.Mutex
+var statisticsStores = map[string]StatisticsStore{}
+
+// GetStatisticsStore returns the statistics store selected for mod's
+// environment, opening it on first use.  Deployments without a config use
+// vault.  If the config can't be read or its store opened, vault is used
+// until a later call succeeds.
+func GetStatisticsStore(mod *kv.Modifier, logger *log.Logger) StatisticsStore {
+	statisticsStoreLock.Lock()
+	defer statisticsStoreLock.Unlock()
+	if store, ok := statisticsStores[mod.Env]; ok {
+		if store == nil {
+			return NewVaultStatisticsStore(mod, logger)
+		}
+		return store
+	}
+
+	mod.SectionPath = ""
+	settings, err := mod.ReadData(STATISTICS_STORE_CONFIG_PATH)
+	if err != nil {
+		logger.Printf("Falling back to vault statistics store, unable to read config: %v\n", err)
+		return NewVaultStatisticsStore(mod, logger)
+	}
+	if settings == nil {
+		statisticsStores[mod.Env] = nil
+		return NewVaultStatisticsStore(mod, logger)
+	}
+	storeConfig, err := NewStatisticsStoreConfig(settings)
+	if err != nil {
+		logger.Printf("Falling back to vault statistics store: %v\n", err)
+		return NewVaultStatisticsStore(mod, logger)
+	}
+	if storeConfig.Store == STATISTICS_STORE_VAULT {
+		statisticsStores[mod.Env] = nil
+		return NewVaultStatisticsStore(mod, logger)
+	}
+	store, err := NewStatisticsStore(mod, storeConfig, logger)
+	if err != nil {
+		logger.Printf("Falling back to vault statistics store: %v\n", err)
+		return NewVaultStatisticsStore(mod, logger)
+	}
+	logger.Printf("Using %s statistics store.\n", store.Name())
+	statisticsStores[mod.Env] = store
+	return store
+}
+
+// LatestStatistics reduces records to the latest of each flow state.
+func LatestStatistics(records []*StatisticRecord) []*StatisticRecord {
+	latest := map[string]*StatisticRecord{}
+	for _, record := range records {
+		if current, ok := latest[record.Series()]; !ok || !record.Time().Before(current.Time()) {
+			latest[record.Series()] = record
+		}
+	}
+	result := make([]*StatisticRecord, 0, len(latest))
+	for _, record := range latest {
+		result = append(result, record)
+	}
+	SortStatistics(result)
+	return result
+}
+
+// SortStatistics orders records by argosId, flow group, flow name and state.
+func SortStatistics(records []*StatisticRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if a.Id != b.Id {
+			return a.Id < b.Id
+		}
+		if a.FlowGroup != b.FlowGroup {
+			return a.FlowGroup < b.FlowGroup
+		}
+		if a.FlowName != b.FlowName {
+			return a.FlowName < b.FlowName
+		}
+		return a.StateName < b.StateName
+	})
+}
+
+// MigrateStatistics copies the latest statistics under an index from one
+// store to another, returning how many were copied.
+func MigrateStatistics(from StatisticsStore, to StatisticsStore, indexPath string, idName string) (int, error) {
+	records, err := from.List(indexPath, idName)
+	if err != nil {
+		return 0, err
+	}
+	for i, record := range records {
+		if err := to.Put(record); err != nil {
+			return i, err
+		}
+	}
+	return len(records), nil
+}
@@ -0,0 +1,225 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const localStatisticsSegmentLayout = "2006-01-02"
+const localStatisticsSegmentExt = ".jsonl"
+
+// StatisticsHistory is implemented by stores keeping every statistic put
+// rather than only the latest of each flow state.
+type StatisticsHistory interface {
+	// History returns every statistic kept under an index, oldest first.
+	History(indexPath string, idName string) ([]*StatisticRecord, error)
+}
+
+// LocalStatisticsStore is an embedded time series store keeping every
+// statistic put in append only daily segments under a directory, one
+// directory per index.
+type LocalStatisticsStore struct {
+	dir    string
+	logger *log.Logger
+	lock   sync.Mutex
+}
+
+// NewLocalStatisticsStore opens a local store in dir, creating it if needed.
+func NewLocalStatisticsStore(dir string, logger *log.Logger) (*LocalStatisticsStore, error) {
+	if len(dir) == 0 {
+		return nil, errors.New("local statistics store requires a directory")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &LocalStatisticsStore{dir: dir, logger: logger}, nil
+}
+
+func (s *LocalStatisticsStore) Name() string {
+	return STATISTICS_STORE_LOCAL
+}
+
+func (s *LocalStatisticsStore) indexDir(indexPath string, idName string) string {
+	return filepath.Join(s.dir, strings.ReplaceAll(indexPath, "/", "_"), strings.ReplaceAll(idName, "/", "_"))
+}
+
+func (s *LocalStatisticsStore) Put(record *StatisticRecord) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	dir := s.indexDir(record.IndexPath, record.IdName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	segment, err := os.OpenFile(filepath.Join(dir, time.Now().UTC().Format(localStatisticsSegmentLayout)+localStatisticsSegmentExt), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer segment.Close()
+	_, err = segment.Write(append(encoded, '\n'))
+	return err
+}
+
+// segments returns the segment files of an index, oldest first.
+func (s *LocalStatisticsStore) segments(indexPath string, idName string) ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(s.indexDir(indexPath, idName), "*"+localStatisticsSegmentExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+func readLocalStatisticsSegment(segmentPath string, logger *log.Logger) ([]*StatisticRecord, error) {
+	segment, err := os.Open(segmentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer segment.Close()
+	records := []*StatisticRecord{}
+	scanner := bufio.NewScanner(segment)
+	for scanner.Scan() {
+		record := &StatisticRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			// A torn write at the end of a segment loses only that statistic.
+			logger.Printf("Skipping unreadable statistic in %s: %v\n", segmentPath, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+func (s *LocalStatisticsStore) history(indexPath string, idName string) ([]*StatisticRecord, error) {
+	segments, err := s.segments(indexPath, idName)
+	if err != nil {
+		return nil, err
+	}
+	records := []*StatisticRecord{}
+	for _, segmentPath := range segments {
+		segmentRecords, err := readLocalStatisticsSegment(segmentPath, s.logger)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, segmentRecords...)
+	}
+	return records, nil
+}
+
+func (s *LocalStatisticsStore) History(indexPath string, idName string) ([]*StatisticRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.history(indexPath, idName)
+}
+
+func (s *LocalStatisticsStore) List(indexPath string, idName string) ([]*StatisticRecord, error) {
+	records, err := s.History(indexPath, idName)
+	if err != nil {
+		return nil, err
+	}
+	return LatestStatistics(records), nil
+}
+
+// Compact removes segments older than the policy's age, then rewrites the
+// remaining segments without expired statistics or samples beyond
+// MaxPerSeries of each flow state.
+func (s *LocalStatisticsStore) Compact(indexPath string, idName string, policy RetentionPolicy) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	segments, err := s.segments(indexPath, idName)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	compacted := 0
+	kept := []string{}
+	for _, segmentPath := range segments {
+		day, err := time.Parse(localStatisticsSegmentLayout, strings.TrimSuffix(filepath.Base(segmentPath), localStatisticsSegmentExt))
+		if err == nil && policy.MaxAge > 0 && now.Sub(day.Add(24*time.Hour)) > policy.MaxAge {
+			records, _ := readLocalStatisticsSegment(segmentPath, s.logger)
+			if err := os.Remove(segmentPath); err != nil {
+				return compacted, err
+			}
+			compacted += len(records)
+			continue
+		}
+		kept = append(kept, segmentPath)
+	}
+
+	// Newest samples of each series are counted first.
+	perSeries := map[string]int{}
+	for i := len(kept) - 1; i >= 0; i-- {
+		records, err := readLocalStatisticsSegment(kept[i], s.logger)
+		if err != nil {
+			return compacted, err
+		}
+		retained := []*StatisticRecord{}
+		for j := len(records) - 1; j >= 0; j-- {
+			record := records[j]
+			if policy.Expired(record, now) {
+				continue
+			}
+			if policy.MaxPerSeries > 0 && perSeries[record.Series()] >= policy.MaxPerSeries {
+				continue
+			}
+			perSeries[record.Series()]++
+			retained = append([]*StatisticRecord{record}, retained...)
+		}
+		if len(retained) == len(records) {
+			continue
+		}
+		compacted += len(records) - len(retained)
+		if len(retained) == 0 {
+			if err := os.Remove(kept[i]); err != nil {
+				return compacted, err
+			}
+			continue
+		}
+		if err := writeLocalStatisticsSegment(kept[i], retained); err != nil {
+			return compacted, err
+		}
+	}
+	return compacted, nil
+}
+
+// writeLocalStatisticsSegment replaces a segment, renaming into place so a
+// failed rewrite leaves the old one.
+func writeLocalStatisticsSegment(segmentPath string, records []*StatisticRecord) error {
+	tmpPath := segmentPath + ".tmp"
+	segment, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(segment)
+	for _, record := range records {
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			segment.Close()
+			return err
+		}
+		writer.Write(append(encoded, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		segment.Close()
+		return err
+	}
+	if err := segment.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, segmentPath)
+}
+
+func (s *LocalStatisticsStore) Close() error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/trimble-oss/tierceron/atrium/buildopts/flowcoreopts"
+	"github.com/trimble-oss/tierceron/buildopts"
+	"github.com/trimble-oss/tierceron/pkg/core"
+	trcdbutil "github.com/trimble-oss/tierceron/pkg/core/dbutil"
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// STATISTICS_STORE_TABLE is the flume table holding data flow statistics.
+const STATISTICS_STORE_TABLE = "DataFlowStatistics"
+
+// TrcdbStatisticsStore keeps statistics in the DataFlowStatistics table,
+// connecting directly with the SpiralDatabase credentials.  The table is
+// per deployment, so index paths only label the records listed.
+type TrcdbStatisticsStore struct {
+	db     *sql.DB
+	logger *log.Logger
+}
+
+// NewTrcdbStatisticsStore connects to the statistics table using the
+// database credentials in vault.
+func NewTrcdbStatisticsStore(mod *kv.Modifier, logger *log.Logger) (*TrcdbStatisticsStore, error) {
+	mod.SectionPath = ""
+	data, err := mod.ReadData("super-secrets/Protected/SpiralDatabase/config")
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no database config for trcdb statistics store")
+	}
+	dbuser, _ := data["dbuser"].(string)
+	dbpassword, _ := data["dbpassword"].(string)
+	coreConfig := &core.CoreConfig{
+		ExitOnFailure: false,
+		Insecure:      mod.Insecure,
+		Log:           logger,
+	}
+	db, err := trcdbutil.OpenDirectConnection(coreConfig, buildopts.BuildOptions.GetTrcDbUrl(data), dbuser, dbpassword)
+	if err != nil {
+		return nil, err
+	}
+	return &TrcdbStatisticsStore{db: db, logger: logger}, nil
+}
+
+func (s *TrcdbStatisticsStore) Name() string {
+	return STATISTICS_STORE_TRCDB
+}
+
+func (s *TrcdbStatisticsStore) Put(record *StatisticRecord) error {
+	_, err := s.db.Exec(`INSERT INTO `+STATISTICS_STORE_TABLE+`(`+flowcoreopts.DataflowTestNameColumn+`, `+flowcoreopts.DataflowTestIdColumn+`, flowGroup, mode, stateCode, stateName, timeSplit, lastTestedDate) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`+
+		` ON DUPLICATE KEY UPDATE flowGroup = VALUES(flowGroup), mode = VALUES(mode), stateName = VALUES(stateName), timeSplit = VALUES(timeSplit), lastTestedDate = VALUES(lastTestedDate)`,
+		record.FlowName, record.Id, record.FlowGroup, record.Mode, record.StateCode, record.StateName, record.TimeSplit, record.LastTestedDate)
+	return err
+}
+
+func (s *TrcdbStatisticsStore) List(indexPath string, idName string) ([]*StatisticRecord, error) {
+	rows, err := s.db.Query(`select ` + flowcoreopts.DataflowTestNameColumn + `, ` + flowcoreopts.DataflowTestIdColumn + `, flowGroup, mode, stateCode, stateName, timeSplit, lastTestedDate from ` + STATISTICS_STORE_TABLE)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*StatisticRecord{}
+	for rows.Next() {
+		record := &StatisticRecord{IndexPath: indexPath, IdName: idName}
+		var mode sql.NullInt64
+		var timeSplit, lastTestedDate sql.NullString
+		if err := rows.Scan(&record.FlowName, &record.Id, &record.FlowGroup, &mode, &record.StateCode, &record.StateName, &timeSplit, &lastTestedDate); err != nil {
+			return nil, err
+		}
+		record.Mode = int(mode.Int64)
+		record.TimeSplit = timeSplit.String
+		record.LastTestedDate = lastTestedDate.String
+		records = append(records, record)
+	}
+	SortStatistics(records)
+	return records, rows.Err()
+}
+
+// Compact deletes statistics past the policy's age.  lastTestedDate is
+// written in more than one format so ages are compared here rather than in
+// sql.
+func (s *TrcdbStatisticsStore) Compact(indexPath string, idName string, policy RetentionPolicy) (int, error) {
+	if policy.MaxAge <= 0 {
+		return 0, nil
+	}
+	records, err := s.List(indexPath, idName)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	compacted := 0
+	for _, record := range records {
+		if !policy.Expired(record, now) {
+			continue
+		}
+		_, err := s.db.Exec(`delete from `+STATISTICS_STORE_TABLE+` where `+strings.Join([]string{
+			flowcoreopts.DataflowTestNameColumn + ` = ?`,
+			flowcoreopts.DataflowTestIdColumn + ` = ?`,
+			flowcoreopts.DataflowTestStateCodeColumn + ` = ?`,
+		}, " and "), record.FlowName, record.Id, record.StateCode)
+		if err != nil {
+			return compacted, err
+		}
+		compacted++
+	}
+	return compacted, nil
+}
+
+func (s *TrcdbStatisticsStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// VaultStatisticsStore keeps statistics under the tenant index paths in
+// vault, one secret per flow state.
+type VaultStatisticsStore struct {
+	mod    *kv.Modifier
+	logger *log.Logger
+}
+
+// NewVaultStatisticsStore returns a store writing through mod.
+func NewVaultStatisticsStore(mod *kv.Modifier, logger *log.Logger) *VaultStatisticsStore {
+	return &VaultStatisticsStore{mod: mod, logger: logger}
+}
+
+func (s *VaultStatisticsStore) Name() string {
+	return STATISTICS_STORE_VAULT
+}
+
+func (s *VaultStatisticsStore) statPath(record *StatisticRecord) string {
+	return fmt.Sprintf(HIVE_STAT_CODE_PATH,
+		record.IndexPath,
+		record.IdName,
+		record.Id,
+		record.FlowGroup,
+		record.FlowName,
+		record.StateCode)
+}
+
+func (s *VaultStatisticsStore) Put(record *StatisticRecord) error {
+	s.mod.SectionPath = ""
+	_, err := s.mod.Write(s.statPath(record), record.StatMap(), s.logger)
+	return err
+}
+
+// listKeys lists the keys under a vault path without their trailing slash.
+func (s *VaultStatisticsStore) listKeys(path string) ([]string, error) {
+	s.mod.SectionPath = ""
+	listData, err := s.mod.List(path, s.logger)
+	if err != nil || listData == nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, keyList := range listData.Data {
+		if keyList, ok := keyList.([]interface{}); ok {
+			for _, key := range keyList {
+				keys = append(keys, strings.TrimSuffix(key.(string), "/"))
+			}
+		}
+	}
+	return keys, nil
+}
+
+// walk calls visit with the path and record of every statistic under an
+// index.
+func (s *VaultStatisticsStore) walk(indexPath string, idName string, visit func(path string, record *StatisticRecord) error) error {
+	ids, err := s.listKeys(fmt.Sprintf("%s/%s", fmt.Sprintf(PUBLIC_INDEX_BASIS_PATH, indexPath), idName))
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		dfgPath := fmt.Sprintf(HIVE_STAT_DFG_PATH, indexPath, idName, id)
+		flowGroups, err := s.listKeys(dfgPath)
+		if err != nil {
+			return err
+		}
+		for _, flowGroup := range flowGroups {
+			flowNames, err := s.listKeys(fmt.Sprintf("%s/%s/dataFlowName", dfgPath, flowGroup))
+			if err != nil {
+				return err
+			}
+			for _, flowName := range flowNames {
+				statPath := fmt.Sprintf(HIVE_STAT_PATH, indexPath, idName, id, flowGroup, flowName)
+				stateCodes, err := s.listKeys(statPath)
+				if err != nil {
+					return err
+				}
+				for _, stateCode := range stateCodes {
+					path := fmt.Sprintf("%s/%s", statPath, stateCode)
+					s.mod.SectionPath = ""
+					data, err := s.mod.ReadData(path)
+					if err != nil {
+						return err
+					}
+					if data == nil {
+						continue
+					}
+					if err := visit(path, NewStatisticRecord(indexPath, idName, id, data)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *VaultStatisticsStore) List(indexPath string, idName string) ([]*StatisticRecord, error) {
+	records := []*StatisticRecord{}
+	err := s.walk(indexPath, idName, func(_ string, record *StatisticRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	SortStatistics(records)
+	return records, err
+}
+
+// Compact deletes statistics past the policy's age.  Vault keeps only the
+// latest of each flow state so there's no history to trim.
+func (s *VaultStatisticsStore) Compact(indexPath string, idName string, policy RetentionPolicy) (int, error) {
+	if policy.MaxAge <= 0 {
+		return 0, nil
+	}
+	now := time.Now()
+	expired := []string{}
+	err := s.walk(indexPath, idName, func(path string, record *StatisticRecord) error {
+		if policy.Expired(record, now) {
+			expired = append(expired, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, path := range expired {
+		if _, err := s.mod.HardDelete(path, s.logger); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+func (s *VaultStatisticsStore) Close() error {
+	return nil
+}
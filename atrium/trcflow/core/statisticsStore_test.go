@@ -0,0 +1,285 @@
+package core
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv/kvtest"
+)
+
+func TestLocalStatisticsStore(t *testing.T) {
+	logger := log.New(os.Stderr, "[stats]", log.LstdFlags)
+	dir := t.TempDir()
+	store, err := NewLocalStatisticsStore(dir, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 3; i >= 0; i-- {
+		for _, stateCode := range []string{"1", "2"} {
+			record := NewStatisticRecord("Index", "tenantId", "argos1/", map[string]interface{}{
+				"flowGroup":      "System",
+				"flowName":       "login-abc",
+				"stateCode":      stateCode,
+				"stateName":      "state" + stateCode,
+				"mode":           2,
+				"timeSplit":      "1s",
+				"lastTestedDate": now.Add(-time.Duration(i) * time.Hour).Format(time.RFC3339),
+			})
+			if err := store.Put(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	latest, err := store.List("Index", "tenantId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latest) != 2 || latest[0].Id != "argos1" || latest[0].Mode != 2 || now.Sub(latest[0].Time()) > time.Minute {
+		t.Fatalf("Expected the latest of each state, got %v", latest)
+	}
+
+	// An old segment is dropped whole.
+	oldSegment := filepath.Join(store.indexDir("Index", "tenantId"), now.AddDate(0, 0, -40).UTC().Format(localStatisticsSegmentLayout)+localStatisticsSegmentExt)
+	if err := os.WriteFile(oldSegment, []byte("{\"flowName\":\"old\"}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	compacted, err := store.Compact("Index", "tenantId", RetentionPolicy{MaxAge: 30 * 24 * time.Hour, MaxPerSeries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted != 5 {
+		t.Fatalf("Expected 5 statistics compacted, got %d", compacted)
+	}
+	history, _ := store.History("Index", "tenantId")
+	if len(history) != 4 {
+		t.Fatalf("Expected 2 samples of each state kept, got %d", len(history))
+	}
+
+	target, _ := NewLocalStatisticsStore(filepath.Join(dir, "target"), logger)
+	if migrated, err := MigrateStatistics(store, target, "Index", "tenantId"); err != nil || migrated != 2 {
+		t.Fatalf("Expected 2 statistics migrated, got %d %v", migrated, err)
+	}
+
+	fleet := ArgosyFleetFromStatistics("Index", []string{"argos2", "argos1"}, latest, logger)
+	if len(fleet.ChildNodes) != 2 || fleet.ChildNodes[0].Name != "argos1" || len(fleet.ChildNodes[1].ChildNodes) != 0 {
+		t.Fatal("Unexpected argosy fleet")
+	}
+	flowGroup := fleet.ChildNodes[0].ChildNodes[0]
+	if flowGroup.Name != "System" || flowGroup.ChildNodes[0].Name != "login" || len(flowGroup.ChildNodes[0].ChildNodes[0].ChildNodes) != 2 {
+		t.Fatal("Unexpected argosy fleet grouping")
+	}
+	for _, statistic := range flowGroup.ChildNodes[0].ChildNodes[0].ChildNodes {
+		if dsc, _, err := statistic.GetDeliverStatCtx(); err != nil || dsc.GetModeInt() != 2 {
+			t.Fatalf("Expected the statistic mode kept, got %v", err)
+		}
+	}
+}
+
+func TestStatisticsStoreConfig(t *testing.T) {
+	storeConfig, err := NewStatisticsStoreConfig(map[string]interface{}{})
+	if err != nil || storeConfig.Store != STATISTICS_STORE_VAULT || storeConfig.Retention != (RetentionPolicy{}) {
+		t.Fatalf("Expected the vault store by default, got %v %v", storeConfig, err)
+	}
+	storeConfig, err = NewStatisticsStoreConfig(map[string]interface{}{"store": "local", "path": "/var/stats", "retentionDays": json.Number("30"), "maxPerSeries": "100"})
+	if err != nil || storeConfig.Path != "/var/stats" || storeConfig.Retention.MaxAge != 30*24*time.Hour || storeConfig.Retention.MaxPerSeries != 100 {
+		t.Fatalf("Unexpected local store config %v %v", storeConfig, err)
+	}
+	for _, settings := range []map[string]interface{}{
+		{"store": "s3"},
+		{"store": "local"},
+		{"retentionDays": "-1"},
+		{"retentionDays": "month"},
+		{"maxPerSeries": "-1"},
+	} {
+		if _, err := NewStatisticsStoreConfig(settings); err == nil {
+			t.Fatalf("Expected invalid store config %v", settings)
+		}
+	}
+}
+
+func testStatistics(now time.Time) []*StatisticRecord {
+	records := []*StatisticRecord{}
+	for i, id := range []string{"argos1", "argos2"} {
+		records = append(records, NewStatisticRecord("Index", "tenantId", id, map[string]interface{}{
+			"flowGroup":      "System",
+			"flowName":       "login-abc",
+			"stateCode":      "1",
+			"stateName":      "state1",
+			"mode":           2,
+			"timeSplit":      "1s",
+			"lastTestedDate": now.AddDate(0, 0, -40*i).Format(time.RFC3339),
+		}))
+	}
+	return records
+}
+
+func TestVaultStatisticsStore(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	mod := kvtest.NewModifier(t, vault, "dev")
+	logger := log.New(io.Discard, "", 0)
+	store := NewVaultStatisticsStore(mod, logger)
+	for _, record := range testStatistics(time.Now()) {
+		if err := store.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := vault.Data["super-secrets/data/dev/PublicIndex/Index/tenantId/argos1/DataFlowStatistics/DataFlowGroup/System/dataFlowName/login-abc/1"]; !ok {
+		t.Fatalf("Expected the statistic under its index path %v", vault.Data)
+	}
+
+	records, err := store.List("Index", "tenantId")
+	if err != nil || len(records) != 2 || records[0].Id != "argos1" || records[0].Mode != 2 || records[1].StateName != "state1" {
+		t.Fatalf("Unexpected statistics %v %v", records, err)
+	}
+
+	compacted, err := store.Compact("Index", "tenantId", RetentionPolicy{MaxAge: 30 * 24 * time.Hour})
+	if err != nil || compacted != 1 {
+		t.Fatalf("Expected the old statistic compacted, got %d %v", compacted, err)
+	}
+	if records, _ := store.List("Index", "tenantId"); len(records) != 1 || records[0].Id != "argos1" {
+		t.Fatalf("Unexpected statistics after compacting %v", records)
+	}
+}
+
+func TestGetStatisticsStore(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	configPath := "super-secrets/data/%s/Restricted/StatisticsStore/config"
+	defer func() { statisticsStores = map[string]StatisticsStore{} }()
+
+	// A config that can't be read is retried.
+	vault := kvtest.NewVault(t)
+	mod := kvtest.NewModifier(t, vault, "staging")
+	vault.SetFailPath(strings.Replace(configPath, "%s", "staging", 1))
+	if store := GetStatisticsStore(mod, logger); store.Name() != STATISTICS_STORE_VAULT {
+		t.Fatalf("Expected the vault store, got %s", store.Name())
+	}
+	vault.SetFailPath("")
+	dir := t.TempDir()
+	vault.Put(strings.Replace(configPath, "%s", "staging", 1), map[string]interface{}{"store": "local", "path": dir})
+	if store := GetStatisticsStore(mod, logger); store.Name() != STATISTICS_STORE_LOCAL {
+		t.Fatalf("Expected the configured store once readable, got %s", store.Name())
+	}
+
+	// Without a config vault is used without asking again.
+	vault = kvtest.NewVault(t)
+	mod = kvtest.NewModifier(t, vault, "dev")
+	for i := 0; i < 2; i++ {
+		if store := GetStatisticsStore(mod, logger); store.Name() != STATISTICS_STORE_VAULT {
+			t.Fatalf("Expected the vault store, got %s", store.Name())
+		}
+	}
+	if reads := vault.Requests[strings.Replace(configPath, "%s", "dev", 1)]; reads != 1 {
+		t.Fatalf("Expected the absent config read once, got %d", reads)
+	}
+}
+
+// testStatisticsDriver is a database/sql driver holding the statistics
+// table in memory.
+type testStatisticsDriver struct {
+	mu      sync.Mutex
+	rows    map[string][]driver.Value
+	queries []string
+}
+
+func (d *testStatisticsDriver) Open(string) (driver.Conn, error) { return d, nil }
+func (d *testStatisticsDriver) Prepare(query string) (driver.Stmt, error) {
+	return &testStatisticsStmt{driver: d, query: query}, nil
+}
+func (d *testStatisticsDriver) Close() error              { return nil }
+func (d *testStatisticsDriver) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+type testStatisticsStmt struct {
+	driver *testStatisticsDriver
+	query  string
+}
+
+func (s *testStatisticsStmt) Close() error  { return nil }
+func (s *testStatisticsStmt) NumInput() int { return -1 }
+
+func (s *testStatisticsStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.driver.mu.Lock()
+	defer s.driver.mu.Unlock()
+	s.driver.queries = append(s.driver.queries, s.query)
+	if strings.HasPrefix(s.query, "INSERT") {
+		// flowName, argosId, flowGroup, mode, stateCode, stateName, timeSplit, lastTestedDate
+		s.driver.rows[strings.Join([]string{args[0].(string), args[1].(string), args[4].(string)}, "/")] = args
+	} else {
+		delete(s.driver.rows, strings.Join([]string{args[0].(string), args[1].(string), args[2].(string)}, "/"))
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *testStatisticsStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.driver.mu.Lock()
+	defer s.driver.mu.Unlock()
+	s.driver.queries = append(s.driver.queries, s.query)
+	rows := &testStatisticsRows{}
+	for _, row := range s.driver.rows {
+		rows.rows = append(rows.rows, row)
+	}
+	return rows, nil
+}
+
+type testStatisticsRows struct {
+	rows [][]driver.Value
+}
+
+func (r *testStatisticsRows) Columns() []string {
+	return []string{"flowName", "argosId", "flowGroup", "mode", "stateCode", "stateName", "timeSplit", "lastTestedDate"}
+}
+func (r *testStatisticsRows) Close() error { return nil }
+func (r *testStatisticsRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestTrcdbStatisticsStore(t *testing.T) {
+	statsDriver := &testStatisticsDriver{rows: map[string][]driver.Value{}}
+	sql.Register("teststatistics", statsDriver)
+	db, err := sql.Open("teststatistics", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &TrcdbStatisticsStore{db: db, logger: log.New(io.Discard, "", 0)}
+	defer store.Close()
+
+	for _, record := range testStatistics(time.Now()) {
+		if err := store.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(statsDriver.queries[0], "INSERT INTO DataFlowStatistics(flowName, argosId,") || !strings.Contains(statsDriver.queries[0], "ON DUPLICATE KEY UPDATE") {
+		t.Fatalf("Unexpected insert %s", statsDriver.queries[0])
+	}
+
+	records, err := store.List("Index", "tenantId")
+	if err != nil || len(records) != 2 || records[0].Id != "argos1" || records[0].Mode != 2 || records[0].IndexPath != "Index" || records[1].FlowGroup != "System" {
+		t.Fatalf("Unexpected statistics %v %v", records, err)
+	}
+
+	compacted, err := store.Compact("Index", "tenantId", RetentionPolicy{MaxAge: 30 * 24 * time.Hour})
+	if err != nil || compacted != 1 {
+		t.Fatalf("Expected the old statistic compacted, got %d %v", compacted, err)
+	}
+	if last := statsDriver.queries[len(statsDriver.queries)-1]; last != "delete from DataFlowStatistics where flowName = ? and argosId = ? and stateCode = ?" {
+		t.Fatalf("Unexpected delete %s", last)
+	}
+	if records, _ := store.List("Index", "tenantId"); len(records) != 1 || records[0].Id != "argos1" {
+		t.Fatalf("Unexpected statistics after compacting %v", records)
+	}
+}
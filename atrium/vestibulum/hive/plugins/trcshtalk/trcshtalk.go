@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"flag"
@@ -18,6 +19,8 @@ import (
 	"os"
 	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/trimble-oss/tierceron-core/v2/core"
@@ -46,32 +49,70 @@ var dfstat *tccore.TTDINode
 // Returns DiagnosticResponse, forwarding the MessageId of the DiagnosticRequest,
 // and providing the results of the diagnostics ran.
 func (s *trcshtalkServiceServer) RunDiagnostics(ctx context.Context, req *pb.DiagnosticRequest) (*pb.DiagnosticResponse, error) {
+	return runDiagnostics(ctx, req), nil
+}
+
+const (
+	HEALTHCHECK_QUERY = "healthcheck"
+	DIAGNOSTICS_QUERY = "diagnostics" // Answered by the kernel itself.
+
+	DEFAULT_DIAGNOSTIC_TIMEOUT = 10 * time.Second
+)
+
+// Maps requested diagnostics to kernel diagnostics queries.
+var kernelDiagnostics = map[pb.Diagnostics]string{
+	pb.Diagnostics_VAULT:   "vault",
+	pb.Diagnostics_CERTS:   "certs",
+	pb.Diagnostics_PLUGINS: "plugins",
+	pb.Diagnostics_MEMFS:   "memfs",
+	pb.Diagnostics_FLOWS:   "flows",
+	pb.Diagnostics_ERRORS:  "errors",
+}
+
+var kernelDiagnosticStatus = map[string]pb.DiagnosticStatus{
+	"pass": pb.DiagnosticStatus_PASS,
+	"warn": pb.DiagnosticStatus_WARN,
+	"fail": pb.DiagnosticStatus_FAIL,
+}
+
+// Result of a single kernel diagnostic check, as reported by the kernel.
+type kernelDiagnosticResult struct {
+	Name       string            `json:"name"`
+	Status     string            `json:"status"`
+	Message    string            `json:"message"`
+	DurationMs int64             `json:"duration_ms"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+// Only one set of diagnostics may be waiting on the kernel at a time.
+var diagnosticsLock sync.Mutex
+
+func runDiagnostics(ctx context.Context, req *pb.DiagnosticRequest) *pb.DiagnosticResponse {
+	diagnosticsLock.Lock()
+	defer diagnosticsLock.Unlock()
+
+	checkTimeout := DEFAULT_DIAGNOSTIC_TIMEOUT
+	if req.GetTimeoutSeconds() > 0 {
+		checkTimeout = time.Duration(req.GetTimeoutSeconds()) * time.Second
+	}
+	// The kernel bounds each of its checks by the requested timeout.
+	timeoutParam := fmt.Sprintf(";timeout=%d", int(checkTimeout/time.Second))
 
-	// TODO: Implement diagnostics plugin
-	// if req.Diagnostics contains 0, run all
-	// else run each
 	cmds := req.GetDiagnostics()
 	queries := []string{}
+	kernelChecks := []string{}
 	tenant_test := req.GetTenantId() + ":"
-	if slices.Contains(cmds, pb.Diagnostics_ALL) {
+	if len(cmds) == 0 || slices.Contains(cmds, pb.Diagnostics_ALL) {
 		// run all
-		//set queries to all cmds
-		fmt.Println("Running all queries.")
-		queries = append(queries, "healthcheck")
+		configContext.Log.Println("Running all queries.")
+		queries = append(queries, HEALTHCHECK_QUERY, DIAGNOSTICS_QUERY+timeoutParam)
+		kernelChecks = append(kernelChecks, "vault", "certs", "plugins", "memfs", "flows", "errors")
 	} else {
 		for _, q := range cmds {
 			if q == pb.Diagnostics_HEALTH_CHECK {
 				// set name to plugin...
-				fmt.Println("Running healthcheck diagnostic.")
-				queries = append(queries, "healthcheck")
-				// plugin_tests := req.GetData()
-				// for i, test := range plugin_tests {
-				// 	if i == 0 {
-				// 		tenant_test = fmt.Sprintf("%s%s", tenant_test, test)
-				// 	} else {
-				// 		tenant_test = fmt.Sprintf("%s,%s", tenant_test, test)
-				// 	}
-				// }
+				configContext.Log.Println("Running healthcheck diagnostic.")
+				queries = append(queries, HEALTHCHECK_QUERY)
 				plugin_tests := req.GetQueries()
 				for i, rq := range plugin_tests {
 					test := pb.PluginQuery_name[int32(rq)]
@@ -81,61 +122,108 @@ func (s *trcshtalkServiceServer) RunDiagnostics(ctx context.Context, req *pb.Dia
 						tenant_test = fmt.Sprintf("%s,%s", tenant_test, test)
 					}
 				}
+			} else if check, ok := kernelDiagnostics[q]; ok && !slices.Contains(kernelChecks, check) {
+				configContext.Log.Printf("Running %s diagnostic.\n", check)
+				kernelChecks = append(kernelChecks, check)
 			}
 		}
+		if len(kernelChecks) > 0 {
+			queries = append(queries, fmt.Sprintf("%s:%s%s", DIAGNOSTICS_QUERY, strings.Join(kernelChecks, ","), timeoutParam))
+		}
 	}
+
+	// Kernel checks run one after another, so allow each its own timeout.
+	deadline := time.After(checkTimeout * time.Duration(len(kernelChecks)+1))
+
 	name := "trcshtalk"
 	*configContext.ChatSenderChan <- &tccore.ChatMsg{
 		ChatId: &tenant_test,
 		Name:   &name,
 		Query:  &queries,
 	}
-	// Placeholder code
-	results := ""
 	finished_queries := make(map[string]string)
-	fmt.Printf("Sent queries to kernel: %d\n", len(queries))
-	for {
-		event := <-*configContext.ChatReceiverChan
-		fmt.Println("TrcshTalk received message from kernel.")
+	configContext.Log.Printf("Sent queries to kernel: %d\n", len(queries))
+
+waitForKernel:
+	for len(finished_queries) < len(queries) {
+		select {
+		case event := <-*configContext.ChatReceiverChan:
+			if event == nil || event.Query == nil {
+				continue
+			}
+			configContext.Log.Printf("Received response from query: %s\n", *event.Query)
+			if len(*event.Query) == 1 && event.Response != nil && slices.Contains(queries, (*event.Query)[0]) {
+				finished_queries[(*event.Query)[0]] = *event.Response
+			}
+		case <-deadline:
+			configContext.Log.Println("Timed out waiting for diagnostics from kernel.")
+			break waitForKernel
+		case <-ctx.Done():
+			configContext.Log.Println("Diagnostics request cancelled.")
+			break waitForKernel
+		}
+	}
+
+	diagResults := []*pb.DiagnosticResult{}
+	for _, query := range queries {
+		response, ok := finished_queries[query]
 		switch {
-		case len(finished_queries) == len(queries):
-			// send results to google chat
-			fmt.Println("Formatting responses from kernel.")
-			for _, v := range finished_queries {
-				results = results + v + " "
+		case !ok && query == HEALTHCHECK_QUERY:
+			diagResults = append(diagResults, &pb.DiagnosticResult{
+				Name:    HEALTHCHECK_QUERY,
+				Status:  pb.DiagnosticStatus_FAIL,
+				Message: fmt.Sprintf("timed out after %s", checkTimeout),
+			})
+		case !ok:
+			for _, check := range kernelChecks {
+				diagResults = append(diagResults, &pb.DiagnosticResult{
+					Name:    check,
+					Status:  pb.DiagnosticStatus_FAIL,
+					Message: "timed out waiting for kernel",
+				})
+			}
+		case query == HEALTHCHECK_QUERY:
+			status := pb.DiagnosticStatus_PASS
+			if response == "Service unavailable" {
+				status = pb.DiagnosticStatus_FAIL
 			}
-			configContext.Log.Printf("Sending response to chat from kernel: %s\n", results)
-			return &pb.DiagnosticResponse{
-				MessageId: req.MessageId,
-				Results:   results,
-			}, nil
+			diagResults = append(diagResults, &pb.DiagnosticResult{
+				Name:    HEALTHCHECK_QUERY,
+				Status:  status,
+				Message: response,
+			})
 		default:
-			fmt.Printf("Received response from query: %s\n", *(*event).Query)
-			if len(*(*event).Query) == 1 && event.Response != nil && (*event).Response != nil {
-				fmt.Printf("Processing response from query: %s\n", *(*event).Query)
-				finished_queries[(*event.Query)[0]] = *((*event).Response)
+			kernelResults := []kernelDiagnosticResult{}
+			if err := json.Unmarshal([]byte(response), &kernelResults); err != nil {
+				diagResults = append(diagResults, &pb.DiagnosticResult{
+					Name:    query,
+					Status:  pb.DiagnosticStatus_FAIL,
+					Message: fmt.Sprintf("unable to read kernel diagnostics: %s", response),
+				})
+				continue
 			}
-			if len(finished_queries) == len(queries) {
-				// send results to google chat
-				fmt.Println("Formatting responses.")
-				for _, v := range finished_queries {
-					results = results + v + " "
-				}
-				fmt.Printf("Sending response to chat: %s", results)
-				return &pb.DiagnosticResponse{
-					MessageId: req.MessageId,
-					Results:   results,
-				}, nil
+			for _, kernelResult := range kernelResults {
+				diagResults = append(diagResults, &pb.DiagnosticResult{
+					Name:       kernelResult.Name,
+					Status:     kernelDiagnosticStatus[kernelResult.Status],
+					Message:    kernelResult.Message,
+					DurationMs: kernelResult.DurationMs,
+					Details:    kernelResult.Details,
+				})
 			}
 		}
 	}
-	// res := &pb.DiagnosticResponse{
-	// 	MessageId: req.MessageId,
-	// 	Results:   "gRPCS client/server successful. Diagnostic service not yet implemented.",
-	// }
-	// // End placeholder code
 
-	// return res, nil
+	results := ""
+	for _, diagResult := range diagResults {
+		results = results + fmt.Sprintf("%s: %s %s\n", diagResult.Name, diagResult.Status, diagResult.Message)
+	}
+	configContext.Log.Printf("Sending response to chat from kernel: %s\n", results)
+	return &pb.DiagnosticResponse{
+		MessageId:         req.MessageId,
+		Results:           results,
+		DiagnosticResults: diagResults,
+	}
 }
 
 const (
@@ -485,86 +573,7 @@ func StartTrashTalking(remoteServerName string, port int, ttbToken *string, isRe
 
 // The new endpoint kind of.
 func TrcshTalkBack(req *pb.DiagnosticRequest) *pb.DiagnosticResponse {
-	cmds := req.GetDiagnostics()
-	queries := []string{}
-	tenant_test := req.GetTenantId() + ":"
-	if slices.Contains(cmds, pb.Diagnostics_ALL) {
-		// run all
-		//set queries to all cmds
-		log.Println("Running all queries.")
-		queries = append(queries, "healthcheck")
-	} else {
-		for _, q := range cmds {
-			if q == pb.Diagnostics_HEALTH_CHECK {
-				// set name to plugin...
-				log.Println("Running healthcheck diagnostic.")
-				queries = append(queries, "healthcheck")
-				plugin_tests := req.GetQueries()
-				// plugin_tests := req.GetData()
-				// for i, test := range plugin_tests {
-				// 	if i == 0 {
-				// 		tenant_test = fmt.Sprintf("%s%s", tenant_test, test)
-				// 	} else {
-				// 		tenant_test = fmt.Sprintf("%s,%s", tenant_test, test)
-				// 	}
-				// }
-				for i, rq := range plugin_tests {
-					test := pb.PluginQuery_name[int32(rq)]
-					if i == 0 {
-						tenant_test = fmt.Sprintf("%s%s", tenant_test, test)
-					} else {
-						tenant_test = fmt.Sprintf("%s,%s", tenant_test, test)
-					}
-				}
-			}
-		}
-	}
-	name := "trcshtalk"
-
-	*configContext.ChatSenderChan <- &tccore.ChatMsg{
-		Name:   &name,
-		ChatId: &tenant_test,
-		Query:  &queries,
-	}
-	// Placeholder code
-	results := ""
-	finished_queries := make(map[string]string)
-	fmt.Printf("Sent queries to kernel: %d\n", len(queries))
-	for {
-		event := <-*configContext.ChatReceiverChan
-		fmt.Println("TrcshTalk received message from kernel.")
-		switch {
-		case len(finished_queries) == len(queries):
-			// send results to google chat
-			fmt.Println("Formatting responses.")
-			for _, v := range finished_queries {
-				results = results + v + " "
-			}
-			configContext.Log.Printf("Sending response to chat: %s\n", results)
-			return &pb.DiagnosticResponse{
-				MessageId: req.MessageId,
-				Results:   results,
-			}
-		default:
-			fmt.Printf("Received response from query: %s\n", *(*event).Query)
-			if len(*(*event).Query) == 1 && event.Response != nil && (*event).Response != nil {
-				fmt.Printf("Processing response from query: %s\n", *(*event).Query)
-				finished_queries[(*event.Query)[0]] = *((*event).Response)
-			}
-			if len(finished_queries) == len(queries) {
-				// send results to google chat
-				fmt.Println("Formatting responses.")
-				for _, v := range finished_queries {
-					results = results + v + " "
-				}
-				fmt.Printf("Sending response to chat: %s\n", results)
-				return &pb.DiagnosticResponse{
-					MessageId: req.MessageId,
-					Results:   results,
-				}
-			}
-		}
-	}
+	return runDiagnostics(context.Background(), req)
 }
 
 func start(pluginName string) {
@@ -26,7 +26,13 @@ const (
 	// if a diagnostic deprecates, comment it out
 	// if adding a diagnostic, append to the end incrementing integer
 	Diagnostics_ALL          Diagnostics = 0 // Default
-	Diagnostics_HEALTH_CHECK Diagnostics = 1 // future plugins
+	Diagnostics_HEALTH_CHECK Diagnostics = 1
+	Diagnostics_VAULT        Diagnostics = 2
+	Diagnostics_CERTS        Diagnostics = 3
+	Diagnostics_PLUGINS      Diagnostics = 4
+	Diagnostics_MEMFS        Diagnostics = 5
+	Diagnostics_FLOWS        Diagnostics = 6
+	Diagnostics_ERRORS       Diagnostics = 7 // future plugins
 )
 
 // Enum value maps for Diagnostics.
@@ -34,10 +40,22 @@ var (
 	Diagnostics_name = map[int32]string{
 		0: "ALL",
 		1: "HEALTH_CHECK",
+		2: "VAULT",
+		3: "CERTS",
+		4: "PLUGINS",
+		5: "MEMFS",
+		6: "FLOWS",
+		7: "ERRORS",
 	}
 	Diagnostics_value = map[string]int32{
 		"ALL":          0,
 		"HEALTH_CHECK": 1,
+		"VAULT":        2,
+		"CERTS":        3,
+		"PLUGINS":      4,
+		"MEMFS":        5,
+		"FLOWS":        6,
+		"ERRORS":       7,
 	}
 )
 
@@ -68,6 +86,58 @@ func (Diagnostics) EnumDescriptor() ([]byte, []int) {
 	return file_trcshtalksdk_trcshtalksdk_proto_rawDescGZIP(), []int{0}
 }
 
+type DiagnosticStatus int32
+
+const (
+	DiagnosticStatus_UNKNOWN DiagnosticStatus = 0
+	DiagnosticStatus_PASS    DiagnosticStatus = 1
+	DiagnosticStatus_WARN    DiagnosticStatus = 2
+	DiagnosticStatus_FAIL    DiagnosticStatus = 3
+)
+
+// Enum value maps for DiagnosticStatus.
+var (
+	DiagnosticStatus_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "PASS",
+		2: "WARN",
+		3: "FAIL",
+	}
+	DiagnosticStatus_value = map[string]int32{
+		"UNKNOWN": 0,
+		"PASS":    1,
+		"WARN":    2,
+		"FAIL":    3,
+	}
+)
+
+func (x DiagnosticStatus) Enum() *DiagnosticStatus {
+	p := new(DiagnosticStatus)
+	*p = x
+	return p
+}
+
+func (x DiagnosticStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (DiagnosticStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_trcshtalksdk_trcshtalksdk_proto_enumTypes[1].Descriptor()
+}
+
+func (DiagnosticStatus) Type() protoreflect.EnumType {
+	return &file_trcshtalksdk_trcshtalksdk_proto_enumTypes[1]
+}
+
+func (x DiagnosticStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use DiagnosticStatus.Descriptor instead.
+func (DiagnosticStatus) EnumDescriptor() ([]byte, []int) {
+	return file_trcshtalksdk_trcshtalksdk_proto_rawDescGZIP(), []int{1}
+}
+
 type PluginQuery int32
 
 const (
@@ -95,11 +165,11 @@ func (x PluginQuery) String() string {
 }
 
 func (PluginQuery) Descriptor() protoreflect.EnumDescriptor {
-	return file_trcshtalksdk_trcshtalksdk_proto_enumTypes[1].Descriptor()
+	return file_trcshtalksdk_trcshtalksdk_proto_enumTypes[2].Descriptor()
 }
 
 func (PluginQuery) Type() protoreflect.EnumType {
-	return &file_trcshtalksdk_trcshtalksdk_proto_enumTypes[1]
+	return &file_trcshtalksdk_trcshtalksdk_proto_enumTypes[2]
 }
 
 func (x PluginQuery) Number() protoreflect.EnumNumber {
@@ -108,7 +178,7 @@ func (x PluginQuery) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use PluginQuery.Descriptor instead.
 func (PluginQuery) EnumDescriptor() ([]byte, []int) {
-	return file_trcshtalksdk_trcshtalksdk_proto_rawDescGZIP(), []int{1}
+	return file_trcshtalksdk_trcshtalksdk_proto_rawDescGZIP(), []int{2}
 }
 
 type DiagnosticRequest struct {
@@ -116,11 +186,12 @@ type DiagnosticRequest struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	MessageId   string        `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
-	Diagnostics []Diagnostics `protobuf:"varint,2,rep,packed,name=diagnostics,proto3,enum=trcshtalksdk.Diagnostics" json:"diagnostics,omitempty"`
-	TenantId    string        `protobuf:"bytes,3,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
-	Data        []string      `protobuf:"bytes,4,rep,name=data,proto3" json:"data,omitempty"`
-	Queries     []PluginQuery `protobuf:"varint,5,rep,packed,name=queries,proto3,enum=trcshtalksdk.PluginQuery" json:"queries,omitempty"`
+	MessageId      string        `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	Diagnostics    []Diagnostics `protobuf:"varint,2,rep,packed,name=diagnostics,proto3,enum=trcshtalksdk.Diagnostics" json:"diagnostics,omitempty"`
+	TenantId       string        `protobuf:"bytes,3,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+	Data           []string      `protobuf:"bytes,4,rep,name=data,proto3" json:"data,omitempty"`
+	Queries        []PluginQuery `protobuf:"varint,5,rep,packed,name=queries,proto3,enum=trcshtalksdk.PluginQuery" json:"queries,omitempty"`
+	TimeoutSeconds int32         `protobuf:"varint,6,opt,name=timeout_seconds,json=timeoutSeconds,proto3" json:"timeout_seconds,omitempty"` // Per check timeout, 0 uses the kernel default
 }
 
 func (x *DiagnosticRequest) Reset() {
@@ -188,13 +259,21 @@ func (x *DiagnosticRequest) GetQueries() []PluginQuery {
 	return nil
 }
 
+func (x *DiagnosticRequest) GetTimeoutSeconds() int32 {
+	if x != nil {
+		return x.TimeoutSeconds
+	}
+	return 0
+}
+
 type DiagnosticResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	MessageId string `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
-	Results   string `protobuf:"bytes,2,opt,name=results,proto3" json:"results,omitempty"`
+	MessageId         string              `protobuf:"bytes,1,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	Results           string              `protobuf:"bytes,2,opt,name=results,proto3" json:"results,omitempty"`
+	DiagnosticResults []*DiagnosticResult `protobuf:"bytes,3,rep,name=diagnostic_results,json=diagnosticResults,proto3" json:"diagnostic_results,omitempty"`
 }
 
 func (x *DiagnosticResponse) Reset() {
@@ -241,13 +320,97 @@ func (x *DiagnosticResponse) GetResults() string {
 	return ""
 }
 
+func (x *DiagnosticResponse) GetDiagnosticResults() []*DiagnosticResult {
+	if x != nil {
+		return x.DiagnosticResults
+	}
+	return nil
+}
+
+type DiagnosticResult struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name       string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Status     DiagnosticStatus  `protobuf:"varint,2,opt,name=status,proto3,enum=trcshtalksdk.DiagnosticStatus" json:"status,omitempty"`
+	Message    string            `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	DurationMs int64             `protobuf:"varint,4,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	Details    map[string]string `protobuf:"bytes,5,rep,name=details,proto3" json:"details,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (x *DiagnosticResult) Reset() {
+	*x = DiagnosticResult{}
+	mi := &file_trcshtalksdk_trcshtalksdk_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DiagnosticResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DiagnosticResult) ProtoMessage() {}
+
+func (x *DiagnosticResult) ProtoReflect() protoreflect.Message {
+	mi := &file_trcshtalksdk_trcshtalksdk_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DiagnosticResult.ProtoReflect.Descriptor instead.
+func (*DiagnosticResult) Descriptor() ([]byte, []int) {
+	return file_trcshtalksdk_trcshtalksdk_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DiagnosticResult) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *DiagnosticResult) GetStatus() DiagnosticStatus {
+	if x != nil {
+		return x.Status
+	}
+	return DiagnosticStatus_UNKNOWN
+}
+
+func (x *DiagnosticResult) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *DiagnosticResult) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *DiagnosticResult) GetDetails() map[string]string {
+	if x != nil {
+		return x.Details
+	}
+	return nil
+}
+
 var File_trcshtalksdk_trcshtalksdk_proto protoreflect.FileDescriptor
 
 var file_trcshtalksdk_trcshtalksdk_proto_rawDesc = []byte{
 	0x0a, 0x1f, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x73, 0x64, 0x6b, 0x2f, 0x74,
 	0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x73, 0x64, 0x6b, 0x2e, 0x70, 0x72, 0x6f, 0x74,
 	0x6f, 0x12, 0x0c, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x73, 0x64, 0x6b, 0x22,
-	0xd5, 0x01, 0x0a, 0x11, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65,
+	0xfe, 0x01, 0x0a, 0x11, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65,
 	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1d, 0x0a, 0x0a, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
 	0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6d, 0x65, 0x73, 0x73, 0x61,
 	0x67, 0x65, 0x49, 0x64, 0x12, 0x3b, 0x0a, 0x0b, 0x64, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74,
@@ -260,29 +423,63 @@ var file_trcshtalksdk_trcshtalksdk_proto_rawDesc = []byte{
 	0x74, 0x61, 0x12, 0x33, 0x0a, 0x07, 0x71, 0x75, 0x65, 0x72, 0x69, 0x65, 0x73, 0x18, 0x05, 0x20,
 	0x03, 0x28, 0x0e, 0x32, 0x19, 0x2e, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x73,
 	0x64, 0x6b, 0x2e, 0x50, 0x6c, 0x75, 0x67, 0x69, 0x6e, 0x51, 0x75, 0x65, 0x72, 0x79, 0x52, 0x07,
-	0x71, 0x75, 0x65, 0x72, 0x69, 0x65, 0x73, 0x22, 0x4d, 0x0a, 0x12, 0x44, 0x69, 0x61, 0x67, 0x6e,
-	0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1d, 0x0a,
-	0x0a, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x09, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x07,
-	0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x72,
-	0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x2a, 0x28, 0x0a, 0x0b, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f,
-	0x73, 0x74, 0x69, 0x63, 0x73, 0x12, 0x07, 0x0a, 0x03, 0x41, 0x4c, 0x4c, 0x10, 0x00, 0x12, 0x10,
-	0x0a, 0x0c, 0x48, 0x45, 0x41, 0x4c, 0x54, 0x48, 0x5f, 0x43, 0x48, 0x45, 0x43, 0x4b, 0x10, 0x01,
-	0x2a, 0x1f, 0x0a, 0x0b, 0x50, 0x6c, 0x75, 0x67, 0x69, 0x6e, 0x51, 0x75, 0x65, 0x72, 0x79, 0x12,
-	0x10, 0x0a, 0x0c, 0x41, 0x43, 0x54, 0x49, 0x56, 0x45, 0x5f, 0x43, 0x4f, 0x55, 0x4e, 0x54, 0x10,
-	0x00, 0x32, 0x67, 0x0a, 0x10, 0x54, 0x72, 0x63, 0x73, 0x68, 0x54, 0x61, 0x6c, 0x6b, 0x53, 0x65,
-	0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x53, 0x0a, 0x0e, 0x52, 0x75, 0x6e, 0x44, 0x69, 0x61, 0x67,
-	0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x73, 0x12, 0x1f, 0x2e, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74,
-	0x61, 0x6c, 0x6b, 0x73, 0x64, 0x6b, 0x2e, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69,
-	0x63, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x20, 0x2e, 0x74, 0x72, 0x63, 0x73, 0x68,
+	0x71, 0x75, 0x65, 0x72, 0x69, 0x65, 0x73, 0x12, 0x27, 0x0a, 0x0f, 0x74, 0x69, 0x6d, 0x65, 0x6f,
+	0x75, 0x74, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05,
+	0x52, 0x0e, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73,
+	0x22, 0x9c, 0x01, 0x0a, 0x12, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x6d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6d, 0x65, 0x73,
+	0x73, 0x61, 0x67, 0x65, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74,
+	0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73,
+	0x12, 0x4d, 0x0a, 0x12, 0x64, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x5f, 0x72,
+	0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x74,
+	0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x73, 0x64, 0x6b, 0x2e, 0x44, 0x69, 0x61, 0x67,
+	0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x52, 0x11, 0x64, 0x69,
+	0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x22,
+	0x9c, 0x02, 0x0a, 0x10, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65,
+	0x73, 0x75, 0x6c, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x36, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1e, 0x2e, 0x74, 0x72, 0x63, 0x73, 0x68,
 	0x74, 0x61, 0x6c, 0x6b, 0x73, 0x64, 0x6b, 0x2e, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74,
-	0x69, 0x63, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x57, 0x5a, 0x55, 0x67, 0x69,
-	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x72, 0x69, 0x6d, 0x62, 0x6c, 0x65,
-	0x2d, 0x6f, 0x73, 0x73, 0x2f, 0x74, 0x69, 0x65, 0x72, 0x63, 0x65, 0x72, 0x6f, 0x6e, 0x2f, 0x69,
-	0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x74, 0x72, 0x63, 0x73,
-	0x68, 0x68, 0x69, 0x76, 0x65, 0x2f, 0x74, 0x72, 0x63, 0x73, 0x68, 0x6b, 0x2f, 0x74, 0x72, 0x63,
-	0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x2f, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b,
-	0x73, 0x64, 0x6b, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x69, 0x63, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x12, 0x18, 0x0a, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x07, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x64, 0x75,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x6d, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0a, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4d, 0x73, 0x12, 0x45, 0x0a, 0x07, 0x64,
+	0x65, 0x74, 0x61, 0x69, 0x6c, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2b, 0x2e, 0x74,
+	0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x73, 0x64, 0x6b, 0x2e, 0x44, 0x69, 0x61, 0x67,
+	0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x2e, 0x44, 0x65, 0x74,
+	0x61, 0x69, 0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x64, 0x65, 0x74, 0x61, 0x69,
+	0x6c, 0x73, 0x1a, 0x3a, 0x0a, 0x0c, 0x44, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x73, 0x45, 0x6e, 0x74,
+	0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x2a, 0x6d,
+	0x0a, 0x0b, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x73, 0x12, 0x07, 0x0a,
+	0x03, 0x41, 0x4c, 0x4c, 0x10, 0x00, 0x12, 0x10, 0x0a, 0x0c, 0x48, 0x45, 0x41, 0x4c, 0x54, 0x48,
+	0x5f, 0x43, 0x48, 0x45, 0x43, 0x4b, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x56, 0x41, 0x55, 0x4c,
+	0x54, 0x10, 0x02, 0x12, 0x09, 0x0a, 0x05, 0x43, 0x45, 0x52, 0x54, 0x53, 0x10, 0x03, 0x12, 0x0b,
+	0x0a, 0x07, 0x50, 0x4c, 0x55, 0x47, 0x49, 0x4e, 0x53, 0x10, 0x04, 0x12, 0x09, 0x0a, 0x05, 0x4d,
+	0x45, 0x4d, 0x46, 0x53, 0x10, 0x05, 0x12, 0x09, 0x0a, 0x05, 0x46, 0x4c, 0x4f, 0x57, 0x53, 0x10,
+	0x06, 0x12, 0x0a, 0x0a, 0x06, 0x45, 0x52, 0x52, 0x4f, 0x52, 0x53, 0x10, 0x07, 0x2a, 0x3d, 0x0a,
+	0x10, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x08,
+	0x0a, 0x04, 0x50, 0x41, 0x53, 0x53, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x57, 0x41, 0x52, 0x4e,
+	0x10, 0x02, 0x12, 0x08, 0x0a, 0x04, 0x46, 0x41, 0x49, 0x4c, 0x10, 0x03, 0x2a, 0x1f, 0x0a, 0x0b,
+	0x50, 0x6c, 0x75, 0x67, 0x69, 0x6e, 0x51, 0x75, 0x65, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x0c, 0x41,
+	0x43, 0x54, 0x49, 0x56, 0x45, 0x5f, 0x43, 0x4f, 0x55, 0x4e, 0x54, 0x10, 0x00, 0x32, 0x67, 0x0a,
+	0x10, 0x54, 0x72, 0x63, 0x73, 0x68, 0x54, 0x61, 0x6c, 0x6b, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63,
+	0x65, 0x12, 0x53, 0x0a, 0x0e, 0x52, 0x75, 0x6e, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74,
+	0x69, 0x63, 0x73, 0x12, 0x1f, 0x2e, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x73,
+	0x64, 0x6b, 0x2e, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x20, 0x2e, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b,
+	0x73, 0x64, 0x6b, 0x2e, 0x44, 0x69, 0x61, 0x67, 0x6e, 0x6f, 0x73, 0x74, 0x69, 0x63, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x57, 0x5a, 0x55, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62,
+	0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x72, 0x69, 0x6d, 0x62, 0x6c, 0x65, 0x2d, 0x6f, 0x73, 0x73,
+	0x2f, 0x74, 0x69, 0x65, 0x72, 0x63, 0x65, 0x72, 0x6f, 0x6e, 0x2f, 0x69, 0x6e, 0x73, 0x74, 0x61,
+	0x6c, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2f, 0x74, 0x72, 0x63, 0x73, 0x68, 0x68, 0x69, 0x76,
+	0x65, 0x2f, 0x74, 0x72, 0x63, 0x73, 0x68, 0x6b, 0x2f, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74, 0x61,
+	0x6c, 0x6b, 0x2f, 0x74, 0x72, 0x63, 0x73, 0x68, 0x74, 0x61, 0x6c, 0x6b, 0x73, 0x64, 0x6b, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -297,24 +494,30 @@ func file_trcshtalksdk_trcshtalksdk_proto_rawDescGZIP() []byte {
 	return file_trcshtalksdk_trcshtalksdk_proto_rawDescData
 }
 
-var file_trcshtalksdk_trcshtalksdk_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
-var file_trcshtalksdk_trcshtalksdk_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_trcshtalksdk_trcshtalksdk_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_trcshtalksdk_trcshtalksdk_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_trcshtalksdk_trcshtalksdk_proto_goTypes = []any{
 	(Diagnostics)(0),           // 0: trcshtalksdk.Diagnostics
-	(PluginQuery)(0),           // 1: trcshtalksdk.PluginQuery
-	(*DiagnosticRequest)(nil),  // 2: trcshtalksdk.DiagnosticRequest
-	(*DiagnosticResponse)(nil), // 3: trcshtalksdk.DiagnosticResponse
+	(DiagnosticStatus)(0),      // 1: trcshtalksdk.DiagnosticStatus
+	(PluginQuery)(0),           // 2: trcshtalksdk.PluginQuery
+	(*DiagnosticRequest)(nil),  // 3: trcshtalksdk.DiagnosticRequest
+	(*DiagnosticResponse)(nil), // 4: trcshtalksdk.DiagnosticResponse
+	(*DiagnosticResult)(nil),   // 5: trcshtalksdk.DiagnosticResult
+	nil,                        // 6: trcshtalksdk.DiagnosticResult.DetailsEntry
 }
 var file_trcshtalksdk_trcshtalksdk_proto_depIdxs = []int32{
 	0, // 0: trcshtalksdk.DiagnosticRequest.diagnostics:type_name -> trcshtalksdk.Diagnostics
-	1, // 1: trcshtalksdk.DiagnosticRequest.queries:type_name -> trcshtalksdk.PluginQuery
-	2, // 2: trcshtalksdk.TrcshTalkService.RunDiagnostics:input_type -> trcshtalksdk.DiagnosticRequest
-	3, // 3: trcshtalksdk.TrcshTalkService.RunDiagnostics:output_type -> trcshtalksdk.DiagnosticResponse
-	3, // [3:4] is the sub-list for method output_type
-	2, // [2:3] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	2, // 1: trcshtalksdk.DiagnosticRequest.queries:type_name -> trcshtalksdk.PluginQuery
+	5, // 2: trcshtalksdk.DiagnosticResponse.diagnostic_results:type_name -> trcshtalksdk.DiagnosticResult
+	1, // 3: trcshtalksdk.DiagnosticResult.status:type_name -> trcshtalksdk.DiagnosticStatus
+	6, // 4: trcshtalksdk.DiagnosticResult.details:type_name -> trcshtalksdk.DiagnosticResult.DetailsEntry
+	3, // 5: trcshtalksdk.TrcshTalkService.RunDiagnostics:input_type -> trcshtalksdk.DiagnosticRequest
+	4, // 6: trcshtalksdk.TrcshTalkService.RunDiagnostics:output_type -> trcshtalksdk.DiagnosticResponse
+	6, // [6:7] is the sub-list for method output_type
+	5, // [5:6] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_trcshtalksdk_trcshtalksdk_proto_init() }
@@ -327,8 +530,8 @@ func file_trcshtalksdk_trcshtalksdk_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_trcshtalksdk_trcshtalksdk_proto_rawDesc,
-			NumEnums:      2,
-			NumMessages:   2,
+			NumEnums:      3,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
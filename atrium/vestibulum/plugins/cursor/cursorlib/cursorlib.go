@@ -303,6 +303,11 @@ func GetCursorPluginOpts(pluginName string, tlsProviderFunc func() (*tls.Config,
 				return nil
 			}
 
+			bkv.(*kv.PassthroughBackend).Clean = func(ctx context.Context) {
+				// Save the latest stats before the plugin goes away.
+				StopStats()
+			}
+
 			bkv.(*kv.PassthroughBackend).Paths = []*framework.Path{
 				{
 					Pattern:         "(dev|QA|staging|prod)",
@@ -0,0 +1,300 @@
+package cursorlib
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+type StatScope string
+
+const (
+	STAT_SCOPE_READ  StatScope = "read"
+	STAT_SCOPE_WRITE StatScope = "write"
+)
+
+const (
+	DEFAULT_STAT_CLIENT_REFRESH = time.Minute
+	MAX_STAT_DENIED_AUDIT       = 200
+	LEGACY_STAT_CLIENT          = "legacy"
+	STAT_DENIED_METRIC          = "trcstats_denied_attempts_total"
+)
+
+// StatClient is a single credential allowed to talk to the stat server.
+// A client authenticates either by bearer token or by the common name of a
+// verified mTLS client certificate.
+type StatClient struct {
+	Name      string
+	tokenHash []byte
+	CN        string
+	Read      bool
+	Write     bool
+	Prefixes  []string // Empty means all keys.
+}
+
+func (c *StatClient) allows(scope StatScope, key string) bool {
+	switch scope {
+	case STAT_SCOPE_READ:
+		if !c.Read {
+			return false
+		}
+	case STAT_SCOPE_WRITE:
+		if !c.Write {
+			return false
+		}
+	default:
+		return false
+	}
+	if len(c.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// StatDeniedAttempt records a rejected stat server call.
+type StatDeniedAttempt struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Peer   string    `json:"peer"`
+	Method string    `json:"method"`
+	Key    string    `json:"key"`
+	Scope  StatScope `json:"scope"`
+	Reason string    `json:"reason"`
+}
+
+type statClientSet struct {
+	byToken map[[sha256.Size]byte]*StatClient
+	byCN    map[string]*StatClient
+}
+
+// StatAuthorizer resolves callers to scoped clients.  The client set is
+// swapped atomically so credentials can be rotated while serving.
+type StatAuthorizer struct {
+	clients     atomic.Pointer[statClientSet]
+	deniedLock  sync.Mutex
+	denied      []StatDeniedAttempt
+	deniedCount uint64
+}
+
+var GlobalStatAuth = &StatAuthorizer{}
+
+func hashStatToken(token string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(token))
+}
+
+// ParseStatClients builds clients from a vault StatClients entry.  Each key
+// is a client name whose value is either a map or a json string with the
+// fields token, cn, scopes (comma separated read,write) and prefixes (comma
+// separated key prefixes).
+func ParseStatClients(data map[string]interface{}) ([]*StatClient, error) {
+	clients := []*StatClient{}
+	for name, entry := range data {
+		var fields map[string]interface{}
+		switch e := entry.(type) {
+		case map[string]interface{}:
+			fields = e
+		case string:
+			if err := json.Unmarshal([]byte(e), &fields); err != nil {
+				return nil, fmt.Errorf("invalid stat client %s: %v", name, err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid stat client %s", name)
+		}
+		client := &StatClient{Name: name}
+		if token, ok := fields["token"].(string); ok && len(token) > 0 {
+			h := hashStatToken(token)
+			client.tokenHash = h[:]
+		}
+		if cn, ok := fields["cn"].(string); ok {
+			client.CN = cn
+		}
+		if client.tokenHash == nil && len(client.CN) == 0 {
+			return nil, fmt.Errorf("stat client %s has neither token nor cn", name)
+		}
+		scopes, _ := fields["scopes"].(string)
+		for _, scope := range strings.Split(scopes, ",") {
+			switch StatScope(strings.TrimSpace(scope)) {
+			case STAT_SCOPE_READ:
+				client.Read = true
+			case STAT_SCOPE_WRITE:
+				client.Write = true
+			}
+		}
+		if prefixes, ok := fields["prefixes"].(string); ok {
+			for _, prefix := range strings.Split(prefixes, ",") {
+				if prefix = strings.TrimSpace(prefix); len(prefix) > 0 {
+					client.Prefixes = append(client.Prefixes, prefix)
+				}
+			}
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// LegacyStatClient wraps the single trcstatstoken as a full access client.
+func LegacyStatClient(token string) *StatClient {
+	h := hashStatToken(token)
+	return &StatClient{Name: LEGACY_STAT_CLIENT, tokenHash: h[:], Read: true, Write: true}
+}
+
+// SetClients atomically replaces the active client set.
+func (a *StatAuthorizer) SetClients(clients []*StatClient) {
+	set := &statClientSet{
+		byToken: map[[sha256.Size]byte]*StatClient{},
+		byCN:    map[string]*StatClient{},
+	}
+	for _, client := range clients {
+		if client.tokenHash != nil {
+			var h [sha256.Size]byte
+			copy(h[:], client.tokenHash)
+			set.byToken[h] = client
+		}
+		if len(client.CN) > 0 {
+			set.byCN[client.CN] = client
+		}
+	}
+	a.clients.Store(set)
+}
+
+func (a *StatAuthorizer) lookup(ctx context.Context, token string) (*StatClient, string) {
+	peerAddr := ""
+	var verifiedChains [][]*x509.Certificate
+	if p, hasPeer := peer.FromContext(ctx); hasPeer {
+		if p.Addr != nil {
+			peerAddr = p.Addr.String()
+		}
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			verifiedChains = tlsInfo.State.VerifiedChains
+		}
+	}
+	return a.resolve(token, verifiedChains), peerAddr
+}
+
+// resolve finds the client holding token, or else the client named by the
+// common name of a verified client certificate.
+func (a *StatAuthorizer) resolve(token string, verifiedChains [][]*x509.Certificate) *StatClient {
+	set := a.clients.Load()
+	if set == nil {
+		return nil
+	}
+	if len(token) > 0 {
+		h := hashStatToken(token)
+		if client, ok := set.byToken[h]; ok {
+			return client
+		}
+	}
+	for _, chain := range verifiedChains {
+		if len(chain) == 0 {
+			continue
+		}
+		if client, ok := set.byCN[chain[0].Subject.CommonName]; ok {
+			return client
+		}
+	}
+	return nil
+}
+
+// Authorize checks that the caller identified by token or mTLS identity may
+// perform scope on key.  Denied attempts are logged and kept for audit.
+func (a *StatAuthorizer) Authorize(ctx context.Context, method string, token string, key string, scope StatScope) error {
+	client, peerAddr := a.lookup(ctx, token)
+	return a.authorizeClient(client, peerAddr, method, key, scope)
+}
+
+func (a *StatAuthorizer) authorizeClient(client *StatClient, peerAddr string, method string, key string, scope StatScope) error {
+	if client != nil && client.allows(scope, key) {
+		return nil
+	}
+	attempt := StatDeniedAttempt{
+		Time:   time.Now(),
+		Peer:   peerAddr,
+		Method: method,
+		Key:    key,
+		Scope:  scope,
+		Reason: "unknown client",
+	}
+	if client != nil {
+		attempt.Client = client.Name
+		attempt.Reason = "scope not granted"
+	}
+	a.recordDenied(attempt)
+	return errors.New("unauthorized to access statistic server")
+}
+
+func (a *StatAuthorizer) recordDenied(attempt StatDeniedAttempt) {
+	if logger != nil {
+		logger.Printf("Denied stat %s for client '%s' from %s on key %s (%s): %s\n",
+			attempt.Method, attempt.Client, attempt.Peer, attempt.Key, attempt.Scope, attempt.Reason)
+	}
+	a.deniedLock.Lock()
+	defer a.deniedLock.Unlock()
+	a.deniedCount++
+	a.denied = append(a.denied, attempt)
+	if len(a.denied) > MAX_STAT_DENIED_AUDIT {
+		a.denied = a.denied[len(a.denied)-MAX_STAT_DENIED_AUDIT:]
+	}
+}
+
+// DeniedAttempts returns the most recent denied attempts and the total
+// number denied since startup.
+func (a *StatAuthorizer) DeniedAttempts() ([]StatDeniedAttempt, uint64) {
+	a.deniedLock.Lock()
+	defer a.deniedLock.Unlock()
+	return append([]StatDeniedAttempt{}, a.denied...), a.deniedCount
+}
+
+// MetricsHandler serves next only to clients with read access to every
+// stat, identified by an Authorization bearer token or a verified client
+// certificate.  The number of denied attempts is exposed alongside.
+func (a *StatAuthorizer) MetricsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		var verifiedChains [][]*x509.Certificate
+		if r.TLS != nil {
+			verifiedChains = r.TLS.VerifiedChains
+		}
+		if err := a.authorizeClient(a.resolve(token, verifiedChains), r.RemoteAddr, "Metrics", "", STAT_SCOPE_READ); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+		_, deniedCount := a.DeniedAttempts()
+		fmt.Fprintf(w, "# TYPE %s counter\n%s %d\n", STAT_DENIED_METRIC, STAT_DENIED_METRIC, deniedCount)
+	})
+}
+
+// RefreshLoop periodically reloads clients so rotated credentials take
+// effect without a restart.  On load failure the current clients are kept.
+func (a *StatAuthorizer) RefreshLoop(load func() ([]*StatClient, error), interval time.Duration) {
+	if interval <= 0 {
+		interval = DEFAULT_STAT_CLIENT_REFRESH
+	}
+	for {
+		time.Sleep(interval)
+		clients, err := load()
+		if err != nil {
+			if logger != nil {
+				logger.Printf("Failed to refresh stat clients: %v\n", err)
+			}
+			continue
+		}
+		a.SetClients(clients)
+	}
+}
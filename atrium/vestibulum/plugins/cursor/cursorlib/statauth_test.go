@@ -0,0 +1,129 @@
+package cursorlib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func testStatAuthorizer(t *testing.T) *StatAuthorizer {
+	clients, err := ParseStatClients(map[string]interface{}{
+		"reader":  map[string]interface{}{"token": "read-token", "scopes": "read", "prefixes": "app."},
+		"writer":  `{"token": "write-token", "scopes": "read,write"}`,
+		"scraper": map[string]interface{}{"cn": "scraper.example.com", "scopes": "read"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizer := &StatAuthorizer{}
+	authorizer.SetClients(append(clients, LegacyStatClient("legacy-token")))
+	return authorizer
+}
+
+func TestParseStatClients(t *testing.T) {
+	for name, entry := range map[string]interface{}{
+		"no credential": map[string]interface{}{"scopes": "read"},
+		"bad json":      "{",
+		"bad type":      1,
+	} {
+		if _, err := ParseStatClients(map[string]interface{}{"client": entry}); err == nil {
+			t.Fatalf("Expected %s rejected", name)
+		}
+	}
+}
+
+func TestStatAuthorize(t *testing.T) {
+	authorizer := testStatAuthorizer(t)
+	ctx := context.Background()
+	for _, allowed := range []struct {
+		token string
+		key   string
+		scope StatScope
+	}{
+		{"read-token", "app.requests", STAT_SCOPE_READ},
+		{"write-token", "other", STAT_SCOPE_WRITE},
+		{"legacy-token", "other", STAT_SCOPE_WRITE},
+	} {
+		if err := authorizer.Authorize(ctx, "Test", allowed.token, allowed.key, allowed.scope); err != nil {
+			t.Fatalf("Expected %s allowed %s on %s", allowed.token, allowed.scope, allowed.key)
+		}
+	}
+	for _, denied := range []struct {
+		token string
+		key   string
+		scope StatScope
+	}{
+		{"read-token", "app.requests", STAT_SCOPE_WRITE},
+		{"read-token", "other", STAT_SCOPE_READ},
+		{"unknown", "app.requests", STAT_SCOPE_READ},
+		{"", "app.requests", STAT_SCOPE_READ},
+	} {
+		if err := authorizer.Authorize(ctx, "Test", denied.token, denied.key, denied.scope); err == nil {
+			t.Fatalf("Expected %s denied %s on %s", denied.token, denied.scope, denied.key)
+		}
+	}
+	attempts, deniedCount := authorizer.DeniedAttempts()
+	if deniedCount != 4 || len(attempts) != 4 || attempts[0].Client != "reader" || attempts[0].Reason != "scope not granted" || attempts[2].Reason != "unknown client" {
+		t.Fatalf("Unexpected denied attempts %d %+v", deniedCount, attempts)
+	}
+
+	// A verified client certificate stands in for a token.
+	chains := [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "scraper.example.com"}}}}
+	certCtx := peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: chains}}})
+	if err := authorizer.Authorize(certCtx, "Test", "", "any", STAT_SCOPE_READ); err != nil {
+		t.Fatalf("Expected the client certificate allowed %v", err)
+	}
+	if err := authorizer.Authorize(certCtx, "Test", "", "any", STAT_SCOPE_WRITE); err == nil {
+		t.Fatalf("Expected the client certificate denied write")
+	}
+
+	// Rotated clients take effect at once.
+	authorizer.SetClients([]*StatClient{LegacyStatClient("rotated")})
+	if err := authorizer.Authorize(ctx, "Test", "legacy-token", "other", STAT_SCOPE_READ); err == nil {
+		t.Fatalf("Expected the rotated token denied")
+	}
+	for i := 0; i < MAX_STAT_DENIED_AUDIT+10; i++ {
+		authorizer.Authorize(ctx, "Test", "", "key", STAT_SCOPE_READ)
+	}
+	if attempts, deniedCount := authorizer.DeniedAttempts(); len(attempts) != MAX_STAT_DENIED_AUDIT || deniedCount != uint64(MAX_STAT_DENIED_AUDIT+16) {
+		t.Fatalf("Expected the audit bounded, got %d of %d", len(attempts), deniedCount)
+	}
+}
+
+func TestStatMetricsHandler(t *testing.T) {
+	authorizer := testStatAuthorizer(t)
+	store := NewStatStore(0)
+	store.Set("app.requests", "3", "int")
+	handler := authorizer.MetricsHandler(store)
+
+	scrape := func(token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if len(token) > 0 {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+	for _, token := range []string{"", "unknown", "read-token"} {
+		// read-token only reads app. keys, not every stat.
+		if response := scrape(token); response.Code != http.StatusUnauthorized || strings.Contains(response.Body.String(), "app_requests") {
+			t.Fatalf("Expected %q unauthorized, got %d", token, response.Code)
+		}
+	}
+	response := scrape("write-token")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "app_requests 3\n") {
+		t.Fatalf("Unexpected metrics %d %s", response.Code, response.Body.String())
+	}
+	if !strings.Contains(response.Body.String(), STAT_DENIED_METRIC+" 3\n") {
+		t.Fatalf("Expected the denied attempts exposed:\n%s", response.Body.String())
+	}
+}
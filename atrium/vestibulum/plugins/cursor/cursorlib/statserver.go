@@ -3,13 +3,15 @@ package cursorlib
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	tccore "github.com/trimble-oss/tierceron-core/v2/core"
@@ -22,35 +24,35 @@ import (
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 
-	cmap "github.com/orcaman/concurrent-map/v2"
-
 	pb "github.com/trimble-oss/tierceron-core/v2/statsdk"
+	sqpb "github.com/trimble-oss/tierceron/atrium/vestibulum/plugins/cursor/statquerysdk"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
 
 	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
 	sys "github.com/trimble-oss/tierceron/pkg/vaulthelper/system"
 )
 
-var GlobalStats *cmap.ConcurrentMap[string, string]
-
-var globalToken *string
+var GlobalStats *StatStore
 
-var m sync.Mutex
+var statPersistCancel context.CancelFunc
+var statPersistDone chan struct{}
 
 type statServiceServer struct {
 	pb.UnimplementedStatServiceServer
 }
 
+type statQueryServiceServer struct {
+	sqpb.UnimplementedStatQueryServiceServer
+}
+
 func (s *statServiceServer) GetStats(ctx context.Context, req *pb.GetStatRequest) (*pb.GetStatResponse, error) {
-	token := req.GetToken()
-	if !eUtils.RefEquals(globalToken, token) {
-		logger.Println("Unauthorized attempt to access statistics.")
+	if err := GlobalStatAuth.Authorize(ctx, "GetStats", req.GetToken(), req.GetKey(), STAT_SCOPE_READ); err != nil {
 		return &pb.GetStatResponse{
 			Results: "",
-		}, errors.New("unauthorized to access statistic server")
+		}, err
 	}
 	key := req.GetKey()
-	value, ok := (*GlobalStats).Get(key)
+	value, ok := GlobalStats.Get(key)
 	if !ok {
 		return &pb.GetStatResponse{
 			Results: "",
@@ -62,88 +64,30 @@ func (s *statServiceServer) GetStats(ctx context.Context, req *pb.GetStatRequest
 }
 
 func (s *statServiceServer) SetStats(ctx context.Context, req *pb.UpdateStatRequest) (*pb.UpdateStatResponse, error) {
-	token := req.GetToken()
-	if !eUtils.RefEquals(globalToken, token) {
-		logger.Println("Unauthorized attempt to set statistics.")
+	if err := GlobalStatAuth.Authorize(ctx, "SetStats", req.GetToken(), req.GetKey(), STAT_SCOPE_WRITE); err != nil {
 		return &pb.UpdateStatResponse{
 			Success: false,
-		}, errors.New("unauthorized to set statistics in server")
+		}, err
 	}
-	key := req.GetKey()
-	value := req.GetValue()
-	(*GlobalStats).Set(key, value)
+	GlobalStats.Set(req.GetKey(), req.GetValue(), req.GetDatatype())
 	return &pb.UpdateStatResponse{
 		Success: true,
 	}, nil
 }
 
 func (s *statServiceServer) IncrementStats(ctx context.Context, req *pb.UpdateStatRequest) (*pb.UpdateStatResponse, error) {
-	token := req.GetToken()
-	if !eUtils.RefEquals(globalToken, token) {
-		logger.Println("Unauthorized attempt to set statistics.")
+	if err := GlobalStatAuth.Authorize(ctx, "IncrementStats", req.GetToken(), req.GetKey(), STAT_SCOPE_WRITE); err != nil {
 		return &pb.UpdateStatResponse{
 			Success: false,
-		}, errors.New("unauthorized to set statistics in server")
+		}, err
 	}
-	m.Lock()
-	defer m.Unlock()
-	data_type := req.GetDatatype()
-	key := req.GetKey()
-	value := req.GetValue()
-	prev_value, ok := (*GlobalStats).Get(key)
-	var err error
-	var total_value string
-	if data_type == "int" {
-		total := 0
-		if ok {
-			total, err = strconv.Atoi(prev_value)
-			if err != nil {
-				logger.Printf("error converting stats for incrementing int value: %v\n", err)
-				return &pb.UpdateStatResponse{
-					Success: false,
-				}, errors.New("error converting stats for incrementing int value")
-			}
-		}
-		toAdd := 0
-		if v, err := strconv.Atoi(value); err == nil {
-			toAdd = v
-		} else {
-			logger.Println("Different type of value passed in than specified.")
-			return &pb.UpdateStatResponse{
-				Success: false,
-			}, errors.New("different type of value passed in than specified")
-		}
-		total = total + toAdd
-		total_value = strconv.Itoa(total)
-	} else if data_type == "float64" {
-		var prev float64 = 0
-		if ok {
-			prev, err = strconv.ParseFloat(prev_value, 64)
-			if err != nil {
-				logger.Printf("error converting stats for incrementing float value: %v", err)
-				return &pb.UpdateStatResponse{
-					Success: false,
-				}, errors.New("error converting stats for incrementing float value")
-			}
-		}
-		var toAdd float64 = 0
-		if t, err := strconv.ParseFloat(value, 64); err == nil {
-			toAdd = t
-		} else {
-			logger.Println("Different type of value passed in than specified.")
-			return &pb.UpdateStatResponse{
-				Success: false,
-			}, errors.New("different type of value passed in than specified")
-		}
-		total_value = fmt.Sprintf("%v", prev+toAdd)
-	} else {
-		logger.Println("Unsupported data type for statistics server.")
+	err := GlobalStats.Increment(req.GetKey(), req.GetValue(), req.GetDatatype())
+	if err != nil {
+		logger.Printf("error incrementing stats for %s: %v\n", req.GetKey(), err)
 		return &pb.UpdateStatResponse{
 			Success: false,
-		}, errors.New("unsupported data type for statistics server")
+		}, err
 	}
-
-	(*GlobalStats).Set(key, total_value)
 	return &pb.UpdateStatResponse{
 		Success: true,
 	}, nil
@@ -156,99 +100,96 @@ func resetLongestConverting(key string) error {
 		durationUntilNextHour := nextHour.Sub(currentTime)
 		time.Sleep(durationUntilNextHour)
 		var t float64 = 0
-		(*GlobalStats).Set(key, fmt.Sprintf("%f", t))
+		GlobalStats.Set(key, fmt.Sprintf("%f", t), "float64")
 	}
 }
 
 func (s *statServiceServer) UpdateMaxStats(ctx context.Context, req *pb.UpdateStatRequest) (*pb.UpdateStatResponse, error) {
-	token := req.GetToken()
-	if !eUtils.RefEquals(globalToken, token) {
-		logger.Println("Unauthorized attempt to update max statistics.")
+	if err := GlobalStatAuth.Authorize(ctx, "UpdateMaxStats", req.GetToken(), req.GetKey(), STAT_SCOPE_WRITE); err != nil {
 		return &pb.UpdateStatResponse{
 			Success: false,
-		}, errors.New("unauthorized to update max statistics in server")
+		}, err
 	}
-	m.Lock()
-	defer m.Unlock()
-	data_type := req.GetDatatype()
 	key := req.GetKey()
-	value := req.GetValue()
-	prev_value, ok := (*GlobalStats).Get(key)
-	if !ok && strings.HasPrefix(key, "LONGEST_PDF_CONVERTING") {
+	if !GlobalStats.Exists(key) && strings.HasPrefix(key, "LONGEST_PDF_CONVERTING") {
 		go resetLongestConverting(key)
 	}
-	var err error
-	var max_value string
-	reset := false
-	if data_type == "int" {
-		old_val := 0
-		if ok {
-			old_val, err = strconv.Atoi(prev_value)
-			if err != nil {
-				logger.Printf("error converting stats for updating max int value: %v", err)
-				return &pb.UpdateStatResponse{
-					Success: false,
-				}, errors.New("error converting stats for updating max int value")
-			}
-		}
-		new_val := 0
-		if v, err := strconv.Atoi(value); err == nil {
-			new_val = v
-		} else {
-			logger.Println("Different type of value passed in than specified.")
-			return &pb.UpdateStatResponse{
-				Success: false,
-			}, errors.New("different type of value passed in than specified")
-		}
-		if new_val > old_val {
-			reset = true
-			old_val = new_val
-		}
-		max_value = strconv.Itoa(old_val)
-	} else if data_type == "float64" {
-		var prev float64 = 0
-		if ok {
-			prev, err = strconv.ParseFloat(prev_value, 64)
-			if err != nil {
-				logger.Printf("error converting stats for updating max float value: %v", err)
-				return &pb.UpdateStatResponse{
-					Success: false,
-				}, errors.New("error converting stats for updating max float value")
-			}
-		}
-		var new_val float64 = 0
-		if t, err := strconv.ParseFloat(value, 64); err == nil {
-			new_val = t
-		} else {
-			logger.Println("Different type of value passed in than specified.")
-			return &pb.UpdateStatResponse{
-				Success: false,
-			}, errors.New("different type of value passed in than specified")
-		}
-		if new_val > prev {
-			reset = true
-			prev = new_val
-		}
-		max_value = fmt.Sprintf("%v", prev)
-	} else {
-		logger.Println("Unsupported data type for updating max in statistics server.")
+	reset, err := GlobalStats.UpdateMax(key, req.GetValue(), req.GetDatatype())
+	if err != nil {
+		logger.Printf("error updating max stats for %s: %v\n", key, err)
 		return &pb.UpdateStatResponse{
 			Success: false,
-		}, errors.New("unsupported data type for updating max in statistics server")
+		}, err
 	}
-
-	(*GlobalStats).Set(key, max_value)
 	return &pb.UpdateStatResponse{
 		Success: reset,
 	}, nil
 }
 
-func InitStats() {
-	ccmap := cmap.New[string]()
-	GlobalStats = &ccmap
+func (s *statQueryServiceServer) GetStatsRange(ctx context.Context, req *sqpb.GetStatRangeRequest) (*sqpb.GetStatRangeResponse, error) {
+	if err := GlobalStatAuth.Authorize(ctx, "GetStatsRange", req.GetToken(), req.GetKey(), STAT_SCOPE_READ); err != nil {
+		return &sqpb.GetStatRangeResponse{}, err
+	}
+	start := time.Unix(req.GetStartTime(), 0)
+	end := time.Now()
+	if req.GetEndTime() > 0 {
+		end = time.Unix(req.GetEndTime(), 0)
+	}
+	percentiles := req.GetPercentiles()
+	if len(percentiles) == 0 {
+		percentiles = DefaultStatPercentiles
+	}
+	statRange, err := GlobalStats.Range(req.GetKey(), start, end, percentiles)
+	if err != nil {
+		return &sqpb.GetStatRangeResponse{Key: req.GetKey()}, err
+	}
+	response := &sqpb.GetStatRangeResponse{
+		Key:      statRange.Key,
+		StatType: string(statRange.Type),
+		Min:      statRange.Min,
+		Max:      statRange.Max,
+		Sum:      statRange.Sum,
+		Count:    statRange.Count,
+	}
+	for _, sample := range statRange.Samples {
+		response.Samples = append(response.Samples, &sqpb.StatSample{Time: sample.Time, Value: sample.Value})
+	}
+	for _, p := range percentiles {
+		response.Percentiles = append(response.Percentiles, &sqpb.StatPercentile{Percentile: p, Value: statRange.Percentiles[p]})
+	}
+	return response, nil
 }
 
-func InitServer(port int, certBytes []byte, keyBytes []byte) (net.Listener, *grpc.Server, error) {
+// InitStats initializes the stat store, restoring previously persisted stats
+// when a persister is provided.
+func InitStats(retention time.Duration, persister StatPersister) {
+	GlobalStats = NewStatStore(retention)
+	if persister != nil {
+		snapshot, err := persister.Load()
+		if err != nil {
+			logger.Printf("Unable to load persisted statistics: %v\n", err)
+		} else if len(snapshot) > 0 {
+			if err := GlobalStats.Restore(snapshot); err != nil {
+				logger.Printf("Unable to restore persisted statistics: %v\n", err)
+			}
+		}
+	}
+}
+
+// StopStats stops persisting stats, waiting for the final save.
+func StopStats() {
+	if statPersistCancel == nil {
+		return
+	}
+	statPersistCancel()
+	<-statPersistDone
+	statPersistCancel = nil
+}
+
+// InitServer starts a tls listener for the stat server.  When clientCA is
+// provided, client certificates signed by it are verified and their common
+// name may be used in place of a bearer token.
+func InitServer(port int, certBytes []byte, keyBytes []byte, clientCA []byte) (net.Listener, *grpc.Server, error) {
 	var err error
 
 	cert, err := tls.X509KeyPair(certBytes, keyBytes)
@@ -256,6 +197,18 @@ func InitServer(port int, certBytes []byte, keyBytes []byte) (net.Listener, *grp
 		log.Printf("Couldn't construct key pair: %v\n", err) //Should this just return instead?? - no panic
 	}
 	creds := credentials.NewServerTLSFromCert(&cert)
+	if len(clientCA) > 0 {
+		clientCAPool := x509.NewCertPool()
+		if !clientCAPool.AppendCertsFromPEM(clientCA) {
+			return nil, nil, errors.New("invalid stat client ca")
+		}
+		creds = credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+			ClientCAs:    clientCAPool,
+			MinVersion:   tls.VersionTLS12,
+		})
+	}
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
@@ -315,12 +268,36 @@ func StatServerInit(trcshDriverConfig *capauth.TrcshDriverConfig, pluginConfig m
 		return err
 	}
 
-	if t, ok := certifyMap["trcstatstoken"].(string); ok {
-		globalToken = &t
-	} else {
-		logger.Printf("No valid token found for trcstats server.\n")
+	statClients, err := statClientsFromVault(goMod, pluginName, certifyMap)
+	if err != nil {
+		logger.Printf("Invalid stat clients for trcstats server: %v\n", err)
 		return err
 	}
+	if len(statClients) == 0 {
+		logger.Printf("No valid token found for trcstats server.\n")
+		return errors.New("no valid token found for trcstats server")
+	}
+	GlobalStatAuth.SetClients(statClients)
+	clientRefresh := DEFAULT_STAT_CLIENT_REFRESH
+	if r, ok := certifyMap["trcstatsclientrefresh"].(string); ok && len(r) > 0 {
+		if d, err := time.ParseDuration(r); err == nil && d > 0 {
+			clientRefresh = d
+		} else {
+			logger.Printf("Invalid trcstatsclientrefresh %s, using default.\n", r)
+		}
+	}
+	go GlobalStatAuth.RefreshLoop(func() ([]*StatClient, error) {
+		var clients []*StatClient
+		err := withStatVaultMod(trcshDriverConfig, pluginConfig, func(mod *helperkv.Modifier) error {
+			var loadErr error
+			clients, loadErr = statClientsFromVault(mod, pluginName, nil)
+			if loadErr == nil && len(clients) == 0 {
+				loadErr = errors.New("no stat clients found")
+			}
+			return loadErr
+		})
+		return clients, err
+	}, clientRefresh)
 
 	if portInterface, ok := certifyMap["trcstatsport"]; ok {
 		var trcstatsport int
@@ -353,11 +330,43 @@ func StatServerInit(trcshDriverConfig *capauth.TrcshDriverConfig, pluginConfig m
 		}
 
 		// Initialize the stats.
-		InitStats()
+		retention := DEFAULT_STAT_RETENTION
+		if r, ok := certifyMap["trcstatsretention"].(string); ok && len(r) > 0 {
+			if d, err := time.ParseDuration(r); err == nil && d > 0 {
+				retention = d
+			} else {
+				logger.Printf("Invalid trcstatsretention %s, using default.\n", r)
+			}
+		}
+		persistInterval := DEFAULT_STAT_PERSIST_INTERVAL
+		if i, ok := certifyMap["trcstatspersistinterval"].(string); ok && len(i) > 0 {
+			if d, err := time.ParseDuration(i); err == nil && d > 0 {
+				persistInterval = d
+			} else {
+				logger.Printf("Invalid trcstatspersistinterval %s, using default.\n", i)
+			}
+		}
+		persister := newStatPersister(trcshDriverConfig, pluginConfig, pluginName, certifyMap)
+		InitStats(retention, persister)
+		var persistCtx context.Context
+		persistCtx, statPersistCancel = context.WithCancel(context.Background())
+		statPersistDone = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			GlobalStats.PersistLoop(persistCtx, persister, persistInterval, func(err error) {
+				logger.Printf("Failed to persist statistics: %v\n", err)
+			})
+		}(statPersistDone)
+
+		var clientCA []byte
+		if ca, ok := certifyMap["trcstatsclientca"].(string); ok && len(ca) > 0 {
+			clientCA = []byte(ca)
+		}
 
 		lis, gServer, err := InitServer(trcstatsport,
 			statCert,
-			statKey)
+			statKey,
+			clientCA)
 		if err != nil {
 			logger.Printf("Failed to start server: %v", err)
 			return err
@@ -367,6 +376,7 @@ func StatServerInit(trcshDriverConfig *capauth.TrcshDriverConfig, pluginConfig m
 		grpcServer := gServer
 		grpc_health_v1.RegisterHealthServer(grpcServer, health.NewServer())
 		pb.RegisterStatServiceServer(grpcServer, &statServiceServer{})
+		sqpb.RegisterStatQueryServiceServer(grpcServer, &statQueryServiceServer{})
 		// reflection.Register(grpcServer)
 		// addr := lis.Addr().String()
 		logger.Printf("server listening at %v", lis.Addr())
@@ -376,6 +386,130 @@ func StatServerInit(trcshDriverConfig *capauth.TrcshDriverConfig, pluginConfig m
 				return
 			}
 		}(lis, logger)
+
+		if metricsPortInterface, ok := certifyMap["trcstatsmetricsport"]; ok {
+			metricsPort, err := strconv.Atoi(fmt.Sprintf("%v", metricsPortInterface))
+			if err != nil {
+				logger.Printf("Failed to process metrics port: %v", err)
+				return err
+			}
+			cert, err := tls.X509KeyPair(statCert, statKey)
+			if err != nil {
+				logger.Printf("Couldn't construct metrics key pair: %v\n", err)
+				return err
+			}
+			metricsTLSConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+			if len(clientCA) > 0 {
+				clientCAPool := x509.NewCertPool()
+				clientCAPool.AppendCertsFromPEM(clientCA)
+				metricsTLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+				metricsTLSConfig.ClientCAs = clientCAPool
+			}
+			metricsServer := &http.Server{
+				Addr:      fmt.Sprintf(":%d", metricsPort),
+				Handler:   GlobalStatAuth.MetricsHandler(GlobalStats),
+				TLSConfig: metricsTLSConfig,
+			}
+			logger.Printf("metrics listening at :%d", metricsPort)
+			go func(logger *log.Logger) {
+				if err := metricsServer.ListenAndServeTLS("", ""); err != nil {
+					logger.Println("Failed to serve metrics:", err)
+				}
+			}(logger)
+		}
 	}
 	return nil
 }
+
+// newStatPersister picks the stat persistence backend from the plugin's
+// certification: vault (default), local (encrypted file) or none.
+func newStatPersister(trcshDriverConfig *capauth.TrcshDriverConfig, pluginConfig map[string]interface{}, pluginName string, certifyMap map[string]interface{}) StatPersister {
+	store, _ := certifyMap["trcstatsstore"].(string)
+	switch store {
+	case "none":
+		return nil
+	case "local":
+		path, _ := certifyMap["trcstatspath"].(string)
+		encodedKey, _ := certifyMap["trcstatskey"].(string)
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if len(path) == 0 || err != nil || len(key) == 0 {
+			logger.Println("Local stat store requires trcstatspath and a base64 trcstatskey.  Statistics will not be persisted.")
+			return nil
+		}
+		return &LocalStatPersister{Path: path, Key: key}
+	default:
+		return &VaultStatPersister{
+			Path: fmt.Sprintf("super-secrets/Index/TrcVault/trcplugin/%s/Stats", pluginName),
+			Write: func(path string, data map[string]interface{}) error {
+				return withStatVaultMod(trcshDriverConfig, pluginConfig, func(mod *helperkv.Modifier) error {
+					_, err := mod.Write(path, data, trcshDriverConfig.DriverConfig.CoreConfig.Log)
+					return err
+				})
+			},
+			Read: func(path string) (map[string]interface{}, error) {
+				var data map[string]interface{}
+				err := withStatVaultMod(trcshDriverConfig, pluginConfig, func(mod *helperkv.Modifier) error {
+					var readErr error
+					data, readErr = mod.ReadData(path)
+					return readErr
+				})
+				return data, err
+			},
+		}
+	}
+}
+
+// withStatVaultMod runs action against a short lived config modifier.  The
+// modifier used during init is released once init completes, so background
+// work (persistence, credential refresh) acquires its own.
+func withStatVaultMod(trcshDriverConfig *capauth.TrcshDriverConfig, pluginConfig map[string]interface{}, action func(mod *helperkv.Modifier) error) error {
+	statConfig := map[string]interface{}{}
+	for k, v := range pluginConfig {
+		statConfig[k] = v
+	}
+	if cAddr, cAddressOk := statConfig["caddress"].(string); cAddressOk && len(cAddr) > 0 {
+		statConfig["vaddress"] = cAddr
+	}
+	if cTokenPtr, cTokOk := statConfig["ctokenptr"].(*string); cTokOk && eUtils.RefLength(cTokenPtr) > 0 {
+		statConfig["tokenptr"] = cTokenPtr
+	}
+	_, mod, vault, err := eUtils.InitVaultModForPlugin(statConfig,
+		trcshDriverConfig.DriverConfig.CoreConfig.TokenCache,
+		"config_token_pluginany", trcshDriverConfig.DriverConfig.CoreConfig.Log)
+	if vault != nil {
+		defer vault.Close()
+	}
+	if mod != nil {
+		defer mod.Release()
+	}
+	if err != nil {
+		return err
+	}
+	return action(mod)
+}
+
+// statClientsFromVault assembles the stat server clients: the legacy
+// trcstatstoken from Certify (full access) plus any scoped clients in
+// StatClients.
+func statClientsFromVault(mod *helperkv.Modifier, pluginName string, certifyMap map[string]interface{}) ([]*StatClient, error) {
+	clients := []*StatClient{}
+	if certifyMap == nil {
+		var err error
+		certifyMap, err = mod.ReadData(fmt.Sprintf("super-secrets/Index/TrcVault/trcplugin/%s/Certify", pluginName))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t, ok := certifyMap["trcstatstoken"].(string); ok && len(t) > 0 {
+		clients = append(clients, LegacyStatClient(t))
+	}
+	statClientData, err := mod.ReadData(fmt.Sprintf("super-secrets/Index/TrcVault/trcplugin/%s/StatClients", pluginName))
+	if err == nil && statClientData != nil {
+		scoped, err := ParseStatClients(statClientData)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, scoped...)
+	}
+	return clients, nil
+}
@@ -0,0 +1,514 @@
+package cursorlib
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type StatType string
+
+const (
+	STAT_COUNTER   StatType = "counter"
+	STAT_GAUGE     StatType = "gauge"
+	STAT_HISTOGRAM StatType = "histogram"
+)
+
+const (
+	DEFAULT_STAT_RETENTION        = 24 * time.Hour
+	DEFAULT_STAT_PERSIST_INTERVAL = 5 * time.Minute
+	STAT_SAMPLE_RESOLUTION        = time.Minute
+	MAX_HISTOGRAM_SAMPLES         = 10000
+)
+
+var DefaultStatPercentiles = []float64{50, 90, 99}
+
+// StatSample is a single point in a stat time series.
+type StatSample struct {
+	Time  int64   `json:"t"` // Unix seconds
+	Value float64 `json:"v"`
+}
+
+type statSeries struct {
+	Type     StatType     `json:"type"`
+	Datatype string       `json:"datatype"` // int or float64, how values are reported back
+	Current  float64      `json:"current"`
+	Raw      string       `json:"raw,omitempty"` // Non numeric values set through SetStats
+	Samples  []StatSample `json:"samples"`
+}
+
+// StatStore keeps stats as typed time series bounded by a retention window.
+type StatStore struct {
+	lock      sync.Mutex
+	series    map[string]*statSeries
+	Retention time.Duration
+}
+
+func NewStatStore(retention time.Duration) *StatStore {
+	if retention <= 0 {
+		retention = DEFAULT_STAT_RETENTION
+	}
+	return &StatStore{
+		series:    map[string]*statSeries{},
+		Retention: retention,
+	}
+}
+
+func parseStatValue(datatype string, value string) (float64, error) {
+	switch datatype {
+	case "int":
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, errors.New("different type of value passed in than specified")
+		}
+		return float64(v), nil
+	case "float64", "histogram":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, errors.New("different type of value passed in than specified")
+		}
+		return v, nil
+	}
+	return 0, errors.New("unsupported data type for statistics server")
+}
+
+func formatStatValue(datatype string, value float64) string {
+	if datatype == "int" {
+		return strconv.Itoa(int(value))
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// getSeries returns the series for key, creating it if needed.  Caller holds lock.
+func (s *StatStore) getSeries(key string, statType StatType, datatype string) *statSeries {
+	series, ok := s.series[key]
+	if !ok {
+		series = &statSeries{Type: statType, Datatype: datatype}
+		s.series[key] = series
+	}
+	return series
+}
+
+// record adds a sample, collapsing samples within the same resolution bucket.  Caller holds lock.
+func (s *StatStore) record(series *statSeries, now time.Time, value float64) {
+	if series.Type == STAT_HISTOGRAM {
+		series.Samples = append(series.Samples, StatSample{Time: now.Unix(), Value: value})
+		if len(series.Samples) > MAX_HISTOGRAM_SAMPLES {
+			series.Samples = series.Samples[len(series.Samples)-MAX_HISTOGRAM_SAMPLES:]
+		}
+		return
+	}
+	bucket := now.Truncate(STAT_SAMPLE_RESOLUTION).Unix()
+	if n := len(series.Samples); n > 0 && series.Samples[n-1].Time == bucket {
+		series.Samples[n-1].Value = value
+		return
+	}
+	series.Samples = append(series.Samples, StatSample{Time: bucket, Value: value})
+}
+
+// Get returns the current value of key as reported by GetStats.
+func (s *StatStore) Get(key string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	series, ok := s.series[key]
+	if !ok {
+		return "", false
+	}
+	if len(series.Raw) > 0 {
+		return series.Raw, true
+	}
+	return formatStatValue(series.Datatype, series.Current), true
+}
+
+// Set stores a gauge value, or observes a histogram value when datatype is histogram.
+func (s *StatStore) Set(key string, value string, datatype string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if datatype == "histogram" {
+		if v, err := parseStatValue(datatype, value); err == nil {
+			series := s.getSeries(key, STAT_HISTOGRAM, "float64")
+			series.Current = v
+			s.record(series, time.Now(), v)
+			return
+		}
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		// Not a numeric stat, keep it around for lookups only.
+		series := s.getSeries(key, STAT_GAUGE, "")
+		series.Raw = value
+		return
+	}
+	if len(datatype) == 0 {
+		datatype = "float64"
+		if _, err := strconv.Atoi(value); err == nil {
+			datatype = "int"
+		}
+	}
+	series := s.getSeries(key, STAT_GAUGE, datatype)
+	series.Raw = ""
+	series.Current = v
+	s.record(series, time.Now(), v)
+}
+
+// Increment adds value to the counter stored at key.
+func (s *StatStore) Increment(key string, value string, datatype string) error {
+	toAdd, err := parseStatValue(datatype, value)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	series := s.getSeries(key, STAT_COUNTER, datatype)
+	if len(series.Raw) > 0 {
+		return errors.New("error converting stats for incrementing value")
+	}
+	series.Current = series.Current + toAdd
+	s.record(series, time.Now(), series.Current)
+	return nil
+}
+
+// UpdateMax stores value at key if it is larger than the current value.
+// Returns true if the max was replaced.
+func (s *StatStore) UpdateMax(key string, value string, datatype string) (bool, error) {
+	newValue, err := parseStatValue(datatype, value)
+	if err != nil {
+		return false, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	series, ok := s.series[key]
+	if ok && len(series.Raw) > 0 {
+		return false, errors.New("error converting stats for updating max value")
+	}
+	if !ok {
+		series = s.getSeries(key, STAT_GAUGE, datatype)
+	}
+	replaced := false
+	if newValue > series.Current {
+		series.Current = newValue
+		replaced = true
+	}
+	s.record(series, time.Now(), series.Current)
+	return replaced, nil
+}
+
+// Exists returns true if a stat has been recorded for key.
+func (s *StatStore) Exists(key string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.series[key]
+	return ok
+}
+
+// StatRange is the result of a range query over a single stat.
+type StatRange struct {
+	Key         string
+	Type        StatType
+	Samples     []StatSample
+	Min         float64
+	Max         float64
+	Sum         float64
+	Count       int64
+	Percentiles map[float64]float64
+}
+
+// Range returns the samples for key recorded between start and end along with
+// summary values and the requested percentiles.
+func (s *StatStore) Range(key string, start time.Time, end time.Time, percentiles []float64) (*StatRange, error) {
+	s.lock.Lock()
+	series, ok := s.series[key]
+	if !ok {
+		s.lock.Unlock()
+		return nil, fmt.Errorf("no statistic found for %s", key)
+	}
+	statRange := &StatRange{Key: key, Type: series.Type, Percentiles: map[float64]float64{}}
+	for _, sample := range series.Samples {
+		if sample.Time < start.Unix() || sample.Time > end.Unix() {
+			continue
+		}
+		statRange.Samples = append(statRange.Samples, sample)
+	}
+	s.lock.Unlock()
+
+	if len(statRange.Samples) == 0 {
+		return statRange, nil
+	}
+	values := make([]float64, 0, len(statRange.Samples))
+	statRange.Min = math.Inf(1)
+	statRange.Max = math.Inf(-1)
+	for _, sample := range statRange.Samples {
+		values = append(values, sample.Value)
+		statRange.Min = math.Min(statRange.Min, sample.Value)
+		statRange.Max = math.Max(statRange.Max, sample.Value)
+		statRange.Sum += sample.Value
+	}
+	statRange.Count = int64(len(values))
+	sort.Float64s(values)
+	for _, p := range percentiles {
+		statRange.Percentiles[p] = percentile(values, p)
+	}
+	return statRange, nil
+}
+
+// percentile uses nearest rank over already sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return sorted[0]
+	}
+	if p >= 100 {
+		return sorted[len(sorted)-1]
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// Compact drops samples that fall outside of the retention window.
+func (s *StatStore) Compact() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	cutoff := time.Now().Add(-s.Retention).Unix()
+	for _, series := range s.series {
+		i := sort.Search(len(series.Samples), func(i int) bool { return series.Samples[i].Time >= cutoff })
+		if i > 0 {
+			series.Samples = append([]StatSample{}, series.Samples[i:]...)
+		}
+	}
+}
+
+// Snapshot serializes the store for persistence.
+func (s *StatStore) Snapshot() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return json.Marshal(s.series)
+}
+
+// Restore loads a snapshot previously produced by Snapshot.
+func (s *StatStore) Restore(snapshot []byte) error {
+	restored := map[string]*statSeries{}
+	if err := json.Unmarshal(snapshot, &restored); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.series = restored
+	s.lock.Unlock()
+	s.Compact()
+	return nil
+}
+
+// StatPersister saves and loads stat store snapshots.
+type StatPersister interface {
+	Save(snapshot []byte) error
+	Load() ([]byte, error)
+}
+
+// VaultStatPersister stores snapshots in a vault path.
+type VaultStatPersister struct {
+	Path  string
+	Write func(path string, data map[string]interface{}) error
+	Read  func(path string) (map[string]interface{}, error)
+}
+
+func (v *VaultStatPersister) Save(snapshot []byte) error {
+	return v.Write(v.Path, map[string]interface{}{
+		"snapshot":  string(snapshot),
+		"persisted": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+func (v *VaultStatPersister) Load() ([]byte, error) {
+	data, err := v.Read(v.Path)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot, ok := data["snapshot"].(string); ok {
+		return []byte(snapshot), nil
+	}
+	return nil, nil
+}
+
+// LocalStatPersister stores AES-GCM encrypted snapshots in a local file.
+type LocalStatPersister struct {
+	Path string
+	Key  []byte // 16, 24 or 32 bytes
+}
+
+func (l *LocalStatPersister) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(l.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (l *LocalStatPersister) Save(snapshot []byte) error {
+	gcm, err := l.gcm()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, snapshot, nil)
+	tmpPath := l.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, sealed, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, l.Path)
+}
+
+func (l *LocalStatPersister) Load() ([]byte, error) {
+	sealed, err := os.ReadFile(l.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	gcm, err := l.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("stat store file is corrupt")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// PersistLoop periodically compacts the store and saves it with persister
+// until ctx is done, then saves it a final time.
+func (s *StatStore) PersistLoop(ctx context.Context, persister StatPersister, interval time.Duration, onError func(error)) {
+	if interval <= 0 {
+		interval = DEFAULT_STAT_PERSIST_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.persist(persister, onError)
+			return
+		case <-ticker.C:
+			s.persist(persister, onError)
+		}
+	}
+}
+
+// persist compacts the store and saves it with persister, if any.
+func (s *StatStore) persist(persister StatPersister, onError func(error)) {
+	s.Compact()
+	if persister == nil {
+		return
+	}
+	snapshot, err := s.Snapshot()
+	if err == nil {
+		err = persister.Save(snapshot)
+	}
+	if err != nil && onError != nil {
+		onError(err)
+	}
+}
+
+func prometheusName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// WritePrometheus writes all numeric stats in the Prometheus text exposition format.
+// Histograms are exposed as summaries over the retention window.  Keys that
+// map to a name already written, such as a.b and a_b, are skipped as a
+// metric may only be described once.
+func (s *StatStore) WritePrometheus(w io.Writer) error {
+	s.lock.Lock()
+	keys := make([]string, 0, len(s.series))
+	for key, series := range s.series {
+		if len(series.Raw) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	s.lock.Unlock()
+	sort.Strings(keys)
+
+	written := map[string]bool{}
+	for _, key := range keys {
+		name := prometheusName(key)
+		if written[name] {
+			continue
+		}
+		written[name] = true
+		s.lock.Lock()
+		series, ok := s.series[key]
+		if !ok {
+			s.lock.Unlock()
+			continue
+		}
+		statType := series.Type
+		current := series.Current
+		s.lock.Unlock()
+
+		switch statType {
+		case STAT_HISTOGRAM:
+			statRange, err := s.Range(key, time.Unix(0, 0), time.Now(), DefaultStatPercentiles)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "# TYPE %s summary\n", name); err != nil {
+				return err
+			}
+			for _, p := range DefaultStatPercentiles {
+				fmt.Fprintf(w, "%s{quantile=\"%v\"} %v\n", name, p/100, statRange.Percentiles[p])
+			}
+			fmt.Fprintf(w, "%s_sum %v\n", name, statRange.Sum)
+			fmt.Fprintf(w, "%s_count %d\n", name, statRange.Count)
+			written[name+"_sum"] = true
+			written[name+"_count"] = true
+		default:
+			if _, err := fmt.Fprintf(w, "# TYPE %s %s\n%s %v\n", name, statType, name, current); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ServeHTTP exposes the store as a Prometheus scrape endpoint.
+func (s *StatStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := s.WritePrometheus(w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
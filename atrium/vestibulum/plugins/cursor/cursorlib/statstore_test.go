@@ -0,0 +1,159 @@
+package cursorlib
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testStatPersister struct {
+	lock      sync.Mutex
+	snapshots [][]byte
+}
+
+func (p *testStatPersister) Save(snapshot []byte) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.snapshots = append(p.snapshots, snapshot)
+	return nil
+}
+
+func (p *testStatPersister) Load() ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if len(p.snapshots) == 0 {
+		return nil, nil
+	}
+	return p.snapshots[len(p.snapshots)-1], nil
+}
+
+func TestStatStore(t *testing.T) {
+	store := NewStatStore(time.Hour)
+	store.Set("gauge", "5", "")
+	if err := store.Increment("counter", "2", "int"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Increment("counter", "3", "int"); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := store.Get("counter"); !ok || value != "5" {
+		t.Fatalf("Expected counter 5, got %s", value)
+	}
+	if err := store.Increment("counter", "x", "int"); err == nil {
+		t.Fatalf("Expected a bad counter value rejected")
+	}
+	if replaced, err := store.UpdateMax("max", "3", "int"); err != nil || !replaced {
+		t.Fatalf("Expected max replaced %v", err)
+	}
+	if replaced, _ := store.UpdateMax("max", "2", "int"); replaced {
+		t.Fatalf("Expected lower max ignored")
+	}
+	store.Set("status", "running", "")
+	if value, _ := store.Get("status"); value != "running" {
+		t.Fatalf("Expected raw status kept, got %s", value)
+	}
+	for _, v := range []string{"1", "2", "3", "4"} {
+		store.Set("latency", v, "histogram")
+	}
+	statRange, err := store.Range("latency", time.Unix(0, 0), time.Now(), []float64{50, 99})
+	if err != nil || statRange.Count != 4 || statRange.Sum != 10 || statRange.Percentiles[50] != 2 || statRange.Percentiles[99] != 4 {
+		t.Fatalf("Unexpected range %+v %v", statRange, err)
+	}
+	if _, err := store.Range("missing", time.Unix(0, 0), time.Now(), nil); err == nil {
+		t.Fatalf("Expected missing stat to fail")
+	}
+
+	// Old samples are compacted away.
+	store.lock.Lock()
+	store.series["gauge"].Samples = append([]StatSample{{Time: time.Now().Add(-2 * time.Hour).Unix(), Value: 1}}, store.series["gauge"].Samples...)
+	store.lock.Unlock()
+	store.Compact()
+	if statRange, _ := store.Range("gauge", time.Unix(0, 0), time.Now(), nil); statRange.Count != 1 {
+		t.Fatalf("Expected old samples compacted, got %d", statRange.Count)
+	}
+
+	snapshot, err := store.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewStatStore(time.Hour)
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := restored.Get("counter"); value != "5" {
+		t.Fatalf("Expected restored counter 5, got %s", value)
+	}
+}
+
+func TestStatStorePrometheus(t *testing.T) {
+	store := NewStatStore(time.Hour)
+	store.Set("requests.total", "1", "int")
+	store.Set("requests_total", "2", "int")
+	store.Set("latency", "1", "histogram")
+	store.Set("latency_sum", "7", "int")
+	store.Set("status", "running", "")
+
+	var b bytes.Buffer
+	if err := store.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	exposition := b.String()
+	if strings.Count(exposition, "# TYPE requests_total ") != 1 || strings.Count(exposition, "latency_sum ") != 1 {
+		t.Fatalf("Expected each metric described once:\n%s", exposition)
+	}
+	if !strings.Contains(exposition, "# TYPE latency summary\n") || !strings.Contains(exposition, "latency_count 1\n") {
+		t.Fatalf("Expected latency as a summary:\n%s", exposition)
+	}
+	if strings.Contains(exposition, "status") {
+		t.Fatalf("Expected non numeric stats skipped:\n%s", exposition)
+	}
+}
+
+func TestStatStorePersistLoop(t *testing.T) {
+	store := NewStatStore(time.Hour)
+	persister := &testStatPersister{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		store.PersistLoop(ctx, persister, time.Hour, nil)
+	}()
+	store.Set("gauge", "5", "")
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the loop to stop")
+	}
+
+	// Stopping flushes the latest stats.
+	restored := NewStatStore(time.Hour)
+	snapshot, _ := persister.Load()
+	if err := restored.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := restored.Get("gauge"); value != "5" {
+		t.Fatalf("Expected gauge flushed on stop, got %s", value)
+	}
+}
+
+func TestLocalStatPersister(t *testing.T) {
+	persister := &LocalStatPersister{Path: filepath.Join(t.TempDir(), "stats"), Key: bytes.Repeat([]byte{1}, 32)}
+	if snapshot, err := persister.Load(); err != nil || snapshot != nil {
+		t.Fatalf("Expected nothing persisted yet %v", err)
+	}
+	if err := persister.Save([]byte("snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, err := persister.Load(); err != nil || string(snapshot) != "snapshot" {
+		t.Fatalf("Unexpected snapshot %s %v", snapshot, err)
+	}
+	wrongKey := &LocalStatPersister{Path: persister.Path, Key: bytes.Repeat([]byte{2}, 32)}
+	if _, err := wrongKey.Load(); err == nil {
+		t.Fatalf("Expected the wrong key to fail")
+	}
+}
@@ -0,0 +1,416 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.35.1
+// 	protoc        v3.21.12
+// source: statquerysdk/statquerysdk.proto
+
+package statquerysdk
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetStatRangeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key         string    `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Token       string    `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	StartTime   int64     `protobuf:"varint,3,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"` // Unix seconds, 0 for the start of retention
+	EndTime     int64     `protobuf:"varint,4,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"`       // Unix seconds, 0 for now
+	Percentiles []float64 `protobuf:"fixed64,5,rep,packed,name=percentiles,proto3" json:"percentiles,omitempty"`      // 0-100
+}
+
+func (x *GetStatRangeRequest) Reset() {
+	*x = GetStatRangeRequest{}
+	mi := &file_statquerysdk_statquerysdk_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatRangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatRangeRequest) ProtoMessage() {}
+
+func (x *GetStatRangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_statquerysdk_statquerysdk_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatRangeRequest.ProtoReflect.Descriptor instead.
+func (*GetStatRangeRequest) Descriptor() ([]byte, []int) {
+	return file_statquerysdk_statquerysdk_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetStatRangeRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *GetStatRangeRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *GetStatRangeRequest) GetStartTime() int64 {
+	if x != nil {
+		return x.StartTime
+	}
+	return 0
+}
+
+func (x *GetStatRangeRequest) GetEndTime() int64 {
+	if x != nil {
+		return x.EndTime
+	}
+	return 0
+}
+
+func (x *GetStatRangeRequest) GetPercentiles() []float64 {
+	if x != nil {
+		return x.Percentiles
+	}
+	return nil
+}
+
+type StatSample struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time  int64   `protobuf:"varint,1,opt,name=time,proto3" json:"time,omitempty"`
+	Value float64 `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *StatSample) Reset() {
+	*x = StatSample{}
+	mi := &file_statquerysdk_statquerysdk_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatSample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatSample) ProtoMessage() {}
+
+func (x *StatSample) ProtoReflect() protoreflect.Message {
+	mi := &file_statquerysdk_statquerysdk_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatSample.ProtoReflect.Descriptor instead.
+func (*StatSample) Descriptor() ([]byte, []int) {
+	return file_statquerysdk_statquerysdk_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StatSample) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *StatSample) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type StatPercentile struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Percentile float64 `protobuf:"fixed64,1,opt,name=percentile,proto3" json:"percentile,omitempty"`
+	Value      float64 `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (x *StatPercentile) Reset() {
+	*x = StatPercentile{}
+	mi := &file_statquerysdk_statquerysdk_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatPercentile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatPercentile) ProtoMessage() {}
+
+func (x *StatPercentile) ProtoReflect() protoreflect.Message {
+	mi := &file_statquerysdk_statquerysdk_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatPercentile.ProtoReflect.Descriptor instead.
+func (*StatPercentile) Descriptor() ([]byte, []int) {
+	return file_statquerysdk_statquerysdk_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StatPercentile) GetPercentile() float64 {
+	if x != nil {
+		return x.Percentile
+	}
+	return 0
+}
+
+func (x *StatPercentile) GetValue() float64 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+type GetStatRangeResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key         string            `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	StatType    string            `protobuf:"bytes,2,opt,name=stat_type,json=statType,proto3" json:"stat_type,omitempty"` // counter, gauge or histogram
+	Samples     []*StatSample     `protobuf:"bytes,3,rep,name=samples,proto3" json:"samples,omitempty"`
+	Min         float64           `protobuf:"fixed64,4,opt,name=min,proto3" json:"min,omitempty"`
+	Max         float64           `protobuf:"fixed64,5,opt,name=max,proto3" json:"max,omitempty"`
+	Sum         float64           `protobuf:"fixed64,6,opt,name=sum,proto3" json:"sum,omitempty"`
+	Count       int64             `protobuf:"varint,7,opt,name=count,proto3" json:"count,omitempty"`
+	Percentiles []*StatPercentile `protobuf:"bytes,8,rep,name=percentiles,proto3" json:"percentiles,omitempty"`
+}
+
+func (x *GetStatRangeResponse) Reset() {
+	*x = GetStatRangeResponse{}
+	mi := &file_statquerysdk_statquerysdk_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatRangeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatRangeResponse) ProtoMessage() {}
+
+func (x *GetStatRangeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_statquerysdk_statquerysdk_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatRangeResponse.ProtoReflect.Descriptor instead.
+func (*GetStatRangeResponse) Descriptor() ([]byte, []int) {
+	return file_statquerysdk_statquerysdk_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetStatRangeResponse) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *GetStatRangeResponse) GetStatType() string {
+	if x != nil {
+		return x.StatType
+	}
+	return ""
+}
+
+func (x *GetStatRangeResponse) GetSamples() []*StatSample {
+	if x != nil {
+		return x.Samples
+	}
+	return nil
+}
+
+func (x *GetStatRangeResponse) GetMin() float64 {
+	if x != nil {
+		return x.Min
+	}
+	return 0
+}
+
+func (x *GetStatRangeResponse) GetMax() float64 {
+	if x != nil {
+		return x.Max
+	}
+	return 0
+}
+
+func (x *GetStatRangeResponse) GetSum() float64 {
+	if x != nil {
+		return x.Sum
+	}
+	return 0
+}
+
+func (x *GetStatRangeResponse) GetCount() int64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *GetStatRangeResponse) GetPercentiles() []*StatPercentile {
+	if x != nil {
+		return x.Percentiles
+	}
+	return nil
+}
+
+var File_statquerysdk_statquerysdk_proto protoreflect.FileDescriptor
+
+var file_statquerysdk_statquerysdk_proto_rawDesc = []byte{
+	0x0a, 0x1f, 0x73, 0x74, 0x61, 0x74, 0x71, 0x75, 0x65, 0x72, 0x79, 0x73, 0x64, 0x6b, 0x2f, 0x73,
+	0x74, 0x61, 0x74, 0x71, 0x75, 0x65, 0x72, 0x79, 0x73, 0x64, 0x6b, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x12, 0x0c, 0x73, 0x74, 0x61, 0x74, 0x71, 0x75, 0x65, 0x72, 0x79, 0x73, 0x64, 0x6b, 0x22,
+	0x99, 0x01, 0x0a, 0x13, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x52, 0x61, 0x6e, 0x67, 0x65,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x6b,
+	0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x12,
+	0x1d, 0x0a, 0x0a, 0x73, 0x74, 0x61, 0x72, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x09, 0x73, 0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x19,
+	0x0a, 0x08, 0x65, 0x6e, 0x64, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x07, 0x65, 0x6e, 0x64, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x20, 0x0a, 0x0b, 0x70, 0x65, 0x72,
+	0x63, 0x65, 0x6e, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x01, 0x52, 0x0b,
+	0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x22, 0x36, 0x0a, 0x0a, 0x53,
+	0x74, 0x61, 0x74, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x14, 0x0a,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x22, 0x46, 0x0a, 0x0e, 0x53, 0x74, 0x61, 0x74, 0x50, 0x65, 0x72, 0x63, 0x65,
+	0x6e, 0x74, 0x69, 0x6c, 0x65, 0x12, 0x1e, 0x0a, 0x0a, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74,
+	0x69, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x70, 0x65, 0x72, 0x63, 0x65,
+	0x6e, 0x74, 0x69, 0x6c, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x22, 0x85, 0x02, 0x0a, 0x14,
+	0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x74, 0x61, 0x74, 0x5f, 0x74,
+	0x79, 0x70, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x74, 0x61, 0x74, 0x54,
+	0x79, 0x70, 0x65, 0x12, 0x32, 0x0a, 0x07, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x18, 0x03,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x71, 0x75, 0x65, 0x72, 0x79,
+	0x73, 0x64, 0x6b, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x52, 0x07,
+	0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x69, 0x6e, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6d, 0x69, 0x6e, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x78,
+	0x18, 0x05, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6d, 0x61, 0x78, 0x12, 0x10, 0x0a, 0x03, 0x73,
+	0x75, 0x6d, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x73, 0x75, 0x6d, 0x12, 0x14, 0x0a,
+	0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x63, 0x6f,
+	0x75, 0x6e, 0x74, 0x12, 0x3e, 0x0a, 0x0b, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x69, 0x6c,
+	0x65, 0x73, 0x18, 0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x71,
+	0x75, 0x65, 0x72, 0x79, 0x73, 0x64, 0x6b, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x50, 0x65, 0x72, 0x63,
+	0x65, 0x6e, 0x74, 0x69, 0x6c, 0x65, 0x52, 0x0b, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x69,
+	0x6c, 0x65, 0x73, 0x32, 0x6a, 0x0a, 0x10, 0x53, 0x74, 0x61, 0x74, 0x51, 0x75, 0x65, 0x72, 0x79,
+	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x56, 0x0a, 0x0d, 0x47, 0x65, 0x74, 0x53, 0x74,
+	0x61, 0x74, 0x73, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x12, 0x21, 0x2e, 0x73, 0x74, 0x61, 0x74, 0x71,
+	0x75, 0x65, 0x72, 0x79, 0x73, 0x64, 0x6b, 0x2e, 0x47, 0x65, 0x74, 0x53, 0x74, 0x61, 0x74, 0x52,
+	0x61, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x22, 0x2e, 0x73, 0x74,
+	0x61, 0x74, 0x71, 0x75, 0x65, 0x72, 0x79, 0x73, 0x64, 0x6b, 0x2e, 0x47, 0x65, 0x74, 0x53, 0x74,
+	0x61, 0x74, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42,
+	0x50, 0x5a, 0x4e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x74, 0x72,
+	0x69, 0x6d, 0x62, 0x6c, 0x65, 0x2d, 0x6f, 0x73, 0x73, 0x2f, 0x74, 0x69, 0x65, 0x72, 0x63, 0x65,
+	0x72, 0x6f, 0x6e, 0x2f, 0x61, 0x74, 0x72, 0x69, 0x75, 0x6d, 0x2f, 0x76, 0x65, 0x73, 0x74, 0x69,
+	0x62, 0x75, 0x6c, 0x75, 0x6d, 0x2f, 0x70, 0x6c, 0x75, 0x67, 0x69, 0x6e, 0x73, 0x2f, 0x63, 0x75,
+	0x72, 0x73, 0x6f, 0x72, 0x2f, 0x73, 0x74, 0x61, 0x74, 0x71, 0x75, 0x65, 0x72, 0x79, 0x73, 0x64,
+	0x6b, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_statquerysdk_statquerysdk_proto_rawDescOnce sync.Once
+	file_statquerysdk_statquerysdk_proto_rawDescData = file_statquerysdk_statquerysdk_proto_rawDesc
+)
+
+func file_statquerysdk_statquerysdk_proto_rawDescGZIP() []byte {
+	file_statquerysdk_statquerysdk_proto_rawDescOnce.Do(func() {
+		file_statquerysdk_statquerysdk_proto_rawDescData = protoimpl.X.CompressGZIP(file_statquerysdk_statquerysdk_proto_rawDescData)
+	})
+	return file_statquerysdk_statquerysdk_proto_rawDescData
+}
+
+var file_statquerysdk_statquerysdk_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_statquerysdk_statquerysdk_proto_goTypes = []any{
+	(*GetStatRangeRequest)(nil),  // 0: statquerysdk.GetStatRangeRequest
+	(*StatSample)(nil),           // 1: statquerysdk.StatSample
+	(*StatPercentile)(nil),       // 2: statquerysdk.StatPercentile
+	(*GetStatRangeResponse)(nil), // 3: statquerysdk.GetStatRangeResponse
+}
+var file_statquerysdk_statquerysdk_proto_depIdxs = []int32{
+	1, // 0: statquerysdk.GetStatRangeResponse.samples:type_name -> statquerysdk.StatSample
+	2, // 1: statquerysdk.GetStatRangeResponse.percentiles:type_name -> statquerysdk.StatPercentile
+	0, // 2: statquerysdk.StatQueryService.GetStatsRange:input_type -> statquerysdk.GetStatRangeRequest
+	3, // 3: statquerysdk.StatQueryService.GetStatsRange:output_type -> statquerysdk.GetStatRangeResponse
+	3, // [3:4] is the sub-list for method output_type
+	2, // [2:3] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_statquerysdk_statquerysdk_proto_init() }
+func file_statquerysdk_statquerysdk_proto_init() {
+	if File_statquerysdk_statquerysdk_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_statquerysdk_statquerysdk_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_statquerysdk_statquerysdk_proto_goTypes,
+		DependencyIndexes: file_statquerysdk_statquerysdk_proto_depIdxs,
+		MessageInfos:      file_statquerysdk_statquerysdk_proto_msgTypes,
+	}.Build()
+	File_statquerysdk_statquerysdk_proto = out.File
+	file_statquerysdk_statquerysdk_proto_rawDesc = nil
+	file_statquerysdk_statquerysdk_proto_goTypes = nil
+	file_statquerysdk_statquerysdk_proto_depIdxs = nil
+}
@@ -0,0 +1,125 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.21.12
+// source: statquerysdk/statquerysdk.proto
+
+package statquerysdk
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	StatQueryService_GetStatsRange_FullMethodName = "/statquerysdk.StatQueryService/GetStatsRange"
+)
+
+// StatQueryServiceClient is the client API for StatQueryService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Range queries over the time series kept by the cursor stat server.
+type StatQueryServiceClient interface {
+	GetStatsRange(ctx context.Context, in *GetStatRangeRequest, opts ...grpc.CallOption) (*GetStatRangeResponse, error)
+}
+
+type statQueryServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStatQueryServiceClient(cc grpc.ClientConnInterface) StatQueryServiceClient {
+	return &statQueryServiceClient{cc}
+}
+
+func (c *statQueryServiceClient) GetStatsRange(ctx context.Context, in *GetStatRangeRequest, opts ...grpc.CallOption) (*GetStatRangeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStatRangeResponse)
+	err := c.cc.Invoke(ctx, StatQueryService_GetStatsRange_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StatQueryServiceServer is the server API for StatQueryService service.
+// All implementations must embed UnimplementedStatQueryServiceServer
+// for forward compatibility.
+//
+// Range queries over the time series kept by the cursor stat server.
+type StatQueryServiceServer interface {
+	GetStatsRange(context.Context, *GetStatRangeRequest) (*GetStatRangeResponse, error)
+	mustEmbedUnimplementedStatQueryServiceServer()
+}
+
+// UnimplementedStatQueryServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedStatQueryServiceServer struct{}
+
+func (UnimplementedStatQueryServiceServer) GetStatsRange(context.Context, *GetStatRangeRequest) (*GetStatRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatsRange not implemented")
+}
+func (UnimplementedStatQueryServiceServer) mustEmbedUnimplementedStatQueryServiceServer() {}
+func (UnimplementedStatQueryServiceServer) testEmbeddedByValue()                          {}
+
+// UnsafeStatQueryServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StatQueryServiceServer will
+// result in compilation errors.
+type UnsafeStatQueryServiceServer interface {
+	mustEmbedUnimplementedStatQueryServiceServer()
+}
+
+func RegisterStatQueryServiceServer(s grpc.ServiceRegistrar, srv StatQueryServiceServer) {
+	// If the following call pancis, it indicates UnimplementedStatQueryServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&StatQueryService_ServiceDesc, srv)
+}
+
+func _StatQueryService_GetStatsRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StatQueryServiceServer).GetStatsRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: StatQueryService_GetStatsRange_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StatQueryServiceServer).GetStatsRange(ctx, req.(*GetStatRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// StatQueryService_ServiceDesc is the grpc.ServiceDesc for StatQueryService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var StatQueryService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "statquerysdk.StatQueryService",
+	HandlerType: (*StatQueryServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatsRange",
+			Handler:    _StatQueryService_GetStatsRange_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "statquerysdk/statquerysdk.proto",
+}
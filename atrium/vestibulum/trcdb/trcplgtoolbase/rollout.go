@@ -0,0 +1,326 @@
+package trcplgtoolbase
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// Rollout strategies, set as rolloutstrategy on a plugin certification.
+const (
+	ROLLOUT_STRATEGY_ALL    = "all"    // Every instance deploys at once.
+	ROLLOUT_STRATEGY_CANARY = "canary" // The first rolloutcanary instances, then the rest.
+	ROLLOUT_STRATEGY_WAVES  = "waves"  // Cumulative percentages of instances in rolloutwaves.
+	ROLLOUT_STRATEGY_MANUAL = "manual" // Canary or waves, each promoted with trcplgtool -promoteRollout.
+)
+
+// Rollout health checks, set as rollouthealth on a plugin certification.
+const (
+	ROLLOUT_HEALTH_STATUS = "status" // The plugin reports itself deployed with the rollout sha.
+	ROLLOUT_HEALTH_GRPC   = "grpc"   // A grpc health service at rollouthealthaddr reports serving.
+)
+
+// Rollout states.
+const (
+	ROLLOUT_STATE_INPROGRESS        = "inprogress"
+	ROLLOUT_STATE_AWAITINGPROMOTION = "awaitingpromotion"
+	ROLLOUT_STATE_COMPLETE          = "complete"
+	ROLLOUT_STATE_ROLLEDBACK        = "rolledback"
+)
+
+// Results recorded per instance in a rollout's state.
+const (
+	ROLLOUT_RESULT_HEALTHY   = "healthy"
+	ROLLOUT_RESULT_UNHEALTHY = "unhealthy"
+)
+
+const rolloutResultPrefix = "instance_"
+
+// DefaultRolloutHealthTimeout is how long a deployed instance has to pass
+// its health check when the certification doesn't set rollouthealthtimeout.
+const DefaultRolloutHealthTimeout = 5 * time.Minute
+
+// Rollout is the rollout strategy certified for a plugin.
+type Rollout struct {
+	Strategy       string
+	Instances      []string // Instance indexes in certified order.
+	Waves          []int    // Cumulative instance count of each wave.
+	Health         string
+	HealthAddr     string
+	HealthService  string
+	HealthInsecure bool
+	HealthTimeout  time.Duration
+	PreviousSha256 string
+}
+
+// NewRollout reads the rollout strategy from a plugin certification.  The
+// rollout is nil if every instance deploys at once.
+func NewRollout(certification map[string]interface{}) (*Rollout, error) {
+	strategy, _ := certification["rolloutstrategy"].(string)
+	if len(strategy) == 0 || strategy == ROLLOUT_STRATEGY_ALL {
+		return nil, nil
+	}
+	rollout := &Rollout{
+		Strategy:      strategy,
+		Health:        ROLLOUT_HEALTH_STATUS,
+		HealthTimeout: DefaultRolloutHealthTimeout,
+	}
+	instanceList, _ := certification["instances"].(string)
+	if len(instanceList) == 0 {
+		instanceList = "0"
+	}
+	for _, instance := range strings.Split(instanceList, ",") {
+		if instance = strings.Trim(strings.TrimSpace(instance), "\""); len(instance) > 0 {
+			rollout.Instances = append(rollout.Instances, instance)
+		}
+	}
+	canary, _ := certification["rolloutcanary"].(string)
+	waves, _ := certification["rolloutwaves"].(string)
+	switch strategy {
+	case ROLLOUT_STRATEGY_CANARY:
+		waves = ""
+	case ROLLOUT_STRATEGY_WAVES:
+		if len(waves) == 0 {
+			return nil, errors.New("waves rollout requires rolloutwaves")
+		}
+	case ROLLOUT_STRATEGY_MANUAL:
+	default:
+		return nil, fmt.Errorf("unsupported rollout strategy: %s", strategy)
+	}
+	var err error
+	if len(waves) > 0 {
+		rollout.Waves, err = RolloutWaves(waves, len(rollout.Instances))
+	} else {
+		if len(canary) == 0 {
+			canary = "1"
+		}
+		rollout.Waves, err = RolloutCanary(canary, len(rollout.Instances))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if health, ok := certification["rollouthealth"].(string); ok && len(health) > 0 {
+		rollout.Health = health
+	}
+	rollout.HealthAddr, _ = certification["rollouthealthaddr"].(string)
+	rollout.HealthService, _ = certification["rollouthealthservice"].(string)
+	if insecure, ok := certification["rollouthealthinsecure"].(string); ok {
+		rollout.HealthInsecure, _ = strconv.ParseBool(insecure)
+	}
+	switch rollout.Health {
+	case ROLLOUT_HEALTH_STATUS:
+	case ROLLOUT_HEALTH_GRPC:
+		if len(rollout.HealthAddr) == 0 {
+			return nil, errors.New("grpc rollout health requires rollouthealthaddr")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported rollout health check: %s", rollout.Health)
+	}
+	if timeout, ok := certification["rollouthealthtimeout"].(string); ok && len(timeout) > 0 {
+		seconds, err := strconv.Atoi(timeout)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid rollouthealthtimeout: %s", timeout)
+		}
+		rollout.HealthTimeout = time.Duration(seconds) * time.Second
+	}
+	rollout.PreviousSha256, _ = certification["trcprevioussha256"].(string)
+	return rollout, nil
+}
+
+// RolloutCanary returns the waves of a canary of the first canary instances
+// followed by the rest.
+func RolloutCanary(canary string, instanceCount int) ([]int, error) {
+	count, err := strconv.Atoi(strings.TrimSpace(canary))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid rolloutcanary: %s", canary)
+	}
+	if count >= instanceCount {
+		return []int{instanceCount}, nil
+	}
+	return []int{count, instanceCount}, nil
+}
+
+// RolloutWaves returns the cumulative instance count of each wave in a
+// comma separated list of percentages such as 10,50,100.  Every wave holds
+// at least one more instance than the last, and the final wave holds them
+// all.
+func RolloutWaves(waves string, instanceCount int) ([]int, error) {
+	counts := []int{}
+	last := 0
+	for _, wave := range strings.Split(waves, ",") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(wave), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid rolloutwaves: %s", waves)
+		}
+		count := int(math.Ceil(percent * float64(instanceCount) / 100))
+		if count <= last {
+			continue
+		}
+		counts = append(counts, count)
+		last = count
+	}
+	if last < instanceCount {
+		counts = append(counts, instanceCount)
+	}
+	return counts, nil
+}
+
+// WaveOf returns the wave deploying instance, or -1 if it isn't certified.
+func (r *Rollout) WaveOf(instance string) int {
+	for position, certified := range r.Instances {
+		if certified != instance {
+			continue
+		}
+		for wave, count := range r.Waves {
+			if position < count {
+				return wave
+			}
+		}
+	}
+	return -1
+}
+
+// WaveInstances returns the instances deploying in wave.
+func (r *Rollout) WaveInstances(wave int) []string {
+	if wave < 0 || wave >= len(r.Waves) {
+		return nil
+	}
+	start := 0
+	if wave > 0 {
+		start = r.Waves[wave-1]
+	}
+	return r.Instances[start:r.Waves[wave]]
+}
+
+// RolloutState is the progress of rolling out a certified sha, shared by
+// every instance through vault.
+type RolloutState struct {
+	Sha256         string
+	RollbackSha256 string
+	Wave           int
+	State          string
+	Results        map[string]string // Instance index to result.
+}
+
+// RolloutPath is where the rollout state of a plugin is kept.
+func RolloutPath(pluginName string) string {
+	return fmt.Sprintf("super-secrets/Index/TrcVault/trcplugin/%s/Rollout", pluginName)
+}
+
+// ReadRolloutState reads a plugin's rollout state along with its version.
+func ReadRolloutState(mod *helperkv.Modifier, pluginName string) (*RolloutState, int64, error) {
+	data, version, err := mod.ReadDataVersion(RolloutPath(pluginName))
+	if err != nil {
+		return nil, 0, err
+	}
+	state := &RolloutState{Results: map[string]string{}}
+	state.Sha256, _ = data["trcsha256"].(string)
+	state.RollbackSha256, _ = data["rollbacksha256"].(string)
+	state.State, _ = data["state"].(string)
+	if wave, ok := data["wave"].(string); ok {
+		state.Wave, _ = strconv.Atoi(wave)
+	}
+	for field, value := range data {
+		if instance, ok := strings.CutPrefix(field, rolloutResultPrefix); ok {
+			state.Results[instance] = fmt.Sprintf("%v", value)
+		}
+	}
+	return state, version, nil
+}
+
+func (s *RolloutState) data() map[string]interface{} {
+	data := map[string]interface{}{
+		"trcsha256":      s.Sha256,
+		"rollbacksha256": s.RollbackSha256,
+		"wave":           strconv.Itoa(s.Wave),
+		"state":          s.State,
+	}
+	for instance, result := range s.Results {
+		data[rolloutResultPrefix+instance] = result
+	}
+	return data
+}
+
+// UpdateRolloutState applies update to a plugin's rollout state, rereading
+// and retrying if another instance changed it first.  Nothing is written if
+// update returns false.
+func UpdateRolloutState(mod *helperkv.Modifier, pluginName string, logger *log.Logger, update func(state *RolloutState) bool) (*RolloutState, error) {
+	var err error
+	for retries := 0; retries < 5; retries++ {
+		state, version, readErr := ReadRolloutState(mod, pluginName)
+		if readErr != nil {
+			return nil, readErr
+		}
+		if !update(state) {
+			return state, nil
+		}
+		mod.SectionPath = ""
+		if _, err = mod.WriteCAS(RolloutPath(pluginName), state.data(), version, logger); err == nil {
+			return state, nil
+		}
+		if !strings.Contains(err.Error(), "check-and-set") {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// RecordRolloutResult records an instance's health check on the rollout of
+// sha and moves the rollout along.  The next wave starts once every
+// instance of the current one is healthy, unless it's waiting on manual
+// promotion.
+func RecordRolloutResult(mod *helperkv.Modifier, pluginName string, rollout *Rollout, sha string, instance string, healthy bool, logger *log.Logger) (*RolloutState, error) {
+	return UpdateRolloutState(mod, pluginName, logger, func(state *RolloutState) bool {
+		if state.Sha256 != sha || state.State == ROLLOUT_STATE_ROLLEDBACK {
+			return false
+		}
+		if !healthy {
+			state.Results[instance] = ROLLOUT_RESULT_UNHEALTHY
+			return true
+		}
+		state.Results[instance] = ROLLOUT_RESULT_HEALTHY
+		if state.State != ROLLOUT_STATE_INPROGRESS {
+			return true
+		}
+		for _, waveInstance := range rollout.WaveInstances(state.Wave) {
+			if state.Results[waveInstance] != ROLLOUT_RESULT_HEALTHY {
+				return true
+			}
+		}
+		switch {
+		case state.Wave >= len(rollout.Waves)-1:
+			state.State = ROLLOUT_STATE_COMPLETE
+		case rollout.Strategy == ROLLOUT_STRATEGY_MANUAL:
+			state.State = ROLLOUT_STATE_AWAITINGPROMOTION
+		default:
+			state.Wave++
+		}
+		return true
+	})
+}
+
+// PromoteRollout starts the next wave of a manual rollout waiting on
+// promotion.
+func PromoteRollout(mod *helperkv.Modifier, pluginName string, logger *log.Logger) (*RolloutState, error) {
+	var promoteErr error
+	state, err := UpdateRolloutState(mod, pluginName, logger, func(state *RolloutState) bool {
+		if state.State != ROLLOUT_STATE_AWAITINGPROMOTION {
+			promoteErr = fmt.Errorf("rollout of %s is not awaiting promotion: %s", pluginName, state.State)
+			return false
+		}
+		state.Wave++
+		state.State = ROLLOUT_STATE_INPROGRESS
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return state, promoteErr
+}
@@ -0,0 +1,54 @@
+package trcplgtoolbase
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRolloutWaves(t *testing.T) {
+	rollout, err := NewRollout(map[string]interface{}{"instances": "0,1,2,3,4,5,6,7,8,9"})
+	if err != nil || rollout != nil {
+		t.Fatalf("Expected no rollout without a strategy, got %v %v", rollout, err)
+	}
+
+	rollout, err = NewRollout(map[string]interface{}{
+		"instances":       "0,1,2,3,4,5,6,7,8,9",
+		"rolloutstrategy": ROLLOUT_STRATEGY_WAVES,
+		"rolloutwaves":    "10,25,50",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rollout.Waves, []int{1, 3, 5, 10}) {
+		t.Fatalf("Unexpected waves %v", rollout.Waves)
+	}
+	if rollout.WaveOf("0") != 0 || rollout.WaveOf("2") != 1 || rollout.WaveOf("9") != 3 || rollout.WaveOf("10") != -1 {
+		t.Fatal("Unexpected wave membership")
+	}
+	if !reflect.DeepEqual(rollout.WaveInstances(2), []string{"3", "4"}) {
+		t.Fatalf("Unexpected wave instances %v", rollout.WaveInstances(2))
+	}
+
+	rollout, err = NewRollout(map[string]interface{}{
+		"instances":       "\"4\",\"7\",\"2\"",
+		"rolloutstrategy": ROLLOUT_STRATEGY_CANARY,
+		"rolloutcanary":   "1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rollout.Waves, []int{1, 3}) || rollout.WaveOf("4") != 0 || rollout.WaveOf("2") != 1 {
+		t.Fatalf("Unexpected canary %v", rollout.Waves)
+	}
+
+	for _, certification := range []map[string]interface{}{
+		{"rolloutstrategy": "sideways"},
+		{"rolloutstrategy": ROLLOUT_STRATEGY_WAVES},
+		{"rolloutstrategy": ROLLOUT_STRATEGY_WAVES, "rolloutwaves": "0,100"},
+		{"rolloutstrategy": ROLLOUT_STRATEGY_CANARY, "rollouthealth": ROLLOUT_HEALTH_GRPC},
+	} {
+		if _, err := NewRollout(certification); err == nil {
+			t.Fatalf("Expected invalid rollout %v", certification)
+		}
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/trimble-oss/tierceron/pkg/core/util/docker"
 	"github.com/trimble-oss/tierceron/pkg/core/util/hive"
 	"github.com/trimble-oss/tierceron/pkg/core/util/repository"
+	"github.com/trimble-oss/tierceron/pkg/core/util/sign"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
 
 	trcapimgmtbase "github.com/trimble-oss/tierceron/atrium/vestibulum/trcdb/trcapimgmtbase"
@@ -74,6 +75,8 @@ func CommonMain(envPtr *string,
 	projectservicePtr := flagset.String("projectservice", "", "Provide template root path in form project/service")
 	deploysubpathPtr := flagset.String("deploysubpath", "", "Subpath under root to deliver code bundles.")
 	buildImagePtr := flagset.String("buildImage", "", "Path to Dockerfile to build")
+	builderPtr := flagset.String("builder", docker.BUILDER_DOCKER, "Image builder used by -buildImage: docker, or oci to build reproducibly without a docker daemon.")
+	imageOutputPtr := flagset.String("imageOutput", "", "OCI image layout directory, or tarball if it ends in .tar, written by -builder=oci and pushed by -pushImage (default <pluginName>.oci).")
 	pushImagePtr := flagset.Bool("pushImage", false, "Push an image to the registry.")
 	pushAliasPtr := flagset.String("pushAlias", "", "Image name:tag to push to registry, separated by commas (eg: egg:plant,egg:salad,egg:bar).")
 
@@ -99,6 +102,22 @@ func CommonMain(envPtr *string,
 	checkDeployedPtr := flagset.Bool("checkDeployed", false, "Used to check if plugin has been copied, deployed, & certified")
 	checkCopiedPtr := flagset.Bool("checkCopied", false, "Used to check if plugin has been copied & certified")
 
+	// Signing flags...
+	signPtr := flagset.Bool("sign", false, "Used by release engineering to sign a plugin certification.  Prints the signature to provide to -certify.")
+	signingKeyPtr := flagset.String("signingKey", "", "Path to PEM private key used with -sign")
+	signaturePtr := flagset.String("signature", "", "Plugin signature from -sign.  Required by -certify once signing keys are configured.")
+
+	// Rollout flags...
+	rolloutStrategyPtr := flagset.String("rolloutStrategy", "", "Used with -certify to stage the deploy: all, canary, waves or manual.")
+	rolloutCanaryPtr := flagset.String("rolloutCanary", "", "Number of instances deploying first in a canary or manual rollout.")
+	rolloutWavesPtr := flagset.String("rolloutWaves", "", "Cumulative percentages of instances deploying in each wave (eg: 10,50,100).")
+	rolloutHealthPtr := flagset.String("rolloutHealth", "", "Health check gating each wave: status or grpc.")
+	rolloutHealthAddrPtr := flagset.String("rolloutHealthAddr", "", "Address of the grpc health service checked on each instance (eg: localhost:8443).")
+	rolloutHealthServicePtr := flagset.String("rolloutHealthService", "", "Service name checked on the grpc health service.  Defaults to the server's overall health.")
+	rolloutHealthInsecurePtr := flagset.Bool("rolloutHealthInsecure", false, "Skip verifying the grpc health service's certificate.")
+	rolloutHealthTimeoutPtr := flagset.String("rolloutHealthTimeout", "", "Seconds an instance has to pass its health check before the rollout is rolled back.")
+	promoteRolloutPtr := flagset.Bool("promoteRollout", false, "Start the next wave of a manual rollout.")
+
 	// NewRelic flags...
 	newrelicAppNamePtr := flagset.String("newRelicAppName", "", "App name for New Relic")
 	newrelicLicenseKeyPtr := flagset.String("newRelicLicenseKey", "", "License key for New Relic")
@@ -164,6 +183,16 @@ func CommonMain(envPtr *string,
 		return errors.New("must use -pluginName && -sha256 flags to use -certify flag")
 	}
 
+	if *promoteRolloutPtr && (len(*pluginNamePtr) == 0) {
+		fmt.Println("Must use -pluginName flag to use -promoteRollout flag")
+		return errors.New("must use -pluginName flag to use -promoteRollout flag")
+	}
+
+	if !*certifyImagePtr && (len(*rolloutStrategyPtr) > 0 || len(*rolloutCanaryPtr) > 0 || len(*rolloutWavesPtr) > 0 || len(*rolloutHealthPtr) > 0 || len(*rolloutHealthAddrPtr) > 0 || len(*rolloutHealthServicePtr) > 0 || *rolloutHealthInsecurePtr || len(*rolloutHealthTimeoutPtr) > 0) {
+		fmt.Println("Must use -certify flag to use rollout flags")
+		return errors.New("must use -certify flag to use rollout flags")
+	}
+
 	if *checkDeployedPtr && (len(*pluginNamePtr) == 0) {
 		fmt.Println("Must use -pluginName flag to use -checkDeployed flag")
 		return errors.New("must use -pluginName flag to use -checkDeployed flag")
@@ -189,6 +218,14 @@ func CommonMain(envPtr *string,
 		return errors.New("image tag cannot be longer than 128 characters")
 	}
 
+	if *builderPtr != docker.BUILDER_DOCKER && *builderPtr != docker.BUILDER_OCI {
+		fmt.Printf("Unsupported builder %s\n", *builderPtr)
+		return fmt.Errorf("unsupported builder %s", *builderPtr)
+	}
+	if len(*imageOutputPtr) == 0 && len(*pluginNamePtr) > 0 {
+		*imageOutputPtr = strings.ReplaceAll(strings.Split(*pluginNamePtr, ":")[0], "/", "_") + ".oci"
+	}
+
 	if len(*pushAliasPtr) > 0 && !*pushImagePtr {
 		fmt.Println("Must use -pushImage flag to use -pushAlias flag")
 		return errors.New("must use -pushImage flag to use -pushAlias flag")
@@ -254,6 +291,35 @@ func CommonMain(envPtr *string,
 		}
 	}
 
+	if *signPtr {
+		// Signing is done offline by release engineering and never touches vault.
+		if len(*pluginNamePtr) == 0 || len(*sha256Ptr) == 0 || len(*signingKeyPtr) == 0 {
+			fmt.Println("Must use -pluginName, -sha256 && -signingKey flags to use -sign flag")
+			return errors.New("must use -pluginName, -sha256 && -signingKey flags to use -sign flag")
+		}
+		pluginSha := *sha256Ptr
+		if pluginImage, readErr := os.ReadFile(*sha256Ptr); readErr == nil {
+			pluginSha = fmt.Sprintf("%x", sha256.Sum256(pluginImage))
+		}
+		signingKey, readErr := os.ReadFile(*signingKeyPtr)
+		if readErr != nil {
+			fmt.Println("Unable to read signing key: " + readErr.Error())
+			return readErr
+		}
+		payload, payloadErr := sign.NewPluginSignaturePayload(*pluginNamePtr, *pluginTypePtr, pluginSha)
+		if payloadErr != nil {
+			fmt.Println(payloadErr.Error())
+			return payloadErr
+		}
+		signature, signErr := sign.Sign(signingKey, payload)
+		if signErr != nil {
+			fmt.Println("Unable to sign plugin: " + signErr.Error())
+			return signErr
+		}
+		fmt.Println(signature)
+		return nil
+	}
+
 	if *pluginTypePtr != "vault" {
 		*regionPtr = ""
 	}
@@ -396,6 +462,17 @@ func CommonMain(envPtr *string,
 		return nil
 	}
 
+	if *promoteRolloutPtr {
+		mod.AuditReason = fmt.Sprintf("promote rollout %s", *pluginNamePtr)
+		state, err := PromoteRollout(mod, *pluginNamePtr, trcshDriverConfigBase.DriverConfig.CoreConfig.Log)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		fmt.Printf("Rollout of %s promoted to wave %d.\n", *pluginNamePtr, state.Wave+1)
+		return nil
+	}
+
 	// Get existing configs if they exist...
 	pluginToolConfig, plcErr := trcvutils.GetPluginToolConfig(trcshDriverConfigBase.DriverConfig, mod, coreopts.BuildOptions.InitPluginConfig(map[string]interface{}{}), *defineServicePtr)
 	if plcErr != nil {
@@ -455,6 +532,31 @@ func CommonMain(envPtr *string,
 	pluginToolConfig["buildImagePtr"] = *buildImagePtr
 	pluginToolConfig["pushAliasPtr"] = *pushAliasPtr
 	pluginToolConfig["trcbootstrapPtr"] = trcbootstrapPtr
+	if len(*signaturePtr) > 0 {
+		pluginToolConfig["trcsignature"] = *signaturePtr
+	}
+	for rolloutField, rolloutValue := range map[string]string{
+		"rolloutstrategy":      *rolloutStrategyPtr,
+		"rolloutcanary":        *rolloutCanaryPtr,
+		"rolloutwaves":         *rolloutWavesPtr,
+		"rollouthealth":        *rolloutHealthPtr,
+		"rollouthealthaddr":    *rolloutHealthAddrPtr,
+		"rollouthealthservice": *rolloutHealthServicePtr,
+		"rollouthealthtimeout": *rolloutHealthTimeoutPtr,
+	} {
+		if len(rolloutValue) > 0 {
+			pluginToolConfig[rolloutField] = rolloutValue
+		}
+	}
+	if *rolloutHealthInsecurePtr {
+		pluginToolConfig["rollouthealthinsecure"] = "true"
+	}
+	if *certifyImagePtr {
+		if _, rolloutErr := NewRollout(pluginToolConfig); rolloutErr != nil {
+			fmt.Println("Invalid rollout: " + rolloutErr.Error())
+			return rolloutErr
+		}
+	}
 
 	if _, ok := pluginToolConfig["trcplugin"].(string); !ok {
 		if *defineServicePtr {
@@ -515,7 +617,36 @@ func CommonMain(envPtr *string,
 		}
 	}
 
-	if len(*buildImagePtr) > 0 {
+	if *pushImagePtr && *builderPtr == docker.BUILDER_OCI && !repository.IsOCIRegistryConfigured(pluginToolConfig) {
+		// The registry specific push needs the image in a docker daemon.
+		err := errors.New("-builder=oci -pushImage requires an ociregistry to push to")
+		fmt.Println(err)
+		return err
+	}
+
+	if len(*buildImagePtr) > 0 && *builderPtr == docker.BUILDER_OCI {
+		fmt.Println("Building image without docker...")
+		platform, _ := pluginToolConfig["ociplatform"].(string)
+		image, err := docker.BuildOCIImage(trcshDriverConfigBase.DriverConfig, &docker.OCIBuildOptions{
+			Dockerfile: *buildImagePtr,
+			ImageName:  *pluginNamePtr,
+			Platform:   platform,
+		})
+		if err == nil {
+			err = docker.WriteOCILayout(image, *pluginNamePtr, *imageOutputPtr)
+		}
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		// Rebuilding the same sources yields the same digests, so these are
+		// the values to certify and to verify on deploy.
+		pluginToolConfig["imagesha256"] = image.PluginSha256
+		pluginToolConfig["ocidigest"] = image.ManifestDigest.String()
+		fmt.Printf("Image successfully built to %s\n", *imageOutputPtr)
+		fmt.Printf("Image digest: %s\n", image.ManifestDigest)
+		fmt.Printf("Image sha256: %s\n", image.PluginSha256)
+	} else if len(*buildImagePtr) > 0 {
 		fmt.Println("Building image using local docker repository...")
 		err := docker.BuildDockerImage(trcshDriverConfigBase.DriverConfig, *buildImagePtr, *pluginNamePtr)
 		if err != nil {
@@ -539,11 +670,20 @@ func CommonMain(envPtr *string,
 		}
 
 		fmt.Println("Pushing image to registry...")
-		err := repository.PushImage(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
+		var err error
+		if *builderPtr == docker.BUILDER_OCI {
+			err = repository.PushImageLayoutToOCI(trcshDriverConfigBase.DriverConfig, pluginToolConfig, *imageOutputPtr)
+		} else {
+			err = repository.PushImage(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
+		}
 		if err != nil {
 			fmt.Println(err.Error())
+			return err
 		} else {
 			fmt.Println("Image successfully pushed")
+			if ociDigest, ok := pluginToolConfig["ocidigest"].(string); ok && len(ociDigest) > 0 {
+				fmt.Printf("Image digest: %s\n", ociDigest)
+			}
 		}
 	}
 
@@ -592,6 +732,7 @@ func CommonMain(envPtr *string,
 			writeMap["newrelic_license_key"] = pluginToolConfig["newrelicLicenseKey"].(string)
 		}
 
+		mod.AuditReason = fmt.Sprintf("certify %v", pluginToolConfig["trcplugin"])
 		_, err = mod.Write(pluginToolConfig["pluginpath"].(string), writeMap, trcshDriverConfigBase.DriverConfig.CoreConfig.Log)
 		if err != nil {
 			fmt.Println(err)
@@ -634,7 +775,7 @@ func CommonMain(envPtr *string,
 		}
 
 		if pluginToolConfig["trcsha256"] != nil && len(pluginToolConfig["trcsha256"].(string)) > 0 {
-			err := repository.GetImageAndShaFromDownload(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
+			err := repository.GetPluginImageAndSha(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
 			if err != nil {
 				fmt.Println("Image download failure.")
 				if trcshDriverConfigBase.FeatherCtx != nil {
@@ -765,9 +906,13 @@ func CommonMain(envPtr *string,
 					return err
 				}
 				if pluginHandler != nil {
-					if pluginHandler.State == 2 && sha == pluginHandler.Signature { //make sure this won't break...not set yet
+					if pluginHandler.GetState() == 2 && sha == pluginHandler.Signature { //make sure this won't break...not set yet
 						trcshDriverConfigBase.DriverConfig.CoreConfig.Log.Printf("Tried to redeploy same failed plugin: %s\n", *pluginNamePtr)
 						// do we want to remove from available services???
+					} else if signErr := verifyPluginSignature(pluginToolConfig); signErr != nil {
+						// Certification alone isn't enough - release engineering must have signed it.
+						trcshDriverConfigBase.DriverConfig.CoreConfig.Log.Printf("Refusing to load plugin %s: %v\n", *pluginNamePtr, signErr)
+						pluginHandler.SetState(2)
 					} else {
 						pluginHandler.LoadPluginMod(trcshDriverConfigBase.DriverConfig, pathToSO)
 						pluginHandler.Signature = sha
@@ -787,7 +932,9 @@ func CommonMain(envPtr *string,
 		} else if !certifyInit {
 			// Already certified...
 			fmt.Println("Checking for existing image.")
-			err := repository.GetImageAndShaFromDownload(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
+			// The digest pinned by the last certification names the old image.
+			delete(pluginToolConfig, "trcocidigest")
+			err := repository.GetPluginImageAndSha(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
 			if _, ok := pluginToolConfig["imagesha256"].(string); err != nil || !ok {
 				fmt.Println("Invalid or nonexistent image on download.")
 				if err != nil {
@@ -800,6 +947,17 @@ func CommonMain(envPtr *string,
 			}
 		}
 		if certifyInit || carrierCertify || pluginToolConfig["trcsha256"].(string) == pluginToolConfig["imagesha256"].(string) { // Comparing generated sha from image to sha from flag
+			if sign.SigningRequired(pluginToolConfig) {
+				signedConfig := WriteMapUpdate(map[string]interface{}{
+					"trcsigningkeys": pluginToolConfig["trcsigningkeys"],
+					"trctype":        pluginToolConfig["trctype"],
+				}, pluginToolConfig, false, *pluginTypePtr, "")
+				if err := sign.VerifyPluginCertification(signedConfig); err != nil {
+					fmt.Println("Plugin signature verification failed: " + err.Error())
+					return err
+				}
+				fmt.Println("Valid plugin signature.")
+			}
 			// ||
 			//(pluginToolConfig["imagesha256"].(string) != "" && pluginToolConfig["trctype"].(string) == "trcshservice") {
 			if !strings.Contains(pluginToolConfig["trcplugin"].(string), "carrier") {
@@ -854,6 +1012,7 @@ func CommonMain(envPtr *string,
 					return err
 				}
 
+				mod.AuditReason = fmt.Sprintf("certify %v", pluginToolConfig["trcplugin"])
 				_, err = mod.Write(pluginToolConfig["pluginpath"].(string), WriteMapUpdate(writeMap, pluginToolConfig, *defineServicePtr, *pluginTypePtr, *pathParamPtr), trcshDriverConfigBase.DriverConfig.CoreConfig.Log)
 				if err != nil {
 					fmt.Println(err)
@@ -877,7 +1036,7 @@ func CommonMain(envPtr *string,
 			return err
 		}
 	} else if *pluginservicestartPtr && kernelopts.BuildOptions.IsKernel() {
-		if pluginHandler != nil && pluginHandler.State != 2 && kernelPluginHandler != nil {
+		if pluginHandler != nil && pluginHandler.GetState() != 2 && kernelPluginHandler != nil {
 			if kernelPluginHandler.ConfigContext == nil || kernelPluginHandler.ConfigContext.ChatReceiverChan == nil {
 				fmt.Printf("Unable to access chat channel configuration data for %s\n", *pluginNamePtr)
 				driverConfig.CoreConfig.Log.Printf("Unable to access chat channel configuration data for %s\n", *pluginNamePtr)
@@ -890,7 +1049,7 @@ func CommonMain(envPtr *string,
 			trcshDriverConfigBase.DriverConfig.CoreConfig.Log.Printf("Handler not initialized for plugin to start: %s\n", *pluginNamePtr)
 		}
 	} else if *pluginservicestopPtr && kernelopts.BuildOptions.IsKernel() {
-		if pluginHandler != nil && pluginHandler.State != 2 {
+		if pluginHandler != nil && pluginHandler.GetState() != 2 {
 			pluginHandler.PluginserviceStop(trcshDriverConfigBase.DriverConfig)
 		} else {
 			fmt.Printf("Handler not initialized for plugin to shutdown: %s\n", *pluginNamePtr)
@@ -906,7 +1065,7 @@ func CommonMain(envPtr *string,
 			return nil
 		}
 
-		err := repository.GetImageAndShaFromDownload(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
+		err := repository.GetPluginImageAndSha(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
 		if err != nil {
 			fmt.Println(err.Error())
 			return err
@@ -929,7 +1088,7 @@ func CommonMain(envPtr *string,
 			return nil
 		}
 
-		err := repository.GetImageAndShaFromDownload(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
+		err := repository.GetPluginImageAndSha(trcshDriverConfigBase.DriverConfig, pluginToolConfig)
 		if err != nil {
 			fmt.Println(err.Error())
 			return err
@@ -948,6 +1107,15 @@ func CommonMain(envPtr *string,
 	return nil
 }
 
+// verifyPluginSignature checks the release engineering signature on a
+// certification before the kernel loads a plugin module.
+func verifyPluginSignature(pluginToolConfig map[string]interface{}) error {
+	if pluginopts.BuildOptions.IsPluginHardwired() || !sign.SigningRequired(pluginToolConfig) {
+		return nil
+	}
+	return sign.VerifyPluginCertification(pluginToolConfig)
+}
+
 func WriteMapUpdate(writeMap map[string]interface{}, pluginToolConfig map[string]interface{}, defineServicePtr bool, pluginTypePtr string, pathParamPtr string) map[string]interface{} {
 	if pluginTypePtr != "trcshservice" {
 		writeMap["trcplugin"] = pluginToolConfig["trcplugin"].(string)
@@ -963,11 +1131,33 @@ func WriteMapUpdate(writeMap map[string]interface{}, pluginToolConfig map[string
 		writeMap["trcprojectservice"] = pluginToolConfig["trcprojectservice"].(string)
 		writeMap["trcdeployroot"] = pluginToolConfig["trcdeployroot"].(string)
 	}
+	certifiedSha, _ := writeMap["trcsha256"].(string)
+	certifiedDigest, _ := writeMap["trcocidigest"].(string)
+	certifiedSignature, _ := writeMap["trcsignature"].(string)
 	if _, imgShaOk := pluginToolConfig["imagesha256"].(string); imgShaOk {
 		writeMap["trcsha256"] = pluginToolConfig["imagesha256"].(string) // Pull image sha from registry...
 	} else {
 		writeMap["trcsha256"] = pluginToolConfig["trcsha256"].(string) // Pull image sha from registry...
 	}
+	if ociDigest, ociDigestOk := pluginToolConfig["ocidigest"].(string); ociDigestOk && len(ociDigest) > 0 {
+		writeMap["trcocidigest"] = ociDigest // Pin deployments to the certified manifest.
+	} else if certifiedSha != writeMap["trcsha256"] {
+		// The pinned manifest holds the old image.
+		delete(writeMap, "trcocidigest")
+	}
+	if len(certifiedSha) > 0 && certifiedSha != writeMap["trcsha256"] {
+		// Keep what was certified before so a failed rollout can roll back to it.
+		writeMap["trcprevioussha256"] = certifiedSha
+		writeMap["trcpreviousocidigest"] = certifiedDigest
+		writeMap["trcprevioussignature"] = certifiedSignature
+		// The old signature doesn't sign the new sha.
+		delete(writeMap, "trcsignature")
+	}
+	for _, rolloutField := range []string{"rolloutstrategy", "rolloutcanary", "rolloutwaves", "rollouthealth", "rollouthealthaddr", "rollouthealthservice", "rollouthealthinsecure", "rollouthealthtimeout"} {
+		if rolloutValue, ok := pluginToolConfig[rolloutField].(string); ok && len(rolloutValue) > 0 {
+			writeMap[rolloutField] = rolloutValue
+		}
+	}
 	if pathParamPtr != "" { //optional if not found.
 		writeMap["trcpathparam"] = pathParamPtr
 	} else if pathParam, pathOK := writeMap["trcpathparam"].(string); pathOK {
@@ -985,6 +1175,10 @@ func WriteMapUpdate(writeMap map[string]interface{}, pluginToolConfig map[string
 		writeMap["trcbootstrap"] = trcbootstrap
 	}
 
+	if signature, ok := pluginToolConfig["trcsignature"].(string); ok && len(signature) > 0 {
+		writeMap["trcsignature"] = signature
+	}
+
 	writeMap["copied"] = false
 	writeMap["deployed"] = false
 	return writeMap
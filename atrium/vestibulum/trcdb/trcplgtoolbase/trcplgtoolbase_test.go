@@ -0,0 +1,35 @@
+package trcplgtoolbase
+
+import (
+	"testing"
+)
+
+func TestWriteMapUpdateDigest(t *testing.T) {
+	certified := map[string]interface{}{"trcsha256": "sha1", "trcocidigest": "sha256:digest1"}
+
+	// A new image pushed to the registry pins its own manifest.
+	writeMap := WriteMapUpdate(map[string]interface{}{"trcsha256": "sha1", "trcocidigest": "sha256:digest1", "trcsignature": "sig1"},
+		map[string]interface{}{"trcplugin": "plugin", "imagesha256": "sha2", "ocidigest": "sha256:digest2", "trcsignature": "sig2"}, false, "vault", "")
+	if writeMap["trcocidigest"] != "sha256:digest2" || writeMap["trcprevioussha256"] != "sha1" || writeMap["trcpreviousocidigest"] != "sha256:digest1" {
+		t.Fatalf("Unexpected certification %v", writeMap)
+	}
+	if writeMap["trcsignature"] != "sig2" || writeMap["trcprevioussignature"] != "sig1" {
+		t.Fatalf("Unexpected signatures %v", writeMap)
+	}
+
+	// A new sha without a digest must not stay pinned to the old image.
+	writeMap = WriteMapUpdate(map[string]interface{}{"trcsha256": "sha1", "trcocidigest": "sha256:digest1", "trcsignature": "sig1"},
+		map[string]interface{}{"trcplugin": "plugin", "trcsha256": "sha2"}, false, "vault", "")
+	if _, ok := writeMap["trcocidigest"]; ok || writeMap["trcsha256"] != "sha2" {
+		t.Fatalf("Expected the stale digest cleared %v", writeMap)
+	}
+	if _, ok := writeMap["trcsignature"]; ok || writeMap["trcprevioussignature"] != "sig1" {
+		t.Fatalf("Expected the stale signature cleared %v", writeMap)
+	}
+
+	// Recertifying the same sha keeps the pin.
+	writeMap = WriteMapUpdate(certified, map[string]interface{}{"trcplugin": "plugin", "trcsha256": "sha1"}, false, "vault", "")
+	if writeMap["trcocidigest"] != "sha256:digest1" {
+		t.Fatalf("Expected the digest kept %v", writeMap)
+	}
+}
@@ -76,6 +76,7 @@ func PluginDeployFlow(pluginConfig map[string]interface{}, logger *log.Logger) e
 	logger.Println("PluginDeployFlow begun.")
 	var err error
 	var pluginName string
+	rolloutConfig := rolloutPluginConfig(pluginConfig)
 
 	if pluginNameInterface, pluginNameOk := pluginConfig["trcplugin"]; pluginNameOk {
 		pluginName = pluginNameInterface.(string)
@@ -145,6 +146,7 @@ func PluginDeployFlow(pluginConfig map[string]interface{}, logger *log.Logger) e
 	}
 
 	//Checks if this instance of carrier is allowed to deploy that certain plugin.
+	var instanceIndex string
 	if instanceList, ok := vaultPluginSignature["instances"].(string); !ok {
 		eUtils.LogErrorMessage(carrierDriverConfig.CoreConfig, fmt.Sprintf("PluginDeployFlow failure: env: %s Plugin has no valid instances: %s", carrierDriverConfig.CoreConfig.Env, vaultPluginSignature["trcplugin"].(string)), false)
 		return nil
@@ -154,7 +156,6 @@ func PluginDeployFlow(pluginConfig map[string]interface{}, logger *log.Logger) e
 			re := regexp.MustCompile("-[0-9]+")
 			hostNameRegex := re.FindAllString(hostName, 1)
 
-			var instanceIndex string
 			if len(hostNameRegex) > 0 {
 				instanceIndex = strings.TrimPrefix(hostNameRegex[0], "-")
 			} else {
@@ -207,6 +208,34 @@ func PluginDeployFlow(pluginConfig map[string]interface{}, logger *log.Logger) e
 		agentPath = coreopts.BuildOptions.GetVaultInstallRoot() + "/plugins/" + vaultPluginSignature["trcplugin"].(string)
 	}
 
+	// Staged rollouts only deploy to the instances of the current wave.
+	rollout, rolloutErr := trcplgtool.NewRollout(vaultPluginSignature)
+	rolloutGated := false
+	if rolloutErr != nil {
+		eUtils.LogErrorMessage(carrierDriverConfig.CoreConfig, fmt.Sprintf("PluginDeployFlow failure: env: %s plugin: %s invalid rollout: %s", carrierDriverConfig.CoreConfig.Env, pluginName, rolloutErr.Error()), false)
+		return nil
+	}
+	if rollout != nil {
+		var rolloutDeploy bool
+		cGoMod.AuditReason = fmt.Sprintf("rollout %s", pluginName)
+		rolloutDeploy, rolloutGated, rolloutErr = rolloutGate(cGoMod, rollout, pluginName, vaultPluginSignature["trcsha256"].(string), instanceIndex, logger)
+		if rolloutErr != nil {
+			eUtils.LogErrorMessage(carrierDriverConfig.CoreConfig, fmt.Sprintf("PluginDeployFlow failure: env: %s plugin: %s rollout state unavailable: %s", carrierDriverConfig.CoreConfig.Env, pluginName, rolloutErr.Error()), false)
+			return nil
+		}
+		if !rolloutDeploy {
+			eUtils.LogInfo(carrierDriverConfig.CoreConfig, fmt.Sprintf("Rollout of plugin %s has not reached env: %s instance: %s\n", pluginName, carrierDriverConfig.CoreConfig.Env, instanceIndex))
+			if len(rollout.PreviousSha256) > 0 {
+				vaultPluginSignature["trcsha256"] = rollout.PreviousSha256
+			} else {
+				vaultPluginSignature["trcsha256"] = "notfound"
+			}
+			factory.PushPluginSha(carrierDriverConfig, pluginConfig, vaultPluginSignature)
+			go watchRollout(rolloutConfig, rollout, pluginName, instanceIndex, agentPath, logger)
+			return nil
+		}
+	}
+
 	if _, err := os.Stat(agentPath); errors.Is(err, os.ErrNotExist) {
 		pluginDownloadNeeded = true
 		logger.Printf("Attempting to download new image for env: %s and plugin %s\n", carrierDriverConfig.CoreConfig.Env, vaultPluginSignature["trcplugin"].(string))
@@ -352,6 +381,10 @@ func PluginDeployFlow(pluginConfig map[string]interface{}, logger *log.Logger) e
 	// This will also release any clients attempting to communicate with carrier.
 	factory.PushPluginSha(carrierDriverConfig, pluginConfig, vaultPluginSignature)
 
+	if rolloutGated {
+		go gateRolloutHealth(rolloutConfig, rollout, vaultPluginSignature, vaultPluginSignature["trcsha256"].(string), instanceIndex, hostName, agentPath, logger)
+	}
+
 	logger.Println("PluginDeployFlow complete.")
 
 	return nil
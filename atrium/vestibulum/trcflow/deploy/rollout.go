@@ -0,0 +1,325 @@
+package deploy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	trcplgtool "github.com/trimble-oss/tierceron/atrium/vestibulum/trcdb/trcplgtoolbase"
+	"github.com/trimble-oss/tierceron/buildopts/coreopts"
+	"github.com/trimble-oss/tierceron/pkg/core/cache"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+	sys "github.com/trimble-oss/tierceron/pkg/vaulthelper/system"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	rolloutHealthInterval = 10 * time.Second
+	rolloutWatchTimeout   = 24 * time.Hour
+)
+
+var rolloutPollInterval = 30 * time.Second
+
+// rolloutWatchers holds the plugins this instance is watching a rollout of.
+var rolloutWatchers sync.Map
+
+// rolloutGate decides whether this instance deploys sha now, starting a
+// new rollout if sha is newly certified.  Instances deploying within a
+// rollout in progress are gated on their health check.
+func rolloutGate(mod *helperkv.Modifier, rollout *trcplgtool.Rollout, pluginName string, sha string, instance string, logger *log.Logger) (bool, bool, error) {
+	state, err := trcplgtool.UpdateRolloutState(mod, pluginName, logger, func(state *trcplgtool.RolloutState) bool {
+		if state.Sha256 == sha || (state.State == trcplgtool.ROLLOUT_STATE_ROLLEDBACK && state.RollbackSha256 == sha) {
+			return false
+		}
+		*state = trcplgtool.RolloutState{
+			Sha256:  sha,
+			State:   trcplgtool.ROLLOUT_STATE_INPROGRESS,
+			Results: map[string]string{},
+		}
+		return true
+	})
+	if err != nil {
+		return false, false, err
+	}
+	switch state.State {
+	case trcplgtool.ROLLOUT_STATE_ROLLEDBACK:
+		// Everyone goes back at once, but never forward to the sha that failed.
+		return sha == state.RollbackSha256, false, nil
+	case trcplgtool.ROLLOUT_STATE_COMPLETE:
+		return true, false, nil
+	}
+	wave := rollout.WaveOf(instance)
+	if wave < 0 || wave > state.Wave {
+		return false, false, nil
+	}
+	return true, state.Results[instance] != trcplgtool.ROLLOUT_RESULT_HEALTHY, nil
+}
+
+// rolloutPluginConfig copies the plugin config for deploys outside of a
+// carrier update request.
+func rolloutPluginConfig(pluginConfig map[string]interface{}) map[string]interface{} {
+	rolloutConfig := map[string]interface{}{}
+	for k, v := range pluginConfig {
+		if k == "trcsha256chan" {
+			continue
+		}
+		rolloutConfig[k] = v
+	}
+	return rolloutConfig
+}
+
+// rolloutMod connects to the certification vault.
+var rolloutMod = connectRolloutMod
+
+func connectRolloutMod(pluginConfig map[string]interface{}, logger *log.Logger) (*helperkv.Modifier, *sys.Vault, error) {
+	modConfig := rolloutPluginConfig(pluginConfig)
+	modConfig["vaddress"] = pluginConfig["caddress"]
+	modConfig["tokenptr"] = pluginConfig["ctokenptr"]
+	_, mod, vault, err := eUtils.InitVaultModForPlugin(modConfig,
+		cache.NewTokenCache("config_token_pluginany", eUtils.RefMap(modConfig, "tokenptr")),
+		"config_token_pluginany", logger)
+	if err != nil {
+		if vault != nil {
+			vault.Close()
+		}
+		return nil, nil, err
+	}
+	return mod, vault, nil
+}
+
+// gateRolloutHealth waits for this instance to pass its health check on
+// sha and records the result.  A failed check rolls back every instance to
+// the previously certified sha.
+func gateRolloutHealth(pluginConfig map[string]interface{}, rollout *trcplgtool.Rollout, certification map[string]interface{}, sha string, instance string, hostName string, agentPath string, logger *log.Logger) {
+	pluginName := certification["trcplugin"].(string)
+	mod, vault, err := rolloutMod(pluginConfig, logger)
+	if err != nil {
+		logger.Printf("Rollout health check unavailable for plugin %s: %v\n", pluginName, err)
+		return
+	}
+	defer vault.Close()
+	defer mod.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rollout.HealthTimeout)
+	healthErr := checkRolloutHealth(ctx, mod, rollout, certification, sha, hostName)
+	cancel()
+	if healthErr != nil {
+		logger.Printf("Rollout health check failed for plugin %s instance %s: %v\n", pluginName, instance, healthErr)
+	} else {
+		logger.Printf("Rollout health check passed for plugin %s instance %s\n", pluginName, instance)
+	}
+	mod.AuditReason = fmt.Sprintf("rollout %s", pluginName)
+	state, err := trcplgtool.RecordRolloutResult(mod, pluginName, rollout, sha, instance, healthErr == nil, logger)
+	if err != nil {
+		logger.Printf("Unable to record rollout result for plugin %s: %v\n", pluginName, err)
+	} else if healthErr != nil {
+		if rollbackErr := rollbackRollout(mod, rollout, pluginName, sha, logger); rollbackErr != nil {
+			logger.Printf("Unable to roll back plugin %s: %v\n", pluginName, rollbackErr)
+		}
+	} else if state != nil {
+		logger.Printf("Rollout of plugin %s is %s at wave %d\n", pluginName, state.State, state.Wave+1)
+	}
+	watchRollout(pluginConfig, rollout, pluginName, instance, agentPath, logger)
+}
+
+// checkRolloutHealth polls the rollout's health check until it passes or
+// ctx is done.
+func checkRolloutHealth(ctx context.Context, mod *helperkv.Modifier, rollout *trcplgtool.Rollout, certification map[string]interface{}, sha string, hostName string) error {
+	var err error
+	for {
+		switch rollout.Health {
+		case trcplgtool.ROLLOUT_HEALTH_GRPC:
+			err = grpcHealthy(ctx, rollout)
+		default:
+			err = pluginStatusHealthy(mod, certification, sha, hostName)
+		}
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(rolloutHealthInterval):
+		}
+	}
+}
+
+// pluginStatusHealthy checks the plugin reported itself deployed with sha.
+// Agents report per host, vault plugins per region.
+func pluginStatusHealthy(mod *helperkv.Modifier, certification map[string]interface{}, sha string, hostName string) error {
+	pluginName := certification["trcplugin"].(string)
+	statusPath := fmt.Sprintf("super-secrets/Index/TrcVault/trcplugin/%s/Certify", pluginName)
+	region := ""
+	if certification["trctype"] == "agent" {
+		statusPath = fmt.Sprintf("super-secrets/Index/TrcVault/trcplugin/overrides/%s/%s/Certify", hostName, pluginName)
+	} else {
+		region = coreopts.BuildOptions.GetRegion(hostName)
+	}
+	status, _, err := mod.ReadDataVersion(statusPath)
+	if err != nil {
+		return err
+	}
+	statusField := func(field string) string {
+		if value, ok := status[field+"~"+region]; ok && region != "" {
+			return fmt.Sprintf("%v", value)
+		}
+		return fmt.Sprintf("%v", status[field])
+	}
+	if statusField("trcsha256") != sha {
+		return fmt.Errorf("plugin status sha is %s", statusField("trcsha256"))
+	}
+	if statusField("deployed") != "true" {
+		return errors.New("plugin has not reported itself deployed")
+	}
+	return nil
+}
+
+// grpcHealthy checks the grpc health service, such as the one served by
+// trchealthcheck, reports serving.
+func grpcHealthy(ctx context.Context, rollout *trcplgtool.Rollout) error {
+	conn, err := grpc.Dial(rollout.HealthAddr,
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: rollout.HealthInsecure})))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	checkCtx, cancel := context.WithTimeout(ctx, rolloutHealthInterval)
+	defer cancel()
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(checkCtx, &grpc_health_v1.HealthCheckRequest{Service: rollout.HealthService})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health service is %s", resp.GetStatus())
+	}
+	return nil
+}
+
+// rollbackRollout marks the rollout of sha rolled back and certifies the
+// previously certified sha, digest and signature again.  Only the instance making the change
+// rewrites the certification.
+func rollbackRollout(mod *helperkv.Modifier, rollout *trcplgtool.Rollout, pluginName string, sha string, logger *log.Logger) error {
+	rolledBack := false
+	_, err := trcplgtool.UpdateRolloutState(mod, pluginName, logger, func(state *trcplgtool.RolloutState) bool {
+		if state.Sha256 != sha || state.State == trcplgtool.ROLLOUT_STATE_ROLLEDBACK {
+			return false
+		}
+		state.State = trcplgtool.ROLLOUT_STATE_ROLLEDBACK
+		state.RollbackSha256 = rollout.PreviousSha256
+		rolledBack = true
+		return true
+	})
+	if err != nil || !rolledBack {
+		return err
+	}
+	if len(rollout.PreviousSha256) == 0 {
+		return errors.New("rollout halted, no previously certified sha to roll back to")
+	}
+
+	certifyPath := fmt.Sprintf("super-secrets/Index/TrcVault/trcplugin/%s/Certify", pluginName)
+	certification, version, err := mod.ReadDataVersion(certifyPath)
+	if err != nil {
+		return err
+	}
+	if certification["trcsha256"] != sha {
+		// Certified again since, so there is nothing to roll back.
+		return nil
+	}
+	certification["trcsha256"] = rollout.PreviousSha256
+	if previousDigest, ok := certification["trcpreviousocidigest"].(string); ok && len(previousDigest) > 0 {
+		certification["trcocidigest"] = previousDigest
+	} else {
+		delete(certification, "trcocidigest")
+	}
+	if previousSignature, ok := certification["trcprevioussignature"].(string); ok && len(previousSignature) > 0 {
+		certification["trcsignature"] = previousSignature
+	} else {
+		delete(certification, "trcsignature")
+	}
+	delete(certification, "trcprevioussha256")
+	delete(certification, "trcpreviousocidigest")
+	delete(certification, "trcprevioussignature")
+	certification["copied"] = false
+	certification["deployed"] = false
+	mod.SectionPath = ""
+	if _, err := mod.WriteCAS(certifyPath, certification, version, logger); err != nil {
+		return err
+	}
+	logger.Printf("Rolled back plugin %s from %s to %s\n", pluginName, sha, rollout.PreviousSha256)
+	return nil
+}
+
+// watchRollout follows a rollout this instance is part of, deploying once
+// its wave starts or the rollout is rolled back.
+func watchRollout(pluginConfig map[string]interface{}, rollout *trcplgtool.Rollout, pluginName string, instance string, agentPath string, logger *log.Logger) {
+	if _, watching := rolloutWatchers.LoadOrStore(pluginName, true); watching {
+		return
+	}
+	mod, vault, err := rolloutMod(pluginConfig, logger)
+	if err != nil {
+		rolloutWatchers.Delete(pluginName)
+		logger.Printf("Unable to watch rollout of plugin %s: %v\n", pluginName, err)
+		return
+	}
+	defer vault.Close()
+	defer mod.Release()
+
+	deployNeeded := false
+	for deadline := time.Now().Add(rolloutWatchTimeout); time.Now().Before(deadline) && !deployNeeded; {
+		time.Sleep(rolloutPollInterval)
+		state, _, err := trcplgtool.ReadRolloutState(mod, pluginName)
+		if err != nil {
+			logger.Printf("Unable to read rollout of plugin %s: %v\n", pluginName, err)
+			continue
+		}
+		switch state.State {
+		case trcplgtool.ROLLOUT_STATE_COMPLETE:
+			rolloutWatchers.Delete(pluginName)
+			return
+		case trcplgtool.ROLLOUT_STATE_ROLLEDBACK:
+			if len(state.RollbackSha256) == 0 {
+				rolloutWatchers.Delete(pluginName)
+				return
+			}
+			deployNeeded = imageSha256(agentPath) != state.RollbackSha256
+			if !deployNeeded {
+				rolloutWatchers.Delete(pluginName)
+				return
+			}
+		default:
+			wave := rollout.WaveOf(instance)
+			deployNeeded = wave >= 0 && wave <= state.Wave && imageSha256(agentPath) != state.Sha256
+		}
+	}
+	rolloutWatchers.Delete(pluginName)
+	if deployNeeded {
+		logger.Printf("Rollout of plugin %s reached instance %s\n", pluginName, instance)
+		if err := PluginDeployFlow(pluginConfig, logger); err != nil {
+			logger.Printf("Rollout deploy of plugin %s failed: %v\n", pluginName, err)
+		}
+	}
+}
+
+// imageSha256 returns the sha256 of the image at path, or "" if unreadable.
+func imageSha256(path string) string {
+	imageFile, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer imageFile.Close()
+	sha256 := sha256.New()
+	if _, err := io.Copy(sha256, imageFile); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum(nil))
+}
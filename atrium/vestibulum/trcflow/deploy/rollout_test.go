@@ -0,0 +1,170 @@
+package deploy
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	trcplgtool "github.com/trimble-oss/tierceron/atrium/vestibulum/trcdb/trcplgtoolbase"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv/kvtest"
+	sys "github.com/trimble-oss/tierceron/pkg/vaulthelper/system"
+)
+
+const testCertifyPath = "super-secrets/data/dev/Index/TrcVault/trcplugin/plugin/Certify"
+
+func testRollout(t *testing.T, instances string, certification map[string]interface{}) *trcplgtool.Rollout {
+	certification["instances"] = instances
+	certification["rolloutstrategy"] = trcplgtool.ROLLOUT_STRATEGY_CANARY
+	certification["rolloutcanary"] = "1"
+	rollout, err := trcplgtool.NewRollout(certification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rollout
+}
+
+func TestRolloutGate(t *testing.T) {
+	mod := kvtest.NewModifier(t, kvtest.NewVault(t), "dev")
+	logger := log.New(io.Discard, "", 0)
+	rollout := testRollout(t, "0,1,2", map[string]interface{}{"trcprevioussha256": "sha1"})
+
+	gate := func(sha string, instance string, wantDeploy bool, wantGated bool) {
+		t.Helper()
+		deploy, gated, err := rolloutGate(mod, rollout, "plugin", sha, instance, logger)
+		if err != nil || deploy != wantDeploy || gated != wantGated {
+			t.Fatalf("Expected instance %s deploy %v gated %v on %s, got %v %v %v", instance, wantDeploy, wantGated, sha, deploy, gated, err)
+		}
+	}
+
+	// Only the canary deploys a newly certified sha.
+	gate("sha2", "0", true, true)
+	gate("sha2", "2", false, false)
+	if state, _, _ := trcplgtool.ReadRolloutState(mod, "plugin"); state.Sha256 != "sha2" || state.State != trcplgtool.ROLLOUT_STATE_INPROGRESS {
+		t.Fatalf("Unexpected rollout %v", state)
+	}
+
+	// The rest follow once the canary is healthy, and the canary isn't gated again.
+	if _, err := trcplgtool.RecordRolloutResult(mod, "plugin", rollout, "sha2", "0", true, logger); err != nil {
+		t.Fatal(err)
+	}
+	gate("sha2", "0", true, false)
+	gate("sha2", "2", true, true)
+
+	// A rolled back rollout only deploys the sha rolled back to.
+	if err := rollbackRollout(mod, rollout, "plugin", "sha2", logger); err != nil {
+		t.Fatal(err)
+	}
+	gate("sha2", "2", false, false)
+	gate("sha1", "2", true, false)
+
+	// Certifying again starts over.
+	gate("sha3", "1", false, false)
+	gate("sha3", "0", true, true)
+}
+
+func TestRollbackRollout(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	mod := kvtest.NewModifier(t, vault, "dev")
+	logger := log.New(io.Discard, "", 0)
+	certification := map[string]interface{}{
+		"trcplugin":            "plugin",
+		"trcsha256":            "sha2",
+		"trcocidigest":         "sha256:digest2",
+		"trcsignature":         "sig2",
+		"trcprevioussha256":    "sha1",
+		"trcpreviousocidigest": "sha256:digest1",
+		"trcprevioussignature": "sig1",
+	}
+	vault.Put(testCertifyPath, certification)
+	rollout := testRollout(t, "0,1", certification)
+	if _, _, err := rolloutGate(mod, rollout, "plugin", "sha2", "0", logger); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rollbackRollout(mod, rollout, "plugin", "sha2", logger); err != nil {
+		t.Fatal(err)
+	}
+	restored := vault.Get(testCertifyPath)
+	if restored["trcsha256"] != "sha1" || restored["trcocidigest"] != "sha256:digest1" || restored["trcsignature"] != "sig1" {
+		t.Fatalf("Expected the previous certification restored %v", restored)
+	}
+	for _, previous := range []string{"trcprevioussha256", "trcpreviousocidigest", "trcprevioussignature"} {
+		if _, ok := restored[previous]; ok {
+			t.Fatalf("Expected %s cleared %v", previous, restored)
+		}
+	}
+	if state, _, _ := trcplgtool.ReadRolloutState(mod, "plugin"); state.State != trcplgtool.ROLLOUT_STATE_ROLLEDBACK || state.RollbackSha256 != "sha1" {
+		t.Fatalf("Unexpected rollout %v", state)
+	}
+
+	// Only the first instance to fail rolls back.
+	vault.Put(testCertifyPath, map[string]interface{}{"trcsha256": "sha2"})
+	if err := rollbackRollout(mod, rollout, "plugin", "sha2", logger); err != nil || vault.Get(testCertifyPath)["trcsha256"] != "sha2" {
+		t.Fatalf("Expected no second rollback %v", err)
+	}
+
+	// Without a previous sha the rollout halts.
+	rollout = testRollout(t, "0,1", map[string]interface{}{})
+	if _, _, err := rolloutGate(mod, rollout, "plugin", "sha3", "0", logger); err != nil {
+		t.Fatal(err)
+	}
+	if err := rollbackRollout(mod, rollout, "plugin", "sha3", logger); err == nil {
+		t.Fatal("Expected a halted rollout")
+	}
+}
+
+func TestGateRolloutHealth(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	mod := kvtest.NewModifier(t, vault, "dev")
+	logger := log.New(io.Discard, "", 0)
+	defer func(connect func(map[string]interface{}, *log.Logger) (*helperkv.Modifier, *sys.Vault, error), interval time.Duration) {
+		rolloutMod = connect
+		rolloutPollInterval = interval
+	}(rolloutMod, rolloutPollInterval)
+	rolloutMod = func(map[string]interface{}, *log.Logger) (*helperkv.Modifier, *sys.Vault, error) {
+		return mod, &sys.Vault{}, nil
+	}
+	rolloutPollInterval = time.Millisecond
+
+	agentPath := filepath.Join(t.TempDir(), "plugin")
+	if err := os.WriteFile(agentPath, []byte("previous image"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	previousSha := fmt.Sprintf("%x", sha256.Sum256([]byte("previous image")))
+	statusPath := "super-secrets/data/dev/Index/TrcVault/trcplugin/overrides/host/plugin/Certify"
+	certification := map[string]interface{}{"trcplugin": "plugin", "trctype": "agent", "trcsha256": "sha2", "trcprevioussha256": previousSha}
+	vault.Put(testCertifyPath, certification)
+	rollout := testRollout(t, "0", certification)
+	rollout.HealthTimeout = 10 * time.Millisecond
+
+	// A healthy instance completes the rollout.
+	vault.Put(statusPath, map[string]interface{}{"trcsha256": "sha2", "deployed": true})
+	if _, _, err := rolloutGate(mod, rollout, "plugin", "sha2", "0", logger); err != nil {
+		t.Fatal(err)
+	}
+	gateRolloutHealth(nil, rollout, certification, "sha2", "0", "host", agentPath, logger)
+	if state, _, _ := trcplgtool.ReadRolloutState(mod, "plugin"); state.State != trcplgtool.ROLLOUT_STATE_COMPLETE || state.Results["0"] != trcplgtool.ROLLOUT_RESULT_HEALTHY {
+		t.Fatalf("Expected a complete rollout %v", state)
+	}
+
+	// An instance failing its check rolls everyone back.
+	vault.Put(testCertifyPath, map[string]interface{}{"trcplugin": "plugin", "trcsha256": "sha3", "trcprevioussha256": previousSha})
+	vault.Put(statusPath, map[string]interface{}{"trcsha256": "sha2", "deployed": true})
+	if _, _, err := rolloutGate(mod, rollout, "plugin", "sha3", "0", logger); err != nil {
+		t.Fatal(err)
+	}
+	gateRolloutHealth(nil, rollout, certification, "sha3", "0", "host", agentPath, logger)
+	state, _, _ := trcplgtool.ReadRolloutState(mod, "plugin")
+	if state.State != trcplgtool.ROLLOUT_STATE_ROLLEDBACK || state.Results["0"] != trcplgtool.ROLLOUT_RESULT_UNHEALTHY || state.RollbackSha256 != previousSha {
+		t.Fatalf("Expected a rolled back rollout %v", state)
+	}
+	if vault.Get(testCertifyPath)["trcsha256"] != previousSha {
+		t.Fatalf("Expected the previous sha certified %v", vault.Get(testCertifyPath))
+	}
+}
@@ -510,13 +510,19 @@ func ProcessFlows(pluginConfig map[string]interface{}, logger *log.Logger) error
 		controllerVaultDatabaseConfig["dbuser"] = cdbuser
 		controllerCheck++
 	}
+	// The controller interface has its own grants, or just controllerdbuser.
+	if cdbgrants, ok := vaultDatabaseConfig["controllerdbgrants"]; ok {
+		controllerVaultDatabaseConfig["dbgrants"] = cdbgrants
+	} else {
+		delete(controllerVaultDatabaseConfig, "dbgrants")
+	}
 
 	controllerVaultDatabaseConfig["controller"] = true
 
 	if controllerCheck == 3 {
 		eUtils.LogInfo(driverConfig.CoreConfig, "Starting controller interface...")
 		controllerVaultDatabaseConfig["vaddress"] = strings.Split(controllerVaultDatabaseConfig["vaddress"].(string), ":")[0]
-		controllerInterfaceErr := harbingeropts.BuildOptions.BuildInterface(driverConfig, goMod, tfmFlumeContext, controllerVaultDatabaseConfig, &TrcDBServerEventListener{Log: driverConfig.CoreConfig.Log})
+		controllerInterfaceErr := harbingeropts.BuildOptions.BuildInterface(driverConfig, goMod, tfmFlumeContext, controllerVaultDatabaseConfig, NewTrcDBServerEventListener(driverConfig.CoreConfig.Log, controllerVaultDatabaseConfig))
 		if controllerInterfaceErr != nil {
 			eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to start up controller database interface:"+controllerInterfaceErr.Error(), false)
 			return controllerInterfaceErr
@@ -551,7 +557,7 @@ func ProcessFlows(pluginConfig map[string]interface{}, logger *log.Logger) error
 	}
 
 	eUtils.LogInfo(driverConfig.CoreConfig, "Starting db interface...")
-	interfaceErr := harbingeropts.BuildOptions.BuildInterface(driverConfig, goMod, tfmContext, vaultDatabaseConfig, &TrcDBServerEventListener{Log: driverConfig.CoreConfig.Log})
+	interfaceErr := harbingeropts.BuildOptions.BuildInterface(driverConfig, goMod, tfmContext, vaultDatabaseConfig, NewTrcDBServerEventListener(driverConfig.CoreConfig.Log, vaultDatabaseConfig))
 	if interfaceErr != nil {
 		eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to start up database interface:"+interfaceErr.Error(), false)
 		return interfaceErr
@@ -1,9 +1,11 @@
 package flumen
 
 import (
+	"io"
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	flowcore "github.com/trimble-oss/tierceron/atrium/trcflow/core"
 
@@ -17,14 +19,28 @@ import (
 var changeLock sync.Mutex
 
 type TrcDBServerEventListener struct {
-	Log *log.Logger
+	Log                  *log.Logger
+	AuditLog             io.Writer     // query audit records, as json lines
+	SlowQueryThreshold   time.Duration // 0 disables the slow query log
+	MaxConcurrentQueries int           // per user, 0 is unlimited
+	MaxQueriesPerSecond  float64       // per user, 0 is unlimited
+
+	connections int64
+	queriesMu   sync.Mutex
+	queries     map[uint64]*governedQuery
+	users       map[string]*userLimits
+	auditMu     sync.Mutex
 }
 
 var _ server.ServerEventListener = (*TrcDBServerEventListener)(nil)
 
-func (t *TrcDBServerEventListener) ClientConnected() {}
+func (tl *TrcDBServerEventListener) ClientConnected() {
+	atomic.AddInt64(&tl.connections, 1)
+}
 
-func (tl *TrcDBServerEventListener) ClientDisconnected() {}
+func (tl *TrcDBServerEventListener) ClientDisconnected() {
+	atomic.AddInt64(&tl.connections, -1)
+}
 
 func (tl *TrcDBServerEventListener) QueryStarted( /* query string */ ) {
 	//	if query contains "FOR UPDATE" {
@@ -0,0 +1,246 @@
+package flumen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	sqles "github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/vitess/go/vt/sqlparser"
+	"golang.org/x/time/rate"
+)
+
+// QueryAuditRecord is written for every query run through the interface.
+// Statements are recorded with their literals redacted.
+type QueryAuditRecord struct {
+	Time         string `json:"time"`
+	ConnectionID uint32 `json:"connectionId"`
+	User         string `json:"user"`
+	Address      string `json:"address"`
+	Fingerprint  string `json:"fingerprint"`
+	Statement    string `json:"statement"`
+	DurationMs   int64  `json:"durationMs"`
+	WaitedMs     int64  `json:"waitedMs,omitempty"`
+	RowsAffected int64  `json:"rowsAffected"`
+	Slow         bool   `json:"slow,omitempty"`
+}
+
+// userLimits throttles the queries of a single user.
+type userLimits struct {
+	running chan struct{} // concurrency slots, nil when unlimited
+	rate    *rate.Limiter // nil when unlimited
+}
+
+type governedQuery struct {
+	connectionID uint32
+	user         string
+	address      string
+	query        string
+	session      sqles.Session
+	start        time.Time
+	waited       time.Duration
+	slot         *userLimits // set while holding a concurrency slot
+}
+
+// NewTrcDBServerEventListener creates a listener governed by the TrcDb
+// config:
+//
+//	dbauditlog             append query audit records to this file
+//	dbslowqueryms          log queries running at least this long
+//	dbmaxconcurrentqueries queries each user may run at once
+//	dbmaxqueriespersecond  queries each user may start per second
+//
+// Users over a limit wait rather than fail, so a noisy client is slowed down
+// without starving anyone else.
+func NewTrcDBServerEventListener(logger *log.Logger, vaultDatabaseConfig map[string]interface{}) *TrcDBServerEventListener {
+	tl := &TrcDBServerEventListener{Log: logger}
+	if auditLog, ok := vaultDatabaseConfig["dbauditlog"].(string); ok && len(auditLog) > 0 {
+		auditFile, err := os.OpenFile(auditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			logger.Printf("Unable to open query audit log %s: %v\n", auditLog, err)
+		} else {
+			tl.AuditLog = auditFile
+		}
+	}
+	tl.SlowQueryThreshold = time.Duration(configNumber(vaultDatabaseConfig, "dbslowqueryms")) * time.Millisecond
+	tl.MaxConcurrentQueries = int(configNumber(vaultDatabaseConfig, "dbmaxconcurrentqueries"))
+	tl.MaxQueriesPerSecond = configNumber(vaultDatabaseConfig, "dbmaxqueriespersecond")
+	return tl
+}
+
+func configNumber(vaultDatabaseConfig map[string]interface{}, key string) float64 {
+	switch value := vaultDatabaseConfig[key].(type) {
+	case string:
+		number, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return number
+	case int:
+		return float64(value)
+	case float64:
+		return value
+	}
+	return 0
+}
+
+// GovernEngine routes the engine's queries through the listener's audit log,
+// slow query log and per user limits.  It must be called before the server is
+// created from the engine.
+func (tl *TrcDBServerEventListener) GovernEngine(engine *sqle.Engine) {
+	engine.ProcessList = &governedProcessList{ProcessList: engine.ProcessList, tl: tl}
+}
+
+// governedProcessList sees every query with its session as it starts and
+// finishes.
+type governedProcessList struct {
+	sqles.ProcessList
+	tl *TrcDBServerEventListener
+}
+
+// AddProcess implements sql.ProcessList.
+func (pl *governedProcessList) AddProcess(ctx *sqles.Context, query string) (*sqles.Context, error) {
+	gq := pl.tl.admit(ctx, query)
+	processCtx, err := pl.ProcessList.AddProcess(ctx, query)
+	if err != nil {
+		pl.tl.release(gq)
+		return processCtx, err
+	}
+	pl.tl.queriesMu.Lock()
+	if pl.tl.queries == nil {
+		pl.tl.queries = map[uint64]*governedQuery{}
+	}
+	pl.tl.queries[ctx.Pid()] = gq
+	pl.tl.queriesMu.Unlock()
+	return processCtx, nil
+}
+
+// Done implements sql.ProcessList.
+func (pl *governedProcessList) Done(pid uint64) {
+	pl.ProcessList.Done(pid)
+	pl.tl.queriesMu.Lock()
+	gq, ok := pl.tl.queries[pid]
+	delete(pl.tl.queries, pid)
+	pl.tl.queriesMu.Unlock()
+	if ok {
+		pl.tl.release(gq)
+		pl.tl.completed(gq)
+	}
+}
+
+func (tl *TrcDBServerEventListener) limitsFor(user string) *userLimits {
+	tl.queriesMu.Lock()
+	defer tl.queriesMu.Unlock()
+	if tl.users == nil {
+		tl.users = map[string]*userLimits{}
+	}
+	limits, ok := tl.users[user]
+	if !ok {
+		limits = &userLimits{}
+		if tl.MaxConcurrentQueries > 0 {
+			limits.running = make(chan struct{}, tl.MaxConcurrentQueries)
+		}
+		if tl.MaxQueriesPerSecond > 0 {
+			burst := int(tl.MaxQueriesPerSecond)
+			if burst < 1 {
+				burst = 1
+			}
+			limits.rate = rate.NewLimiter(rate.Limit(tl.MaxQueriesPerSecond), burst)
+		}
+		tl.users[user] = limits
+	}
+	return limits
+}
+
+// admit waits until the session's user is within its limits.
+func (tl *TrcDBServerEventListener) admit(ctx *sqles.Context, query string) *governedQuery {
+	client := ctx.Session.Client()
+	gq := &governedQuery{
+		connectionID: ctx.Session.ID(),
+		user:         client.User,
+		address:      client.Address,
+		query:        query,
+		session:      ctx.Session,
+	}
+	limits := tl.limitsFor(client.User)
+	waitStart := time.Now()
+	if limits.rate != nil {
+		limits.rate.Wait(ctx)
+	}
+	if limits.running != nil {
+		select {
+		case limits.running <- struct{}{}:
+			gq.slot = limits
+		case <-ctx.Done():
+		}
+	}
+	gq.start = time.Now()
+	gq.waited = gq.start.Sub(waitStart)
+	if tl.Log != nil && gq.waited >= time.Second {
+		tl.Log.Printf("Throttled %s@%s for %v\n", gq.user, gq.address, gq.waited)
+	}
+	return gq
+}
+
+func (tl *TrcDBServerEventListener) release(gq *governedQuery) {
+	if gq.slot != nil {
+		<-gq.slot.running
+		gq.slot = nil
+	}
+}
+
+// QueryFingerprint redacts the literals of a statement and returns it with a
+// short hash identifying statements of the same shape.
+func QueryFingerprint(query string) (string, string) {
+	statement, err := sqlparser.RedactSQLQuery(query)
+	if err != nil {
+		// Unparseable statements are kept to their leading keyword.
+		statement = strings.ToUpper(strings.SplitN(strings.TrimSpace(query), " ", 2)[0]) + " ..."
+	}
+	sum := sha256.Sum256([]byte(statement))
+	return hex.EncodeToString(sum[:8]), statement
+}
+
+func (tl *TrcDBServerEventListener) completed(gq *governedQuery) {
+	duration := time.Since(gq.start)
+	slow := tl.SlowQueryThreshold > 0 && duration >= tl.SlowQueryThreshold
+	if tl.AuditLog == nil && !slow {
+		return
+	}
+	fingerprint, statement := QueryFingerprint(gq.query)
+	if slow && tl.Log != nil {
+		tl.Log.Printf("Slow query (%v) by %s@%s on connection %d with %d clients connected: %s\n", duration, gq.user, gq.address, gq.connectionID, atomic.LoadInt64(&tl.connections), statement)
+	}
+	if tl.AuditLog == nil {
+		return
+	}
+	rowsAffected := gq.session.GetLastQueryInfo(sqles.RowCount)
+	if rowsAffected < 0 {
+		rowsAffected = 0
+	}
+	record := QueryAuditRecord{
+		Time:         gq.start.UTC().Format(time.RFC3339Nano),
+		ConnectionID: gq.connectionID,
+		User:         gq.user,
+		Address:      gq.address,
+		Fingerprint:  fingerprint,
+		Statement:    statement,
+		DurationMs:   duration.Milliseconds(),
+		WaitedMs:     gq.waited.Milliseconds(),
+		RowsAffected: rowsAffected,
+		Slow:         slow,
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	tl.auditMu.Lock()
+	defer tl.auditMu.Unlock()
+	if _, err := tl.AuditLog.Write(append(recordBytes, '\n')); err != nil && tl.Log != nil {
+		tl.Log.Printf("Failed to write query audit record: %v\n", err)
+	}
+}
@@ -0,0 +1,92 @@
+package flumen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	sqles "github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/information_schema"
+)
+
+func TestQueryGovernor(t *testing.T) {
+	engine := sqle.NewDefault(sqles.NewDatabaseProvider(memory.NewDatabase("TrcDb"), information_schema.NewInformationSchemaDatabase()))
+	var audit bytes.Buffer
+	tl := &TrcDBServerEventListener{Log: log.New(io.Discard, "", 0), AuditLog: &audit, MaxConcurrentQueries: 1, MaxQueriesPerSecond: 10}
+	tl.GovernEngine(engine)
+
+	// Queries run the way the server runs them: added to the process list
+	// and done when their rows are closed.
+	var pidLock sync.Mutex
+	pid := uint64(0)
+	run := func(query string) {
+		pidLock.Lock()
+		pid++
+		session := sqles.NewBaseSessionWithClientServer("", sqles.Client{User: "reporting", Address: "10.0.0.1"}, 7)
+		ctx := sqles.NewContext(context.Background(), sqles.WithSession(session), sqles.WithPid(pid), sqles.WithProcessList(engine.ProcessList))
+		pidLock.Unlock()
+		ctx, err := engine.ProcessList.AddProcess(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rows, err := engine.Query(ctx, query)
+		if err != nil {
+			t.Error(err)
+			engine.ProcessList.Done(ctx.Pid())
+			return
+		}
+		for {
+			if _, err := rows.Next(ctx); err != nil {
+				break
+			}
+		}
+		rows.Close(ctx)
+	}
+	run("CREATE TABLE TrcDb.Customer (id int primary key, name text)")
+	run("INSERT INTO TrcDb.Customer VALUES (1, 'secret'), (2, 'other')")
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run("SELECT * FROM TrcDb.Customer WHERE name = 'secret'")
+		}()
+	}
+	wg.Wait()
+
+	if strings.Contains(audit.String(), "secret") {
+		t.Error("audit log records literals")
+	}
+	records := []QueryAuditRecord{}
+	waited := false
+	for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+		record := QueryAuditRecord{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.User != "reporting" || record.Address != "10.0.0.1" || record.ConnectionID != 7 {
+			t.Errorf("unexpected record %v", record)
+		}
+		waited = waited || record.WaitedMs > 0
+		records = append(records, record)
+	}
+	if len(records) != 14 {
+		t.Fatalf("expected 14 audit records, got %d", len(records))
+	}
+	if records[1].RowsAffected != 2 {
+		t.Errorf("expected insert to affect 2 rows, got %d", records[1].RowsAffected)
+	}
+	if records[2].Fingerprint != records[13].Fingerprint {
+		t.Error("expected selects to share a fingerprint")
+	}
+	if !waited {
+		t.Error("expected queries over the rate limit to wait")
+	}
+}
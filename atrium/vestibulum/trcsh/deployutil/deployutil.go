@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/trimble-oss/tierceron/buildopts/coreopts"
 	"github.com/trimble-oss/tierceron/buildopts/kernelopts"
@@ -20,8 +22,16 @@ const (
 	KERNEL_PIDFILE = "/tmp/trcshk.pid"
 )
 
-// Watches for pidfile deletion and exits  Used by kubernetes to manage pods
-func KernelShutdownWatcher(logger *log.Logger) {
+// Watches for pidfile deletion or SIGTERM and calls shutdown, which drains
+// the kernel before exiting.  Used by kubernetes to manage pods.
+func KernelShutdownWatcher(shutdown func(reason string), logger *log.Logger) {
+	termChan := make(chan os.Signal, 1)
+	signal.Notify(termChan, syscall.SIGTERM)
+	go func() {
+		<-termChan
+		shutdown("SIGTERM")
+	}()
+
 	if _, err := os.Stat(KERNEL_PIDFILE); os.IsNotExist(err) {
 		_, mkErr := os.Create(KERNEL_PIDFILE)
 		if mkErr != nil {
@@ -42,11 +52,11 @@ func KernelShutdownWatcher(logger *log.Logger) {
 			select {
 			case event := <-watcher.Events:
 				if event.Op&fsnotify.Remove == fsnotify.Remove {
-					os.Exit(0)
+					shutdown("pidfile removed")
 				}
 			case err := <-watcher.Errors:
 				l.Printf("Pidfile watch error: %s.  Shutting down\n", err.Error())
-				os.Exit(0)
+				shutdown("pidfile watch error")
 			}
 		}
 	}(logger)
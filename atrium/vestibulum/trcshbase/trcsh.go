@@ -43,8 +43,10 @@ import (
 	"github.com/trimble-oss/tierceron/pkg/core/cache"
 	"github.com/trimble-oss/tierceron/pkg/core/util"
 	"github.com/trimble-oss/tierceron/pkg/core/util/hive"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
 	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	"go.opentelemetry.io/otel/attribute"
 	"gopkg.in/yaml.v2"
 
 	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
@@ -53,6 +55,7 @@ import (
 var gAgentConfig *capauth.AgentConfigs = nil
 var gTrcshConfig *capauth.TrcShConfig
 var kernelPluginHandler *hive.PluginHandler = nil
+var kernelPluginHandlerLock sync.Mutex // Guards kernelPluginHandler for the shutdown watcher.
 
 var (
 	MODE_PERCH_STR string = string([]byte{cap.MODE_PERCH})
@@ -364,6 +367,10 @@ func CommonMain(envPtr *string, addrPtr *string, envCtxPtr *string,
 			os.Exit(124)
 		}
 
+		defer telemetry.InitFromEnv("trcsh", trcshDriverConfig.DriverConfig.CoreConfig.Log)()
+		endRun := eUtils.StartRun(trcshDriverConfig.DriverConfig.CoreConfig, "trcsh", attribute.String("trc.env", trcshDriverConfig.DriverConfig.CoreConfig.Env))
+		defer endRun(nil)
+
 		//Open deploy script and parse it.
 		ProcessDeploy(nil, trcshDriverConfig, "", *trcPathPtr, *projectServicePtr, secretIDPtr, appRoleIDPtr, dronePtr)
 	} else {
@@ -377,7 +384,20 @@ func CommonMain(envPtr *string, addrPtr *string, envCtxPtr *string,
 		}
 
 		if kernelopts.BuildOptions.IsKernel() {
-			go deployutil.KernelShutdownWatcher(driverConfigPtr.CoreConfig.Log)
+			go deployutil.KernelShutdownWatcher(func(reason string) {
+				// Plugins only start once the kernel handler exists.
+				kernelPluginHandlerLock.Lock()
+				pluginHandler := kernelPluginHandler
+				kernelPluginHandlerLock.Unlock()
+				if pluginHandler == nil {
+					driverConfigPtr.CoreConfig.Log.Printf("Kernel shutting down before startup: %s\n", reason)
+					os.Exit(0)
+				}
+				pluginHandler.Shutdown(driverConfigPtr, reason)
+			}, driverConfigPtr.CoreConfig.Log)
+		}
+		if !kernelopts.BuildOptions.IsKernel() {
+			defer telemetry.InitFromEnv("trcsh", driverConfigPtr.CoreConfig.Log)()
 		}
 		var agentEnv string
 		var addressPtr *string
@@ -424,6 +444,19 @@ func CommonMain(envPtr *string, addrPtr *string, envCtxPtr *string,
 			} else {
 				driverConfigPtr.CoreConfig.Log.Println("Error reading config value")
 			}
+			if drainTimeout, ok := config["drain_timeout"].(int); ok && drainTimeout > 0 {
+				hive.KernelDrainTimeout = time.Duration(drainTimeout) * time.Second
+			}
+			if drainSenders, ok := config["drain_senders"].(string); ok {
+				hive.AllowKernelDrain(strings.Split(drainSenders, ",")...)
+			}
+			if telemetryConfig, err := telemetry.NewTelemetryConfig(config); err != nil {
+				driverConfigPtr.CoreConfig.Log.Printf("Continuing without telemetry: %v\n", err)
+			} else if shutdown, err := telemetry.Init("trcshk", telemetryConfig, driverConfigPtr.CoreConfig.Log); err != nil {
+				driverConfigPtr.CoreConfig.Log.Printf("Continuing without telemetry: %v\n", err)
+			} else {
+				hive.OnKernelShutdown(shutdown)
+			}
 			if deployments, ok := config["deployments"].(string); ok {
 				deploymentsShard = deployments
 			} else {
@@ -724,9 +757,13 @@ func CommonMain(envPtr *string, addrPtr *string, envCtxPtr *string,
 		}
 
 		if kernelopts.BuildOptions.IsKernel() && kernelPluginHandler == nil {
-			kernelPluginHandler = hive.InitKernel(fmt.Sprintf("%s-%d", kernelName, kernelId))
-			kernelPluginHandler.ConfigContext.Log = driverConfigPtr.CoreConfig.Log
+			pluginHandler := hive.InitKernel(fmt.Sprintf("%s-%d", kernelName, kernelId))
+			pluginHandler.ConfigContext.Log = driverConfigPtr.CoreConfig.Log
+			kernelPluginHandlerLock.Lock()
+			kernelPluginHandler = pluginHandler
+			kernelPluginHandlerLock.Unlock()
 			go kernelPluginHandler.DynamicReloader(trcshDriverConfig.DriverConfig)
+			go kernelPluginHandler.CertInventoryMonitor(trcshDriverConfig.DriverConfig)
 		}
 
 		trcshDriverConfig.DriverConfig.CoreConfig.Log.Println("Completed bootstrapping and continuing to initialize services.")
@@ -786,6 +823,10 @@ func CommonMain(envPtr *string, addrPtr *string, envCtxPtr *string,
 					projectService *string) {
 					for {
 						deploy := <-*kernelPluginHandler.KernelCtx.DeployRestartChan
+						if hive.IsKernelDraining() {
+							driverConfigPtr.CoreConfig.Log.Printf("Kernel draining, not restarting deploy for %s.\n", deploy)
+							continue
+						}
 						driverConfigPtr.CoreConfig.Log.Printf("Restarting deploy for %s.\n", deploy)
 						go EnableDeployer(driverConfigPtr,
 							env,
@@ -1548,6 +1589,7 @@ collaboratorReRun:
 				if len(trcshDriverConfig.DriverConfig.CoreConfig.Regions) > 0 {
 					region = trcshDriverConfig.DriverConfig.CoreConfig.Regions[0]
 				}
+				endStep := eUtils.StartRun(trcshDriverConfig.DriverConfig.CoreConfig, "trcsh.deploy.step", attribute.String("trc.deployment", deployment), attribute.String("trc.deploy.command", control))
 				err := processDroneCmds(
 					trcKubeDeploymentConfig,
 					&onceKubeInit,
@@ -1558,6 +1600,7 @@ collaboratorReRun:
 					argsOrig,
 					strings.Split(deployLine, " "),
 					&configCount)
+				endStep(err)
 				if err != nil {
 					if strings.Contains(err.Error(), "Forbidden") {
 						// Critical agent setup error.
@@ -1585,6 +1628,7 @@ collaboratorReRun:
 					region = trcshDriverConfig.DriverConfig.CoreConfig.Regions[0]
 				}
 
+				endStep := eUtils.StartRun(trcshDriverConfig.DriverConfig.CoreConfig, "trcsh.deploy.step", attribute.String("trc.deployment", deployment), attribute.String("trc.deploy.command", control))
 				processPluginCmds(
 					&trcKubeDeploymentConfig,
 					&onceKubeInit,
@@ -1596,6 +1640,7 @@ collaboratorReRun:
 					argsOrig,
 					strings.Split(deployLine, " "),
 					&configCount)
+				endStep(nil)
 			}
 		}
 	}
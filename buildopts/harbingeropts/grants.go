@@ -0,0 +1,578 @@
+package harbingeropts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/server"
+	sqles "github.com/dolthub/go-mysql-server/sql"
+	"github.com/dolthub/go-mysql-server/sql/mysql_db"
+	"github.com/dolthub/vitess/go/mysql"
+	trcdb "github.com/trimble-oss/tierceron/atrium/trcdb"
+	flowcore "github.com/trimble-oss/tierceron/atrium/trcflow/core"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+)
+
+// DEFAULT_REPLICA_REFRESH How often read only replicas are refreshed from
+// their source tables when the grants spec doesn't say.
+const DEFAULT_REPLICA_REFRESH = 60 * time.Second
+
+// GrantsSpec is the declarative set of users and privileges for the SQL
+// interface.  It is stored as json under the dbgrants key of the TrcDb
+// config (controllerdbgrants for the controller interface):
+//
+//	{
+//	  "clientCA": "-----BEGIN CERTIFICATE-----...",
+//	  "roles": {
+//	    "reader": [{"tables": ["*"], "privileges": ["SELECT"]}],
+//	    "support": [{"tables": ["Customer"], "columns": ["id", "name"], "privileges": ["SELECT"]}]
+//	  },
+//	  "users": [
+//	    {"user": "etl", "password": "...", "hosts": ["10.0.0.0/8"], "grants": [{"tables": ["*Changes"], "privileges": ["INSERT", "UPDATE", "DELETE"]}], "roles": ["reader"]},
+//	    {"user": "helpdesk", "password": "...", "roles": ["support"], "certIdentity": "helpdesk.example.com"}
+//	  ]
+//	}
+//
+// Table names are matched with path.Match patterns.  A user's own grants are
+// checked before those of its roles, in order, and the first grant matching a
+// table decides its privileges.  Grants listing columns are served from a read
+// only replica of the table holding just those columns, named
+// <table>_<role or user>, as the engine has no column privileges.
+//
+// The spec is read once when the SQL interface starts.  Changes to users,
+// roles or grants take effect on the next restart; replica refreshes only
+// recopy data.
+type GrantsSpec struct {
+	Users                 []UserGrant             `json:"users"`
+	Roles                 map[string][]TableGrant `json:"roles,omitempty"`
+	ClientCA              string                  `json:"clientCA,omitempty"`
+	RequireClientCert     bool                    `json:"requireClientCert,omitempty"`
+	ReplicaRefreshSeconds int                     `json:"replicaRefreshSeconds,omitempty"`
+}
+
+// UserGrant is a login for the SQL interface.  Hosts default to the cidrblock
+// of the TrcDb config.  When CertIdentity is set, sessions for the user must
+// present a client certificate with that common name or DNS SAN.
+type UserGrant struct {
+	User         string       `json:"user"`
+	Password     string       `json:"password"`
+	Hosts        []string     `json:"hosts,omitempty"`
+	Roles        []string     `json:"roles,omitempty"`
+	Grants       []TableGrant `json:"grants,omitempty"`
+	CertIdentity string       `json:"certIdentity,omitempty"`
+}
+
+// TableGrant gives privileges on the tables matching any of Tables.
+type TableGrant struct {
+	Tables     []string `json:"tables"`
+	Columns    []string `json:"columns,omitempty"`
+	Privileges []string `json:"privileges"`
+}
+
+var grantPrivileges = map[string]bool{
+	"SELECT": true,
+	"INSERT": true,
+	"UPDATE": true,
+	"DELETE": true,
+}
+
+// ownedGrant is a grant along with the role or user it was defined by.
+type ownedGrant struct {
+	owner string
+	TableGrant
+}
+
+// ParseGrants reads and validates a grants spec.
+func ParseGrants(spec string) (*GrantsSpec, error) {
+	grantsSpec := &GrantsSpec{}
+	if err := json.Unmarshal([]byte(spec), grantsSpec); err != nil {
+		return nil, fmt.Errorf("invalid grants spec: %v", err)
+	}
+	if err := grantsSpec.validate(); err != nil {
+		return nil, err
+	}
+	return grantsSpec, nil
+}
+
+func validateTableGrants(owner string, tableGrants []TableGrant) error {
+	for i := range tableGrants {
+		tableGrant := &tableGrants[i]
+		if len(tableGrant.Tables) == 0 {
+			return fmt.Errorf("grant %d of %s has no tables", i, owner)
+		}
+		for _, table := range tableGrant.Tables {
+			if _, err := path.Match(table, ""); err != nil {
+				return fmt.Errorf("grant %d of %s has a bad table pattern %s", i, owner, table)
+			}
+		}
+		if len(tableGrant.Privileges) == 0 {
+			return fmt.Errorf("grant %d of %s has no privileges", i, owner)
+		}
+		for j, privilege := range tableGrant.Privileges {
+			privilege = strings.ToUpper(strings.TrimSpace(privilege))
+			if !grantPrivileges[privilege] {
+				return fmt.Errorf("grant %d of %s has unsupported privilege %s", i, owner, privilege)
+			}
+			if len(tableGrant.Columns) > 0 && privilege != "SELECT" {
+				return fmt.Errorf("grant %d of %s lists columns, which are read only", i, owner)
+			}
+			tableGrant.Privileges[j] = privilege
+		}
+	}
+	return nil
+}
+
+func (g *GrantsSpec) validate() error {
+	for role, tableGrants := range g.Roles {
+		if err := validateTableGrants("role "+role, tableGrants); err != nil {
+			return err
+		}
+	}
+	users := map[string]bool{}
+	for _, user := range g.Users {
+		if len(user.User) == 0 || len(user.Password) == 0 {
+			return errors.New("grants spec users need a user and password")
+		}
+		if users[user.User] {
+			return fmt.Errorf("user %s is listed more than once", user.User)
+		}
+		users[user.User] = true
+		if err := validateTableGrants("user "+user.User, user.Grants); err != nil {
+			return err
+		}
+		for _, role := range user.Roles {
+			if _, ok := g.Roles[role]; !ok {
+				return fmt.Errorf("user %s has undefined role %s", user.User, role)
+			}
+		}
+	}
+	if len(g.ClientCA) > 0 {
+		if !x509.NewCertPool().AppendCertsFromPEM([]byte(g.ClientCA)) {
+			return errors.New("grants spec clientCA has no certificates")
+		}
+	} else if g.RequireClientCert || g.hasCertIdentities() {
+		return errors.New("grants spec needs a clientCA to verify client certificates")
+	}
+	return nil
+}
+
+func (g *GrantsSpec) hasCertIdentities() bool {
+	for _, user := range g.Users {
+		if len(user.CertIdentity) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyGrants is the spec equivalent of the single dbuser the interface has
+// always created.
+func legacyGrants(vaultDatabaseConfig map[string]interface{}) *GrantsSpec {
+	return &GrantsSpec{
+		Users: []UserGrant{
+			{
+				User:     vaultDatabaseConfig["dbuser"].(string),
+				Password: vaultDatabaseConfig["dbpassword"].(string),
+				Grants: []TableGrant{
+					{Tables: []string{"*Changes*"}, Privileges: []string{"INSERT", "UPDATE", "DELETE"}},
+					{Tables: []string{"*DataFlowStatistics*"}, Privileges: []string{"SELECT"}},
+					{Tables: []string{"*TierceronFlow*"}, Privileges: []string{"SELECT", "INSERT", "UPDATE"}},
+					{Tables: []string{"*"}, Privileges: []string{"SELECT", "INSERT", "UPDATE", "DELETE"}},
+				},
+			},
+		},
+	}
+}
+
+// grantsFor lists the grants of a user in the order they are checked.
+func (g *GrantsSpec) grantsFor(user UserGrant) []ownedGrant {
+	grants := []ownedGrant{}
+	for _, tableGrant := range user.Grants {
+		grants = append(grants, ownedGrant{owner: user.User, TableGrant: tableGrant})
+	}
+	for _, role := range user.Roles {
+		for _, tableGrant := range g.Roles[role] {
+			grants = append(grants, ownedGrant{owner: role, TableGrant: tableGrant})
+		}
+	}
+	return grants
+}
+
+// match returns the grant deciding the privileges of user on tableName.
+func (g *GrantsSpec) match(user UserGrant, tableName string) (ownedGrant, bool) {
+	for _, grant := range g.grantsFor(user) {
+		for _, table := range grant.Tables {
+			if matched, _ := path.Match(table, tableName); matched {
+				return grant, true
+			}
+		}
+	}
+	return ownedGrant{}, false
+}
+
+func replicaName(tableName string, owner string) string {
+	return tableName + "_" + owner
+}
+
+// Suffixes of the tables a replica refresh loads and swaps out.
+const (
+	REPLICA_STAGING_SUFFIX = "_staging"
+	REPLICA_RETIRED_SUFFIX = "_retired"
+)
+
+func quoteString(value string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), "'", "''") + "'"
+}
+
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func account(user string, host string) string {
+	return quoteString(user) + "@" + quoteString(host)
+}
+
+// grantStatement builds the GRANT giving user@host its privileges on
+// tableName, or "" if the user has none.  Column grants are made on the
+// table's replica.
+func (g *GrantsSpec) grantStatement(databaseName string, user UserGrant, host string, tableName string) string {
+	grant, ok := g.match(user, tableName)
+	if !ok {
+		return ""
+	}
+	if len(grant.Columns) > 0 {
+		tableName = replicaName(tableName, grant.owner)
+	}
+	return "GRANT " + strings.Join(grant.Privileges, ",") + " ON " + quoteName(databaseName) + "." + quoteName(tableName) + " TO " + account(user.User, host)
+}
+
+// replicas maps the replica tables tableName needs to their columns.
+func (g *GrantsSpec) replicas(tableName string) map[string][]string {
+	replicas := map[string][]string{}
+	for _, user := range g.Users {
+		if grant, ok := g.match(user, tableName); ok && len(grant.Columns) > 0 {
+			replicas[replicaName(tableName, grant.owner)] = grant.Columns
+		}
+	}
+	return replicas
+}
+
+// certIdentityMatches reports whether the leaf of a verified client
+// certificate chain has identity as its common name or a DNS SAN.
+func certIdentityMatches(certs []*x509.Certificate, identity string) bool {
+	if len(certs) == 0 {
+		return false
+	}
+	leaf := certs[0]
+	if strings.EqualFold(leaf.Subject.CommonName, identity) {
+		return true
+	}
+	for _, dnsName := range leaf.DNSNames {
+		if strings.EqualFold(dnsName, identity) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantManager keeps the engine's users and replicas in line with a spec.
+type grantManager struct {
+	spec         *GrantsSpec
+	driverConfig *config.DriverConfig
+	engine       *sqle.Engine
+	tfmContext   *flowcore.TrcFlowMachineContext
+	defaultHosts []string
+	tables       map[string]bool // source tables grants have been applied to
+	mu           sync.Mutex
+}
+
+func newGrantManager(driverConfig *config.DriverConfig, engine *sqle.Engine, tfmContext *flowcore.TrcFlowMachineContext, spec *GrantsSpec, vaultDatabaseConfig map[string]interface{}) *grantManager {
+	defaultHosts := []string{}
+	if cidrBlocks, ok := vaultDatabaseConfig["cidrblock"].(string); ok {
+		for _, cidrBlock := range strings.Split(cidrBlocks, ",") {
+			if cidrBlock = strings.TrimSpace(cidrBlock); len(cidrBlock) > 0 {
+				defaultHosts = append(defaultHosts, cidrBlock)
+			}
+		}
+	}
+	return &grantManager{
+		spec:         spec,
+		driverConfig: driverConfig,
+		engine:       engine,
+		tfmContext:   tfmContext,
+		defaultHosts: defaultHosts,
+		tables:       map[string]bool{},
+	}
+}
+
+func (gm *grantManager) hosts(user UserGrant) []string {
+	if len(user.Hosts) > 0 {
+		return user.Hosts
+	}
+	return gm.defaultHosts
+}
+
+func (gm *grantManager) query(query string) error {
+	_, _, _, err := engineQuery(gm.engine, gm.tfmContext.TierceronEngine.Context, query)
+	return err
+}
+
+// sourceTables lists the flow tables, leaving out replicas.
+func (gm *grantManager) sourceTables() ([]string, error) {
+	_, _, tableNameMatrix, err := engineQuery(gm.engine, gm.tfmContext.TierceronEngine.Context, "SHOW TABLES FROM "+gm.tfmContext.TierceronEngine.Database.Name())
+	if err != nil {
+		return nil, err
+	}
+	tableNames := []string{}
+	for _, tableNameList := range tableNameMatrix {
+		for _, tableName := range tableNameList {
+			if name, ok := tableName.(string); ok {
+				tableNames = append(tableNames, name)
+			}
+		}
+	}
+	isReplica := map[string]bool{}
+	for _, tableName := range tableNames {
+		for replica := range gm.spec.replicas(tableName) {
+			isReplica[replica] = true
+			isReplica[replica+REPLICA_STAGING_SUFFIX] = true
+			isReplica[replica+REPLICA_RETIRED_SUFFIX] = true
+		}
+	}
+	sourceTables := []string{}
+	for _, tableName := range tableNames {
+		if !isReplica[tableName] {
+			sourceTables = append(sourceTables, tableName)
+		}
+	}
+	sort.Strings(sourceTables)
+	return sourceTables, nil
+}
+
+// setup runs apply as a temporary super user, which is deleted once the
+// privileges are flushed.
+func (gm *grantManager) setup(apply func()) error {
+	superRandom := make([]byte, 32)
+	if _, err := rand.Read(superRandom); err != nil {
+		return err
+	}
+	gm.engine.Analyzer.Catalog.MySQLDb.AddSuperUser("", "", hex.EncodeToString(superRandom)) //Use for permission set up -> deleted before setup finishes
+	apply()
+	if err := gm.query("FLUSH PRIVILEGES"); err != nil {
+		eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed refresh permissions for users- "+err.Error(), false)
+		return err
+	}
+	if err := gm.query("DELETE USER FROM Mysql.user where USER=''"); err != nil {
+		eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to delete user used to set up permissions:"+err.Error(), false)
+		return err
+	}
+	return nil
+}
+
+// applyAll creates the users and grants privileges on every table.
+func (gm *grantManager) applyAll() error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	return gm.setup(func() {
+		for _, user := range gm.spec.Users {
+			for _, host := range gm.hosts(user) {
+				if err := gm.query("CREATE USER " + account(user.User, host) + " IDENTIFIED BY " + quoteString(user.Password)); err != nil {
+					eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to create user "+user.User+" - "+err.Error(), false)
+				}
+				if err := gm.query("GRANT SELECT ON INFORMATION_SCHEMA.* TO " + account(user.User, host)); err != nil {
+					eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to grant user permissions on information schema:"+err.Error(), false)
+				}
+			}
+		}
+		tableNames, err := gm.sourceTables()
+		if err != nil {
+			eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to list tables for permissions:"+err.Error(), false)
+			return
+		}
+		for _, tableName := range tableNames {
+			gm.grantTable(tableName)
+		}
+	})
+}
+
+// applyTable grants privileges on a newly registered table.
+func (gm *grantManager) applyTable(tableName string) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if gm.tables[tableName] {
+		gm.refreshReplicas(tableName)
+		return nil
+	}
+	return gm.setup(func() { gm.grantTable(tableName) })
+}
+
+func (gm *grantManager) grantTable(tableName string) {
+	if err := gm.createReplicas(tableName); err != nil {
+		eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to create replicas of "+tableName+" - "+err.Error(), false)
+	}
+	databaseName := gm.tfmContext.TierceronEngine.Database.Name()
+	for _, user := range gm.spec.Users {
+		for _, host := range gm.hosts(user) {
+			if grant := gm.spec.grantStatement(databaseName, user, host, tableName); len(grant) > 0 {
+				if err := gm.query(grant); err != nil {
+					eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to grant user permissions on "+tableName+" - "+err.Error(), false)
+				}
+			}
+		}
+	}
+	gm.tables[tableName] = true
+	gm.refreshReplicas(tableName)
+}
+
+// createReplicas creates the replicas tableName needs from its schema.
+func (gm *grantManager) createReplicas(tableName string) error {
+	database := gm.tfmContext.TierceronEngine.Database
+	ctx := sqles.NewContext(context.Background())
+	for replica, columns := range gm.spec.replicas(tableName) {
+		if _, exists, _ := database.GetTableInsensitive(ctx, replica); exists {
+			continue
+		}
+		if err := gm.createReplica(ctx, replica, tableName, columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createReplica creates an empty table named replica holding columns of tableName.
+func (gm *grantManager) createReplica(ctx *sqles.Context, replica string, tableName string, columns []string) error {
+	database := gm.tfmContext.TierceronEngine.Database
+	table, ok, err := database.GetTableInsensitive(ctx, tableName)
+	if err != nil || !ok {
+		return fmt.Errorf("table %s not found", tableName)
+	}
+	replicaSchema := sqles.Schema{}
+	for _, column := range columns {
+		index := table.Schema().IndexOfColName(column)
+		if index < 0 {
+			return fmt.Errorf("table %s has no column %s", tableName, column)
+		}
+		replicaColumn := *table.Schema()[index]
+		replicaColumn.Source = replica
+		replicaColumn.AutoIncrement = false
+		replicaSchema = append(replicaSchema, &replicaColumn)
+	}
+	return database.CreateTable(ctx, replica, sqles.NewPrimaryKeySchema(replicaSchema), sqles.Collation_Default)
+}
+
+func (gm *grantManager) flowLock(tableName string) *sync.Mutex {
+	for _, tfContext := range gm.tfmContext.FlowMap {
+		if tfContext.Flow.TableName() == tableName && tfContext.FlowLock != nil {
+			return tfContext.FlowLock
+		}
+	}
+	return &sync.Mutex{}
+}
+
+// refreshReplicas copies the granted columns of tableName into its replicas.
+// Each replica is loaded into a staging table which is then renamed over it,
+// so readers never see a replica emptied part way through a refresh.
+func (gm *grantManager) refreshReplicas(tableName string) {
+	databaseName := quoteName(gm.tfmContext.TierceronEngine.Database.Name())
+	flowLock := gm.flowLock(tableName)
+	for replica, columns := range gm.spec.replicas(tableName) {
+		quotedColumns := make([]string, len(columns))
+		for i, column := range columns {
+			quotedColumns[i] = quoteName(column)
+		}
+		columnList := strings.Join(quotedColumns, ",")
+		stagingTable := databaseName + "." + quoteName(replica+REPLICA_STAGING_SUFFIX)
+		retiredTable := databaseName + "." + quoteName(replica+REPLICA_RETIRED_SUFFIX)
+		if _, _, _, err := trcdb.Query(gm.tfmContext.TierceronEngine, "DROP TABLE IF EXISTS "+stagingTable+", "+retiredTable, flowLock); err != nil {
+			eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to clear staging of replica "+replica+" - "+err.Error(), false)
+			continue
+		}
+		if err := gm.createReplica(sqles.NewContext(context.Background()), replica+REPLICA_STAGING_SUFFIX, tableName, columns); err != nil {
+			eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to stage replica "+replica+" - "+err.Error(), false)
+			continue
+		}
+		if _, _, _, err := trcdb.Query(gm.tfmContext.TierceronEngine, "INSERT INTO "+stagingTable+" ("+columnList+") SELECT "+columnList+" FROM "+databaseName+"."+quoteName(tableName), flowLock); err != nil {
+			eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to refresh replica "+replica+" - "+err.Error(), false)
+			continue
+		}
+		if err := gm.swapReplica(replica, flowLock); err != nil {
+			eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to swap in replica "+replica+" - "+err.Error(), false)
+			continue
+		}
+		if _, _, _, err := trcdb.Query(gm.tfmContext.TierceronEngine, "DROP TABLE "+retiredTable, flowLock); err != nil {
+			eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Failed to drop retired replica "+replica+" - "+err.Error(), false)
+		}
+	}
+}
+
+// swapReplica renames the staged copy of replica over it, keeping the old one
+// as its retired table.
+func (gm *grantManager) swapReplica(replica string, flowLock *sync.Mutex) error {
+	database := gm.tfmContext.TierceronEngine.Database
+	ctx := sqles.NewContext(context.Background())
+	flowLock.Lock()
+	defer flowLock.Unlock()
+	if err := database.RenameTable(ctx, replica, replica+REPLICA_RETIRED_SUFFIX); err != nil {
+		return err
+	}
+	if err := database.RenameTable(ctx, replica+REPLICA_STAGING_SUFFIX, replica); err != nil {
+		// Put the old replica back rather than leave none.
+		database.RenameTable(ctx, replica+REPLICA_RETIRED_SUFFIX, replica)
+		return err
+	}
+	return nil
+}
+
+// refreshAllReplicas refreshes the replicas of every table granted so far.
+func (gm *grantManager) refreshAllReplicas() {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	for tableName := range gm.tables {
+		gm.refreshReplicas(tableName)
+	}
+}
+
+func (gm *grantManager) hasReplicas() bool {
+	for _, user := range gm.spec.Users {
+		for _, grant := range gm.spec.grantsFor(user) {
+			if len(grant.Columns) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (gm *grantManager) replicaRefresh() time.Duration {
+	if gm.spec.ReplicaRefreshSeconds > 0 {
+		return time.Duration(gm.spec.ReplicaRefreshSeconds) * time.Second
+	}
+	return DEFAULT_REPLICA_REFRESH
+}
+
+// sessionBuilder refuses sessions for users bound to a certificate identity
+// unless the connection presented a matching, verified client certificate.
+func (gm *grantManager) sessionBuilder(ctx context.Context, c *mysql.Conn, addr string) (sqles.Session, error) {
+	if connectionUser, ok := c.UserData.(mysql_db.MysqlConnectionUser); ok {
+		for _, user := range gm.spec.Users {
+			if user.User == connectionUser.User && len(user.CertIdentity) > 0 && !certIdentityMatches(c.GetTLSClientCerts(), user.CertIdentity) {
+				eUtils.LogErrorMessage(gm.driverConfig.CoreConfig, "Rejected session for "+user.User+" without client certificate "+user.CertIdentity, false)
+				return nil, fmt.Errorf("user %s requires a client certificate for %s", user.User, user.CertIdentity)
+			}
+		}
+	}
+	return server.DefaultSessionBuilder(ctx, c, addr)
+}
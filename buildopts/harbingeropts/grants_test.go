@@ -0,0 +1,137 @@
+package harbingeropts
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log"
+	"sync"
+	"testing"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	sqles "github.com/dolthub/go-mysql-server/sql"
+	trcdb "github.com/trimble-oss/tierceron/atrium/trcdb"
+	"github.com/trimble-oss/tierceron/atrium/trcdb/engine"
+	flowcore "github.com/trimble-oss/tierceron/atrium/trcflow/core"
+	"github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+)
+
+func TestGrants(t *testing.T) {
+	spec, err := ParseGrants(`{
+		"roles": {
+			"reader": [{"tables": ["*"], "privileges": ["select"]}],
+			"support": [{"tables": ["Customer"], "columns": ["id", "name"], "privileges": ["SELECT"]}]
+		},
+		"users": [
+			{"user": "etl", "password": "p'w", "hosts": ["10.0.0.0/8"], "grants": [{"tables": ["*Changes"], "privileges": ["INSERT", "DELETE"]}], "roles": ["reader"]},
+			{"user": "helpdesk", "password": "pw", "roles": ["support", "reader"]}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etl, helpdesk := spec.Users[0], spec.Users[1]
+
+	for _, test := range []struct {
+		user     UserGrant
+		table    string
+		expected string
+	}{
+		{etl, "CustomerChanges", "GRANT INSERT,DELETE ON `db`.`CustomerChanges` TO 'etl'@'10.0.0.1'"},
+		{etl, "Customer", "GRANT SELECT ON `db`.`Customer` TO 'etl'@'10.0.0.1'"},
+		{helpdesk, "Customer", "GRANT SELECT ON `db`.`Customer_support` TO 'helpdesk'@'10.0.0.1'"},
+		{helpdesk, "Order", "GRANT SELECT ON `db`.`Order` TO 'helpdesk'@'10.0.0.1'"},
+	} {
+		if grant := spec.grantStatement("db", test.user, "10.0.0.1", test.table); grant != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, grant)
+		}
+	}
+	if replicas := spec.replicas("Customer"); len(replicas["Customer_support"]) != 2 || len(replicas) != 1 {
+		t.Errorf("unexpected replicas %v", replicas)
+	}
+	if account(etl.User, "%") != "'etl'@'%'" || quoteString(etl.Password) != "'p''w'" {
+		t.Error("bad quoting")
+	}
+
+	for _, bad := range []string{
+		`{"users": [{"user": "a", "password": "b", "roles": ["missing"]}]}`,
+		`{"users": [{"user": "a", "password": "b", "grants": [{"tables": ["*"], "columns": ["id"], "privileges": ["UPDATE"]}]}]}`,
+		`{"users": [{"user": "a", "password": "b", "grants": [{"tables": ["*"], "privileges": ["DROP"]}]}]}`,
+		`{"users": [{"user": "a", "password": "b", "certIdentity": "a.example.com"}]}`,
+	} {
+		if _, err := ParseGrants(bad); err == nil {
+			t.Errorf("expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestLegacyGrants(t *testing.T) {
+	spec := legacyGrants(map[string]interface{}{"dbuser": "user", "dbpassword": "pw"})
+	user := spec.Users[0]
+	for table, expected := range map[string]string{
+		"DataFlowStatistics":        "GRANT SELECT ON `db`.`DataFlowStatistics` TO 'user'@'h'",
+		"DataFlowStatisticsChanges": "GRANT INSERT,UPDATE,DELETE ON `db`.`DataFlowStatisticsChanges` TO 'user'@'h'",
+		"TierceronFlow":             "GRANT SELECT,INSERT,UPDATE ON `db`.`TierceronFlow` TO 'user'@'h'",
+		"Customer":                  "GRANT SELECT,INSERT,UPDATE,DELETE ON `db`.`Customer` TO 'user'@'h'",
+	} {
+		if grant := spec.grantStatement("db", user, "h", table); grant != expected {
+			t.Errorf("expected %s, got %s", expected, grant)
+		}
+	}
+}
+
+func TestCertIdentityMatches(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client"}, DNSNames: []string{"client.example.com"}}
+	if !certIdentityMatches([]*x509.Certificate{cert}, "client") || !certIdentityMatches([]*x509.Certificate{cert}, "CLIENT.example.com") {
+		t.Error("expected identity to match")
+	}
+	if certIdentityMatches([]*x509.Certificate{cert}, "other") || certIdentityMatches(nil, "client") {
+		t.Error("expected identity not to match")
+	}
+}
+
+func TestRefreshReplicas(t *testing.T) {
+	spec, err := ParseGrants(`{
+		"roles": {"support": [{"tables": ["Customer"], "columns": ["id", "name"], "privileges": ["SELECT"]}]},
+		"users": [{"user": "helpdesk", "password": "pw", "roles": ["support"]}]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := memory.NewDatabase("TrcDb")
+	te := &engine.TierceronEngine{Database: database, Engine: sqle.NewDefault(memory.NewMemoryDBProvider(database)), Context: sqles.NewEmptyContext()}
+	tfmContext := &flowcore.TrcFlowMachineContext{TierceronEngine: te}
+	var logs bytes.Buffer
+	gm := newGrantManager(&config.DriverConfig{CoreConfig: &core.CoreConfig{Log: log.New(&logs, "", 0)}}, te.Engine, tfmContext, spec, map[string]interface{}{})
+	query := func(query string) [][]interface{} {
+		_, _, matrix, err := trcdb.Query(te, query, &sync.Mutex{})
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return matrix
+	}
+	query("CREATE TABLE TrcDb.Customer (id BIGINT PRIMARY KEY, name TEXT, ssn TEXT)")
+	query("INSERT INTO TrcDb.Customer VALUES (1, 'ann', '123')")
+	if err := gm.createReplicas("Customer"); err != nil {
+		t.Fatal(err)
+	}
+
+	gm.refreshReplicas("Customer")
+	query("INSERT INTO TrcDb.Customer VALUES (2, 'bob', '456')")
+	gm.refreshReplicas("Customer")
+	if logs.Len() > 0 {
+		t.Fatalf("Expected the replica refreshed, got %s", logs.String())
+	}
+	if rows := query("SELECT id, name FROM TrcDb.Customer_support ORDER BY id"); len(rows) != 2 || rows[1][1] != "bob" {
+		t.Fatalf("Expected the replica refreshed, got %v", rows)
+	}
+	if columns := query("SHOW COLUMNS FROM TrcDb.Customer_support"); len(columns) != 2 {
+		t.Fatalf("Expected only the granted columns, got %v", columns)
+	}
+	tables, err := gm.sourceTables()
+	if err != nil || len(tables) != 1 || tables[0] != "Customer" {
+		t.Fatalf("Expected no staging tables left, got %v %v", tables, err)
+	}
+}
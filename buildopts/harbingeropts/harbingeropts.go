@@ -1,7 +1,6 @@
 package harbingeropts
 
 import (
-	"crypto/md5"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -9,7 +8,6 @@ import (
 	"net"
 	"net/url"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +15,7 @@ import (
 	"github.com/dolthub/go-mysql-server/server"
 	sqles "github.com/dolthub/go-mysql-server/sql"
 	"github.com/dolthub/go-mysql-server/sql/information_schema"
+	"github.com/dolthub/go-mysql-server/sql/mysql_db"
 	flowcore "github.com/trimble-oss/tierceron/atrium/trcflow/core"
 	"github.com/trimble-oss/tierceron/atrium/vestibulum/trcdb/opts/insecure"
 	"github.com/trimble-oss/tierceron/buildopts/coreopts"
@@ -102,6 +101,12 @@ func engineQuery(engine *sqle.Engine, ctx *sqles.Context, query string) (string,
 	return tableName, columns, matrix, nil
 }
 
+// QueryGovernor is implemented by server event listeners that need each
+// query's session, such as to audit or limit queries per user.
+type QueryGovernor interface {
+	GovernEngine(engine *sqle.Engine)
+}
+
 // Used to define a database interface for querying TrcDb.
 // Builds interface for TrcDB
 func BuildInterface(driverConfig *config.DriverConfig, goMod *kv.Modifier, tfmContextInterface interface{}, vaultDatabaseConfig map[string]interface{}, serverListenerInterface interface{}) error {
@@ -126,6 +131,11 @@ func BuildInterface(driverConfig *config.DriverConfig, goMod *kv.Modifier, tfmCo
 			tfmContext.TierceronEngine.Database,
 			information_schema.NewInformationSchemaDatabase(),
 		))
+	// Users and grants are rebuilt from vault on start, so are never persisted.
+	engine.Analyzer.Catalog.MySQLDb.SetPersister(&mysql_db.NoopPersister{})
+	if governor, ok := serverListener.(QueryGovernor); ok {
+		governor.GovernEngine(engine)
+	}
 
 	driverConfig.CoreConfig.Log.Println("Loading cert from vault.")
 	pwd, _ := os.Getwd()
@@ -188,92 +198,60 @@ func BuildInterface(driverConfig *config.DriverConfig, goMod *kv.Modifier, tfmCo
 		}
 	}
 
-	dbserver, serverErr := server.NewServer(serverConfig, engine, server.DefaultSessionBuilder, serverListener)
+	grantsSpec := &GrantsSpec{}
+	if dbGrants, ok := vaultDatabaseConfig["dbgrants"].(string); ok && len(strings.TrimSpace(dbGrants)) > 0 {
+		var grantsErr error
+		grantsSpec, grantsErr = ParseGrants(dbGrants)
+		if grantsErr != nil {
+			eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to load grants:"+grantsErr.Error(), false)
+			return grantsErr
+		}
+	} else if vaultDatabaseConfig["dbuser"] != nil && vaultDatabaseConfig["dbpassword"] != nil {
+		grantsSpec = legacyGrants(vaultDatabaseConfig)
+	}
+	grants := newGrantManager(driverConfig, engine, tfmContext, grantsSpec, vaultDatabaseConfig)
+
+	if len(grantsSpec.ClientCA) > 0 {
+		clientCAs := x509.NewCertPool()
+		clientCAs.AppendCertsFromPEM([]byte(grantsSpec.ClientCA))
+		serverConfig.TLSConfig.ClientCAs = clientCAs
+		if grantsSpec.RequireClientCert {
+			serverConfig.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			serverConfig.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	dbserver, serverErr := server.NewServer(serverConfig, engine, grants.sessionBuilder, serverListener)
 	if serverErr != nil {
 		eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to start server:"+serverErr.Error(), false)
 		return serverErr
 	}
 
 	//Adding auth
-	if okSourcePath, okDestPath := vaultDatabaseConfig["dbuser"], vaultDatabaseConfig["dbpassword"]; okSourcePath != nil && okDestPath != nil {
-		//engine.Analyzer.Catalog.GrantTables.AddSuperUser(vaultDatabaseConfig["dbuser"].(string), vaultDatabaseConfig["dbpassword"].(string))
-		h := md5.New()
-		io.WriteString(h, strconv.FormatInt(time.Now().Unix(), 10))
-		superRandom := string(h.Sum(nil))
-
-		engine.Analyzer.Catalog.MySQLDb.AddSuperUser("", "", superRandom) //Use for permission set up -> deleted before setup finishes
-		ctx := tfmContext.TierceronEngine.Context
-		cidrBlockSlice := strings.Split(vaultDatabaseConfig["cidrblock"].(string), ",")
-		for _, cidrBlock := range cidrBlockSlice {
-			cidrBlock = strings.TrimSpace(cidrBlock)
-			_, _, _, queryErr := engineQuery(engine, ctx, "CREATE USER '"+vaultDatabaseConfig["dbuser"].(string)+"'@'"+cidrBlock+"' IDENTIFIED BY '"+vaultDatabaseConfig["dbpassword"].(string)+"'")
-			if queryErr != nil {
-				eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to select user - "+queryErr.Error(), false)
-			}
-
-			_, _, tableNameMatrix, showQueryErr := engineQuery(engine, ctx, "SHOW TABLES FROM "+tfmContext.TierceronEngine.Database.Name())
-			if showQueryErr != nil {
-				eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to grant user permissions - 1 :"+showQueryErr.Error(), false)
-			}
-
-			for _, tableNameListInterface := range tableNameMatrix {
-				for _, tableName := range tableNameListInterface {
-					if !strings.Contains(tableName.(string), "Changes") {
-						if strings.Contains(tableName.(string), "DataFlowStatistics") {
-							_, _, _, queryErr = engineQuery(engine, ctx, "GRANT SELECT ON "+tfmContext.TierceronEngine.Database.Name()+"."+tableName.(string)+" TO '"+vaultDatabaseConfig["dbuser"].(string)+"'@'"+cidrBlock+"'")
-						} else if strings.Contains(tableName.(string), "TierceronFlow") {
-							_, _, _, queryErr = engineQuery(engine, ctx, "GRANT SELECT,INSERT,UPDATE ON "+tfmContext.TierceronEngine.Database.Name()+"."+tableName.(string)+" TO '"+vaultDatabaseConfig["dbuser"].(string)+"'@'"+cidrBlock+"'")
-						} else {
-							_, _, _, queryErr = engineQuery(engine, ctx, "GRANT SELECT,INSERT,UPDATE,DELETE ON "+tfmContext.TierceronEngine.Database.Name()+"."+tableName.(string)+" TO '"+vaultDatabaseConfig["dbuser"].(string)+"'@'"+cidrBlock+"'")
-						}
-						if queryErr != nil {
-							eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to grant user permissions - 2a :"+queryErr.Error(), false)
-						}
-					} else {
-						_, _, _, queryErr = engineQuery(engine, ctx, "GRANT INSERT,UPDATE,DELETE ON "+tfmContext.TierceronEngine.Database.Name()+"."+tableName.(string)+" TO '"+vaultDatabaseConfig["dbuser"].(string)+"'@'"+cidrBlock+"'")
-						if queryErr != nil {
-							eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to grant user permissions - 2b :"+queryErr.Error(), false)
-						}
-					}
-				}
-			}
-
-			_, _, _, queryErr = engineQuery(engine, ctx, "GRANT SELECT ON INFORMATION_SCHEMA.* TO '"+vaultDatabaseConfig["dbuser"].(string)+"'@'"+cidrBlock+"'")
-			if queryErr != nil {
-				eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to grant user permissions - 3 :"+queryErr.Error(), false)
-			}
-
-			_, _, _, queryErr = engineQuery(engine, ctx, "REVOKE INSERT,UPDATE,DELETE ON "+tfmContext.TierceronEngine.Database.Name()+"."+"DataFlowStatistics FROM '"+vaultDatabaseConfig["dbuser"].(string)+"'@'"+cidrBlock+"'")
-			if queryErr != nil {
-				eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to grant user permissions - 4 :"+queryErr.Error(), false)
-			}
-		}
-		_, _, _, queryErr := engineQuery(engine, ctx, "FLUSH PRIVILEGES")
-		if queryErr != nil {
-			eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed refresh permissions for users- "+queryErr.Error(), false)
-			dbserver = nil
-			goto permsfailure
-		}
-
-		_, _, _, queryErr = engineQuery(engine, ctx, "DELETE USER FROM Mysql.user where USER=''")
-		if queryErr != nil {
-			eUtils.LogErrorMessage(driverConfig.CoreConfig, "Failed to delete user used to set up permissions:"+queryErr.Error(), false)
-			dbserver = nil
-			goto permsfailure
-		}
-
-		eUtils.LogErrorMessage(driverConfig.CoreConfig, "Permissions have been set up.", false)
+	if grantsErr := grants.applyAll(); grantsErr != nil {
+		dbserver = nil
+		goto permsfailure
 	}
-
-	go func(tfC *flowcore.TrcFlowMachineContext, vdc map[string]interface{}) {
+	eUtils.LogErrorMessage(driverConfig.CoreConfig, "Permissions have been set up.", false)
+
+	go func(tfC *flowcore.TrcFlowMachineContext, gm *grantManager) {
+		var refresh <-chan time.Time
+		if gm.hasReplicas() {
+			ticker := time.NewTicker(gm.replicaRefresh())
+			defer ticker.Stop()
+			refresh = ticker.C
+		}
 		for {
 			select {
-			case <-tfmContext.PermissionChan:
-				// TODO: Do something if you feel like it...
-				break
+			case permissionUpdate := <-tfC.PermissionChan:
+				// Newly registered tables pick up their grants.
+				gm.applyTable(permissionUpdate.TableName)
+			case <-refresh:
+				gm.refreshAllReplicas()
 			}
 		}
-	}(tfmContext, vaultDatabaseConfig)
+	}(tfmContext, grants)
 
 	go dbserver.Start()
 
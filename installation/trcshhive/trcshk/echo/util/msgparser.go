@@ -14,6 +14,12 @@ var (
 	diagnostics = map[string]ttsdk.Diagnostics{
 		"HEALTH CHECK": ttsdk.Diagnostics_HEALTH_CHECK,
 		"ALL":          ttsdk.Diagnostics_ALL,
+		"VAULT":        ttsdk.Diagnostics_VAULT,
+		"CERTS":        ttsdk.Diagnostics_CERTS,
+		"PLUGINS":      ttsdk.Diagnostics_PLUGINS,
+		"MEMFS":        ttsdk.Diagnostics_MEMFS,
+		"FLOWS":        ttsdk.Diagnostics_FLOWS,
+		"ERRORS":       ttsdk.Diagnostics_ERRORS,
 	}
 
 	acceptableTests = []string{
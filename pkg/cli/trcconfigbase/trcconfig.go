@@ -17,11 +17,13 @@ import (
 	vcutils "github.com/trimble-oss/tierceron/pkg/cli/trcconfigbase/utils"
 	"github.com/trimble-oss/tierceron/pkg/core"
 	"github.com/trimble-oss/tierceron/pkg/core/cache"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
 	"github.com/trimble-oss/tierceron/pkg/utils"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
 	"github.com/trimble-oss/tierceron/pkg/utils/config"
 
 	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func messenger(configCtx *config.ConfigContext, inData *string, inPath string) {
@@ -157,6 +159,10 @@ func CommonMain(envDefaultPtr *string,
 		diffPtr = flagset.Bool("diff", false, "Diff files")
 		versionInfoPtr = flagset.Bool("versions", false, "Version information about values")
 		flagset.Parse(argLines[1:])
+		if err := eUtils.ApplyContextProfile(flagset); err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
 	} else {
 		versionInfo := false
 		versionInfoPtr = &versionInfo
@@ -273,6 +279,9 @@ func CommonMain(envDefaultPtr *string,
 		appRoleConfigPtr = new(string)
 		eUtils.CheckError(driverConfigBase.CoreConfig, err, true)
 	}
+	defer telemetry.InitFromEnv(coreopts.BuildOptions.GetFolderPrefix(nil)+"config", driverConfigBase.CoreConfig.Log)()
+	endRun := eUtils.StartRun(driverConfigBase.CoreConfig, coreopts.BuildOptions.GetFolderPrefix(nil)+"config", attribute.String("trc.env", *envPtr))
+	defer endRun(nil)
 
 	//Dont allow these combinations of flags
 	if *templateInfoPtr && *diffPtr {
@@ -487,6 +496,7 @@ func CommonMain(envDefaultPtr *string,
 					WantCerts:       *wantCertsPtr,
 					ExitOnFailure:   driverConfigBase.CoreConfig.ExitOnFailure,
 					Log:             driverConfigBase.CoreConfig.Log,
+					RunCtx:          driverConfigBase.CoreConfig.RunCtx,
 				},
 				IsShellSubProcess: driverConfigBase.IsShellSubProcess,
 				SecretMode:        *secretMode,
@@ -540,6 +550,7 @@ func CommonMain(envDefaultPtr *string,
 				Regions:         regions,
 				ExitOnFailure:   driverConfigBase.CoreConfig.ExitOnFailure,
 				Log:             driverConfigBase.CoreConfig.Log,
+				RunCtx:          driverConfigBase.CoreConfig.RunCtx,
 			},
 			IsShellSubProcess: driverConfigBase.IsShellSubProcess,
 			SecretMode:        *secretMode,
@@ -0,0 +1,351 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// TemplateLookup resolves lookup "Project/Service" "key" in a template.
+type TemplateLookup func(projectService string, key string) (string, error)
+
+// TemplateFuncs returns the functions available to .tmpl files, on top of
+// the text/template builtins such as urlquery.  Anything parsing templates
+// must use these so the same templates parse everywhere.  A nil lookup
+// fails any render that calls lookup.
+func TemplateFuncs(lookup TemplateLookup) template.FuncMap {
+	if lookup == nil {
+		lookup = func(projectService string, key string) (string, error) {
+			return "", fmt.Errorf("lookup %s %s: no vault available", projectService, key)
+		}
+	}
+	return template.FuncMap{
+		"default":  templateDefault,
+		"required": templateRequired,
+		"b64enc": func(value interface{}) string {
+			return base64.StdEncoding.EncodeToString([]byte(templateString(value)))
+		},
+		"b64dec": func(value interface{}) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(templateString(value))
+			return string(decoded), err
+		},
+		"toJson": func(value interface{}) (string, error) {
+			encoded, err := json.Marshal(templateValue(value))
+			return string(encoded), err
+		},
+		"toYaml": func(value interface{}) (string, error) {
+			encoded, err := yaml.Marshal(templateValue(value))
+			return strings.TrimSuffix(string(encoded), "\n"), err
+		},
+		"toToml": templateToml,
+		"indent": func(spaces int, value interface{}) string {
+			pad := strings.Repeat(" ", spaces)
+			return pad + strings.ReplaceAll(templateString(value), "\n", "\n"+pad)
+		},
+		"join": templateJoin,
+		"split": func(sep string, value interface{}) []string {
+			return strings.Split(templateString(value), sep)
+		},
+		"sha256": func(value interface{}) string {
+			sum := sha256.Sum256([]byte(templateString(value)))
+			return hex.EncodeToString(sum[:])
+		},
+		"lookup": lookup,
+	}
+}
+
+// NewTemplateLookup resolves lookups through a ConfigDataStore of the other
+// service, read once per render.  Project/Service/file picks the config
+// file, otherwise the service's config files are searched in order.
+// Lookups are limited to renderProject, and to renderService and the
+// ServicesWanted of the render when it names any.
+func NewTemplateLookup(driverConfig *config.DriverConfig, modifier *helperkv.Modifier, secretMode bool, renderProject string, renderService string) TemplateLookup {
+	stores := map[string]*ConfigDataStore{}
+	return func(projectService string, key string) (string, error) {
+		parts := strings.SplitN(projectService, "/", 3)
+		if len(parts) < 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return "", fmt.Errorf("lookup %s: expected Project/Service", projectService)
+		}
+		project, service := parts[0], parts[1]
+		if !lookupInScope(driverConfig.ServicesWanted, renderProject, renderService, project, service) {
+			return "", fmt.Errorf("lookup %s: outside of the %s/%s render", projectService, renderProject, renderService)
+		}
+		cds, ok := stores[project+"/"+service]
+		if !ok {
+			cds = new(ConfigDataStore)
+			// Init rewrites the modifier for the service it reads.
+			saved := *modifier
+			err := cds.Init(driverConfig.CoreConfig, lookupModifier(modifier), secretMode, true, project, nil, service)
+			restoreModifier(modifier, &saved)
+			if err != nil {
+				return "", fmt.Errorf("lookup %s: %v", projectService, err)
+			}
+			stores[project+"/"+service] = cds
+		}
+
+		configs := []string{}
+		if len(parts) == 3 {
+			configs = append(configs, parts[2])
+		} else if serviceValues, ok := cds.dataMap[service].(map[string]interface{}); ok {
+			for config := range serviceValues {
+				configs = append(configs, config)
+			}
+			sort.Strings(configs)
+		}
+		for _, config := range configs {
+			if value, ok := cds.GetConfigValue(service, config, key); ok {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("lookup %s %s: value not found", projectService, key)
+	}
+}
+
+// lookupInScope reports whether a render of renderProject/renderService may
+// look up project/service.
+func lookupInScope(servicesWanted []string, renderProject string, renderService string, project string, service string) bool {
+	if project != renderProject {
+		return false
+	}
+	renderService, _, _ = strings.Cut(renderService, ".")
+	if service == renderService {
+		return true
+	}
+	scoped := false
+	for _, wanted := range servicesWanted {
+		wanted = strings.Trim(strings.ReplaceAll(wanted, "\\", "/"), "/")
+		if len(wanted) == 0 {
+			continue
+		}
+		scoped = true
+		if wanted == service || wanted == project+"/"+service {
+			return true
+		}
+	}
+	return !scoped
+}
+
+// lookupModifier clears the parts of the modifier pinning it to the
+// template being rendered.
+func lookupModifier(modifier *helperkv.Modifier) *helperkv.Modifier {
+	modifier.TemplatePath = ""
+	modifier.VersionFilter = nil
+	return modifier
+}
+
+func restoreModifier(modifier *helperkv.Modifier, saved *helperkv.Modifier) {
+	modifier.TemplatePath = saved.TemplatePath
+	modifier.VersionFilter = saved.VersionFilter
+	modifier.ProjectIndex = saved.ProjectIndex
+	modifier.SectionKey = saved.SectionKey
+	modifier.SectionName = saved.SectionName
+	modifier.SectionPath = saved.SectionPath
+}
+
+// templateValue dereferences the *string values, such as trcEnvParam,
+// found in template data.
+func templateValue(value interface{}) interface{} {
+	if ptr, ok := value.(*string); ok {
+		if ptr == nil {
+			return nil
+		}
+		return *ptr
+	}
+	return value
+}
+
+func templateString(value interface{}) string {
+	switch v := templateValue(value).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func templateEmpty(value interface{}) bool {
+	value = templateValue(value)
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// templateDefault is default "value" .key, returning value when .key is
+// missing or empty.
+func templateDefault(defaultValue interface{}, value ...interface{}) interface{} {
+	if len(value) == 0 || templateEmpty(value[0]) {
+		return defaultValue
+	}
+	return templateValue(value[0])
+}
+
+// templateRequired is required "message" .key, failing the render when
+// .key is missing or empty.
+func templateRequired(message string, value ...interface{}) (interface{}, error) {
+	if len(value) == 0 || templateEmpty(value[0]) {
+		return nil, errors.New(message)
+	}
+	return templateValue(value[0]), nil
+}
+
+func templateJoin(sep string, value interface{}) string {
+	value = templateValue(value)
+	if s, ok := value.(string); ok {
+		return s
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return templateString(value)
+	}
+	parts := make([]string, v.Len())
+	for i := range parts {
+		parts[i] = templateString(v.Index(i).Interface())
+	}
+	return strings.Join(parts, sep)
+}
+
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// templateToml encodes a map of values as toml, with nested maps as tables.
+func templateToml(value interface{}) (string, error) {
+	table, ok := tomlTable(templateValue(value))
+	if !ok {
+		return "", fmt.Errorf("toToml: expected a map, got %T", value)
+	}
+	var b strings.Builder
+	if err := writeTomlTable(&b, "", table); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+func tomlTable(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[string]string:
+		table := map[string]interface{}{}
+		for key, entry := range v {
+			table[key] = entry
+		}
+		return table, true
+	case map[interface{}]interface{}:
+		// As read by yaml.v2.
+		table := map[string]interface{}{}
+		for key, entry := range v {
+			table[fmt.Sprint(key)] = entry
+		}
+		return table, true
+	}
+	return nil, false
+}
+
+func tomlKey(key string) string {
+	if tomlBareKey.MatchString(key) {
+		return key
+	}
+	return tomlQuote(key)
+}
+
+func tomlQuote(s string) string {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func writeTomlTable(b *strings.Builder, prefix string, table map[string]interface{}) error {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	subTables := []string{}
+	for _, key := range keys {
+		if _, ok := tomlTable(templateValue(table[key])); ok {
+			subTables = append(subTables, key)
+			continue
+		}
+		encoded, err := tomlScalar(templateValue(table[key]))
+		if err != nil {
+			return fmt.Errorf("toToml %s: %v", prefix+key, err)
+		}
+		b.WriteString(tomlKey(key) + " = " + encoded + "\n")
+	}
+	for _, key := range subTables {
+		sub, _ := tomlTable(templateValue(table[key]))
+		name := prefix + tomlKey(key)
+		b.WriteString("\n[" + name + "]\n")
+		if err := writeTomlTable(b, name+".", sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func tomlFloat(f float64) string {
+	encoded := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(encoded, ".eIN") {
+		// Keep it a float, not an integer.
+		encoded += ".0"
+	}
+	return encoded
+}
+
+func tomlScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return `""`, nil
+	case string:
+		return tomlQuote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return tomlFloat(float64(v)), nil
+	case float64:
+		return tomlFloat(v), nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("unsupported type %T", value)
+	}
+	entries := make([]string, v.Len())
+	for i := range entries {
+		encoded, err := tomlScalar(templateValue(v.Index(i).Interface()))
+		if err != nil {
+			return "", err
+		}
+		entries[i] = encoded
+	}
+	return "[" + strings.Join(entries, ", ") + "]", nil
+}
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/trimble-oss/tierceron/buildopts/coreopts"
+	"github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/core/cache"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv/kvtest"
+)
+
+func renderTemplate(t *testing.T, templateText string, values map[string]interface{}) (string, error) {
+	lookup := func(projectService string, key string) (string, error) {
+		return projectService + ":" + key, nil
+	}
+	tmpl, err := template.New("template").Funcs(TemplateFuncs(lookup)).Parse(templateText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bytes.Buffer
+	err = tmpl.Execute(&doc, values)
+	return doc.String(), err
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	env := "dev"
+	values := map[string]interface{}{
+		"host":        "db.example.com",
+		"empty":       "",
+		"trcEnvParam": &env,
+		"hosts":       []interface{}{"a", "b"},
+		"nested":      map[string]interface{}{"port": 5432, "name": "x y"},
+	}
+	for templateText, expected := range map[string]string{
+		`{{default "localhost" .missing}}`:        "localhost",
+		`{{default "localhost" .empty}}`:          "localhost",
+		`{{.missing | default "localhost"}}`:      "localhost",
+		`{{default "localhost" .host}}`:           "db.example.com",
+		`{{default "prod" .trcEnvParam}}`:         "dev",
+		`{{required "host is required" .host}}`:   "db.example.com",
+		`{{.host | b64enc}}`:                      "ZGIuZXhhbXBsZS5jb20=",
+		`{{"ZGIuZXhhbXBsZS5jb20=" | b64dec}}`:     "db.example.com",
+		`{{toJson .hosts}}`:                       `["a","b"]`,
+		`{{toYaml .nested}}`:                      "name: x y\nport: 5432",
+		`{{toToml .}}`:                            "empty = \"\"\nhost = \"db.example.com\"\nhosts = [\"a\", \"b\"]\ntrcEnvParam = \"dev\"\n\n[nested]\nname = \"x y\"\nport = 5432",
+		`{{toYaml .nested | indent 2}}`:           "  name: x y\n  port: 5432",
+		`{{join "," .hosts}}`:                     "a,b",
+		`{{range split "," "a,b"}}[{{.}}]{{end}}`: "[a][b]",
+		`{{urlquery "a b&c"}}`:                    "a+b%26c",
+		`{{sha256 "abc"}}`:                        "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		`{{lookup "Project/Service" "key"}}`:      "Project/Service:key",
+	} {
+		rendered, err := renderTemplate(t, templateText, values)
+		if err != nil || rendered != expected {
+			t.Fatalf("Expected %s to render %q, got %q %v", templateText, expected, rendered, err)
+		}
+	}
+
+	if _, err := renderTemplate(t, `{{required "password is required" .password}}`, values); err == nil || !strings.Contains(err.Error(), "password is required") {
+		t.Fatalf("Expected the render to fail on a missing required value, got %v", err)
+	}
+}
+
+// newTestVault returns a vault holding the Hive/Api and Hive/Talk templates.
+func newTestVault(t *testing.T) (*kvtest.Vault, *config.DriverConfig, *helperkv.Modifier) {
+	vault := kvtest.NewVault(t)
+	vault.Put("templates/data/Hive/Api/config", map[string]interface{}{"port": []interface{}{"values/Hive/Api/config", "port"}})
+	vault.Put("values/data/dev/Hive/Api/config", map[string]interface{}{"port": "5432"})
+	vault.Put("templates/data/Hive/Talk/config", map[string]interface{}{"host": []interface{}{"values/Hive/Talk/config", "host"}})
+	vault.Put("values/data/dev/Hive/Talk/config", map[string]interface{}{"host": "talk.example.com"})
+	vault.Lists["templates/metadata"] = []interface{}{"Hive/"}
+	vault.Lists["templates/metadata/Hive/Api"] = []interface{}{"config/"}
+	vault.Lists["templates/metadata/Hive/Api/config"] = []interface{}{"template-file"}
+	vault.Lists["templates/metadata/Hive/Talk"] = []interface{}{"config/"}
+	vault.Lists["templates/metadata/Hive/Talk/config"] = []interface{}{"template-file"}
+
+	driverConfig := &config.DriverConfig{
+		CoreConfig: &core.CoreConfig{
+			Env:        "dev",
+			EnvBasis:   "dev",
+			TokenCache: cache.NewTokenCacheEmpty(),
+			Log:        log.New(io.Discard, "", 0),
+		},
+	}
+	return vault, driverConfig, kvtest.NewModifier(t, vault, "dev")
+}
+
+func TestNewTemplateLookup(t *testing.T) {
+	_, driverConfig, mod := newTestVault(t)
+	mod.TemplatePath = "templates/Hive/Talk/config"
+	mod.VersionFilter = []string{"config"}
+	mod.ProjectIndex = []string{"Hive"}
+	lookup := NewTemplateLookup(driverConfig, mod, false, "Hive", "Talk")
+
+	if _, err := lookup("Hive", "port"); err == nil || !strings.Contains(err.Error(), "expected Project/Service") {
+		t.Fatalf("Expected a lookup without a service to fail, got %v", err)
+	}
+	for projectService, expected := range map[string]string{
+		"Hive/Api":        "5432",
+		"Hive/Api/config": "5432",
+	} {
+		value, err := lookup(projectService, "port")
+		if err != nil || value != expected {
+			t.Fatalf("Expected lookup %s port to be %s, got %q %v", projectService, expected, value, err)
+		}
+	}
+	if _, err := lookup("Hive/Api", "missing"); err == nil || !strings.Contains(err.Error(), "value not found") {
+		t.Fatalf("Expected a lookup of a missing key to fail, got %v", err)
+	}
+
+	// Lookups stay within the project and the services of the render.
+	if _, err := lookup("Other/Api", "port"); err == nil || !strings.Contains(err.Error(), "outside of the Hive/Talk render") {
+		t.Fatalf("Expected a lookup in another project to fail, got %v", err)
+	}
+	driverConfig.ServicesWanted = []string{"Hive/Talk"}
+	if _, err := lookup("Hive/Api", "port"); err == nil || !strings.Contains(err.Error(), "outside of the Hive/Talk render") {
+		t.Fatalf("Expected a lookup of a service not wanted to fail, got %v", err)
+	}
+	driverConfig.ServicesWanted = []string{"Hive/Talk", "Hive/Api"}
+	if value, err := lookup("Hive/Api", "port"); err != nil || value != "5432" {
+		t.Fatalf("Expected a lookup of a wanted service, got %q %v", value, err)
+	}
+
+	// The lookup reads another service through the render's modifier, which
+	// must still point at the template being rendered afterwards.
+	if mod.TemplatePath != "templates/Hive/Talk/config" {
+		t.Fatalf("Expected the template path to be restored, got %s", mod.TemplatePath)
+	}
+	if len(mod.VersionFilter) != 1 || mod.VersionFilter[0] != "config" {
+		t.Fatalf("Expected the version filter to be restored, got %v", mod.VersionFilter)
+	}
+	if len(mod.ProjectIndex) != 1 || mod.ProjectIndex[0] != "Hive" {
+		t.Fatalf("Expected the project index to be restored, got %v", mod.ProjectIndex)
+	}
+}
+
+func TestConfigTemplate(t *testing.T) {
+	coreopts.NewOptionsBuilder(coreopts.LoadOptions())
+	vault, driverConfig, mod := newTestVault(t)
+	templateDir := filepath.Join(t.TempDir(), "trc_templates")
+	driverConfig.StartDir = []string{templateDir}
+	templatePath := filepath.ToSlash(filepath.Join(templateDir, "Hive", "Talk", "config.yml.tmpl"))
+	if err := os.MkdirAll(filepath.Dir(templatePath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	templateText := "host: {{.host}}\nport: {{lookup \"Hive/Api\" \"port\"}}\npassword: {{required \"password is required\" .password}}"
+	if err := os.WriteFile(templatePath, []byte(templateText), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed render errors rather than returning partial output.
+	if rendered, _, _, err := ConfigTemplate(driverConfig, mod, templatePath, false, "Hive", "Talk", false, false); err == nil || !strings.Contains(err.Error(), "password is required") || len(rendered) > 0 {
+		t.Fatalf("Expected the render to fail on a missing required value, got %q %v", rendered, err)
+	}
+
+	vault.Data["templates/data/Hive/Talk/config"]["password"] = []interface{}{"values/Hive/Talk/config", "password"}
+	vault.Data["values/data/dev/Hive/Talk/config"]["password"] = "secret"
+	rendered, _, _, err := ConfigTemplate(driverConfig, mod, templatePath, false, "Hive", "Talk", false, false)
+	if err != nil || rendered != "host: talk.example.com\nport: 5432\npassword: secret" {
+		t.Fatalf("Expected the template to render, got %q %v", rendered, err)
+	}
+	if mod.TemplatePath != "templates/Hive/Talk/config" {
+		t.Fatalf("Expected the lookup to leave the template path, got %s", mod.TemplatePath)
+	}
+}
@@ -255,10 +255,14 @@ func PopulateTemplate(driverConfig *config.DriverConfig,
 
 	if ok {
 		//create new template from template string
-		t := template.New("template")
-		t, err := t.Parse(emptyTemplate)
+		var lookup TemplateLookup
+		if !utils.RefEquals(driverConfig.CoreConfig.TokenCache.GetToken(fmt.Sprintf("config_token_%s", driverConfig.CoreConfig.EnvBasis)), "novault") {
+			lookup = NewTemplateLookup(driverConfig, modifier, secretMode, project, service)
+		}
+		t, err := template.New("template").Funcs(TemplateFuncs(lookup)).Parse(emptyTemplate)
 		if err != nil {
 			eUtils.LogErrorObject(driverConfig.CoreConfig, err, false)
+			return "", nil, err
 		}
 		var doc bytes.Buffer
 		//configure the template
@@ -356,10 +360,12 @@ func PopulateTemplate(driverConfig *config.DriverConfig,
 		}
 
 		err = t.Execute(&doc, values[filename])
-		str = doc.String()
 		if err != nil {
+			// Such as a required value missing.
 			eUtils.LogErrorObject(driverConfig.CoreConfig, err, false)
+			return "", nil, err
 		}
+		str = doc.String()
 	}
 	return str, certData, nil
 }
@@ -0,0 +1,296 @@
+package trcctlbase
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/trimble-oss/tierceron/buildopts/coreopts"
+	"github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/core/cache"
+	certutil "github.com/trimble-oss/tierceron/pkg/core/util/cert"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// CertsMain reports the templated certs of one or more environments with
+// their expiration status.
+//
+//	trcctl certs -env=dev,QA [-warnDays=30] [-criticalDays=7] [-o=json]
+func CertsMain(args []string) error {
+	flagset := flag.NewFlagSet("certs", flag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprintf(flagset.Output(), "Usage of trcctl certs:\n")
+		flagset.PrintDefaults()
+	}
+	envPtr := flagset.String("env", "dev", "Comma separated environments to inventory")
+	addrPtr := flagset.String("addr", "", "API endpoint for the vault")
+	tokenPtr := flagset.String("token", "", "Vault access token")
+	secretIDPtr := flagset.String("secretID", "", "Secret for app role ID")
+	appRoleIDPtr := flagset.String("appRoleID", "", "Public app role ID")
+	insecurePtr := flagset.Bool("insecure", false, "By default, every ssl connection this tool makes is verified secure.  This option allows to tool to continue with server connections considered insecure.")
+	logFilePtr := flagset.String("log", "./"+coreopts.BuildOptions.GetFolderPrefix(nil)+"config.log", "Output path for log file")
+	templateFilterPtr := flagset.String("templateFilter", "", "Comma separated projects to inventory")
+	warnDaysPtr := flagset.Int("warnDays", int(certutil.DefaultCertThresholds.Warn.Hours()/24), "Warn about certs expiring within this many days")
+	criticalDaysPtr := flagset.Int("criticalDays", int(certutil.DefaultCertThresholds.Critical.Hours()/24), "Certs expiring within this many days are critical")
+	allPtr := flagset.Bool("all", false, "List every cert rather than only those needing attention")
+	outputPtr := flagset.String("o", "text", "Output format: text, json or yaml")
+	flagset.Parse(args)
+	if err := eUtils.ApplyContextProfile(flagset); err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	if *criticalDaysPtr > *warnDaysPtr {
+		return errors.New("criticalDays must not exceed warnDays")
+	}
+	thresholds := certutil.CertThresholds{
+		Warn:     time.Duration(*warnDaysPtr) * 24 * time.Hour,
+		Critical: time.Duration(*criticalDaysPtr) * 24 * time.Hour,
+	}
+	projects := []string{}
+	if len(*templateFilterPtr) > 0 {
+		projects = strings.Split(*templateFilterPtr, ",")
+	}
+
+	f, err := os.OpenFile(*logFilePtr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Log init failure")
+		return err
+	}
+	logger := log.New(f, "["+coreopts.BuildOptions.GetFolderPrefix(nil)+"ctl]", log.LstdFlags)
+
+	records := []certutil.CertRecord{}
+	for _, env := range strings.Split(*envPtr, ",") {
+		env = strings.TrimSpace(env)
+		if len(env) == 0 {
+			continue
+		}
+		mod, err := certsModifier(env, addrPtr, *tokenPtr, secretIDPtr, appRoleIDPtr, *insecurePtr, false, logger)
+		if err != nil {
+			return err
+		}
+		envRecords, err := certutil.InventoryCerts(mod, projects, thresholds, logger)
+		mod.Release()
+		if err != nil {
+			fmt.Printf("Unable to inventory certs for %s: %s\n", env, err.Error())
+			return err
+		}
+		records = append(records, envRecords...)
+	}
+
+	if *outputPtr != "text" {
+		return printConfigOutput(*outputPtr, records)
+	}
+	attention := 0
+	for _, record := range records {
+		if record.Status == certutil.CERT_STATUS_OK && !*allPtr {
+			continue
+		}
+		attention++
+		if len(record.Error) > 0 {
+			fmt.Printf("%s\t%s\t%s\t%s\n", record.Status, record.Env, record.Address(), record.Error)
+			continue
+		}
+		chain := "chain ok"
+		if !record.ChainValid {
+			chain = "chain invalid"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%dd\t%s\t%s\n", record.Status, record.Env, record.Address(),
+			record.NotAfter.Format(time.RFC3339), record.DaysRemaining, chain, record.Subject)
+	}
+	if attention == 0 {
+		fmt.Printf("All %d certs valid for at least %d days\n", len(records), *warnDaysPtr)
+	}
+	return nil
+}
+
+// certsModifier authenticates to the environment and returns its modifier,
+// which the caller must release.
+func certsModifier(env string, addrPtr *string, token string, secretIDPtr *string, appRoleIDPtr *string, insecure bool, unrestricted bool, logger *log.Logger) (*helperkv.Modifier, error) {
+	tokenPtr := &token
+	tokenName := fmt.Sprintf("config_token_%s", eUtils.GetEnvBasis(env))
+	if unrestricted {
+		tokenName = tokenName + "_unrestricted"
+	}
+	driverConfig := &config.DriverConfig{
+		CoreConfig: &core.CoreConfig{
+			Insecure:      insecure,
+			TokenCache:    cache.NewTokenCache(tokenName, tokenPtr),
+			ExitOnFailure: true,
+			Log:           logger,
+		},
+	}
+	autoErr := eUtils.AutoAuth(driverConfig, secretIDPtr, appRoleIDPtr, &tokenName, &tokenPtr, &env, addrPtr, new(string), nil, false)
+	if autoErr != nil {
+		fmt.Println("Missing auth components.")
+		return nil, autoErr
+	}
+
+	mod, err := helperkv.NewModifier(insecure, tokenPtr, addrPtr, env, nil, true, logger)
+	if err != nil {
+		if mod != nil {
+			mod.Release()
+		}
+		fmt.Println("Failure to init to vault")
+		return nil, err
+	}
+	mod.Env = env
+	return mod, nil
+}
+
+// RenewMain renews certs opted in to acme renewal (certRenewal: acme) that
+// are due, writing them back to vault.  The acme configuration is read from
+// the environment and may be overridden by flags.  Addresses limit renewal
+// to specific certs.
+//
+//	trcctl renew -env=dev [-force] [-dryRun] [<project>/<service>/<file>...]
+func RenewMain(args []string) error {
+	flagset := flag.NewFlagSet("renew", flag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprintf(flagset.Output(), "Usage of trcctl renew [<address>...]:\n")
+		flagset.PrintDefaults()
+	}
+	envPtr := flagset.String("env", "dev", "Environment to renew certs in")
+	addrPtr := flagset.String("addr", "", "API endpoint for the vault")
+	tokenPtr := flagset.String("token", "", "Vault access token")
+	secretIDPtr := flagset.String("secretID", "", "Secret for app role ID")
+	appRoleIDPtr := flagset.String("appRoleID", "", "Public app role ID")
+	insecurePtr := flagset.Bool("insecure", false, "By default, every ssl connection this tool makes is verified secure.  This option allows to tool to continue with server connections considered insecure.")
+	logFilePtr := flagset.String("log", "./"+coreopts.BuildOptions.GetFolderPrefix(nil)+"config.log", "Output path for log file")
+	templateFilterPtr := flagset.String("templateFilter", "", "Comma separated projects to renew certs of")
+	flagset.String("directory", "", "ACME directory url")
+	flagset.String("email", "", "ACME account contact")
+	flagset.String("challenge", "", "Challenge to answer: http-01 or dns-01")
+	flagset.String("dnsProvider", "", "DNS provider answering dns-01 challenges")
+	flagset.String("dnsCommand", "", "Command run by the exec dnsProvider with present|cleanup <fqdn> <value>")
+	flagset.String("httpAddress", "", "Listen address of the http-01 responder")
+	flagset.String("renewDays", "", "Renew certs expiring within this many days")
+	flagset.String("keyType", "", "Key type of renewed certs: rsa or ecdsa")
+	caBundlePtr := flagset.String("caBundle", "", "Path to a pem trusted for the ACME directory")
+	forcePtr := flagset.Bool("force", false, "Renew certs regardless of expiration")
+	dryRunPtr := flagset.Bool("dryRun", false, "Only list the certs that would be renewed")
+	outputPtr := flagset.String("o", "text", "Output format: text, json or yaml")
+
+	// Allow flags before, between and after addresses.
+	addresses := map[string]bool{}
+	for {
+		flagset.Parse(args)
+		if flagset.NArg() == 0 {
+			break
+		}
+		addresses[flagset.Arg(0)] = true
+		args = flagset.Args()[1:]
+	}
+	if err := eUtils.ApplyContextProfile(flagset); err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	projects := []string{}
+	if len(*templateFilterPtr) > 0 {
+		projects = strings.Split(*templateFilterPtr, ",")
+	}
+
+	f, err := os.OpenFile(*logFilePtr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Log init failure")
+		return err
+	}
+	logger := log.New(f, "["+coreopts.BuildOptions.GetFolderPrefix(nil)+"ctl]", log.LstdFlags)
+
+	mod, err := certsModifier(*envPtr, addrPtr, *tokenPtr, secretIDPtr, appRoleIDPtr, *insecurePtr, true, logger)
+	if err != nil {
+		return err
+	}
+	defer mod.Release()
+	mod.AuditReason = "trcctl renew"
+
+	settings := map[string]string{}
+	vaultConfig, vaultConfigErr := certutil.ReadAcmeConfig(mod)
+	if vaultConfig != nil {
+		settings = vaultConfig.Settings
+	}
+	flagset.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "directory", "email", "challenge", "dnsProvider", "dnsCommand", "httpAddress", "renewDays", "keyType":
+			settings[f.Name] = f.Value.String()
+		}
+	})
+	if len(*caBundlePtr) > 0 {
+		caBundle, err := os.ReadFile(*caBundlePtr)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		settings["caBundle"] = string(caBundle)
+	}
+	acmeConfig, err := certutil.NewAcmeConfig(settings)
+	if err != nil {
+		if vaultConfigErr != nil {
+			fmt.Println(vaultConfigErr.Error())
+		}
+		fmt.Println(err.Error())
+		return err
+	}
+
+	records, err := certutil.InventoryCerts(mod, projects, certutil.DefaultCertThresholds, logger)
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	candidates := []certutil.CertRecord{}
+	for _, candidate := range certutil.RenewalCandidates(records, time.Duration(acmeConfig.RenewDays)*24*time.Hour, *forcePtr) {
+		if len(addresses) == 0 || addresses[candidate.Address()] {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	var responder *certutil.HTTP01Responder
+	if acmeConfig.Challenge == certutil.ACME_CHALLENGE_HTTP01 {
+		responder = certutil.NewHTTP01Responder()
+		if !*dryRunPtr && len(candidates) > 0 {
+			responder.Start(acmeConfig.HTTPAddress, logger)
+			defer responder.Stop()
+		}
+	}
+	ctx := context.Background()
+	acmeClient, err := certutil.NewAcmeClient(ctx, acmeConfig, responder, logger)
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	if vaultConfig != nil {
+		if err := acmeClient.SaveAccountKey(mod); err != nil {
+			fmt.Printf("Unable to save acme account key: %s\n", err.Error())
+		}
+	}
+	results := acmeClient.RenewCerts(ctx, mod, candidates, *dryRunPtr)
+
+	if *outputPtr != "text" {
+		return printConfigOutput(*outputPtr, results)
+	}
+	failed := 0
+	for _, result := range results {
+		switch {
+		case len(result.Error) > 0:
+			failed++
+			fmt.Printf("failed\t%s\t%s\n", result.Address, result.Error)
+		case result.Renewed:
+			fmt.Printf("renewed\t%s\t%s\tuntil %s\n", result.Address, strings.Join(result.Domains, ","), result.NotAfter.Format(time.RFC3339))
+		default:
+			fmt.Printf("due\t%s\t%s\n", result.Address, strings.Join(result.Domains, ","))
+		}
+	}
+	if len(results) == 0 {
+		fmt.Println("No certs due for acme renewal")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d certs failed to renew", failed)
+	}
+	return nil
+}
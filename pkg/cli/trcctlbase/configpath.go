@@ -0,0 +1,539 @@
+package trcctlbase
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/trimble-oss/tierceron/buildopts/coreopts"
+	"github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/core/cache"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigAddress names a config value, or all the values of a file or section
+// when Key is empty.  Addresses take the forms
+//
+//	<project>/<service>/<file>[.<key>]
+//	Index/<project>/<indexName>/<indexValue>/<service>[.<key>]
+//	Restricted/<service>/<section>[.<key>]
+//	Protected/<service>/<section>[.<key>]
+//
+// where file is the template name without its extensions.
+type ConfigAddress struct {
+	Project         string
+	Service         string
+	File            string
+	Key             string
+	SectionKey      string // /Index/, /Restricted/ or /Protected/ for subsections.
+	SectionName     string
+	SubSectionValue string
+}
+
+// ConfigValue is a value as read by get and accepted by set.
+type ConfigValue struct {
+	Address string `json:"address" yaml:"address"`
+	Value   string `json:"value" yaml:"value"`
+	Path    string `json:"path,omitempty" yaml:"path,omitempty"`
+	Version int64  `json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+// ConfigVersion is a version of a config path as shown by history.
+type ConfigVersion struct {
+	Version   int64  `json:"version" yaml:"version"`
+	Created   string `json:"created" yaml:"created"`
+	Deleted   string `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+	Destroyed bool   `json:"destroyed,omitempty" yaml:"destroyed,omitempty"`
+	Value     string `json:"value,omitempty" yaml:"value,omitempty"`
+}
+
+// ParseConfigAddress parses an address in any of the ConfigAddress forms.
+func ParseConfigAddress(address string) (*ConfigAddress, error) {
+	a := &ConfigAddress{}
+	path := address
+	lastSlash := strings.LastIndex(path, "/")
+	if dot := strings.Index(path[lastSlash+1:], "."); dot >= 0 {
+		a.Key = path[lastSlash+1+dot+1:]
+		path = path[:lastSlash+1+dot]
+	}
+	parts := strings.Split(path, "/")
+	for _, part := range parts {
+		if len(part) == 0 {
+			return nil, fmt.Errorf("invalid address %s", address)
+		}
+	}
+	switch {
+	case parts[0] == "Index" && len(parts) == 5:
+		a.SectionKey = "/Index/"
+		a.Project, a.SectionName, a.SubSectionValue, a.Service = parts[1], parts[2], parts[3], parts[4]
+	case (parts[0] == "Restricted" || parts[0] == "Protected") && len(parts) == 3:
+		a.SectionKey = "/" + parts[0] + "/"
+		a.Service, a.SectionName = parts[1], parts[2]
+	case parts[0] != "Index" && parts[0] != "Restricted" && parts[0] != "Protected" && len(parts) == 3:
+		a.Project, a.Service, a.File = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("invalid address %s, expecting <project>/<service>/<file>.<key>, Index/<project>/<indexName>/<indexValue>/<service>.<key> or Restricted|Protected/<service>/<section>.<key>", address)
+	}
+	return a, nil
+}
+
+// String returns the address without its key.
+func (a *ConfigAddress) String() string {
+	switch a.SectionKey {
+	case "/Index/":
+		return "Index/" + a.Project + "/" + a.SectionName + "/" + a.SubSectionValue + "/" + a.Service
+	case "/Restricted/", "/Protected/":
+		return strings.Trim(a.SectionKey, "/") + "/" + a.Service + "/" + a.SectionName
+	}
+	return a.Project + "/" + a.Service + "/" + a.File
+}
+
+// Path returns the vault path of the address.  For a file that is its
+// template path, where each key holds a [path, key] link to the values or
+// super-secrets of the service storing its value.
+func (a *ConfigAddress) Path() string {
+	switch a.SectionKey {
+	case "/Index/":
+		return "super-secrets" + a.SectionKey + a.Project + "/" + a.SectionName + "/" + a.SubSectionValue + "/" + a.Service
+	case "/Restricted/", "/Protected/":
+		return "super-secrets" + a.SectionKey + a.Service + "/" + a.SectionName
+	}
+	return "templates/" + a.Project + "/" + a.Service + "/" + a.File
+}
+
+// configReader reads config paths through a modifier, reading each path once.
+type configReader struct {
+	mod      *helperkv.Modifier
+	data     map[string]map[string]interface{}
+	versions map[string]int64
+}
+
+func newConfigReader(mod *helperkv.Modifier) *configReader {
+	return &configReader{mod: mod, data: map[string]map[string]interface{}{}, versions: map[string]int64{}}
+}
+
+func (cr *configReader) read(path string) (map[string]interface{}, int64, error) {
+	if data, ok := cr.data[path]; ok {
+		return data, cr.versions[path], nil
+	}
+	data, version, err := cr.mod.ReadDataVersion(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	cr.data[path], cr.versions[path] = data, version
+	return data, version, nil
+}
+
+// resolve returns the path and key actually holding the address's value.
+func (cr *configReader) resolve(a *ConfigAddress, key string) (string, string, error) {
+	path := a.Path()
+	if len(a.SectionKey) > 0 {
+		return path, key, nil
+	}
+	values, _, err := cr.read(path)
+	if err != nil {
+		return "", "", err
+	}
+	entry, ok := values[key]
+	if !ok {
+		return "", "", fmt.Errorf("no key %s in %s", key, a)
+	}
+	link, ok := entry.([]interface{})
+	if !ok || len(link) != 2 {
+		return "", "", fmt.Errorf("key %s in %s does not link to a value", key, path)
+	}
+	return fmt.Sprint(link[0]), fmt.Sprint(link[1]), nil
+}
+
+// get reads the value of the address, or all the values of its file or
+// section if it has no key.
+func (cr *configReader) get(a *ConfigAddress) ([]ConfigValue, error) {
+	keys := []string{a.Key}
+	if len(a.Key) == 0 {
+		data, _, err := cr.read(a.Path())
+		if err != nil {
+			return nil, err
+		}
+		keys = keys[:0]
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+	configValues := []ConfigValue{}
+	for _, key := range keys {
+		path, resolvedKey, err := cr.resolve(a, key)
+		if err != nil {
+			return nil, err
+		}
+		data, version, err := cr.read(path)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := data[resolvedKey]
+		if !ok {
+			return nil, fmt.Errorf("no value for %s.%s in %s", a, key, path)
+		}
+		configValues = append(configValues, ConfigValue{Address: a.String() + "." + key, Value: fmt.Sprint(value), Path: path, Version: version})
+	}
+	return configValues, nil
+}
+
+// change sets (or with unset removes) values, writing each vault path once
+// with a check and set against the version it was read at, or against cas if
+// it is not negative.
+func (cr *configReader) change(changes []ConfigValue, unset bool, cas int64, logger *log.Logger) error {
+	paths := []string{}
+	pathChanges := map[string]map[string]*string{}
+	pathVersions := map[string]int64{}
+	for i := range changes {
+		a, err := ParseConfigAddress(changes[i].Address)
+		if err != nil {
+			return err
+		}
+		if len(a.Key) == 0 {
+			return fmt.Errorf("address %s has no key", changes[i].Address)
+		}
+		path, key, err := cr.resolve(a, a.Key)
+		if err != nil {
+			return err
+		}
+		if _, ok := pathChanges[path]; !ok {
+			paths = append(paths, path)
+			pathChanges[path] = map[string]*string{}
+			pathVersions[path] = cas
+		}
+		if changes[i].Version > 0 {
+			if pathVersions[path] >= 0 && pathVersions[path] != changes[i].Version {
+				return fmt.Errorf("conflicting versions given for %s", path)
+			}
+			pathVersions[path] = changes[i].Version
+		}
+		if unset {
+			pathChanges[path][key] = nil
+		} else {
+			pathChanges[path][key] = &changes[i].Value
+		}
+	}
+
+	for _, path := range paths {
+		data, version, err := cr.read(path)
+		if err != nil {
+			return err
+		}
+		if pathVersions[path] >= 0 && pathVersions[path] != version {
+			return fmt.Errorf("%s is at version %d, not %d", path, version, pathVersions[path])
+		}
+		updated := make(map[string]interface{}, len(data))
+		for key, value := range data {
+			updated[key] = value
+		}
+		for key, value := range pathChanges[path] {
+			if value == nil {
+				if _, ok := updated[key]; !ok {
+					return fmt.Errorf("no key %s in %s", key, path)
+				}
+				delete(updated, key)
+			} else {
+				updated[key] = *value
+			}
+		}
+		warn, err := cr.mod.WriteCAS(path, updated, version, logger)
+		if err != nil {
+			if strings.Contains(err.Error(), "check-and-set") {
+				return fmt.Errorf("%s was changed by someone else since version %d, please retry", path, version)
+			}
+			return err
+		}
+		for _, w := range warn {
+			logger.Println(w)
+		}
+		delete(cr.data, path)
+		fmt.Fprintf(os.Stderr, "Updated %s from version %d\n", path, version)
+	}
+	return nil
+}
+
+// history lists the versions of the address's path, newest first, with the
+// key's value at each version.
+func (cr *configReader) history(a *ConfigAddress, limit int, logger *log.Logger) ([]ConfigVersion, error) {
+	path, key := a.Path(), a.Key
+	if len(key) > 0 {
+		var err error
+		if path, key, err = cr.resolve(a, key); err != nil {
+			return nil, err
+		}
+	}
+	versionsData, err := cr.mod.ReadVersionMetadata(path, logger)
+	if err != nil {
+		return nil, err
+	}
+	history := []ConfigVersion{}
+	for versionKey, versionData := range versionsData {
+		version, err := strconv.ParseInt(versionKey, 10, 64)
+		if err != nil {
+			continue
+		}
+		configVersion := ConfigVersion{Version: version}
+		if metadata, ok := versionData.(map[string]interface{}); ok {
+			configVersion.Created = fmt.Sprint(metadata["created_time"])
+			if deleted, ok := metadata["deletion_time"].(string); ok {
+				configVersion.Deleted = deleted
+			}
+			configVersion.Destroyed, _ = metadata["destroyed"].(bool)
+		}
+		history = append(history, configVersion)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Version > history[j].Version })
+	if limit > 0 && len(history) > limit {
+		history = history[:limit]
+	}
+	if len(key) > 0 {
+		defer func() { cr.mod.Version = "" }()
+		for i := range history {
+			if len(history[i].Deleted) > 0 || history[i].Destroyed {
+				continue
+			}
+			cr.mod.Version = strconv.FormatInt(history[i].Version, 10)
+			data, err := cr.mod.ReadData(path)
+			if err == nil && data != nil {
+				if value, ok := data[key]; ok {
+					history[i].Value = fmt.Sprint(value)
+				}
+			}
+		}
+	}
+	return history, nil
+}
+
+// readConfigChanges reads values to set from stdin as a yaml or json list (as
+// written by get), a map of address to value, or address=value lines.
+func readConfigChanges(in io.Reader) ([]ConfigValue, error) {
+	input, err := io.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	changes := []ConfigValue{}
+	if err := yaml.Unmarshal(input, &changes); err == nil {
+		return changes, nil
+	}
+	changeMap := map[string]string{}
+	if err := yaml.Unmarshal(input, &changeMap); err == nil {
+		for address, value := range changeMap {
+			changes = append(changes, ConfigValue{Address: address, Value: value})
+		}
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Address < changes[j].Address })
+		return changes, nil
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(input)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		change, err := parseConfigChange(line)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, scanner.Err()
+}
+
+func parseConfigChange(arg string) (ConfigValue, error) {
+	address, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return ConfigValue{}, fmt.Errorf("expecting <address>=<value>, got %s", arg)
+	}
+	return ConfigValue{Address: address, Value: value}, nil
+}
+
+func printConfigOutput(format string, output interface{}) error {
+	switch format {
+	case "json":
+		outputBytes, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(outputBytes))
+	case "yaml":
+		outputBytes, err := yaml.Marshal(output)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(outputBytes))
+	default:
+		return fmt.Errorf("unsupported output format %s, expecting text, json or yaml", format)
+	}
+	return nil
+}
+
+// ConfigPathMain reads and edits individual config values.
+//
+//	trcctl get <address>...
+//	trcctl set <address>=<value>... (or - to read them from stdin)
+//	trcctl unset <address>...
+//	trcctl history <address>
+func ConfigPathMain(command string, args []string) error {
+	flagset := flag.NewFlagSet(command, flag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprintf(flagset.Output(), "Usage of trcctl %s <address>:\n", command)
+		flagset.PrintDefaults()
+	}
+	envPtr := flagset.String("env", "dev", "Environment to configure")
+	addrPtr := flagset.String("addr", "", "API endpoint for the vault")
+	tokenPtr := flagset.String("token", "", "Vault access token")
+	secretIDPtr := flagset.String("secretID", "", "Secret for app role ID")
+	appRoleIDPtr := flagset.String("appRoleID", "", "Public app role ID")
+	insecurePtr := flagset.Bool("insecure", false, "By default, every ssl connection this tool makes is verified secure.  This option allows to tool to continue with server connections considered insecure.")
+	logFilePtr := flagset.String("log", "./"+coreopts.BuildOptions.GetFolderPrefix(nil)+"config.log", "Output path for log file")
+	outputPtr := flagset.String("o", "text", "Output format: text, json or yaml")
+	casPtr := flagset.Int64("cas", -1, "Only write if the vault path is at this version")
+	limitPtr := flagset.Int("limit", 10, "Number of versions shown by history")
+
+	// Allow flags before, between and after addresses.
+	addresses := []string{}
+	for {
+		flagset.Parse(args)
+		if flagset.NArg() == 0 {
+			break
+		}
+		addresses = append(addresses, flagset.Arg(0))
+		args = flagset.Args()[1:]
+	}
+	if err := eUtils.ApplyContextProfile(flagset); err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	if len(addresses) == 0 && command != "set" {
+		flagset.Usage()
+		return errors.New("missing address")
+	}
+	if command == "history" && len(addresses) > 1 {
+		return errors.New("history takes a single address")
+	}
+
+	f, err := os.OpenFile(*logFilePtr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Log init failure")
+		return err
+	}
+	logger := log.New(f, "["+coreopts.BuildOptions.GetFolderPrefix(nil)+"ctl]", log.LstdFlags)
+
+	tokenName := fmt.Sprintf("config_token_%s", eUtils.GetEnvBasis(*envPtr))
+	if command == "set" || command == "unset" {
+		tokenName = tokenName + "_unrestricted"
+	}
+	driverConfig := &config.DriverConfig{
+		CoreConfig: &core.CoreConfig{
+			Insecure:      *insecurePtr,
+			TokenCache:    cache.NewTokenCache(tokenName, tokenPtr),
+			ExitOnFailure: true,
+			Log:           logger,
+		},
+	}
+	autoErr := eUtils.AutoAuth(driverConfig, secretIDPtr, appRoleIDPtr, &tokenName, &tokenPtr, envPtr, addrPtr, new(string), nil, false)
+	if autoErr != nil {
+		fmt.Println("Missing auth components.")
+		return autoErr
+	}
+
+	mod, err := helperkv.NewModifier(*insecurePtr, tokenPtr, addrPtr, *envPtr, nil, true, logger)
+	if mod != nil {
+		defer mod.Release()
+	}
+	if err != nil {
+		fmt.Println("Failure to init to vault")
+		return err
+	}
+	mod.Env = *envPtr
+	mod.AuditReason = "trcctl " + command
+	reader := newConfigReader(mod)
+
+	switch command {
+	case "get":
+		configValues := []ConfigValue{}
+		singleValue := false
+		for _, address := range addresses {
+			a, err := ParseConfigAddress(address)
+			if err != nil {
+				return err
+			}
+			singleValue = len(addresses) == 1 && len(a.Key) > 0
+			values, err := reader.get(a)
+			if err != nil {
+				fmt.Println(err.Error())
+				return err
+			}
+			configValues = append(configValues, values...)
+		}
+		if *outputPtr != "text" {
+			return printConfigOutput(*outputPtr, configValues)
+		}
+		if singleValue {
+			fmt.Println(configValues[0].Value)
+			return nil
+		}
+		for _, configValue := range configValues {
+			fmt.Printf("%s=%s\n", configValue.Address, configValue.Value)
+		}
+	case "set", "unset":
+		changes := []ConfigValue{}
+		if command == "set" && (len(addresses) == 0 || (len(addresses) == 1 && addresses[0] == "-")) {
+			if changes, err = readConfigChanges(os.Stdin); err != nil {
+				fmt.Println(err.Error())
+				return err
+			}
+		} else {
+			for _, address := range addresses {
+				change := ConfigValue{Address: address}
+				if command == "set" {
+					if change, err = parseConfigChange(address); err != nil {
+						fmt.Println(err.Error())
+						return err
+					}
+				}
+				changes = append(changes, change)
+			}
+		}
+		if err := reader.change(changes, command == "unset", *casPtr, logger); err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+	case "history":
+		a, err := ParseConfigAddress(addresses[0])
+		if err != nil {
+			return err
+		}
+		history, err := reader.history(a, *limitPtr, logger)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		if *outputPtr != "text" {
+			return printConfigOutput(*outputPtr, history)
+		}
+		for _, version := range history {
+			state := version.Value
+			if version.Destroyed {
+				state = "<destroyed>"
+			} else if len(version.Deleted) > 0 {
+				state = "<deleted " + version.Deleted + ">"
+			}
+			fmt.Printf("%d\t%s\t%s\n", version.Version, version.Created, state)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package trcctlbase
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv/kvtest"
+)
+
+func TestParseConfigAddress(t *testing.T) {
+	for address, expected := range map[string]string{
+		"Spectrum/Service/config.dbpassword":          "templates/Spectrum/Service/config dbpassword",
+		"Spectrum/Service/config":                     "templates/Spectrum/Service/config ",
+		"Index/Spectrum/tenantId/tenant.1/Svc.apikey": "super-secrets/Index/Spectrum/tenantId/tenant.1/Svc apikey",
+		"Restricted/Service/Section.url":              "super-secrets/Restricted/Service/Section url",
+	} {
+		a, err := ParseConfigAddress(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resolved := a.Path() + " " + a.Key; resolved != expected {
+			t.Errorf("Expected %s to resolve to %s, got %s", address, expected, resolved)
+		}
+		if !strings.HasPrefix(address, a.String()) {
+			t.Errorf("Expected %s to round trip, got %s", address, a.String())
+		}
+	}
+	for _, bad := range []string{"Spectrum/config.key", "Index/Spectrum/Svc.key", "Spectrum//config.key"} {
+		if _, err := ParseConfigAddress(bad); err == nil {
+			t.Errorf("Expected %s to be rejected", bad)
+		}
+	}
+}
+
+func TestReadConfigChanges(t *testing.T) {
+	for _, input := range []string{
+		`[{"address": "P/S/config.a", "value": "1", "version": 3}, {"address": "P/S/config.b", "value": "x=y"}]`,
+		"P/S/config.a: \"1\"\nP/S/config.b: x=y\n",
+		"# bulk\nP/S/config.a=1\nP/S/config.b=x=y\n",
+	} {
+		changes, err := readConfigChanges(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 2 || changes[0].Address != "P/S/config.a" || changes[0].Value != "1" || changes[1].Value != "x=y" {
+			t.Errorf("Unexpected changes %v from %s", changes, input)
+		}
+	}
+}
+
+func TestConfigReader(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	vault.Put("templates/data/Spectrum/Service/config", map[string]interface{}{
+		"dbpassword": []interface{}{"super-secrets/Service", "dbpassword"},
+		"port":       []interface{}{"values/Service", "port"},
+		"unlinked":   "x",
+	})
+	vault.Put("super-secrets/data/dev/Service", map[string]interface{}{"dbpassword": "secret", "apikey": "key"})
+	vault.Put("values/data/dev/Service", map[string]interface{}{"port": "1433"})
+	reader := newConfigReader(kvtest.NewModifier(t, vault, "dev"))
+	logger := log.New(io.Discard, "", 0)
+
+	a, _ := ParseConfigAddress("Spectrum/Service/config.dbpassword")
+	values, err := reader.get(a)
+	if err != nil || len(values) != 1 || values[0].Value != "secret" || values[0].Path != "super-secrets/Service" || values[0].Version != 1 {
+		t.Fatalf("Expected the secret through the template link, got %v %v", values, err)
+	}
+	a, _ = ParseConfigAddress("Spectrum/Service/config.unlinked")
+	if _, err := reader.get(a); err == nil {
+		t.Fatal("Expected a key without a link rejected")
+	}
+	a, _ = ParseConfigAddress("Spectrum/Service/config.missing")
+	if _, err := reader.get(a); err == nil {
+		t.Fatal("Expected a missing key rejected")
+	}
+
+	if err := reader.change([]ConfigValue{{Address: "Spectrum/Service/config.dbpassword", Value: "rotated"}}, false, -1, logger); err != nil {
+		t.Fatal(err)
+	}
+	if secrets := vault.Get("super-secrets/data/dev/Service"); secrets["dbpassword"] != "rotated" || secrets["apikey"] != "key" {
+		t.Fatalf("Expected only the secret changed, got %v", secrets)
+	}
+	if err := reader.change([]ConfigValue{{Address: "Spectrum/Service/config.dbpassword", Value: "stale", Version: 1}}, false, -1, logger); err == nil {
+		t.Fatal("Expected a change at a stale version rejected")
+	}
+	if err := reader.change([]ConfigValue{{Address: "Spectrum/Service/config.port"}}, true, -1, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := vault.Get("values/data/dev/Service")["port"]; ok {
+		t.Fatalf("Expected the value unset, got %v", vault.Get("values/data/dev/Service"))
+	}
+}
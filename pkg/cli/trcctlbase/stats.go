@@ -0,0 +1,152 @@
+package trcctlbase
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	flowcore "github.com/trimble-oss/tierceron/atrium/trcflow/core"
+	"github.com/trimble-oss/tierceron/buildopts/coreopts"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// StatsMain migrates data flow statistics between statistics stores or
+// compacts the store selected for an environment.  Compacting is the only
+// place retention is applied, so run it on a schedule to enforce it.
+//
+//	trcctl stats migrate -env=dev -from=vault -to=local -toPath=/var/stats
+//	trcctl stats compact -env=dev [-retentionDays=30] [-maxPerSeries=100]
+func StatsMain(args []string) error {
+	if len(args) == 0 || (args[0] != "migrate" && args[0] != "compact") {
+		fmt.Println("Usage: trcctl stats migrate|compact [flags]")
+		return errors.New("stats requires migrate or compact")
+	}
+	action := args[0]
+	flagset := flag.NewFlagSet("stats "+action, flag.ExitOnError)
+	flagset.Usage = func() {
+		fmt.Fprintf(flagset.Output(), "Usage of trcctl stats %s:\n", action)
+		flagset.PrintDefaults()
+	}
+	envPtr := flagset.String("env", "dev", "Environment of the statistics")
+	addrPtr := flagset.String("addr", "", "API endpoint for the vault")
+	tokenPtr := flagset.String("token", "", "Vault access token")
+	secretIDPtr := flagset.String("secretID", "", "Secret for app role ID")
+	appRoleIDPtr := flagset.String("appRoleID", "", "Public app role ID")
+	insecurePtr := flagset.Bool("insecure", false, "By default, every ssl connection this tool makes is verified secure.  This option allows to tool to continue with server connections considered insecure.")
+	logFilePtr := flagset.String("log", "./"+coreopts.BuildOptions.GetFolderPrefix(nil)+"config.log", "Output path for log file")
+	defaultIndexPath, defaultIdName := coreopts.BuildOptions.GetDFSPathName()
+	indexPathPtr := flagset.String("indexPath", defaultIndexPath, "Tenant index path of the statistics")
+	idNamePtr := flagset.String("idName", defaultIdName, "Tenant id name of the statistics")
+	fromPtr := flagset.String("from", "", "Store to migrate from: vault, trcdb or local (default the selected store)")
+	fromPathPtr := flagset.String("fromPath", "", "Directory of a local store to migrate from")
+	toPtr := flagset.String("to", "", "Store to migrate to: vault, trcdb or local")
+	toPathPtr := flagset.String("toPath", "", "Directory of a local store to migrate to")
+	retentionDaysPtr := flagset.Int("retentionDays", -1, "Drop statistics older than this many days (default the selected store's policy)")
+	maxPerSeriesPtr := flagset.Int("maxPerSeries", -1, "Samples kept of each flow state (default the selected store's policy)")
+	flagset.Parse(args[1:])
+	if err := eUtils.ApplyContextProfile(flagset); err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	if len(*indexPathPtr) == 0 || len(*idNamePtr) == 0 {
+		return errors.New("indexPath and idName are required")
+	}
+
+	f, err := os.OpenFile(*logFilePtr, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Log init failure")
+		return err
+	}
+	logger := log.New(f, "["+coreopts.BuildOptions.GetFolderPrefix(nil)+"ctl]", log.LstdFlags)
+
+	mod, err := certsModifier(*envPtr, addrPtr, *tokenPtr, secretIDPtr, appRoleIDPtr, *insecurePtr, false, logger)
+	if err != nil {
+		return err
+	}
+	defer mod.Release()
+
+	selected, err := statsStoreConfig(mod)
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+
+	switch action {
+	case "migrate":
+		if len(*toPtr) == 0 {
+			return errors.New("migrate requires -to")
+		}
+		fromConfig := selected
+		if len(*fromPtr) > 0 {
+			fromConfig = &flowcore.StatisticsStoreConfig{Store: *fromPtr, Path: *fromPathPtr}
+		}
+		toConfig := &flowcore.StatisticsStoreConfig{Store: *toPtr, Path: *toPathPtr}
+		if fromConfig.Store == toConfig.Store && fromConfig.Path == toConfig.Path {
+			return errors.New("migrate requires different stores")
+		}
+		from, err := flowcore.NewStatisticsStore(mod, fromConfig, logger)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		defer from.Close()
+		to, err := flowcore.NewStatisticsStore(mod, toConfig, logger)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		defer to.Close()
+		migrated, err := flowcore.MigrateStatistics(from, to, *indexPathPtr, *idNamePtr)
+		fmt.Printf("Migrated %d statistics from %s to %s.\n", migrated, from.Name(), to.Name())
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		if toConfig.Store != selected.Store {
+			fmt.Printf("Set store to %s in %s to use it.\n", toConfig.Store, flowcore.STATISTICS_STORE_CONFIG_PATH)
+		}
+	case "compact":
+		policy := selected.Retention
+		if *retentionDaysPtr >= 0 {
+			policy.MaxAge = time.Duration(*retentionDaysPtr) * 24 * time.Hour
+		}
+		if *maxPerSeriesPtr >= 0 {
+			policy.MaxPerSeries = *maxPerSeriesPtr
+		}
+		if policy.MaxAge == 0 && policy.MaxPerSeries == 0 {
+			fmt.Println("No retention policy, nothing to compact.")
+			return nil
+		}
+		store, err := flowcore.NewStatisticsStore(mod, selected, logger)
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+		defer store.Close()
+		compacted, err := store.Compact(*indexPathPtr, *idNamePtr, policy)
+		fmt.Printf("Compacted %d statistics from %s.\n", compacted, store.Name())
+		if err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// statsStoreConfig reads the statistics store selected for mod's
+// environment.
+func statsStoreConfig(mod *helperkv.Modifier) (*flowcore.StatisticsStoreConfig, error) {
+	mod.SectionPath = ""
+	settings, err := mod.ReadData(flowcore.STATISTICS_STORE_CONFIG_PATH)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		settings = map[string]interface{}{}
+	}
+	return flowcore.NewStatisticsStoreConfig(settings)
+}
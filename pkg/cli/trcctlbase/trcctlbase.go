@@ -45,6 +45,18 @@ func CommonMain(envDefaultPtr *string,
 	if memonly.IsMemonly() {
 		memprotectopts.MemProtectInit(nil)
 	}
+	if len(argLines) > 1 {
+		switch argLines[1] {
+		case "get", "set", "unset", "history":
+			return ConfigPathMain(argLines[1], argLines[2:])
+		case "certs":
+			return CertsMain(argLines[2:])
+		case "renew":
+			return RenewMain(argLines[2:])
+		case "stats":
+			return StatsMain(argLines[2:])
+		}
+	}
 	var envPtr *string = nil
 	var envCtxPtr *string = new(string)
 	var logFilePtr *string = nil
@@ -90,10 +102,20 @@ func CommonMain(envDefaultPtr *string,
 		}
 	}
 	flagset.Parse(os.Args[1:])
+	if ctl == "context" {
+		switch flagset.Arg(0) {
+		case "list", "use", "set", "delete":
+			return ContextMain(flagset.Args())
+		}
+	}
 	if flagset.NFlag() == 0 {
 		flagset.Usage()
 		os.Exit(0)
 	}
+	if err := eUtils.ApplyContextProfile(flagset); err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
 
 	if ctl != "" {
 		var err error
@@ -386,3 +408,87 @@ func GetPluginConfigs(driverConfig *config.DriverConfig, flagset *flag.FlagSet,
 		fmt.Printf("Plugin not registered with trcctl.\n")
 	}
 }
+
+// ContextMain manages the named context profiles used by all the tools.
+//
+//	trcctl context list
+//	trcctl context use <name>
+//	trcctl context set <name> [-addr=...] [-env=...] [-auth=token|approle|oidc|exec] ...
+//	trcctl context delete <name>
+func ContextMain(args []string) error {
+	profiles, err := eUtils.LoadContextProfiles()
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	if args[0] == "list" {
+		active := os.Getenv(eUtils.CONTEXT_ENV_VAR)
+		if len(active) == 0 {
+			active = profiles.Current
+		}
+		for _, name := range profiles.Names() {
+			profile := profiles.Contexts[name]
+			marker := " "
+			if name == active {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\t%s\t%s\t%s\n", marker, name, profile.VaultHost, profile.Env, profile.Auth)
+		}
+		return nil
+	}
+	if len(args) < 2 {
+		fmt.Printf("Usage: trcctl context %s <name>\n", args[0])
+		return fmt.Errorf("missing context name")
+	}
+	name := args[1]
+
+	switch args[0] {
+	case "use":
+		err = profiles.Use(name)
+	case "delete":
+		err = profiles.Delete(name)
+	case "set":
+		profile, ok := profiles.Contexts[name]
+		if !ok {
+			profile = &eUtils.ContextProfile{Name: name}
+		}
+		setFlagset := flag.NewFlagSet("context set", flag.ContinueOnError)
+		fields := map[string]*string{
+			"addr":         &profile.VaultHost,
+			"env":          &profile.Env,
+			"region":       &profile.Region,
+			"auth":         &profile.Auth,
+			"token":        &profile.Token,
+			"appRoleID":    &profile.ApproleID,
+			"secretID":     &profile.SecretID,
+			"oidcIssuer":   &profile.OIDCIssuer,
+			"oidcClientID": &profile.OIDCClientID,
+			"oidcScopes":   &profile.OIDCScopes,
+			"oidcRole":     &profile.OIDCRole,
+			"jwtMount":     &profile.JWTMount,
+			"project":      &profile.Project,
+			"service":      &profile.Service,
+		}
+		for field, valuePtr := range fields {
+			setFlagset.StringVar(valuePtr, field, *valuePtr, "Context "+field)
+		}
+		commandPtr := setFlagset.String("command", strings.Join(profile.Command, " "), "Credential helper command for exec auth")
+		usePtr := setFlagset.Bool("use", false, "Make this the current context")
+		if err := setFlagset.Parse(args[2:]); err != nil {
+			return err
+		}
+		profile.Command = strings.Fields(*commandPtr)
+		if err = profiles.Set(profile); err == nil && (*usePtr || len(profiles.Current) == 0) {
+			err = profiles.Use(name)
+		}
+	}
+	if err == nil {
+		err = profiles.Save()
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return err
+	}
+	fmt.Printf("Current context is %s\n", profiles.Current)
+	return nil
+}
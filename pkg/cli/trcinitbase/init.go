@@ -14,12 +14,14 @@ import (
 	"github.com/trimble-oss/tierceron/buildopts/coreopts"
 	"github.com/trimble-oss/tierceron/pkg/core"
 	"github.com/trimble-oss/tierceron/pkg/core/cache"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
 	il "github.com/trimble-oss/tierceron/pkg/trcinit/initlib"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
 	"github.com/trimble-oss/tierceron/pkg/utils/config"
 	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
 	sys "github.com/trimble-oss/tierceron/pkg/vaulthelper/system"
 	"github.com/trimble-oss/tierceron/trcweb/rpc/apinator"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func defaultFalse() *bool {
@@ -140,6 +142,10 @@ func CommonMain(envPtr *string,
 		*insecurePtr = driverConfigBase.CoreConfig.Insecure
 		appRolePtr = driverConfigBase.CoreConfig.AppRoleConfigPtr
 	} else {
+		if err := eUtils.ApplyContextProfile(flagset); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 		// If logging production directory does not exist and is selected log to local directory
 		if _, err := os.Stat("/var/log/"); *logFilePtr == "/var/log/"+coreopts.BuildOptions.GetFolderPrefix(nil)+"init.log" && os.IsNotExist(err) {
 			*logFilePtr = "./" + coreopts.BuildOptions.GetFolderPrefix(nil) + "init.log"
@@ -173,6 +179,9 @@ func CommonMain(envPtr *string,
 
 		eUtils.CheckError(driverConfigBase.CoreConfig, err, true)
 	}
+	defer telemetry.InitFromEnv(coreopts.BuildOptions.GetFolderPrefix(nil)+"init", driverConfigBase.CoreConfig.Log)()
+	endRun := eUtils.StartRun(driverConfigBase.CoreConfig, coreopts.BuildOptions.GetFolderPrefix(nil)+"init", attribute.String("trc.env", *envPtr))
+	defer endRun(nil)
 
 	// indexServiceExtFilterPtr := flag.String("serviceExtFilter", "", "Specifies which nested services (or tables) to filter") //offset or database
 	// indexServiceFilterPtr := flag.String("serviceFilter", "", "Specifies which services (or tables) to filter")              // Table names
@@ -879,6 +888,7 @@ func CommonMain(envPtr *string,
 				EnvBasis:            eUtils.GetEnvBasis(*envPtr),
 				WantCerts:           *uploadCertPtr, // TODO: this was false...
 				Log:                 driverConfigBase.CoreConfig.Log,
+				RunCtx:              driverConfigBase.CoreConfig.RunCtx,
 			},
 			SectionKey:      sectionKey,
 			SectionName:     subSectionName,
@@ -77,6 +77,10 @@ func CommonMain(envPtr *string,
 			appRoleConfigPtr = driverConfigBase.CoreConfig.AppRoleConfigPtr
 		}
 	} else {
+		if err := eUtils.ApplyContextProfile(flagset); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
 		// If logging production directory does not exist and is selected log to local directory
 		if _, err := os.Stat("/var/log/"); os.IsNotExist(err) && *logFilePtr == "/var/log/"+coreopts.BuildOptions.GetFolderPrefix(nil)+"pub.log" {
 			*logFilePtr = "./" + coreopts.BuildOptions.GetFolderPrefix(nil) + "pub.log"
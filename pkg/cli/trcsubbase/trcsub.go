@@ -73,6 +73,12 @@ func CommonMain(envDefaultPtr *string, addrPtr *string, envCtxPtr *string,
 	templatePathsPtr := flagset.String("templatePaths", "", "Specifies which specific templates to download.")
 
 	flagset.Parse(argLines[1:])
+	if !driverConfig.CoreConfig.IsShell {
+		if err := eUtils.ApplyContextProfile(flagset); err != nil {
+			fmt.Println(err.Error())
+			return err
+		}
+	}
 	if envPtr == nil {
 		envPtr = envDefaultPtr
 	}
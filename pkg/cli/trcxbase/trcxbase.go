@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/trimble-oss/tierceron/buildopts/coreopts"
 	"github.com/trimble-oss/tierceron/pkg/core"
 	"github.com/trimble-oss/tierceron/pkg/core/cache"
+	"github.com/trimble-oss/tierceron/pkg/trcx/xgraph"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
 	"github.com/trimble-oss/tierceron/pkg/utils/config"
 	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
@@ -95,6 +97,8 @@ func CommonMain(ctx config.ProcessContext,
 	versionPtr := flagset.Bool("versions", false, "Gets version metadata information")
 	wantCertsPtr := flagset.Bool("certs", false, "Pull certificates into directory specified by endDirPtr")
 	filterTemplatePtr := flagset.String("templateFilter", "", "Specifies which templates to filter") // -templateFilter=config.yml
+	impactPtr := flagset.String("impact", "", "Show the templates and services depending on a key, such as values/Service.key or Project/Service/config.key")
+	graphPtr := flagset.String("graph", "", "Export the dependency graph of templates, keys and vault paths to a .dot or .json file")
 
 	// Checks for proper flag input
 	args := argLines[1:]
@@ -107,6 +111,10 @@ func CommonMain(ctx config.ProcessContext,
 	}
 
 	eUtils.CheckInitFlags(flagset, argLines[1:])
+	if err := eUtils.ApplyContextProfile(flagset); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
 
 	configCtx := &config.ConfigContext{
 		ResultMap:            make(map[string]*string),
@@ -342,7 +350,7 @@ skipDiff:
 			fmt.Println("Missing required start template folder: " + *startDirPtr)
 			os.Exit(1)
 		}
-		if !*diffPtr { // -diff doesn't require seed folder
+		if !*diffPtr && len(*impactPtr) == 0 && len(*graphPtr) == 0 { // -diff and -impact don't require seed folder
 			if _, err := os.Stat(*endDirPtr); os.IsNotExist(err) {
 				fmt.Println("Missing required start seed folder: " + *endDirPtr)
 				os.Exit(1)
@@ -393,6 +401,23 @@ skipDiff:
 		os.Exit(1)
 	}
 
+	if len(*impactPtr) > 0 || len(*graphPtr) > 0 {
+		var mod *kv.Modifier
+		if !*noVaultPtr {
+			mod, err = kv.NewModifier(*insecurePtr, driverConfigBase.CoreConfig.TokenCache.GetToken(fmt.Sprintf("config_token_%s", envBasis)), addrPtr, envBasis, regions, true, logger)
+			if err != nil || mod == nil {
+				eUtils.LogErrorMessage(driverConfigBase.CoreConfig, "Access to vault failure.", false)
+				os.Exit(1)
+			}
+			defer mod.Release()
+		}
+		if err := impactMain(*startDirPtr, mod, *impactPtr, *graphPtr, logger); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	if len(*envPtr) >= 5 && (*envPtr)[:5] == "local" {
 		var err error
 		*envPtr, err = eUtils.LoginToLocal()
@@ -849,6 +874,7 @@ skipDiff:
 			configCtx.FileSysIndex = -1
 			cctx.SetDiffFileCount(len(configCtx.ResultMap) / configCtx.EnvLength)
 			eUtils.DiffHelper(cctx, false)
+			diffImpact(cctx, *startDirPtr, logger)
 		}(configCtx)
 	}
 	waitg.Wait() //Wait for diff
@@ -858,3 +884,83 @@ skipDiff:
 	logger.SetPrefix("[END]")
 	logger.Println()
 }
+
+// dependencyGraph indexes the templates in startDir, and the keys they link
+// to in vault when there is a modifier.
+func dependencyGraph(startDir string, mod *kv.Modifier, logger *log.Logger) (*xgraph.Graph, error) {
+	graph := xgraph.NewGraph()
+	if err := xgraph.IndexTemplates(graph, startDir); err != nil {
+		return nil, err
+	}
+	if mod != nil {
+		if err := xgraph.IndexVault(graph, mod, logger); err != nil {
+			return nil, err
+		}
+	}
+	return graph, nil
+}
+
+// impactMain answers -impact and exports -graph.
+func impactMain(startDir string, mod *kv.Modifier, impact string, graphPath string, logger *log.Logger) error {
+	graph, err := dependencyGraph(startDir, mod, logger)
+	if err != nil {
+		return err
+	}
+	if len(graphPath) > 0 {
+		if err := graph.Export(graphPath); err != nil {
+			return err
+		}
+		fmt.Printf("Dependency graph written to %s\n", graphPath)
+	}
+	if len(impact) > 0 {
+		fmt.Print(graph.Impact(impact).String())
+	}
+	return nil
+}
+
+// diffImpact lists the services depending on the keys differing between
+// the diffed seeds.
+func diffImpact(configCtx *config.ConfigContext, startDir string, logger *log.Logger) {
+	configCtx.Mutex.Lock()
+	resultKeys := []string{}
+	for resultKey := range configCtx.ResultMap {
+		resultKeys = append(resultKeys, resultKey)
+	}
+	sort.Strings(resultKeys)
+	seeds := []string{}
+	for _, resultKey := range resultKeys {
+		if configCtx.ResultMap[resultKey] != nil {
+			seeds = append(seeds, *configCtx.ResultMap[resultKey])
+		}
+	}
+	configCtx.Mutex.Unlock()
+	if len(seeds) < 2 {
+		return
+	}
+
+	changed := []string{}
+	for _, seed := range seeds[1:] {
+		changedKeys, err := xgraph.ChangedKeys(seeds[0], seed)
+		if err != nil {
+			logger.Printf("Unable to compare seeds for impact: %v\n", err)
+			return
+		}
+		changed = append(changed, changedKeys...)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	graph, err := dependencyGraph(startDir, nil, logger)
+	if err != nil {
+		logger.Printf("Unable to index templates for impact: %v\n", err)
+		return
+	}
+	fmt.Println("Services affected by the differences:")
+	for _, service := range graph.AffectedServices(changed) {
+		if service.Deployed {
+			fmt.Printf("  %s (deployed)\n", service.ID)
+		} else {
+			fmt.Printf("  %s\n", service.ID)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"log"
 
 	"github.com/trimble-oss/tierceron/pkg/core/cache"
@@ -24,4 +25,5 @@ type CoreConfig struct {
 	WantCerts         bool
 	ExitOnFailure     bool // Exit on a failure or try to continue
 	Log               *log.Logger
+	RunCtx            context.Context // Telemetry run the vault calls made with this config are part of.
 }
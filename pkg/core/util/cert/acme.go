@@ -0,0 +1,446 @@
+package cert
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	ACME_CHALLENGE_HTTP01 = "http-01"
+	ACME_CHALLENGE_DNS01  = "dns-01"
+)
+
+// Vault path of the acme configuration for an environment.
+const ACME_CONFIG_PATH = "super-secrets/Restricted/AcmeConfig/config"
+
+// AcmeConfig configures certificate renewal through an ACME directory.  It is
+// read from ACME_CONFIG_PATH:
+//
+//	directory    ACME directory url
+//	email        account contact
+//	challenge    http-01 or dns-01
+//	dnsProvider  registered DNS provider answering dns-01 challenges
+//	httpAddress  listen address of the http-01 responder
+//	renewDays    renew certs expiring within this many days
+//	keyType      rsa (default) or ecdsa keys for renewed certs
+//	caBundle     pem trusted for the directory, for test servers
+//	accountKey   written back on first use
+//
+// The remaining settings are left for the DNS provider.
+type AcmeConfig struct {
+	DirectoryURL string
+	Email        string
+	Challenge    string
+	DNSProvider  string
+	HTTPAddress  string
+	RenewDays    int
+	KeyType      string
+	CABundle     string
+	AccountKey   string
+	Settings     map[string]string
+}
+
+// ReadAcmeConfig reads the acme configuration of the modifier's environment.
+func ReadAcmeConfig(mod *helperkv.Modifier) (*AcmeConfig, error) {
+	mod.SectionPath = ""
+	configData, err := mod.ReadData(ACME_CONFIG_PATH)
+	if err != nil {
+		return nil, err
+	}
+	if len(configData) == 0 {
+		return nil, errors.New("no acme configuration at " + ACME_CONFIG_PATH)
+	}
+	settings := map[string]string{}
+	for key, value := range configData {
+		settings[key] = fmt.Sprintf("%v", value)
+	}
+	return NewAcmeConfig(settings)
+}
+
+// NewAcmeConfig builds an acme configuration from settings.
+func NewAcmeConfig(settings map[string]string) (*AcmeConfig, error) {
+	acmeConfig := &AcmeConfig{
+		DirectoryURL: settings["directory"],
+		Email:        settings["email"],
+		Challenge:    settings["challenge"],
+		DNSProvider:  settings["dnsProvider"],
+		HTTPAddress:  settings["httpAddress"],
+		RenewDays:    int(DefaultCertThresholds.Warn.Hours() / 24),
+		KeyType:      settings["keyType"],
+		CABundle:     settings["caBundle"],
+		AccountKey:   settings["accountKey"],
+		Settings:     settings,
+	}
+	if renewDays, ok := settings["renewDays"]; ok && len(renewDays) > 0 {
+		days, err := strconv.Atoi(renewDays)
+		if err != nil {
+			return nil, fmt.Errorf("invalid renewDays %s", renewDays)
+		}
+		acmeConfig.RenewDays = days
+	}
+	if len(acmeConfig.Challenge) == 0 {
+		acmeConfig.Challenge = ACME_CHALLENGE_HTTP01
+	}
+	if len(acmeConfig.HTTPAddress) == 0 {
+		acmeConfig.HTTPAddress = ":80"
+	}
+	return acmeConfig, acmeConfig.Validate()
+}
+
+// Validate checks the configuration is complete.
+func (ac *AcmeConfig) Validate() error {
+	if len(ac.DirectoryURL) == 0 {
+		return errors.New("acme directory is required")
+	}
+	switch ac.Challenge {
+	case ACME_CHALLENGE_HTTP01:
+	case ACME_CHALLENGE_DNS01:
+		if len(ac.DNSProvider) == 0 {
+			return errors.New("dns-01 challenges require a dnsProvider")
+		}
+		if _, ok := dnsProviders[ac.DNSProvider]; !ok {
+			return fmt.Errorf("unsupported dnsProvider %s", ac.DNSProvider)
+		}
+	default:
+		return fmt.Errorf("unsupported challenge %s, expecting http-01 or dns-01", ac.Challenge)
+	}
+	switch ac.KeyType {
+	case "", "rsa", "ecdsa":
+	default:
+		return fmt.Errorf("unsupported keyType %s, expecting rsa or ecdsa", ac.KeyType)
+	}
+	return nil
+}
+
+// DNSProvider publishes the TXT records answering dns-01 challenges.  fqdn is
+// the full record name, including the _acme-challenge label and trailing dot.
+type DNSProvider interface {
+	Present(ctx context.Context, fqdn string, value string) error
+	CleanUp(ctx context.Context, fqdn string, value string) error
+}
+
+var dnsProviders = map[string]func(acmeConfig *AcmeConfig) (DNSProvider, error){
+	"exec": newExecDNSProvider,
+}
+
+// RegisterDNSProvider makes a DNS provider available to dns-01 challenges.
+func RegisterDNSProvider(name string, factory func(acmeConfig *AcmeConfig) (DNSProvider, error)) {
+	dnsProviders[name] = factory
+}
+
+// execDNSProvider runs dnsCommand with the arguments
+// present|cleanup <fqdn> <value> to manage the TXT record.
+type execDNSProvider struct {
+	command []string
+}
+
+func newExecDNSProvider(acmeConfig *AcmeConfig) (DNSProvider, error) {
+	command := strings.Fields(acmeConfig.Settings["dnsCommand"])
+	if len(command) == 0 {
+		return nil, errors.New("exec dnsProvider requires a dnsCommand")
+	}
+	return &execDNSProvider{command: command}, nil
+}
+
+func (p *execDNSProvider) run(ctx context.Context, action string, fqdn string, value string) error {
+	args := append(append([]string{}, p.command[1:]...), action, fqdn, value)
+	output, err := exec.CommandContext(ctx, p.command[0], args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("dnsCommand %s failed: %v %s", action, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (p *execDNSProvider) Present(ctx context.Context, fqdn string, value string) error {
+	return p.run(ctx, "present", fqdn, value)
+}
+
+func (p *execDNSProvider) CleanUp(ctx context.Context, fqdn string, value string) error {
+	return p.run(ctx, "cleanup", fqdn, value)
+}
+
+// HTTP01Responder answers http-01 challenges for the tokens presented to it.
+type HTTP01Responder struct {
+	tokens     map[string]string
+	tokensLock sync.RWMutex
+	server     *http.Server
+}
+
+func NewHTTP01Responder() *HTTP01Responder {
+	return &HTTP01Responder{tokens: map[string]string{}}
+}
+
+func (r *HTTP01Responder) Present(token string, keyAuth string) {
+	r.tokensLock.Lock()
+	defer r.tokensLock.Unlock()
+	r.tokens[token] = keyAuth
+}
+
+func (r *HTTP01Responder) CleanUp(token string) {
+	r.tokensLock.Lock()
+	defer r.tokensLock.Unlock()
+	delete(r.tokens, token)
+}
+
+// ServeHTTP implements http.Handler.
+func (r *HTTP01Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	token, ok := strings.CutPrefix(req.URL.Path, "/.well-known/acme-challenge/")
+	if !ok || req.Method != http.MethodGet {
+		http.NotFound(w, req)
+		return
+	}
+	r.tokensLock.RLock()
+	keyAuth, ok := r.tokens[token]
+	r.tokensLock.RUnlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth))
+}
+
+// Start serves challenges on address until Stop is called.
+func (r *HTTP01Responder) Start(address string, logger *log.Logger) {
+	r.server = &http.Server{Addr: address, Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	go func(server *http.Server) {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Printf("http-01 responder on %s failed: %v\n", address, err)
+		}
+	}(r.server)
+}
+
+func (r *HTTP01Responder) Stop() {
+	if r.server != nil {
+		r.server.Close()
+	}
+}
+
+// AcmeClient obtains certificates from an ACME directory.
+type AcmeClient struct {
+	Config    *AcmeConfig
+	Responder *HTTP01Responder
+	client    *acme.Client
+	dns       DNSProvider
+	log       *log.Logger
+}
+
+// NewAcmeClient registers with the configured directory.  A new account key
+// is generated if the configuration has none, check AccountKey afterwards to
+// save it.  http-01 challenges are answered by responder, which must be
+// reachable on port 80 of each domain.
+func NewAcmeClient(ctx context.Context, acmeConfig *AcmeConfig, responder *HTTP01Responder, logger *log.Logger) (*AcmeClient, error) {
+	if err := acmeConfig.Validate(); err != nil {
+		return nil, err
+	}
+	accountKey, err := acmeAccountKey(acmeConfig)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := http.DefaultClient
+	if len(acmeConfig.CABundle) > 0 {
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM([]byte(acmeConfig.CABundle)) {
+			return nil, errors.New("invalid acme caBundle")
+		}
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	}
+	ac := &AcmeClient{
+		Config:    acmeConfig,
+		Responder: responder,
+		client: &acme.Client{
+			Key:          accountKey,
+			DirectoryURL: acmeConfig.DirectoryURL,
+			HTTPClient:   httpClient,
+			UserAgent:    "tierceron",
+		},
+		log: logger,
+	}
+	switch acmeConfig.Challenge {
+	case ACME_CHALLENGE_DNS01:
+		if ac.dns, err = dnsProviders[acmeConfig.DNSProvider](acmeConfig); err != nil {
+			return nil, err
+		}
+	case ACME_CHALLENGE_HTTP01:
+		if responder == nil {
+			return nil, errors.New("http-01 challenges require a responder")
+		}
+	}
+
+	account := &acme.Account{}
+	if len(acmeConfig.Email) > 0 {
+		account.Contact = []string{"mailto:" + acmeConfig.Email}
+	}
+	if _, err := ac.client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("acme registration failed: %v", err)
+	}
+	return ac, nil
+}
+
+func acmeAccountKey(acmeConfig *AcmeConfig) (crypto.Signer, error) {
+	if len(acmeConfig.AccountKey) > 0 {
+		block, _ := pem.Decode([]byte(acmeConfig.AccountKey))
+		if block == nil {
+			return nil, errors.New("invalid acme accountKey")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acme accountKey: %v", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("invalid acme accountKey")
+		}
+		return signer, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	acmeConfig.AccountKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes}))
+	return key, nil
+}
+
+// SaveAccountKey writes a newly generated account key back to the acme
+// configuration so later renewals reuse the account.
+func (ac *AcmeClient) SaveAccountKey(mod *helperkv.Modifier) error {
+	if ac.Config.Settings["accountKey"] == ac.Config.AccountKey {
+		return nil
+	}
+	configData, version, err := mod.ReadDataVersion(ACME_CONFIG_PATH)
+	if err != nil {
+		return err
+	}
+	configData["accountKey"] = ac.Config.AccountKey
+	if _, err := mod.WriteCAS(ACME_CONFIG_PATH, configData, version, ac.log); err != nil {
+		return err
+	}
+	ac.Config.Settings["accountKey"] = ac.Config.AccountKey
+	return nil
+}
+
+// Obtain requests a certificate for domains, returning the der encoded chain
+// and its new private key.
+func (ac *AcmeClient) Obtain(ctx context.Context, domains []string) ([][]byte, crypto.Signer, error) {
+	if len(domains) == 0 {
+		return nil, nil, errors.New("no domains to certify")
+	}
+	order, err := ac.client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanups := []func(){}
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := ac.client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == ac.Config.Challenge {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			return nil, nil, fmt.Errorf("no %s challenge offered for %s", ac.Config.Challenge, authz.Identifier.Value)
+		}
+		cleanup, err := ac.present(ctx, authz.Identifier.Value, challenge)
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanups = append(cleanups, cleanup)
+		if _, err := ac.client.Accept(ctx, challenge); err != nil {
+			return nil, nil, err
+		}
+		if _, err := ac.client.WaitAuthorization(ctx, authz.URI); err != nil {
+			return nil, nil, fmt.Errorf("authorization of %s failed: %v", authz.Identifier.Value, err)
+		}
+	}
+	if order, err = ac.client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, nil, err
+	}
+
+	var key crypto.Signer
+	if ac.Config.KeyType == "ecdsa" {
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	} else {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.TrimPrefix(domains[0], "*.")},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	chain, _, err := ac.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return chain, key, nil
+}
+
+func (ac *AcmeClient) present(ctx context.Context, domain string, challenge *acme.Challenge) (func(), error) {
+	switch challenge.Type {
+	case ACME_CHALLENGE_DNS01:
+		value, err := ac.client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+		fqdn := "_acme-challenge." + strings.TrimPrefix(domain, "*.") + "."
+		if err := ac.dns.Present(ctx, fqdn, value); err != nil {
+			return nil, err
+		}
+		return func() {
+			if err := ac.dns.CleanUp(context.Background(), fqdn, value); err != nil {
+				ac.log.Printf("Unable to clean up %s: %v\n", fqdn, err)
+			}
+		}, nil
+	default:
+		keyAuth, err := ac.client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return nil, err
+		}
+		ac.Responder.Present(challenge.Token, keyAuth)
+		return func() { ac.Responder.CleanUp(challenge.Token) }, nil
+	}
+}
@@ -0,0 +1,145 @@
+package cert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/validator"
+)
+
+func TestHTTP01Responder(t *testing.T) {
+	responder := NewHTTP01Responder()
+	server := httptest.NewServer(responder)
+	defer server.Close()
+
+	responder.Present("token1", "token1.thumbprint")
+	resp, err := server.Client().Get(server.URL + "/.well-known/acme-challenge/token1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || string(body) != "token1.thumbprint" {
+		t.Fatalf("Unexpected challenge response %d %s", resp.StatusCode, body)
+	}
+	responder.CleanUp("token1")
+	resp, err = server.Client().Get(server.URL + "/.well-known/acme-challenge/token1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Fatalf("Expected cleaned up token to be gone, got %d", resp.StatusCode)
+	}
+}
+
+func TestRenewalCandidatesAndEncoding(t *testing.T) {
+	now := time.Now()
+	records := []CertRecord{
+		{Project: "Common", Service: "svc", File: "svc", CertificateInfo: validator.CertificateInfo{IsCA: true, NotAfter: now.Add(5 * 24 * time.Hour)}},
+		{Project: "Common", Service: "svc", File: "svc", CertificateInfo: validator.CertificateInfo{NotAfter: now.Add(60 * 24 * time.Hour)}},
+		{Project: "Common", Service: "other", File: "other", CertificateInfo: validator.CertificateInfo{NotAfter: now.Add(10 * 24 * time.Hour)}},
+		{Project: "Common", Service: "broken", File: "broken", Error: "unable to decode certData"},
+	}
+	// The leaf decides, not the CA expiring first.
+	candidates := RenewalCandidates(records, 30*24*time.Hour, false)
+	if len(candidates) != 1 || candidates[0].Address() != "Common/other/other" {
+		t.Fatalf("Unexpected candidates %v", candidates)
+	}
+	if candidates := RenewalCandidates(records, 30*24*time.Hour, true); len(candidates) != 2 {
+		t.Fatalf("Expected every readable cert when forced, got %v", candidates)
+	}
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "svc.example.com"},
+		NotBefore:    now,
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "svc.example.com"}}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certBytes, keyBytes, err := EncodeRenewedCert("ENV/svc.pem", [][]byte{der}, key, true)
+	if err != nil || !strings.Contains(string(certBytes), "PRIVATE KEY") || !strings.Contains(string(keyBytes), "PRIVATE KEY") {
+		t.Fatalf("Expected pem with key, got %v", err)
+	}
+	if certBytes, _, err := EncodeRenewedCert("ENV/svc.cer", [][]byte{der}, key, false); err != nil || string(certBytes) != string(der) {
+		t.Fatalf("Expected der cer, got %v", err)
+	}
+	if _, _, err := EncodeRenewedCert("ENV/svc.pfx", [][]byte{der}, key, false); err == nil {
+		t.Fatal("Expected pfx renewal to be unsupported")
+	}
+}
+
+// TestAcmeObtain runs against a local ACME test server such as pebble:
+//
+//	TRC_ACME_TEST_DIRECTORY=https://localhost:14000/dir
+//	TRC_ACME_TEST_CA=pebble.minica.pem
+//	TRC_ACME_TEST_HTTP_ADDRESS=:5002
+//	TRC_ACME_TEST_DOMAIN=localhost
+func TestAcmeObtain(t *testing.T) {
+	directory := os.Getenv("TRC_ACME_TEST_DIRECTORY")
+	if len(directory) == 0 {
+		t.Skip("TRC_ACME_TEST_DIRECTORY not set")
+	}
+	settings := map[string]string{
+		"directory":   directory,
+		"challenge":   ACME_CHALLENGE_HTTP01,
+		"httpAddress": os.Getenv("TRC_ACME_TEST_HTTP_ADDRESS"),
+		"keyType":     "ecdsa",
+	}
+	if len(settings["httpAddress"]) == 0 {
+		settings["httpAddress"] = ":5002"
+	}
+	if caPath := os.Getenv("TRC_ACME_TEST_CA"); len(caPath) > 0 {
+		ca, err := os.ReadFile(caPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		settings["caBundle"] = string(ca)
+	}
+	domain := os.Getenv("TRC_ACME_TEST_DOMAIN")
+	if len(domain) == 0 {
+		domain = "localhost"
+	}
+	acmeConfig, err := NewAcmeConfig(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(os.Stderr, "[acme]", log.LstdFlags)
+	responder := NewHTTP01Responder()
+	responder.Start(acmeConfig.HTTPAddress, logger)
+	defer responder.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	acmeClient, err := NewAcmeClient(ctx, acmeConfig, responder, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, key, err := acmeClient.Obtain(ctx, []string{domain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certBytes, _, err := EncodeRenewedCert("ENV/"+domain+".crt", chain, key, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := validator.ParseCertificates(certBytes, "")
+	if err != nil || certs[0].VerifyHostname(domain) != nil {
+		t.Fatalf("Expected a cert for %s, got %v", domain, err)
+	}
+}
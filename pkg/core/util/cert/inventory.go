@@ -0,0 +1,202 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/validator"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+const (
+	CERT_STATUS_OK       = "ok"
+	CERT_STATUS_WARN     = "warn"
+	CERT_STATUS_CRITICAL = "critical"
+	CERT_STATUS_EXPIRED  = "expired"
+	CERT_STATUS_ERROR    = "error"
+)
+
+// Cert source extensions included in the inventory.
+var CertInventoryExtensions = []string{".crt", ".cer", ".pem", ".pfx", ".jks"}
+
+// CertThresholds are how long before expiration a cert is reported as a
+// warning or as critical.
+type CertThresholds struct {
+	Warn     time.Duration
+	Critical time.Duration
+}
+
+var DefaultCertThresholds = CertThresholds{
+	Warn:     30 * 24 * time.Hour,
+	Critical: 7 * 24 * time.Hour,
+}
+
+// Grade returns the status of a cert expiring at notAfter.
+func (t CertThresholds) Grade(notAfter time.Time, now time.Time) string {
+	remaining := notAfter.Sub(now)
+	switch {
+	case remaining <= 0:
+		return CERT_STATUS_EXPIRED
+	case remaining < t.Critical:
+		return CERT_STATUS_CRITICAL
+	case remaining < t.Warn:
+		return CERT_STATUS_WARN
+	}
+	return CERT_STATUS_OK
+}
+
+// CertRecord is the inventory entry of a single certificate.  A bundle with
+// several certificates has a record per certificate, while a template whose
+// cert could not be read has a single record with Error set.
+type CertRecord struct {
+	Env                       string `json:"env" yaml:"env"`
+	Project                   string `json:"project" yaml:"project"`
+	Service                   string `json:"service" yaml:"service"`
+	File                      string `json:"file" yaml:"file"`
+	SourcePath                string `json:"sourcePath" yaml:"sourcePath"`
+	validator.CertificateInfo `yaml:",inline"`
+	DaysRemaining             int    `json:"daysRemaining" yaml:"daysRemaining"`
+	Status                    string `json:"status" yaml:"status"`
+	Error                     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Address is the trcctl address of the values holding the cert.
+func (r *CertRecord) Address() string {
+	return fmt.Sprintf("%s/%s/%s", r.Project, r.Service, r.File)
+}
+
+// IsCertSource returns true if the cert source path names an inventoried cert.
+func IsCertSource(sourcePath string) bool {
+	ext := strings.ToLower(path.Ext(sourcePath))
+	for _, certExt := range CertInventoryExtensions {
+		if ext == certExt {
+			return true
+		}
+	}
+	return false
+}
+
+// InventoryCerts parses every templated cert of the modifier's environment,
+// limited to projects if any are provided.  Records are sorted with the
+// earliest expiration first and unreadable certs last.
+func InventoryCerts(mod *helperkv.Modifier, projects []string, thresholds CertThresholds, logger *log.Logger) ([]CertRecord, error) {
+	projectServices, err := mod.GetProjectServicesMap(logger)
+	if err != nil {
+		return nil, err
+	}
+	mod.SectionPath = ""
+	now := time.Now()
+	records := []CertRecord{}
+	for project, services := range projectServices {
+		if len(projects) > 0 && !containsProject(projects, project) {
+			continue
+		}
+		for _, service := range services {
+			templateList, err := mod.List(fmt.Sprintf("templates/%s/%s", project, service), logger)
+			if err != nil {
+				logger.Printf("Unable to list templates for %s/%s: %v\n", project, service, err)
+				continue
+			}
+			if templateList == nil || templateList.Data == nil {
+				continue
+			}
+			files, _ := templateList.Data["keys"].([]interface{})
+			for _, file := range files {
+				fileName := strings.TrimSuffix(fmt.Sprintf("%v", file), "/")
+				records = append(records, inventoryCert(mod, project, service, fileName, thresholds, now)...)
+			}
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if (len(records[i].Error) > 0) != (len(records[j].Error) > 0) {
+			return len(records[j].Error) > 0
+		}
+		if !records[i].NotAfter.Equal(records[j].NotAfter) {
+			return records[i].NotAfter.Before(records[j].NotAfter)
+		}
+		return records[i].Address() < records[j].Address()
+	})
+	return records, nil
+}
+
+func containsProject(projects []string, project string) bool {
+	for _, p := range projects {
+		if strings.EqualFold(p, project) {
+			return true
+		}
+	}
+	return false
+}
+
+func inventoryCert(mod *helperkv.Modifier, project string, service string, file string, thresholds CertThresholds, now time.Time) []CertRecord {
+	values, err := mod.ReadData(fmt.Sprintf("values/%s/%s/%s", project, service, file))
+	if err != nil || values == nil {
+		return nil
+	}
+	sourcePath, ok := values["certSourcePath"].(string)
+	if !ok || !IsCertSource(sourcePath) {
+		return nil
+	}
+	record := CertRecord{
+		Env:        mod.Env,
+		Project:    project,
+		Service:    service,
+		File:       file,
+		SourcePath: sourcePath,
+	}
+	certs, err := readCertData(mod, values)
+	if errors.Is(err, validator.ErrNoCertificates) && strings.HasSuffix(strings.ToLower(sourcePath), ".pem") {
+		// Pem files may hold nothing but a key.
+		return nil
+	}
+	if err != nil {
+		record.Status = CERT_STATUS_ERROR
+		record.Error = err.Error()
+		return []CertRecord{record}
+	}
+	records := []CertRecord{}
+	for _, info := range validator.DescribeCertificates(certs) {
+		certRecord := record
+		certRecord.CertificateInfo = info
+		certRecord.DaysRemaining = int(info.NotAfter.Sub(now).Hours() / 24)
+		certRecord.Status = thresholds.Grade(info.NotAfter, now)
+		records = append(records, certRecord)
+	}
+	return records
+}
+
+// readCertData decodes the certData of cert values, following it into
+// super-secrets if it is a link.
+func readCertData(mod *helperkv.Modifier, values map[string]interface{}) ([]*x509.Certificate, error) {
+	data, ok := values["certData"]
+	if !ok {
+		return nil, errors.New("no certData")
+	}
+	if link, ok := data.([]interface{}); ok {
+		if len(link) != 2 {
+			return nil, errors.New("invalid certData link")
+		}
+		bucket := fmt.Sprintf("%v", link[0])
+		secrets, err := mod.ReadData(bucket)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = mod.ReadMapValue(secrets, bucket, fmt.Sprintf("%v", link[1])); err != nil {
+			return nil, err
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(fmt.Sprintf("%v", data))
+	if err != nil {
+		return nil, errors.New("unable to decode certData: " + err.Error())
+	}
+	// As in PopulateTemplate, cert passwords are not yet looked up from
+	// certPasswordVaultPath.
+	return validator.ParseCertificates(decoded, "")
+}
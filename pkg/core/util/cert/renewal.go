@@ -0,0 +1,264 @@
+package cert
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/validator"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// Values of certs renewed through acme set certRenewal to this, with the
+// domains to certify in certHost.
+const CERT_RENEWAL_ACME = "acme"
+
+// RenewalResult is the outcome of renewing a single cert.
+type RenewalResult struct {
+	Env      string    `json:"env" yaml:"env"`
+	Address  string    `json:"address" yaml:"address"`
+	Domains  []string  `json:"domains" yaml:"domains"`
+	NotAfter time.Time `json:"notAfter" yaml:"notAfter"`
+	Renewed  bool      `json:"renewed" yaml:"renewed"`
+	Error    string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// RenewalCandidates returns the leaf record of each cert in records expiring
+// within renewBefore, or of every cert if force is set.
+func RenewalCandidates(records []CertRecord, renewBefore time.Duration, force bool) []CertRecord {
+	leaves := map[string]CertRecord{}
+	addresses := []string{}
+	for _, record := range records {
+		if len(record.Error) > 0 {
+			continue
+		}
+		leaf, ok := leaves[record.Address()]
+		if !ok {
+			addresses = append(addresses, record.Address())
+		}
+		// The leaf is the first certificate that isn't a CA.
+		if !ok || (leaf.IsCA && !record.IsCA) {
+			leaves[record.Address()] = record
+		}
+	}
+	candidates := []CertRecord{}
+	for _, address := range addresses {
+		leaf := leaves[address]
+		if force || time.Until(leaf.NotAfter) < renewBefore {
+			candidates = append(candidates, leaf)
+		}
+	}
+	return candidates
+}
+
+// RenewCerts renews the candidates opted in to acme renewal.  Candidates not
+// opted in are skipped without a result.
+func (ac *AcmeClient) RenewCerts(ctx context.Context, mod *helperkv.Modifier, candidates []CertRecord, dryRun bool) []RenewalResult {
+	results := []RenewalResult{}
+	for _, candidate := range candidates {
+		result, err := ac.RenewCert(ctx, mod, candidate.Project, candidate.Service, candidate.File, dryRun)
+		if result == nil {
+			continue
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, *result)
+	}
+	return results
+}
+
+// RenewCert obtains a new cert for the values at project/service/file and
+// writes it back, along with its key, to the vault paths the values already
+// use.  Each write is a new version checked against the version read, and
+// the values themselves are always rewritten so the kernel's cert cache
+// reloads.  If the cert can't be written the prior key is put back, so the
+// two always match.  The result is nil if the cert is not renewed through
+// acme.
+func (ac *AcmeClient) RenewCert(ctx context.Context, mod *helperkv.Modifier, project string, service string, file string, dryRun bool) (*RenewalResult, error) {
+	valuesPath := fmt.Sprintf("values/%s/%s/%s", project, service, file)
+	values, version, err := mod.ReadDataVersion(valuesPath)
+	if err != nil {
+		return nil, err
+	}
+	if renewal, _ := values["certRenewal"].(string); renewal != CERT_RENEWAL_ACME {
+		return nil, nil
+	}
+	result := &RenewalResult{Env: mod.Env, Address: fmt.Sprintf("%s/%s/%s", project, service, file)}
+	certHost, _ := values["certHost"].(string)
+	for _, domain := range strings.Split(certHost, ",") {
+		if domain = strings.TrimSpace(domain); len(domain) > 0 {
+			result.Domains = append(result.Domains, domain)
+		}
+	}
+	if len(result.Domains) == 0 {
+		return result, errors.New("certHost is required for acme renewal")
+	}
+	sourcePath, _ := values["certSourcePath"].(string)
+	keyFile, keyValues, keyVersion, err := findCertKey(mod, project, service, sourcePath, ac.log)
+	if err != nil {
+		return result, err
+	}
+	if keyFile == "" && strings.ToLower(path.Ext(sourcePath)) != ".pem" {
+		return result, fmt.Errorf("no key template found for %s", sourcePath)
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	chain, key, err := ac.Obtain(ctx, result.Domains)
+	if err != nil {
+		return result, err
+	}
+	certBytes, keyBytes, err := EncodeRenewedCert(sourcePath, chain, key, keyFile == "")
+	if err != nil {
+		return result, err
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return result, err
+	}
+	result.NotAfter = leaf.NotAfter
+
+	// The key is written first so the cert reload finds it in place.
+	keyPath := fmt.Sprintf("values/%s/%s/%s", project, service, keyFile)
+	var priorKey string
+	if keyFile != "" {
+		priorKey, err = encodedCertData(mod, keyValues)
+		if err != nil {
+			return result, fmt.Errorf("unable to read key: %v", err)
+		}
+		if err := writeCertData(mod, keyPath, keyValues, keyVersion, keyBytes, ac.log); err != nil {
+			return result, fmt.Errorf("unable to write key: %v", err)
+		}
+	}
+	if err := writeCertData(mod, valuesPath, values, version, certBytes, ac.log); err != nil {
+		if keyFile != "" {
+			if restoreErr := restoreCertData(mod, keyPath, priorKey, ac.log); restoreErr != nil {
+				return result, fmt.Errorf("unable to write cert: %v, and unable to restore key: %v", err, restoreErr)
+			}
+		}
+		return result, fmt.Errorf("unable to write cert: %v", err)
+	}
+	result.Renewed = true
+	return result, nil
+}
+
+// findCertKey finds the values of the key template matching a cert, such as
+// ENV/service.key for ENV/service.crt.
+func findCertKey(mod *helperkv.Modifier, project string, service string, certSourcePath string, logger *log.Logger) (string, map[string]interface{}, int64, error) {
+	keySourcePath := strings.TrimSuffix(certSourcePath, path.Ext(certSourcePath)) + ".key"
+	templateList, err := mod.List(fmt.Sprintf("templates/%s/%s", project, service), logger)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if templateList == nil || templateList.Data == nil {
+		return "", nil, 0, nil
+	}
+	files, _ := templateList.Data["keys"].([]interface{})
+	for _, file := range files {
+		fileName := strings.TrimSuffix(fmt.Sprintf("%v", file), "/")
+		values, version, err := mod.ReadDataVersion(fmt.Sprintf("values/%s/%s/%s", project, service, fileName))
+		if err != nil {
+			return "", nil, 0, err
+		}
+		if sourcePath, ok := values["certSourcePath"].(string); ok && sourcePath == keySourcePath {
+			return fileName, values, version, nil
+		}
+	}
+	return "", nil, 0, nil
+}
+
+// EncodeRenewedCert encodes a renewed chain and key in the format of the
+// cert's source.  Pem certs without a separate key template hold the key
+// after the chain.
+func EncodeRenewedCert(sourcePath string, chain [][]byte, key crypto.Signer, keyInCert bool) ([]byte, []byte, error) {
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	var certBytes []byte
+	switch strings.ToLower(path.Ext(sourcePath)) {
+	case ".crt", ".pem":
+		for _, der := range chain {
+			certBytes = append(certBytes, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+		}
+		if keyInCert {
+			certBytes = append(certBytes, keyBytes...)
+		}
+	case ".cer":
+		certBytes = chain[0]
+	default:
+		return nil, nil, fmt.Errorf("acme renewal supports .crt, .pem and .cer certs, not %s", sourcePath)
+	}
+	// Make sure the inventory will read back what is written.
+	if _, err := validator.ParseCertificates(certBytes, ""); err != nil {
+		return nil, nil, err
+	}
+	return certBytes, keyBytes, nil
+}
+
+// encodedCertData returns the encoded certData of values, following it into
+// super-secrets if it is a link.
+func encodedCertData(mod *helperkv.Modifier, values map[string]interface{}) (string, error) {
+	if link, ok := values["certData"].([]interface{}); ok {
+		if len(link) != 2 {
+			return "", errors.New("invalid certData link")
+		}
+		secrets, err := mod.ReadData(fmt.Sprintf("%v", link[0]))
+		if err != nil {
+			return "", err
+		}
+		encoded, _ := secrets[fmt.Sprintf("%v", link[1])].(string)
+		return encoded, nil
+	}
+	encoded, _ := values["certData"].(string)
+	return encoded, nil
+}
+
+// restoreCertData puts back the encoded certData of the values at valuesPath.
+func restoreCertData(mod *helperkv.Modifier, valuesPath string, encoded string, logger *log.Logger) error {
+	values, version, err := mod.ReadDataVersion(valuesPath)
+	if err != nil {
+		return err
+	}
+	return writeEncodedCertData(mod, valuesPath, values, version, encoded, logger)
+}
+
+// writeCertData writes data as the certData of values at valuesPath,
+// following it into super-secrets if it is a link.
+func writeCertData(mod *helperkv.Modifier, valuesPath string, values map[string]interface{}, version int64, data []byte, logger *log.Logger) error {
+	return writeEncodedCertData(mod, valuesPath, values, version, base64.StdEncoding.EncodeToString(data), logger)
+}
+
+func writeEncodedCertData(mod *helperkv.Modifier, valuesPath string, values map[string]interface{}, version int64, encoded string, logger *log.Logger) error {
+	if link, ok := values["certData"].([]interface{}); ok {
+		if len(link) != 2 {
+			return errors.New("invalid certData link")
+		}
+		bucket := fmt.Sprintf("%v", link[0])
+		secrets, secretsVersion, err := mod.ReadDataVersion(bucket)
+		if err != nil {
+			return err
+		}
+		secrets[fmt.Sprintf("%v", link[1])] = encoded
+		if _, err := mod.WriteCAS(bucket, secrets, secretsVersion, logger); err != nil {
+			return err
+		}
+	} else {
+		values["certData"] = encoded
+	}
+	// Rewritten even when unchanged, the new version is what the kernel's
+	// cert cache watches.
+	_, err := mod.WriteCAS(valuesPath, values, version, logger)
+	return err
+}
@@ -0,0 +1,81 @@
+package cert
+
+import (
+	"encoding/base64"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv/kvtest"
+)
+
+func TestFindCertKey(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	mod := kvtest.NewModifier(t, vault, "dev")
+	logger := log.New(io.Discard, "", 0)
+	vault.Lists["templates/metadata/Hive/Api"] = []interface{}{"config/", "hivecert/", "hivekey/"}
+	vault.Put("values/data/dev/Hive/Api/config", map[string]interface{}{"port": "1"})
+	vault.Put("values/data/dev/Hive/Api/hivecert", map[string]interface{}{"certSourcePath": "ENV/hive.crt"})
+	vault.Put("values/data/dev/Hive/Api/hivekey", map[string]interface{}{"certSourcePath": "ENV/hive.key"})
+	vault.Put("values/data/dev/Hive/Api/hivekey", map[string]interface{}{"certSourcePath": "ENV/hive.key", "certData": "a2V5"})
+
+	keyFile, keyValues, keyVersion, err := findCertKey(mod, "Hive", "Api", "ENV/hive.crt", logger)
+	if err != nil || keyFile != "hivekey" || keyValues["certData"] != "a2V5" || keyVersion != 2 {
+		t.Fatalf("Unexpected key %s %v %d %v", keyFile, keyValues, keyVersion, err)
+	}
+	if keyFile, _, _, err := findCertKey(mod, "Hive", "Api", "ENV/other.crt", logger); err != nil || keyFile != "" {
+		t.Fatalf("Expected no key for another cert, got %s %v", keyFile, err)
+	}
+	if keyFile, _, _, err := findCertKey(mod, "Hive", "Missing", "ENV/hive.crt", logger); err != nil || keyFile != "" {
+		t.Fatalf("Expected no key without templates, got %s %v", keyFile, err)
+	}
+}
+
+func TestWriteCertData(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	mod := kvtest.NewModifier(t, vault, "dev")
+	logger := log.New(io.Discard, "", 0)
+	encoded := func(data string) string { return base64.StdEncoding.EncodeToString([]byte(data)) }
+
+	// Inline cert data.
+	vault.Put("values/data/dev/Hive/Api/hivecert", map[string]interface{}{"certData": encoded("old")})
+	values, version, _ := mod.ReadDataVersion("values/Hive/Api/hivecert")
+	if err := writeCertData(mod, "values/Hive/Api/hivecert", values, version, []byte("new"), logger); err != nil {
+		t.Fatal(err)
+	}
+	if vault.Data["values/data/dev/Hive/Api/hivecert"]["certData"] != encoded("new") || vault.Versions["values/data/dev/Hive/Api/hivecert"] != 2 {
+		t.Fatalf("Unexpected cert values %v", vault.Data["values/data/dev/Hive/Api/hivecert"])
+	}
+	// A stale version is rejected.
+	if err := writeCertData(mod, "values/Hive/Api/hivecert", values, version, []byte("newer"), logger); err == nil {
+		t.Fatal("Expected the stale write rejected")
+	}
+
+	// Cert data linked into super-secrets, with the values still rewritten.
+	vault.Put("super-secrets/data/dev/Common", map[string]interface{}{"hivekey": encoded("oldkey"), "other": "x"})
+	vault.Put("values/data/dev/Hive/Api/hivekey", map[string]interface{}{"certData": []interface{}{"super-secrets/Common", "hivekey"}})
+	values, version, _ = mod.ReadDataVersion("values/Hive/Api/hivekey")
+	if prior, err := encodedCertData(mod, values); err != nil || prior != encoded("oldkey") {
+		t.Fatalf("Unexpected prior key %s %v", prior, err)
+	}
+	if err := writeCertData(mod, "values/Hive/Api/hivekey", values, version, []byte("newkey"), logger); err != nil {
+		t.Fatal(err)
+	}
+	if secrets := vault.Data["super-secrets/data/dev/Common"]; secrets["hivekey"] != encoded("newkey") || secrets["other"] != "x" || vault.Versions["values/data/dev/Hive/Api/hivekey"] != 2 {
+		t.Fatalf("Unexpected secrets %v", secrets)
+	}
+
+	// Restoring puts back the prior key.
+	if err := restoreCertData(mod, "values/Hive/Api/hivekey", encoded("oldkey"), logger); err != nil {
+		t.Fatal(err)
+	}
+	if vault.Data["super-secrets/data/dev/Common"]["hivekey"] != encoded("oldkey") {
+		t.Fatalf("Expected the key restored, got %v", vault.Data["super-secrets/data/dev/Common"])
+	}
+
+	values, version, _ = mod.ReadDataVersion("values/Hive/Api/hivecert")
+	vault.SetFailPath("values/data/dev/Hive/Api/hivecert")
+	if err := writeCertData(mod, "values/Hive/Api/hivecert", values, version, []byte("newest"), logger); err == nil {
+		t.Fatal("Expected the failed write reported")
+	}
+}
@@ -27,7 +27,7 @@ func BuildDockerImage(driverConfig *config.DriverConfig, dockerfilePath, imageNa
 		return err
 	}
 
-	dockerfileTar, err := createTarContext(cwd)
+	dockerfileTar, err := createTarContext(cwd, dockerfilePath)
 	if err != nil {
 		return err
 	}
@@ -53,28 +53,42 @@ func BuildDockerImage(driverConfig *config.DriverConfig, dockerfilePath, imageNa
 	return nil
 }
 
-func createTarContext(contextDir string) (io.Reader, error) {
+// createTarContext tars contextDir, leaving out what its .dockerignore
+// excludes other than the Dockerfile.
+func createTarContext(contextDir string, dockerfilePath string) (io.Reader, error) {
+	dockerignore, err := ReadDockerignore(contextDir)
+	if err != nil {
+		return nil, err
+	}
 	buf := new(bytes.Buffer)
 	tw := tar.NewWriter(buf)
 	defer tw.Close()
 
-	err := filepath.Walk(contextDir, func(file string, fi os.FileInfo, err error) error {
+	err = filepath.Walk(contextDir, func(file string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
-		// Create a new header from the file info
-		header, err := tar.FileInfoHeader(fi, fi.Name())
+		relPath, err := filepath.Rel(contextDir, file)
 		if err != nil {
 			return err
 		}
+		if relPath != "." && relPath != filepath.Clean(dockerfilePath) && dockerignore.Excluded(relPath) {
+			if fi.IsDir() && !dockerignore.hasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 
-		// Update the header name to the relative path
-		header.Name, err = filepath.Rel(contextDir, file)
+		// Create a new header from the file info
+		header, err := tar.FileInfoHeader(fi, fi.Name())
 		if err != nil {
 			return err
 		}
 
+		// Update the header name to the relative path
+		header.Name = relPath
+
 		// Write the header to the tarball
 		if err := tw.WriteHeader(header); err != nil {
 			return err
@@ -0,0 +1,157 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// dockerfileInstruction is a Dockerfile instruction with its continuation
+// lines joined.
+type dockerfileInstruction struct {
+	Command string // Upper case, such as COPY.
+	Args    string
+	Line    int
+}
+
+// parseDockerfile splits a Dockerfile into instructions.
+func parseDockerfile(content string) ([]dockerfileInstruction, error) {
+	instructions := []dockerfileInstruction{}
+	var current strings.Builder
+	startLine := 0
+	for i, line := range strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			// Comments, including parser directives, may sit within
+			// continuations.
+			continue
+		}
+		if current.Len() == 0 {
+			if len(trimmed) == 0 {
+				continue
+			}
+			startLine = i + 1
+		}
+		if strings.HasSuffix(trimmed, "\\") {
+			current.WriteString(strings.TrimSuffix(trimmed, "\\"))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(trimmed)
+		command, args, _ := strings.Cut(strings.TrimSpace(current.String()), " ")
+		current.Reset()
+		instructions = append(instructions, dockerfileInstruction{
+			Command: strings.ToUpper(command),
+			Args:    strings.TrimSpace(args),
+			Line:    startLine,
+		})
+	}
+	if current.Len() > 0 {
+		return nil, fmt.Errorf("dockerfile line %d: unterminated continuation", startLine)
+	}
+	return instructions, nil
+}
+
+// splitWords splits args as a shell would, honouring quotes and
+// backslashes.
+func splitWords(args string) ([]string, error) {
+	words := []string{}
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range args {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %s", args)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// parseExecForm returns the words of the json form of CMD, ENTRYPOINT and
+// COPY, or of the shell form run with /bin/sh -c when shell is set.
+func parseExecForm(args string, shell bool) ([]string, error) {
+	if strings.HasPrefix(args, "[") {
+		words := []string{}
+		if err := json.Unmarshal([]byte(args), &words); err != nil {
+			return nil, fmt.Errorf("invalid json form %s: %v", args, err)
+		}
+		return words, nil
+	}
+	if shell {
+		return []string{"/bin/sh", "-c", args}, nil
+	}
+	return splitWords(args)
+}
+
+// parseKeyValues parses the key=value pairs of ENV, LABEL and ARG, or the
+// legacy "key value" form of ENV.
+func parseKeyValues(args string, legacy bool) ([][2]string, error) {
+	words, err := splitWords(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("missing key value pairs")
+	}
+	if legacy && !strings.Contains(words[0], "=") {
+		key, value, _ := strings.Cut(args, " ")
+		valueWords, err := splitWords(value)
+		if err != nil {
+			return nil, err
+		}
+		return [][2]string{{key, strings.Join(valueWords, " ")}}, nil
+	}
+	pairs := [][2]string{}
+	for _, word := range words {
+		key, value, found := strings.Cut(word, "=")
+		if len(key) == 0 || (!found && !legacy && len(words) > 1) {
+			return nil, fmt.Errorf("invalid key value pair %s", word)
+		}
+		pairs = append(pairs, [2]string{key, value})
+	}
+	return pairs, nil
+}
+
+// parseCopyFlags separates the --flag=value options of COPY and ADD from
+// their sources and destination.
+func parseCopyFlags(args string) (map[string]string, string) {
+	flags := map[string]string{}
+	for strings.HasPrefix(args, "--") {
+		flag, rest, _ := strings.Cut(args, " ")
+		name, value, _ := strings.Cut(strings.TrimPrefix(flag, "--"), "=")
+		flags[name] = value
+		args = strings.TrimSpace(rest)
+	}
+	return flags, args
+}
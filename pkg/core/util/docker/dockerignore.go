@@ -0,0 +1,125 @@
+package docker
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DOCKERIGNORE is the file listing paths left out of a build context.
+const DOCKERIGNORE = ".dockerignore"
+
+type ignorePattern struct {
+	segments  []string
+	exception bool
+}
+
+// Dockerignore matches build context paths against the patterns of a
+// .dockerignore.  As with docker, the last matching pattern wins, ! marks
+// an exception, ** matches any number of directories and a pattern
+// matching a directory matches everything in it.
+type Dockerignore struct {
+	patterns []ignorePattern
+}
+
+// ReadDockerignore reads the .dockerignore of contextDir.  A context without
+// one ignores nothing.
+func ReadDockerignore(contextDir string) (*Dockerignore, error) {
+	f, err := os.Open(filepath.Join(contextDir, DOCKERIGNORE))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Dockerignore{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewDockerignore(lines)
+}
+
+// NewDockerignore builds a matcher from .dockerignore lines.
+func NewDockerignore(lines []string) (*Dockerignore, error) {
+	dockerignore := &Dockerignore{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			pattern.exception = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = path.Clean(strings.TrimPrefix(filepath.ToSlash(line), "/"))
+		if line == "." {
+			continue
+		}
+		pattern.segments = strings.Split(line, "/")
+		for _, segment := range pattern.segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, err
+			}
+		}
+		dockerignore.patterns = append(dockerignore.patterns, pattern)
+	}
+	return dockerignore, nil
+}
+
+// Excluded returns true if relPath, relative to the context directory, is
+// left out of the build context.
+func (d *Dockerignore) Excluded(relPath string) bool {
+	segments := strings.Split(path.Clean(filepath.ToSlash(relPath)), "/")
+	excluded := false
+	for _, pattern := range d.patterns {
+		// Matching a parent directory matches its contents.
+		for i := 1; i <= len(segments); i++ {
+			if matchSegments(pattern.segments, segments[:i]) {
+				excluded = !pattern.exception
+				break
+			}
+		}
+	}
+	return excluded
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(pattern[0], segments[0]); !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// hasExceptions returns true if a ! pattern may re-include something under
+// an excluded directory.
+func (d *Dockerignore) hasExceptions() bool {
+	for _, pattern := range d.patterns {
+		if pattern.exception {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,653 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+)
+
+// Image builders selectable by trcplgtool -builder.
+const (
+	BUILDER_DOCKER = "docker" // The docker daemon.
+	BUILDER_OCI    = "oci"    // Daemonless, reproducible OCI layer assembly.
+)
+
+// ENV_SOURCE_DATE_EPOCH fixes the timestamps of reproducible builds, see
+// https://reproducible-builds.org/specs/source-date-epoch/.
+const ENV_SOURCE_DATE_EPOCH = "SOURCE_DATE_EPOCH"
+
+// OCIBuildOptions describe a daemonless image build.
+type OCIBuildOptions struct {
+	ContextDir string            // Build context, default the working directory.
+	Dockerfile string            // Relative to ContextDir unless absolute.
+	ImageName  string            // name:tag, the tag names the image in the layout.
+	Platform   string            // os/arch[/variant], default linux on this arch.
+	BuildArgs  map[string]string // ARG values.
+	Created    *time.Time        // Timestamp of everything built, default SOURCE_DATE_EPOCH or the unix epoch.
+}
+
+// OCIImage is a built image.  Building the same context again yields the
+// same bytes, so the same digests.
+type OCIImage struct {
+	ManifestDigest digest.Digest
+	Manifest       []byte
+	Config         []byte
+	Layers         [][]byte // Gzipped layer tars, bottom first.
+	// PluginSha256 is the sha256 of the top layer's file contents, as
+	// computed when the plugin is pulled to deploy, so the value to certify.
+	PluginSha256 string
+}
+
+// layerEntry is a file, directory or symlink of a layer.
+type layerEntry struct {
+	source   string // Empty for directories created implicitly.
+	mode     int64
+	typeflag byte
+	linkname string
+	uid, gid int
+}
+
+// ociBuild is the state of a build as instructions are applied.
+type ociBuild struct {
+	contextDir   string
+	dockerignore *Dockerignore
+	created      time.Time
+	args         map[string]string
+	imageConfig  ocispec.ImageConfig
+	layers       [][]byte
+	diffIds      []digest.Digest
+	pluginSha256 string
+}
+
+func sourceDateEpoch() (time.Time, error) {
+	epoch := os.Getenv(ENV_SOURCE_DATE_EPOCH)
+	if len(epoch) == 0 {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", ENV_SOURCE_DATE_EPOCH, epoch)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+func parsePlatform(platform string) (ocispec.Platform, error) {
+	if len(platform) == 0 {
+		platform = "linux/" + runtime.GOARCH
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return ocispec.Platform{}, fmt.Errorf("invalid platform %s", platform)
+	}
+	ociPlatform := ocispec.Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		ociPlatform.Variant = parts[2]
+	}
+	return ociPlatform, nil
+}
+
+// BuildOCIImage builds an image from a Dockerfile without a docker daemon.
+// Only what can be assembled from the build context is supported: FROM
+// scratch, COPY, ADD of local files, ARG, ENV, LABEL, WORKDIR, USER,
+// EXPOSE, ENTRYPOINT and CMD.  ADD of a local tar archive, which docker
+// would extract, is rejected rather than copied as is.  Each COPY and ADD is a layer, with entries
+// sorted, owned by root unless --chown is given, and timestamped with the
+// build's created time.
+func BuildOCIImage(driverConfig *config.DriverConfig, options *OCIBuildOptions) (*OCIImage, error) {
+	contextDir := options.ContextDir
+	if len(contextDir) == 0 {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+		contextDir = cwd
+	}
+	dockerfilePath := options.Dockerfile
+	if !filepath.IsAbs(dockerfilePath) {
+		dockerfilePath = filepath.Join(contextDir, dockerfilePath)
+	}
+	dockerfile, err := os.ReadFile(dockerfilePath)
+	if err != nil {
+		return nil, err
+	}
+	instructions, err := parseDockerfile(string(dockerfile))
+	if err != nil {
+		return nil, err
+	}
+	dockerignore, err := ReadDockerignore(contextDir)
+	if err != nil {
+		return nil, err
+	}
+	platform, err := parsePlatform(options.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	build := &ociBuild{
+		contextDir:   contextDir,
+		dockerignore: dockerignore,
+		args:         map[string]string{},
+	}
+	if options.Created != nil {
+		build.created = options.Created.UTC().Truncate(time.Second)
+	} else if build.created, err = sourceDateEpoch(); err != nil {
+		return nil, err
+	}
+
+	from := false
+	for _, instruction := range instructions {
+		if instruction.Command == "FROM" {
+			if from {
+				return nil, fmt.Errorf("dockerfile line %d: multi-stage builds are not supported by the %s builder", instruction.Line, BUILDER_OCI)
+			}
+			base, _, _ := strings.Cut(instruction.Args, " ")
+			if strings.ToLower(build.expand(base)) != "scratch" {
+				return nil, fmt.Errorf("dockerfile line %d: the %s builder only builds FROM scratch, use the %s builder for %s", instruction.Line, BUILDER_OCI, BUILDER_DOCKER, base)
+			}
+			from = true
+			continue
+		}
+		if instruction.Command == "ARG" {
+			pairs, err := parseKeyValues(instruction.Args, false)
+			if err != nil {
+				return nil, fmt.Errorf("dockerfile line %d: %v", instruction.Line, err)
+			}
+			for _, pair := range pairs {
+				if value, ok := options.BuildArgs[pair[0]]; ok {
+					build.args[pair[0]] = value
+				} else if _, ok := build.args[pair[0]]; !ok {
+					build.args[pair[0]] = build.expand(pair[1])
+				}
+			}
+			continue
+		}
+		if !from {
+			return nil, fmt.Errorf("dockerfile line %d: %s before FROM", instruction.Line, instruction.Command)
+		}
+		if err := build.apply(instruction); err != nil {
+			return nil, fmt.Errorf("dockerfile line %d: %v", instruction.Line, err)
+		}
+	}
+	if !from {
+		return nil, errors.New("dockerfile has no FROM")
+	}
+	if len(build.layers) == 0 {
+		return nil, errors.New("image has no layers, COPY the plugin into it")
+	}
+
+	image := ocispec.Image{
+		Created:  &build.created,
+		Platform: platform,
+		Config:   build.imageConfig,
+		RootFS:   ocispec.RootFS{Type: "layers", DiffIDs: build.diffIds},
+	}
+	configBytes, err := json.Marshal(image)
+	if err != nil {
+		return nil, err
+	}
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    blobDescriptor(ocispec.MediaTypeImageConfig, configBytes),
+	}
+	manifest.SchemaVersion = 2
+	for _, layer := range build.layers {
+		manifest.Layers = append(manifest.Layers, blobDescriptor(ocispec.MediaTypeImageLayerGzip, layer))
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	ociImage := &OCIImage{
+		ManifestDigest: digest.FromBytes(manifestBytes),
+		Manifest:       manifestBytes,
+		Config:         configBytes,
+		Layers:         build.layers,
+		PluginSha256:   build.pluginSha256,
+	}
+	if driverConfig != nil && driverConfig.CoreConfig != nil && driverConfig.CoreConfig.Log != nil {
+		driverConfig.CoreConfig.Log.Printf("Built %s as %s with %d layers.\n", options.ImageName, ociImage.ManifestDigest, len(ociImage.Layers))
+	}
+	return ociImage, nil
+}
+
+func blobDescriptor(mediaType string, blob []byte) ocispec.Descriptor {
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(blob),
+		Size:      int64(len(blob)),
+	}
+}
+
+// expand substitutes $VAR and ${VAR} with ENV then ARG values.
+func (b *ociBuild) expand(value string) string {
+	return os.Expand(value, func(name string) string {
+		for i := len(b.imageConfig.Env) - 1; i >= 0; i-- {
+			if key, envValue, _ := strings.Cut(b.imageConfig.Env[i], "="); key == name {
+				return envValue
+			}
+		}
+		return b.args[name]
+	})
+}
+
+func (b *ociBuild) setEnv(key string, value string) {
+	for i, env := range b.imageConfig.Env {
+		if envKey, _, _ := strings.Cut(env, "="); envKey == key {
+			b.imageConfig.Env[i] = key + "=" + value
+			return
+		}
+	}
+	b.imageConfig.Env = append(b.imageConfig.Env, key+"="+value)
+}
+
+func (b *ociBuild) apply(instruction dockerfileInstruction) error {
+	switch instruction.Command {
+	case "COPY", "ADD":
+		return b.copy(instruction)
+	case "ENV", "LABEL":
+		pairs, err := parseKeyValues(instruction.Args, instruction.Command == "ENV")
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			if instruction.Command == "ENV" {
+				b.setEnv(pair[0], b.expand(pair[1]))
+				continue
+			}
+			if b.imageConfig.Labels == nil {
+				b.imageConfig.Labels = map[string]string{}
+			}
+			b.imageConfig.Labels[b.expand(pair[0])] = b.expand(pair[1])
+		}
+	case "WORKDIR":
+		b.imageConfig.WorkingDir = b.absolute(b.expand(instruction.Args))
+	case "USER":
+		b.imageConfig.User = b.expand(instruction.Args)
+	case "EXPOSE":
+		if b.imageConfig.ExposedPorts == nil {
+			b.imageConfig.ExposedPorts = map[string]struct{}{}
+		}
+		for _, port := range strings.Fields(b.expand(instruction.Args)) {
+			if !strings.Contains(port, "/") {
+				port = port + "/tcp"
+			}
+			b.imageConfig.ExposedPorts[port] = struct{}{}
+		}
+	case "ENTRYPOINT", "CMD", "STOPSIGNAL":
+		if instruction.Command == "STOPSIGNAL" {
+			b.imageConfig.StopSignal = b.expand(instruction.Args)
+			return nil
+		}
+		words, err := parseExecForm(instruction.Args, true)
+		if err != nil {
+			return err
+		}
+		if instruction.Command == "ENTRYPOINT" {
+			b.imageConfig.Entrypoint = words
+			// As with docker, a new entrypoint resets the command.
+			b.imageConfig.Cmd = nil
+		} else {
+			b.imageConfig.Cmd = words
+		}
+	default:
+		return fmt.Errorf("%s is not supported by the %s builder, use the %s builder", instruction.Command, BUILDER_OCI, BUILDER_DOCKER)
+	}
+	return nil
+}
+
+// absolute resolves target against the working directory.
+func (b *ociBuild) absolute(target string) string {
+	if path.IsAbs(target) {
+		return path.Clean(target)
+	}
+	workingDir := b.imageConfig.WorkingDir
+	if len(workingDir) == 0 {
+		workingDir = "/"
+	}
+	return path.Join(workingDir, target)
+}
+
+// copy adds a layer holding the sources of a COPY or ADD.
+func (b *ociBuild) copy(instruction dockerfileInstruction) error {
+	flags, args := parseCopyFlags(instruction.Args)
+	entryMode := int64(-1)
+	uid, gid := 0, 0
+	for name, value := range flags {
+		switch name {
+		case "chmod":
+			mode, err := strconv.ParseInt(value, 8, 64)
+			if err != nil {
+				return fmt.Errorf("invalid --chmod=%s", value)
+			}
+			entryMode = mode
+		case "chown":
+			// Names need the image's /etc/passwd, so only ids are supported.
+			user, group, found := strings.Cut(value, ":")
+			if !found {
+				group = user
+			}
+			var userErr, groupErr error
+			uid, userErr = strconv.Atoi(user)
+			gid, groupErr = strconv.Atoi(group)
+			if userErr != nil || groupErr != nil {
+				return fmt.Errorf("--chown=%s must be numeric for the %s builder", value, BUILDER_OCI)
+			}
+		case "link":
+		default:
+			return fmt.Errorf("%s --%s is not supported by the %s builder", instruction.Command, name, BUILDER_OCI)
+		}
+	}
+	words, err := parseExecForm(args, false)
+	if err != nil {
+		return err
+	}
+	if len(words) < 2 {
+		return fmt.Errorf("%s requires a source and destination", instruction.Command)
+	}
+	for i := range words {
+		words[i] = b.expand(words[i])
+	}
+	sources, dest := words[:len(words)-1], words[len(words)-1]
+	destDir := strings.HasSuffix(dest, "/") || len(sources) > 1
+	dest = b.absolute(dest)
+
+	entries := map[string]*layerEntry{}
+	matched := 0
+	for _, source := range sources {
+		if instruction.Command == "ADD" && strings.Contains(source, "://") {
+			return fmt.Errorf("ADD of urls is not supported by the %s builder", BUILDER_OCI)
+		}
+		source = path.Clean("/" + filepath.ToSlash(source))[1:]
+		if len(source) == 0 {
+			source = "."
+		}
+		sourceMatches, err := filepath.Glob(filepath.Join(b.contextDir, filepath.FromSlash(source)))
+		if err != nil {
+			return err
+		}
+		sort.Strings(sourceMatches)
+		for _, sourcePath := range sourceMatches {
+			relPath, err := filepath.Rel(b.contextDir, sourcePath)
+			if err != nil {
+				return err
+			}
+			if relPath != "." && b.dockerignore.Excluded(relPath) {
+				continue
+			}
+			info, err := os.Lstat(sourcePath)
+			if err != nil {
+				return err
+			}
+			matched++
+			if info.IsDir() {
+				if err := b.addDir(entries, sourcePath, dest, entryMode, uid, gid); err != nil {
+					return err
+				}
+				continue
+			}
+			if instruction.Command == "ADD" && isArchive(sourcePath) {
+				return fmt.Errorf("ADD of archive %s is not supported by the %s builder, COPY it or extract it into the build context", relPath, BUILDER_OCI)
+			}
+			target := dest
+			if destDir || len(sourceMatches) > 1 {
+				target = path.Join(dest, filepath.Base(sourcePath))
+			}
+			if err := addEntry(entries, sourcePath, info, target, entryMode, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+	if matched == 0 {
+		return fmt.Errorf("no source files were specified or all were excluded by %s: %s", DOCKERIGNORE, strings.Join(sources, " "))
+	}
+	return b.addLayer(entries)
+}
+
+// isArchive returns true if file is a tar archive, or compressed as one
+// might be, which docker extracts when added.
+func isArchive(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+	for _, magic := range [][]byte{
+		{0x1f, 0x8b},                     // gzip
+		[]byte("BZh"),                    // bzip2
+		{0xfd, '7', 'z', 'X', 'Z', 0x00}, // xz
+		{0x28, 0xb5, 0x2f, 0xfd},         // zstd
+	} {
+		if bytes.HasPrefix(header, magic) {
+			return true
+		}
+	}
+	return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+}
+
+func (b *ociBuild) addDir(entries map[string]*layerEntry, sourceDir string, dest string, entryMode int64, uid int, gid int) error {
+	return filepath.Walk(sourceDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(b.contextDir, file)
+		if err != nil {
+			return err
+		}
+		if file != sourceDir && b.dockerignore.Excluded(relPath) {
+			if info.IsDir() && !b.dockerignore.hasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		dirRel, err := filepath.Rel(sourceDir, file)
+		if err != nil {
+			return err
+		}
+		return addEntry(entries, file, info, path.Join(dest, filepath.ToSlash(dirRel)), entryMode, uid, gid)
+	})
+}
+
+// addEntry adds the file at source to entries as target, with its parent
+// directories.  Modes are normalized to 0755 or 0644 so builds don't vary
+// with umask.
+func addEntry(entries map[string]*layerEntry, source string, info os.FileInfo, target string, entryMode int64, uid int, gid int) error {
+	entry := &layerEntry{source: source, uid: uid, gid: gid, mode: 0644}
+	switch {
+	case info.IsDir():
+		entry.typeflag = tar.TypeDir
+		entry.mode = 0755
+	case info.Mode()&os.ModeSymlink != 0:
+		linkname, err := os.Readlink(source)
+		if err != nil {
+			return err
+		}
+		entry.typeflag = tar.TypeSymlink
+		entry.linkname = linkname
+		entry.mode = 0777
+	case info.Mode().IsRegular():
+		entry.typeflag = tar.TypeReg
+		if info.Mode()&0111 != 0 {
+			entry.mode = 0755
+		}
+	default:
+		return fmt.Errorf("unsupported file type %s", source)
+	}
+	if entryMode >= 0 && entry.typeflag != tar.TypeSymlink {
+		entry.mode = entryMode
+	}
+	target = strings.TrimPrefix(path.Clean(target), "/")
+	if len(target) == 0 {
+		// The root itself.
+		return nil
+	}
+	entries[target] = entry
+	for parent := path.Dir(target); parent != "." && parent != "/"; parent = path.Dir(parent) {
+		if _, ok := entries[parent]; !ok {
+			entries[parent] = &layerEntry{typeflag: tar.TypeDir, mode: 0755}
+		}
+	}
+	return nil
+}
+
+// addLayer writes entries, sorted, as a gzipped tar layer.
+func (b *ociBuild) addLayer(entries map[string]*layerEntry) error {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var tarred bytes.Buffer
+	pluginSha := sha256.New()
+	tw := tar.NewWriter(&tarred)
+	for _, name := range names {
+		entry := entries[name]
+		header := &tar.Header{
+			Name:     name,
+			Mode:     entry.mode,
+			Uid:      entry.uid,
+			Gid:      entry.gid,
+			ModTime:  b.created,
+			Typeflag: entry.typeflag,
+			Linkname: entry.linkname,
+		}
+		if entry.typeflag == tar.TypeDir {
+			header.Name = name + "/"
+		}
+		var content []byte
+		if entry.typeflag == tar.TypeReg {
+			var err error
+			if content, err = os.ReadFile(entry.source); err != nil {
+				return err
+			}
+			header.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+		pluginSha.Write(content)
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	var gzipped bytes.Buffer
+	// No name or modification time in the gzip header.
+	gw, err := gzip.NewWriterLevel(&gzipped, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := gw.Write(tarred.Bytes()); err != nil {
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		return err
+	}
+	b.layers = append(b.layers, gzipped.Bytes())
+	b.diffIds = append(b.diffIds, digest.FromBytes(tarred.Bytes()))
+	b.pluginSha256 = hex.EncodeToString(pluginSha.Sum(nil))
+	return nil
+}
+
+// WriteOCILayout writes image as an OCI image layout at output, or as a
+// tarball of one if output ends in .tar.  The image is tagged with the tag
+// of imageName, default latest.
+func WriteOCILayout(image *OCIImage, imageName string, output string) error {
+	tag := "latest"
+	if lastColon := strings.LastIndex(imageName, ":"); lastColon >= 0 && !strings.Contains(imageName[lastColon:], "/") {
+		tag = imageName[lastColon+1:]
+	}
+	manifestDesc := blobDescriptor(ocispec.MediaTypeImageManifest, image.Manifest)
+	manifestDesc.Annotations = map[string]string{ocispec.AnnotationRefName: tag}
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex, Manifests: []ocispec.Descriptor{manifestDesc}}
+	index.SchemaVersion = 2
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	layoutBytes, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{
+		ocispec.ImageLayoutFile: layoutBytes,
+		ocispec.ImageIndexFile:  indexBytes,
+	}
+	for _, blob := range append([][]byte{image.Manifest, image.Config}, image.Layers...) {
+		files[path.Join(ocispec.ImageBlobsDir, "sha256", digest.FromBytes(blob).Encoded())] = blob
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if strings.HasSuffix(output, ".tar") {
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if err := writeLayoutTar(f, names, files); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+	for _, name := range names {
+		file := filepath.Join(output, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, files[name], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeLayoutTar(writer io.Writer, names []string, files map[string][]byte) error {
+	tw := tar.NewWriter(writer)
+	// Blobs sort first, so their directories lead.
+	for _, dir := range []string{ocispec.ImageBlobsDir, path.Join(ocispec.ImageBlobsDir, "sha256")} {
+		if err := tw.WriteHeader(&tar.Header{Name: dir + "/", Mode: 0755, Typeflag: tar.TypeDir, ModTime: time.Unix(0, 0)}); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), Typeflag: tar.TypeReg, ModTime: time.Unix(0, 0)}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
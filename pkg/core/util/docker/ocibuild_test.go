@@ -0,0 +1,140 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeContext(t *testing.T, files map[string]string) string {
+	contextDir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(contextDir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return contextDir
+}
+
+func layerNames(t *testing.T, layer []byte) []string {
+	gr, err := gzip.NewReader(bytes.NewReader(layer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+}
+
+func TestBuildOCIImageReproducible(t *testing.T) {
+	files := map[string]string{
+		"Dockerfile": `FROM scratch
+ARG PLUGIN=trcplugin
+ENV PLUGIN_HOME=/plugins
+COPY config/ $PLUGIN_HOME/config/
+COPY --chmod=0750 bin/${PLUGIN} \
+     $PLUGIN_HOME/
+ENTRYPOINT ["/plugins/trcplugin"]
+`,
+		".dockerignore":         "**/*.log\nconfig/secret*\n!config/secret.example\n",
+		"bin/trcplugin":         "plugin binary",
+		"config/a.yml":          "a: 1",
+		"config/debug.log":      "noise",
+		"config/secret.yml":     "password",
+		"config/secret.example": "password: ",
+	}
+	var digests []string
+	for i := 0; i < 2; i++ {
+		contextDir := writeContext(t, files)
+		// Timestamps of the sources don't matter.
+		os.Chtimes(filepath.Join(contextDir, "bin/trcplugin"), time.Now().Add(time.Duration(i)*time.Hour), time.Now())
+		image, err := BuildOCIImage(nil, &OCIBuildOptions{ContextDir: contextDir, Dockerfile: "Dockerfile", ImageName: "trcplugin:1.0", Platform: "linux/amd64"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, image.ManifestDigest.String())
+		pluginSha := sha256.Sum256([]byte("plugin binary"))
+		if len(image.Layers) != 2 || image.PluginSha256 != hex.EncodeToString(pluginSha[:]) {
+			t.Fatalf("Expected the plugin in the top layer, got %d layers and %s", len(image.Layers), image.PluginSha256)
+		}
+		if names := strings.Join(layerNames(t, image.Layers[0]), ","); names != "plugins/,plugins/config/,plugins/config/a.yml,plugins/config/secret.example" {
+			t.Fatalf("Expected ignored files left out, got %s", names)
+		}
+		if !strings.Contains(string(image.Config), `"Entrypoint":["/plugins/trcplugin"]`) || !strings.Contains(string(image.Config), "PLUGIN_HOME=/plugins") {
+			t.Fatalf("Unexpected config %s", image.Config)
+		}
+	}
+	if digests[0] != digests[1] {
+		t.Fatalf("Expected reproducible builds, got %s and %s", digests[0], digests[1])
+	}
+
+	output := filepath.Join(t.TempDir(), "trcplugin.tar")
+	image, _ := BuildOCIImage(nil, &OCIBuildOptions{ContextDir: writeContext(t, files), Dockerfile: "Dockerfile", ImageName: "trcplugin:1.0"})
+	if err := WriteOCILayout(image, "trcplugin:1.0", output); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteOCILayout(image, "trcplugin:1.0", filepath.Join(t.TempDir(), "trcplugin.oci")); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	tw.WriteHeader(&tar.Header{Name: "trcplugin", Mode: 0755, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+	files["plugin.tar"] = archive.String()
+	files["Dockerfile"] = "FROM scratch\nADD plugin.tar /plugins/\n"
+	if _, err := BuildOCIImage(nil, &OCIBuildOptions{ContextDir: writeContext(t, files), Dockerfile: "Dockerfile"}); err == nil || !strings.Contains(err.Error(), "ADD of archive plugin.tar") {
+		t.Fatalf("Expected ADD of an archive to be rejected, got %v", err)
+	}
+	files["Dockerfile"] = "FROM scratch\nCOPY plugin.tar /plugins/\nADD bin/trcplugin /plugins/\n"
+	if _, err := BuildOCIImage(nil, &OCIBuildOptions{ContextDir: writeContext(t, files), Dockerfile: "Dockerfile"}); err != nil {
+		t.Fatalf("Expected COPY of an archive and ADD of a file, got %v", err)
+	}
+
+	files["Dockerfile"] = "FROM scratch\nRUN make\n"
+	if _, err := BuildOCIImage(nil, &OCIBuildOptions{ContextDir: writeContext(t, files), Dockerfile: "Dockerfile"}); err == nil || !strings.Contains(err.Error(), "RUN is not supported") {
+		t.Fatalf("Expected RUN to be unsupported, got %v", err)
+	}
+}
+
+func TestDockerignore(t *testing.T) {
+	dockerignore, err := NewDockerignore([]string{"# comment", "*.log", "build", "**/tmp", "docs/*", "!docs/README.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for relPath, excluded := range map[string]bool{
+		"a.log":          true,
+		"sub/a.log":      false,
+		"build/plugin":   true,
+		"src/x/tmp/file": true,
+		"docs/guide.md":  true,
+		"docs/README.md": false,
+		"main.go":        false,
+	} {
+		if dockerignore.Excluded(relPath) != excluded {
+			t.Fatalf("Expected %s excluded %v", relPath, excluded)
+		}
+	}
+}
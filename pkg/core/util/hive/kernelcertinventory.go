@@ -0,0 +1,234 @@
+package hive
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trimble-oss/tierceron-core/v2/core"
+	flowcore "github.com/trimble-oss/tierceron/atrium/trcflow/core"
+	"github.com/trimble-oss/tierceron/buildopts/coreopts"
+	certutil "github.com/trimble-oss/tierceron/pkg/core/util/cert"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// Data flow statistic delivered with the results of each cert inventory.
+const CERT_INVENTORY_FLOW_GROUP = "CertInventory"
+
+var (
+	CertInventoryInterval  = 12 * time.Hour
+	DiagnosticCertCritical = 7 * 24 * time.Hour
+)
+
+var certInventory []certutil.CertRecord
+var certInventoryTime time.Time
+var certInventoryLock sync.Mutex
+
+// acmeResponder answers http-01 challenges for the kernel once started.
+var acmeResponder *certutil.HTTP01Responder
+var acmeResponderAddress string
+
+// certInventoryStateCodes map cert statuses to data flow statistic state codes.
+var certInventoryStateCodes = map[string]string{
+	certutil.CERT_STATUS_OK:       "0",
+	certutil.CERT_STATUS_WARN:     "1",
+	certutil.CERT_STATUS_CRITICAL: "2",
+	certutil.CERT_STATUS_EXPIRED:  "3",
+	certutil.CERT_STATUS_ERROR:    "-1",
+}
+
+// CertInventoryMonitor periodically inventories every templated cert of the
+// kernel's environment.  Certs nearing expiration are logged, the results are
+// delivered as a data flow statistic and reported by the certs diagnostic.
+// If the environment has an acme configuration, certs opted in to acme
+// renewal are renewed once they are within its renewDays.
+func (pH *PluginHandler) CertInventoryMonitor(driverConfig *config.DriverConfig) {
+	if pH == nil || pH.Name != "Kernel" {
+		driverConfig.CoreConfig.Log.Println("Unsupported handler attempting to start cert inventory.")
+		return
+	}
+	for {
+		if pH.runCertInventory(driverConfig) {
+			time.Sleep(CertInventoryInterval)
+		} else {
+			time.Sleep(time.Minute)
+		}
+	}
+}
+
+// runCertInventory returns false if the inventory should be retried.
+func (pH *PluginHandler) runCertInventory(driverConfig *config.DriverConfig) bool {
+	if driverConfig.CoreConfig.VaultAddressPtr == nil {
+		// Still bootstrapping.
+		return false
+	}
+	pluginConfig := make(map[string]interface{})
+	pluginConfig["vaddress"] = *driverConfig.CoreConfig.VaultAddressPtr
+	currentTokenName := fmt.Sprintf("config_token_%s", driverConfig.CoreConfig.EnvBasis)
+	pluginConfig["tokenptr"] = driverConfig.CoreConfig.TokenCache.GetToken(currentTokenName)
+	pluginConfig["env"] = driverConfig.CoreConfig.EnvBasis
+
+	_, mod, vault, err := eUtils.InitVaultModForPlugin(pluginConfig,
+		driverConfig.CoreConfig.TokenCache,
+		currentTokenName, driverConfig.CoreConfig.Log)
+	if err != nil {
+		driverConfig.CoreConfig.Log.Printf("Cert inventory unable to initialize mod: %s  Trying again later\n", err)
+		return false
+	}
+	if vault != nil {
+		defer vault.Close()
+	}
+	defer mod.Release()
+
+	thresholds := certutil.CertThresholds{Warn: DiagnosticCertWarn, Critical: DiagnosticCertCritical}
+	records, err := certutil.InventoryCerts(mod, nil, thresholds, driverConfig.CoreConfig.Log)
+	if err != nil {
+		eUtils.LogErrorObject(driverConfig.CoreConfig, err, false)
+		return false
+	}
+	for _, record := range records {
+		switch record.Status {
+		case certutil.CERT_STATUS_OK:
+		case certutil.CERT_STATUS_ERROR:
+			eUtils.LogErrorMessage(driverConfig.CoreConfig, fmt.Sprintf("Unable to read cert %s: %s", record.Address(), record.Error), false)
+		default:
+			eUtils.LogErrorMessage(driverConfig.CoreConfig, fmt.Sprintf("Cert %s (%s) is %s, expires %s", record.Address(), record.Subject, record.Status, record.NotAfter.Format(time.RFC3339)), false)
+		}
+	}
+	certInventoryLock.Lock()
+	certInventory = records
+	certInventoryTime = time.Now()
+	certInventoryLock.Unlock()
+
+	pH.deliverCertInventoryStatistic(driverConfig, records)
+	pH.renewCerts(driverConfig, mod, records)
+	return true
+}
+
+// renewCerts renews the inventoried certs due for acme renewal.  Plugins
+// pick up the renewed certs through the DynamicReloader.
+func (pH *PluginHandler) renewCerts(driverConfig *config.DriverConfig, mod *kv.Modifier, records []certutil.CertRecord) {
+	acmeConfig, err := certutil.ReadAcmeConfig(mod)
+	if err != nil {
+		// Renewal is not configured for this environment.
+		return
+	}
+	candidates := certutil.RenewalCandidates(records, time.Duration(acmeConfig.RenewDays)*24*time.Hour, false)
+	if len(candidates) == 0 {
+		return
+	}
+	if acmeConfig.Challenge == certutil.ACME_CHALLENGE_HTTP01 && acmeResponderAddress != acmeConfig.HTTPAddress {
+		if acmeResponder == nil {
+			acmeResponder = certutil.NewHTTP01Responder()
+		}
+		acmeResponder.Stop()
+		acmeResponder.Start(acmeConfig.HTTPAddress, driverConfig.CoreConfig.Log)
+		acmeResponderAddress = acmeConfig.HTTPAddress
+		driverConfig.CoreConfig.Log.Printf("Answering http-01 challenges on %s\n", acmeConfig.HTTPAddress)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	acmeClient, err := certutil.NewAcmeClient(ctx, acmeConfig, acmeResponder, driverConfig.CoreConfig.Log)
+	if err != nil {
+		eUtils.LogErrorObject(driverConfig.CoreConfig, err, false)
+		return
+	}
+	if err := acmeClient.SaveAccountKey(mod); err != nil {
+		eUtils.LogErrorMessage(driverConfig.CoreConfig, fmt.Sprintf("Unable to save acme account key: %v", err), false)
+	}
+	mod.AuditReason = "kernel acme renewal"
+	for _, result := range acmeClient.RenewCerts(ctx, mod, candidates, false) {
+		if len(result.Error) > 0 {
+			eUtils.LogErrorMessage(driverConfig.CoreConfig, fmt.Sprintf("Unable to renew cert %s: %s", result.Address, result.Error), false)
+		} else {
+			driverConfig.CoreConfig.Log.Printf("Renewed cert %s for %s until %s\n", result.Address, strings.Join(result.Domains, ","), result.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// deliverCertInventoryStatistic records the status of each cert as a data
+// flow statistic of the kernel.
+func (pH *PluginHandler) deliverCertInventoryStatistic(driverConfig *config.DriverConfig, records []certutil.CertRecord) {
+	tenantIndexPath, tenantDFSIdPath := coreopts.BuildOptions.GetDFSPathName()
+	if len(tenantIndexPath) == 0 || len(tenantDFSIdPath) == 0 || len(records) == 0 {
+		return
+	}
+	statPluginConfig := make(map[string]interface{})
+	statPluginConfig["vaddress"] = *driverConfig.CoreConfig.VaultAddressPtr
+	statPluginConfig["env"] = driverConfig.CoreConfig.EnvBasis
+	_, statmod, statvault, err := eUtils.InitVaultModForPlugin(statPluginConfig,
+		driverConfig.CoreConfig.TokenCache,
+		"config_token_pluginany",
+		driverConfig.CoreConfig.Log)
+	if err != nil {
+		driverConfig.CoreConfig.Log.Printf("Problem initializing stat mod: %s\n", err)
+		return
+	}
+	if statvault != nil {
+		defer statvault.Close()
+	}
+	defer statmod.Release()
+
+	logFunc := func(msg string, err error) {
+		if err != nil {
+			eUtils.LogMessageErrorObject(driverConfig.CoreConfig, msg, err, false)
+		} else {
+			eUtils.LogInfo(driverConfig.CoreConfig, msg)
+		}
+	}
+	certStat := core.InitDataFlow(logFunc, CERT_INVENTORY_FLOW_GROUP, false)
+	for _, record := range records {
+		stateName := record.Status
+		if len(record.Error) == 0 {
+			stateName = fmt.Sprintf("%s until %s", record.Status, record.NotAfter.Format(time.RFC3339))
+		}
+		certStat.UpdateDataFlowStatistic(CERT_INVENTORY_FLOW_GROUP,
+			strings.ReplaceAll(record.Address(), "/", "."),
+			stateName,
+			certInventoryStateCodes[record.Status],
+			1,
+			logFunc)
+	}
+	flowcore.DeliverStatistic(nil, nil, statmod, certStat, pH.Id, tenantIndexPath, tenantDFSIdPath, driverConfig.CoreConfig.Log, true)
+}
+
+// certInventoryDiagnostic summarizes the last cert inventory into details,
+// returning the worst status found.
+func certInventoryDiagnostic(details map[string]string) string {
+	certInventoryLock.Lock()
+	defer certInventoryLock.Unlock()
+	if certInventoryTime.IsZero() {
+		details["inventory"] = "not yet run"
+		return DIAGNOSTIC_PASS
+	}
+	status := DIAGNOSTIC_PASS
+	counts := map[string]int{}
+	for _, record := range certInventory {
+		counts[record.Status]++
+		switch record.Status {
+		case certutil.CERT_STATUS_OK:
+			continue
+		case certutil.CERT_STATUS_CRITICAL, certutil.CERT_STATUS_EXPIRED:
+			status = DIAGNOSTIC_FAIL
+		default:
+			if status == DIAGNOSTIC_PASS {
+				status = DIAGNOSTIC_WARN
+			}
+		}
+		key := fmt.Sprintf("inventory:%s/%s", record.Env, record.Address())
+		if len(record.Error) > 0 {
+			details[key] = record.Error
+		} else {
+			details[key+":"+record.Serial] = fmt.Sprintf("%s %s expires %s", record.Status, record.Subject, record.NotAfter.Format(time.RFC3339))
+		}
+	}
+	details["inventory"] = fmt.Sprintf("%d certs at %s: %d ok, %d warn, %d critical, %d expired, %d unreadable",
+		len(certInventory), certInventoryTime.Format(time.RFC3339),
+		counts[certutil.CERT_STATUS_OK], counts[certutil.CERT_STATUS_WARN], counts[certutil.CERT_STATUS_CRITICAL],
+		counts[certutil.CERT_STATUS_EXPIRED], counts[certutil.CERT_STATUS_ERROR])
+	return status
+}
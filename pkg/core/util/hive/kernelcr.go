@@ -1,6 +1,7 @@
 package hive
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -21,11 +22,14 @@ import (
 	"github.com/trimble-oss/tierceron/pkg/capauth"
 	trcvutils "github.com/trimble-oss/tierceron/pkg/core/util"
 	certutil "github.com/trimble-oss/tierceron/pkg/core/util/cert"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
 	"github.com/trimble-oss/tierceron/pkg/utils/config"
 	"github.com/trimble-oss/tierceron/pkg/validator"
 	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
 	"github.com/trimble-oss/tierceron/pkg/vaulthelper/system"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // var PluginMods map[string]*plugin.Plugin = map[string]*plugin.Plugin{}
@@ -49,6 +53,31 @@ type PluginHandler struct {
 	Services      *map[string]*PluginHandler
 	PluginMod     *plugin.Plugin
 	KernelCtx     *KernelCtx
+	stopped       chan struct{} // Closed once the plugin acknowledges a stop.
+	stateLock     sync.Mutex    // Guards State and stopped across the receiver and drain.
+}
+
+// SetState sets the plugin's State: 0 initialized, 1 running, 2 failed.
+func (pluginHandler *PluginHandler) SetState(state int) {
+	pluginHandler.stateLock.Lock()
+	pluginHandler.State = state
+	pluginHandler.stateLock.Unlock()
+}
+
+// GetState returns the plugin's State.
+func (pluginHandler *PluginHandler) GetState() int {
+	pluginHandler.stateLock.Lock()
+	defer pluginHandler.stateLock.Unlock()
+	return pluginHandler.State
+}
+
+// startReceiver creates the stop acknowledgement before the receiver runs
+// so a drain never misses it.
+func (pluginHandler *PluginHandler) startReceiver(driverConfig *config.DriverConfig) {
+	pluginHandler.stateLock.Lock()
+	pluginHandler.stopped = make(chan struct{})
+	pluginHandler.stateLock.Unlock()
+	go pluginHandler.receiver(driverConfig)
 }
 
 type KernelCtx struct {
@@ -83,6 +112,9 @@ func (pH *PluginHandler) DynamicReloader(driverConfig *config.DriverConfig) {
 	var mod *kv.Modifier
 
 	for {
+		if IsKernelDraining() {
+			return
+		}
 		if mod == nil {
 			var err error
 			driverConfig.CoreConfig.Log.Println("")
@@ -135,19 +167,11 @@ func (pH *PluginHandler) DynamicReloader(driverConfig *config.DriverConfig) {
 							valid = true
 						}
 						if valid {
-							for service, servPh := range *pH.Services {
-								*servPh.ConfigContext.CmdSenderChan <- core.KernelCmd{
-									PluginName: servPh.Name,
-									Command:    core.PLUGIN_EVENT_STOP,
-								}
-								driverConfig.CoreConfig.Log.Printf("Shutting down service: %s\n", service)
-							}
 							//TODO: Get rid of os.Exit
 							// 0. Reload certificates
 							// 1. Recall Init function for each plugin
 							// 2. Start each plugin
-							driverConfig.CoreConfig.Log.Println("Shutting down kernel...")
-							os.Exit(0)
+							pH.Shutdown(driverConfig, "certificate reload")
 						} else {
 							continue
 						}
@@ -372,7 +396,7 @@ func (pluginHandler *PluginHandler) RunPlugin(
 		defer statvault.Close()
 	}
 	go pluginHandler.handle_dataflowstat(driverConfig, statmod, statvault)
-	go pluginHandler.receiver(driverConfig)
+	pluginHandler.startReceiver(driverConfig)
 	pluginHandler.Init(serviceConfig)
 	driverConfig.CoreConfig.Log.Printf("Sending start message to plugin service %s\n", service)
 	*pluginHandler.ConfigContext.CmdSenderChan <- core.KernelCmd{
@@ -552,7 +576,7 @@ func (pluginHandler *PluginHandler) PluginserviceStart(driverConfig *config.Driv
 				defer statvault.Close()
 			}
 			go pluginHandler.handle_dataflowstat(driverConfig, statmod, statvault)
-			go pluginHandler.receiver(driverConfig)
+			pluginHandler.startReceiver(driverConfig)
 			pluginHandler.Init(&serviceConfig)
 			driverConfig.CoreConfig.Log.Printf("Sending start message to plugin service %s\n", service)
 			*pluginHandler.ConfigContext.CmdSenderChan <- core.KernelCmd{
@@ -565,18 +589,29 @@ func (pluginHandler *PluginHandler) PluginserviceStart(driverConfig *config.Driv
 }
 
 func (pluginHandler *PluginHandler) receiver(driverConfig *config.DriverConfig) {
+	// Spans the plugin from starting until it acknowledges a stop.
+	_, lifecycleSpan := telemetry.Start(context.Background(), "kernel.plugin", attribute.String("trc.plugin", pluginHandler.Name), attribute.String("trc.plugin.sha256", pluginHandler.Signature))
 	for {
 		event := <-*pluginHandler.ConfigContext.CmdReceiverChan
 		switch {
 		case event.Command == core.PLUGIN_EVENT_START:
-			pluginHandler.State = 1
+			pluginHandler.SetState(1)
+			lifecycleSpan.AddEvent("plugin.started")
 			driverConfig.CoreConfig.Log.Printf("Kernel finished starting plugin: %s\n", pluginHandler.Name)
 		case event.Command == core.PLUGIN_EVENT_STOP:
 			driverConfig.CoreConfig.Log.Printf("Kernel finished stopping plugin: %s\n", pluginHandler.Name)
-			pluginHandler.State = 0
-			*pluginHandler.ConfigContext.ErrorChan <- errors.New(pluginHandler.Name + " shutting down")
+			pluginHandler.SetState(0)
+			lifecycleSpan.AddEvent("plugin.stopped", trace.WithAttributes(attribute.Bool("trc.kernel.draining", IsKernelDraining())))
+			select {
+			case *pluginHandler.ConfigContext.ErrorChan <- errors.New(pluginHandler.Name + " shutting down"):
+			default:
+				// Error handling already ended with an earlier error.
+			}
+			// Returns once statistics sent before stopping are delivered.
 			*pluginHandler.ConfigContext.DfsChan <- nil
 			pluginHandler.PluginMod = nil
+			lifecycleSpan.End()
+			close(pluginHandler.stopped)
 			if pluginHandler.KernelCtx != nil && pluginHandler.KernelCtx.PluginRestartChan != nil {
 				go func(e core.KernelCmd) {
 					*pluginHandler.KernelCtx.PluginRestartChan <- e
@@ -596,6 +631,7 @@ func (pluginHandler *PluginHandler) handle_errors(driverConfig *config.DriverCon
 		result := <-*pluginHandler.ConfigContext.ErrorChan
 		switch {
 		case result != nil:
+			recordDiagnosticError(pluginHandler.Name, result)
 			eUtils.LogErrorObject(driverConfig.CoreConfig, result, false)
 			return
 		}
@@ -621,7 +657,7 @@ func (pluginHandler *PluginHandler) handle_dataflowstat(driverConfig *config.Dri
 			tenantIndexPath, tenantDFSIdPath := coreopts.BuildOptions.GetDFSPathName()
 			if len(tenantIndexPath) == 0 || len(tenantDFSIdPath) == 0 {
 				driverConfig.CoreConfig.Log.Println("GetDFSPathName returned an empty index path value.")
-				return
+				continue
 			}
 			flowcore.DeliverStatistic(nil, nil, mod, dfstat, dfstat.Name, tenantIndexPath, tenantDFSIdPath, driverConfig.CoreConfig.Log, true)
 			driverConfig.CoreConfig.Log.Printf("Delivered dataflow statistic: %s\n", dfstat.Name)
@@ -683,10 +719,12 @@ func (pluginHandler *PluginHandler) LoadPluginMod(driverConfig *config.DriverCon
 
 	var pluginM *plugin.Plugin
 	if !pluginopts.BuildOptions.IsPluginHardwired() {
+		_, loadSpan := telemetry.Start(context.Background(), "kernel.plugin.load", attribute.String("trc.plugin", pluginHandler.Name))
 		pM, err := plugin.Open(pluginPath)
+		telemetry.End(loadSpan, err)
 		if err != nil {
 			driverConfig.CoreConfig.Log.Printf("Unable to open plugin module for service: %s\n", pluginPath)
-			pluginHandler.State = 2
+			pluginHandler.SetState(2)
 			return
 		}
 		pluginM = pM
@@ -696,10 +734,10 @@ func (pluginHandler *PluginHandler) LoadPluginMod(driverConfig *config.DriverCon
 		driverConfig.CoreConfig.Log.Printf("Successfully opened plugin module for %s\n", pluginName)
 		// PluginMods[pluginName] = pluginM
 		pluginHandler.PluginMod = pluginM
-		pluginHandler.State = 0
+		pluginHandler.SetState(0)
 	} else {
 		driverConfig.CoreConfig.Log.Println("Unable to load plugin module because missing plugin name")
-		pluginHandler.State = 2
+		pluginHandler.SetState(2)
 		return
 	}
 }
@@ -712,7 +750,7 @@ func (pluginHandler *PluginHandler) Handle_Chat(driverConfig *config.DriverConfi
 	if pluginHandler.ConfigContext.ChatReceiverChan == nil {
 		msg_receiver := make(chan *core.ChatMsg)
 		pluginHandler.ConfigContext.ChatReceiverChan = &msg_receiver
-		pluginHandler.State = 1
+		pluginHandler.SetState(1)
 	}
 	for {
 		msg := <-*pluginHandler.ConfigContext.ChatReceiverChan
@@ -720,6 +758,10 @@ func (pluginHandler *PluginHandler) Handle_Chat(driverConfig *config.DriverConfi
 			msg.KernelId = &pluginHandler.Id
 		}
 		driverConfig.CoreConfig.Log.Println("Kernel received message from chat.")
+		if IsKernelDraining() {
+			driverConfig.CoreConfig.Log.Println("Kernel draining, dropping chat message.")
+			continue
+		}
 		if eUtils.RefEquals(msg.Name, "SHUTDOWN") {
 			driverConfig.CoreConfig.Log.Println("Shutting down chat receiver.")
 			for _, p := range *pluginHandler.Services {
@@ -736,7 +778,20 @@ func (pluginHandler *PluginHandler) Handle_Chat(driverConfig *config.DriverConfi
 		}
 		for _, q := range *msg.Query {
 			driverConfig.CoreConfig.Log.Println("Kernel processing chat query.")
-			if plugin, ok := (*pluginHandler.Services)[q]; ok && plugin.State == 1 {
+			if q == DRAIN_QUERY {
+				if !pluginHandler.canDrain(msg) {
+					driverConfig.CoreConfig.Log.Println("Kernel drain refused for unauthorized sender.")
+					break
+				}
+				driverConfig.CoreConfig.Log.Printf("Kernel drain requested from chat by %s.\n", *msg.Name)
+				go pluginHandler.Shutdown(driverConfig, "drain requested by "+*msg.Name)
+				break
+			}
+			if IsDiagnosticsQuery(q) {
+				go pluginHandler.handleDiagnosticsQuery(driverConfig, q, msg)
+				continue
+			}
+			if plugin, ok := (*pluginHandler.Services)[q]; ok && plugin.GetState() == 1 {
 				driverConfig.CoreConfig.Log.Printf("Sending query to service: %s.\n", plugin.Name)
 				new_msg := &core.ChatMsg{
 					Name:     &q,
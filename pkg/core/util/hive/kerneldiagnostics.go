@@ -0,0 +1,480 @@
+package hive
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trimble-oss/tierceron-core/v2/core"
+	"github.com/trimble-oss/tierceron/atrium/trcflow/core/flowcorehelper"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	"github.com/trimble-oss/tierceron/pkg/validator"
+)
+
+// Chat queries starting with DIAGNOSTICS_QUERY are answered by the kernel itself
+// rather than routed to a plugin.  "diagnostics" runs every check, while
+// "diagnostics:<check>" runs a single one.  Either may end in
+// ";timeout=<seconds>" to bound each check.
+const DIAGNOSTICS_QUERY = "diagnostics"
+
+const DIAGNOSTICS_TIMEOUT_PARAM = ";timeout="
+
+const (
+	DIAGNOSTIC_VAULT   = "vault"
+	DIAGNOSTIC_CERTS   = "certs"
+	DIAGNOSTIC_PLUGINS = "plugins"
+	DIAGNOSTIC_MEMFS   = "memfs"
+	DIAGNOSTIC_FLOWS   = "flows"
+	DIAGNOSTIC_ERRORS  = "errors"
+)
+
+const (
+	DIAGNOSTIC_PASS = "pass"
+	DIAGNOSTIC_WARN = "warn"
+	DIAGNOSTIC_FAIL = "fail"
+)
+
+var (
+	DiagnosticCheckTimeout = 10 * time.Second
+	DiagnosticTokenTTLWarn = 24 * time.Hour
+	DiagnosticCertWarn     = 30 * 24 * time.Hour
+	DiagnosticMemFsWarn    = int64(256 * 1024 * 1024)
+	DiagnosticErrorWindow  = time.Hour
+)
+
+const maxDiagnosticErrors = 50
+
+// DiagnosticResult is the outcome of a single kernel diagnostic check.
+type DiagnosticResult struct {
+	Name       string            `json:"name"`
+	Status     string            `json:"status"`
+	Message    string            `json:"message"`
+	DurationMs int64             `json:"duration_ms"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+type diagnosticError struct {
+	Plugin string
+	Err    string
+	Time   time.Time
+}
+
+var diagnosticErrors []diagnosticError
+var diagnosticErrorsLock sync.Mutex
+
+// diagnosticCheck runs a check, giving up once ctx is done.
+type diagnosticCheck func(ctx context.Context, pH *PluginHandler, driverConfig *config.DriverConfig) DiagnosticResult
+
+var diagnosticChecks = map[string]diagnosticCheck{
+	DIAGNOSTIC_VAULT:   vaultDiagnostic,
+	DIAGNOSTIC_CERTS:   certsDiagnostic,
+	DIAGNOSTIC_PLUGINS: pluginsDiagnostic,
+	DIAGNOSTIC_MEMFS:   memfsDiagnostic,
+	DIAGNOSTIC_FLOWS:   flowsDiagnostic,
+	DIAGNOSTIC_ERRORS:  errorsDiagnostic,
+}
+
+// Ordered list of checks run when all diagnostics are requested.
+var DiagnosticChecks = []string{
+	DIAGNOSTIC_VAULT,
+	DIAGNOSTIC_CERTS,
+	DIAGNOSTIC_PLUGINS,
+	DIAGNOSTIC_MEMFS,
+	DIAGNOSTIC_FLOWS,
+	DIAGNOSTIC_ERRORS,
+}
+
+// recordDiagnosticError keeps the most recent plugin errors for the errors check.
+func recordDiagnosticError(pluginName string, err error) {
+	if err == nil {
+		return
+	}
+	diagnosticErrorsLock.Lock()
+	defer diagnosticErrorsLock.Unlock()
+	diagnosticErrors = append(diagnosticErrors, diagnosticError{Plugin: pluginName, Err: err.Error(), Time: time.Now()})
+	if len(diagnosticErrors) > maxDiagnosticErrors {
+		diagnosticErrors = diagnosticErrors[len(diagnosticErrors)-maxDiagnosticErrors:]
+	}
+}
+
+// IsDiagnosticsQuery returns true if the query should be handled by the kernel diagnostics.
+func IsDiagnosticsQuery(query string) bool {
+	query, _, _ = strings.Cut(query, DIAGNOSTICS_TIMEOUT_PARAM)
+	return query == DIAGNOSTICS_QUERY || strings.HasPrefix(query, DIAGNOSTICS_QUERY+":")
+}
+
+// DiagnosticChecksForQuery maps a diagnostics query to the checks it requests.
+func DiagnosticChecksForQuery(query string) []string {
+	query, _, _ = strings.Cut(query, DIAGNOSTICS_TIMEOUT_PARAM)
+	if check, ok := strings.CutPrefix(query, DIAGNOSTICS_QUERY+":"); ok && len(check) > 0 {
+		return strings.Split(check, ",")
+	}
+	return DiagnosticChecks
+}
+
+// DiagnosticTimeoutForQuery returns the timeout a diagnostics query asks for
+// each check, or DiagnosticCheckTimeout if it names none.
+func DiagnosticTimeoutForQuery(query string) time.Duration {
+	if _, timeoutSeconds, ok := strings.Cut(query, DIAGNOSTICS_TIMEOUT_PARAM); ok {
+		if seconds, err := strconv.Atoi(timeoutSeconds); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return DiagnosticCheckTimeout
+}
+
+// RunDiagnostics runs the requested checks, each bounded by timeout.  A check
+// still running at its timeout is cancelled.
+func (pH *PluginHandler) RunDiagnostics(driverConfig *config.DriverConfig, checks []string, timeout time.Duration) []DiagnosticResult {
+	if timeout <= 0 {
+		timeout = DiagnosticCheckTimeout
+	}
+	results := []DiagnosticResult{}
+	for _, check := range checks {
+		checkFunc, ok := diagnosticChecks[check]
+		if !ok {
+			results = append(results, DiagnosticResult{
+				Name:    check,
+				Status:  DIAGNOSTIC_FAIL,
+				Message: "unsupported diagnostic",
+			})
+			continue
+		}
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		resultChan := make(chan DiagnosticResult, 1)
+		go func(checkName string) {
+			defer func() {
+				if r := recover(); r != nil {
+					resultChan <- DiagnosticResult{Name: checkName, Status: DIAGNOSTIC_FAIL, Message: fmt.Sprintf("diagnostic panic: %v", r)}
+				}
+			}()
+			resultChan <- checkFunc(ctx, pH, driverConfig)
+		}(check)
+
+		var result DiagnosticResult
+		select {
+		case result = <-resultChan:
+		case <-ctx.Done():
+			result = DiagnosticResult{
+				Name:    check,
+				Status:  DIAGNOSTIC_FAIL,
+				Message: fmt.Sprintf("timed out after %s", timeout),
+			}
+		}
+		cancel()
+		result.Name = check
+		result.DurationMs = time.Since(start).Milliseconds()
+		results = append(results, result)
+	}
+	return results
+}
+
+// handleDiagnosticsQuery runs kernel diagnostics for a chat query and
+// responds to the plugin that asked.
+func (pH *PluginHandler) handleDiagnosticsQuery(driverConfig *config.DriverConfig, query string, msg *core.ChatMsg) {
+	if eUtils.RefLength(msg.Name) == 0 {
+		driverConfig.CoreConfig.Log.Println("Warning, self identification through Name is required for diagnostics. Dropping query...")
+		return
+	}
+	requester, ok := (*pH.Services)[*msg.Name]
+	if !ok || requester.ConfigContext == nil || requester.ConfigContext.ChatSenderChan == nil {
+		driverConfig.CoreConfig.Log.Printf("Unable to respond to diagnostics for %s\n", *msg.Name)
+		return
+	}
+	driverConfig.CoreConfig.Log.Printf("Kernel running diagnostics for %s\n", *msg.Name)
+	results := pH.RunDiagnostics(driverConfig, DiagnosticChecksForQuery(query), DiagnosticTimeoutForQuery(query))
+	resultBytes, err := json.Marshal(results)
+	if err != nil {
+		eUtils.LogErrorObject(driverConfig.CoreConfig, err, false)
+		return
+	}
+	response := string(resultBytes)
+	go func(sender chan *core.ChatMsg, message *core.ChatMsg) {
+		sender <- message
+	}(*requester.ConfigContext.ChatSenderChan, &core.ChatMsg{
+		Name:     msg.Name,
+		KernelId: &pH.Id,
+		ChatId:   msg.ChatId,
+		Query:    &[]string{query},
+		Response: &response,
+	})
+}
+
+func vaultDiagnostic(ctx context.Context, pH *PluginHandler, driverConfig *config.DriverConfig) DiagnosticResult {
+	pluginConfig := make(map[string]interface{})
+	pluginConfig["vaddress"] = *driverConfig.CoreConfig.VaultAddressPtr
+	currentTokenName := fmt.Sprintf("config_token_%s", driverConfig.CoreConfig.EnvBasis)
+	pluginConfig["tokenptr"] = driverConfig.CoreConfig.TokenCache.GetToken(currentTokenName)
+	pluginConfig["env"] = driverConfig.CoreConfig.EnvBasis
+
+	_, mod, vault, err := eUtils.InitVaultModForPlugin(pluginConfig,
+		driverConfig.CoreConfig.TokenCache,
+		currentTokenName, driverConfig.CoreConfig.Log)
+	if err != nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: fmt.Sprintf("unable to connect to vault: %s", err)}
+	}
+	if mod != nil {
+		defer mod.Release()
+	}
+	if vault == nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: "no vault connection available"}
+	}
+	defer vault.Close()
+
+	details := map[string]string{"address": *driverConfig.CoreConfig.VaultAddressPtr}
+	status, err := vault.GetStatus()
+	if err != nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: fmt.Sprintf("vault health check failed: %s", err), Details: details}
+	}
+	for k, v := range status {
+		details[k] = fmt.Sprintf("%v", v)
+	}
+	if ctx.Err() != nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: "cancelled", Details: details}
+	}
+	if sealed, ok := status["sealed"].(bool); ok && sealed {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: "vault is sealed", Details: details}
+	}
+
+	tokenInfo, err := vault.GetTokenSelfInfo()
+	if err != nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: fmt.Sprintf("token lookup failed: %s", err), Details: details}
+	}
+	ttl, err := tokenTTL(tokenInfo["ttl"])
+	if err != nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_WARN, Message: "unable to determine token ttl", Details: details}
+	}
+	details["token_ttl"] = ttl.String()
+	if ttl == 0 {
+		// A ttl of 0 is a non expiring token.
+		return DiagnosticResult{Status: DIAGNOSTIC_PASS, Message: "vault reachable, token does not expire", Details: details}
+	}
+	if ttl < DiagnosticTokenTTLWarn {
+		return DiagnosticResult{Status: DIAGNOSTIC_WARN, Message: fmt.Sprintf("token expires in %s", ttl), Details: details}
+	}
+	return DiagnosticResult{Status: DIAGNOSTIC_PASS, Message: "vault reachable", Details: details}
+}
+
+func tokenTTL(ttl interface{}) (time.Duration, error) {
+	switch t := ttl.(type) {
+	case json.Number:
+		seconds, err := t.Int64()
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	case float64:
+		return time.Duration(t) * time.Second, nil
+	case int:
+		return time.Duration(t) * time.Second, nil
+	case string:
+		seconds, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return 0, errors.New("unsupported ttl type")
+}
+
+func certsDiagnostic(ctx context.Context, pH *PluginHandler, driverConfig *config.DriverConfig) DiagnosticResult {
+	if globalCertCache == nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_WARN, Message: "cert cache not initialized"}
+	}
+	details := map[string]string{}
+	status := certInventoryDiagnostic(details)
+	checked := 0
+	for path, cert := range globalCertCache.Items() {
+		if cert.CertBytes == nil || strings.HasSuffix(path, ".key.mf.tmpl") {
+			continue
+		}
+		notAfter, err := certNotAfter(*cert.CertBytes)
+		if err != nil {
+			details[path] = err.Error()
+			status = DIAGNOSTIC_FAIL
+			continue
+		}
+		checked++
+		remaining := time.Until(notAfter)
+		switch {
+		case remaining <= 0:
+			details[path] = fmt.Sprintf("expired %s", notAfter.Format(time.RFC3339))
+			status = DIAGNOSTIC_FAIL
+		case remaining < DiagnosticCertCritical:
+			details[path] = fmt.Sprintf("expires %s", notAfter.Format(time.RFC3339))
+			status = DIAGNOSTIC_FAIL
+		case remaining < DiagnosticCertWarn:
+			details[path] = fmt.Sprintf("expires %s", notAfter.Format(time.RFC3339))
+			if status == DIAGNOSTIC_PASS {
+				status = DIAGNOSTIC_WARN
+			}
+		default:
+			details[path] = fmt.Sprintf("valid until %s", notAfter.Format(time.RFC3339))
+		}
+	}
+	return DiagnosticResult{Status: status, Message: fmt.Sprintf("checked %d certificates", checked), Details: details}
+}
+
+// certNotAfter returns the earliest expiration of the certificates in certBytes.
+func certNotAfter(certBytes []byte) (time.Time, error) {
+	certs, err := validator.ParseCertificates(certBytes, "")
+	if err != nil {
+		return time.Time{}, err
+	}
+	notAfter := certs[0].NotAfter
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	return notAfter, nil
+}
+
+func pluginsDiagnostic(ctx context.Context, pH *PluginHandler, driverConfig *config.DriverConfig) DiagnosticResult {
+	if pH.Services == nil || len(*pH.Services) == 0 {
+		return DiagnosticResult{Status: DIAGNOSTIC_WARN, Message: "no plugins registered"}
+	}
+	details := map[string]string{}
+	status := DIAGNOSTIC_PASS
+	running := 0
+	for service, servPh := range *pH.Services {
+		switch servPh.GetState() {
+		case 1:
+			details[service] = "running"
+			running++
+		case 2:
+			details[service] = "failed"
+			status = DIAGNOSTIC_FAIL
+		default:
+			details[service] = "initialized"
+			if status == DIAGNOSTIC_PASS {
+				status = DIAGNOSTIC_WARN
+			}
+		}
+	}
+	return DiagnosticResult{Status: status, Message: fmt.Sprintf("%d of %d plugins running", running, len(*pH.Services)), Details: details}
+}
+
+func memfsDiagnostic(ctx context.Context, pH *PluginHandler, driverConfig *config.DriverConfig) DiagnosticResult {
+	if driverConfig.MemFs == nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_PASS, Message: "memfs not in use"}
+	}
+	var files int
+	var size int64
+	dirs := []string{"."}
+	for len(dirs) > 0 {
+		if ctx.Err() != nil {
+			return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: "cancelled"}
+		}
+		var dir string
+		dir, dirs = dirs[len(dirs)-1], dirs[:len(dirs)-1]
+		fileInfos, err := driverConfig.MemFs.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: fmt.Sprintf("unable to read memfs: %s", err)}
+		}
+		for _, fileInfo := range fileInfos {
+			if fileInfo.IsDir() {
+				dirs = append(dirs, fmt.Sprintf("%s/%s", dir, fileInfo.Name()))
+			} else {
+				files++
+				size += fileInfo.Size()
+			}
+		}
+	}
+	details := map[string]string{
+		"files": strconv.Itoa(files),
+		"bytes": strconv.FormatInt(size, 10),
+	}
+	if size > DiagnosticMemFsWarn {
+		return DiagnosticResult{Status: DIAGNOSTIC_WARN, Message: fmt.Sprintf("memfs using %d bytes", size), Details: details}
+	}
+	return DiagnosticResult{Status: DIAGNOSTIC_PASS, Message: fmt.Sprintf("memfs using %d bytes in %d files", size, files), Details: details}
+}
+
+func flowsDiagnostic(ctx context.Context, pH *PluginHandler, driverConfig *config.DriverConfig) DiagnosticResult {
+	pluginConfig := make(map[string]interface{})
+	pluginConfig["vaddress"] = *driverConfig.CoreConfig.VaultAddressPtr
+	currentTokenName := fmt.Sprintf("config_token_%s", driverConfig.CoreConfig.EnvBasis)
+	pluginConfig["tokenptr"] = driverConfig.CoreConfig.TokenCache.GetToken(currentTokenName)
+	pluginConfig["env"] = driverConfig.CoreConfig.EnvBasis
+
+	_, mod, vault, err := eUtils.InitVaultModForPlugin(pluginConfig,
+		driverConfig.CoreConfig.TokenCache,
+		currentTokenName, driverConfig.CoreConfig.Log)
+	if err != nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: fmt.Sprintf("unable to connect to vault: %s", err)}
+	}
+	if vault != nil {
+		defer vault.Close()
+	}
+	if mod == nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: "no vault modifier available"}
+	}
+	defer mod.Release()
+
+	flowIndexPath := fmt.Sprintf("super-secrets/Index/%s/flowName", flowcorehelper.TierceronFlowDB)
+	secret, err := mod.List(flowIndexPath, driverConfig.CoreConfig.Log)
+	if err != nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: fmt.Sprintf("unable to list flows: %s", err)}
+	}
+	if secret == nil || secret.Data == nil {
+		return DiagnosticResult{Status: DIAGNOSTIC_PASS, Message: "no flows registered"}
+	}
+	details := map[string]string{}
+	status := DIAGNOSTIC_PASS
+	if keys, ok := secret.Data["keys"].([]interface{}); ok {
+		for _, key := range keys {
+			if ctx.Err() != nil {
+				return DiagnosticResult{Status: DIAGNOSTIC_FAIL, Message: "cancelled", Details: details}
+			}
+			keyName, ok := key.(string)
+			if !ok {
+				continue
+			}
+			flowName := strings.TrimSuffix(keyName, "/")
+			flowData, err := mod.ReadData(fmt.Sprintf("%s/%s/%s", flowIndexPath, flowName, flowcorehelper.TierceronFlowConfigurationTableName))
+			if err != nil || flowData == nil {
+				details[flowName] = "unreadable"
+				if status == DIAGNOSTIC_PASS {
+					status = DIAGNOSTIC_WARN
+				}
+				continue
+			}
+			syncMode, _ := flowData["syncMode"].(string)
+			details[flowName] = fmt.Sprintf("state: %v syncMode: %s", flowData["state"], syncMode)
+			if strings.HasSuffix(syncMode, "error") {
+				status = DIAGNOSTIC_FAIL
+			}
+		}
+	}
+	return DiagnosticResult{Status: status, Message: fmt.Sprintf("%d flows found", len(details)), Details: details}
+}
+
+func errorsDiagnostic(ctx context.Context, pH *PluginHandler, driverConfig *config.DriverConfig) DiagnosticResult {
+	diagnosticErrorsLock.Lock()
+	defer diagnosticErrorsLock.Unlock()
+	details := map[string]string{}
+	recent := 0
+	for i, diagErr := range diagnosticErrors {
+		if time.Since(diagErr.Time) > DiagnosticErrorWindow {
+			continue
+		}
+		recent++
+		details[fmt.Sprintf("%d:%s", i, diagErr.Plugin)] = fmt.Sprintf("%s %s", diagErr.Time.Format(time.RFC3339), diagErr.Err)
+	}
+	if recent > 0 {
+		return DiagnosticResult{Status: DIAGNOSTIC_WARN, Message: fmt.Sprintf("%d errors in the last %s", recent, DiagnosticErrorWindow), Details: details}
+	}
+	return DiagnosticResult{Status: DIAGNOSTIC_PASS, Message: fmt.Sprintf("no errors in the last %s", DiagnosticErrorWindow)}
+}
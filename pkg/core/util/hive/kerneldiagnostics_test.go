@@ -0,0 +1,61 @@
+package hive
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+)
+
+func TestDiagnosticsQuery(t *testing.T) {
+	for query, expected := range map[string]string{
+		"diagnostics":                       strings.Join(DiagnosticChecks, ","),
+		"diagnostics;timeout=30":            strings.Join(DiagnosticChecks, ","),
+		"diagnostics:vault,certs":           "vault,certs",
+		"diagnostics:vault,certs;timeout=5": "vault,certs",
+	} {
+		if !IsDiagnosticsQuery(query) {
+			t.Fatalf("Expected %s to be a diagnostics query", query)
+		}
+		if checks := strings.Join(DiagnosticChecksForQuery(query), ","); checks != expected {
+			t.Fatalf("Expected %s to run %s, got %s", query, expected, checks)
+		}
+	}
+	if IsDiagnosticsQuery("diagnosticsx") {
+		t.Fatalf("Unexpected diagnostics query")
+	}
+
+	for query, expected := range map[string]time.Duration{
+		"diagnostics":                       DiagnosticCheckTimeout,
+		"diagnostics;timeout=30":            30 * time.Second,
+		"diagnostics:vault,certs;timeout=5": 5 * time.Second,
+		"diagnostics;timeout=-1":            DiagnosticCheckTimeout,
+		"diagnostics;timeout=x":             DiagnosticCheckTimeout,
+	} {
+		if timeout := DiagnosticTimeoutForQuery(query); timeout != expected {
+			t.Fatalf("Expected %s to time out after %s, got %s", query, expected, timeout)
+		}
+	}
+}
+
+func TestRunDiagnosticsTimeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	diagnosticChecks["slow"] = func(ctx context.Context, pH *PluginHandler, driverConfig *config.DriverConfig) DiagnosticResult {
+		<-ctx.Done()
+		close(cancelled)
+		return DiagnosticResult{Status: DIAGNOSTIC_PASS}
+	}
+	defer delete(diagnosticChecks, "slow")
+
+	results := (&PluginHandler{}).RunDiagnostics(nil, []string{"slow"}, 10*time.Millisecond)
+	if len(results) != 1 || results[0].Status != DIAGNOSTIC_FAIL || !strings.HasPrefix(results[0].Message, "timed out") {
+		t.Fatalf("Expected the check to time out, got %v", results)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the timed out check cancelled")
+	}
+}
@@ -0,0 +1,149 @@
+package hive
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/trimble-oss/tierceron-core/v2/core"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+)
+
+// Chat query asking the kernel to drain its plugins and shut down, honored
+// only from plugins allowed by AllowKernelDrain.
+const DRAIN_QUERY = "drain"
+
+// KernelDrainTimeout is how long plugins have to acknowledge a stop while
+// the kernel drains.
+var KernelDrainTimeout = 30 * time.Second
+
+// kernelExit ends the process once the kernel has drained.
+var kernelExit = os.Exit
+
+var kernelDraining atomic.Bool
+var kernelShutdownOnce sync.Once
+var kernelShutdownLock sync.Mutex
+var kernelShutdownHooks []func()
+
+var kernelDrainLock sync.Mutex
+var kernelDrainSenders = map[string]bool{}
+
+// AllowKernelDrain lets the named plugins drain the kernel with DRAIN_QUERY.
+// No plugin may by default, leaving SIGTERM and pidfile removal.
+func AllowKernelDrain(senders ...string) {
+	kernelDrainLock.Lock()
+	defer kernelDrainLock.Unlock()
+	for _, sender := range senders {
+		if sender = strings.TrimSpace(sender); len(sender) > 0 {
+			kernelDrainSenders[sender] = true
+		}
+	}
+}
+
+// canDrain returns true if msg comes from a running plugin allowed to drain
+// the kernel.
+func (pH *PluginHandler) canDrain(msg *core.ChatMsg) bool {
+	if msg.Name == nil {
+		return false
+	}
+	kernelDrainLock.Lock()
+	allowed := kernelDrainSenders[*msg.Name]
+	kernelDrainLock.Unlock()
+	if !allowed {
+		return false
+	}
+	sender, ok := (*pH.Services)[*msg.Name]
+	return ok && sender.GetState() == 1
+}
+
+// OnKernelShutdown registers hook to run once plugins have drained, just
+// before the kernel exits.
+func OnKernelShutdown(hook func()) {
+	kernelShutdownLock.Lock()
+	defer kernelShutdownLock.Unlock()
+	kernelShutdownHooks = append(kernelShutdownHooks, hook)
+}
+
+// IsKernelDraining returns true once the kernel has stopped accepting work.
+func IsKernelDraining() bool {
+	return kernelDraining.Load()
+}
+
+// Drain stops the kernel accepting new work and stops each running plugin,
+// waiting until timeout for each to acknowledge.  Statistics a plugin sent
+// before stopping are delivered before it acknowledges.  The plugins that
+// failed to stop in time are returned.
+func (pH *PluginHandler) Drain(driverConfig *config.DriverConfig, timeout time.Duration) []string {
+	kernelDraining.Store(true)
+	if pH == nil || pH.Services == nil {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = KernelDrainTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pending := map[string]chan struct{}{}
+	for service, servPh := range *pH.Services {
+		servPh.stateLock.Lock()
+		running, stopped := servPh.State == 1, servPh.stopped
+		servPh.stateLock.Unlock()
+		if !running || servPh.ConfigContext == nil || servPh.ConfigContext.CmdSenderChan == nil || stopped == nil {
+			continue
+		}
+		driverConfig.CoreConfig.Log.Printf("Draining plugin: %s\n", service)
+		pending[service] = stopped
+		go func(sender chan core.KernelCmd, pluginName string) {
+			select {
+			case sender <- core.KernelCmd{PluginName: pluginName, Command: core.PLUGIN_EVENT_STOP}:
+			case <-ctx.Done():
+			}
+		}(*servPh.ConfigContext.CmdSenderChan, servPh.Name)
+	}
+
+	failed := []string{}
+	for service, stopped := range pending {
+		select {
+		case <-stopped:
+			driverConfig.CoreConfig.Log.Printf("Plugin drained: %s\n", service)
+		case <-ctx.Done():
+			// The deadline has passed, the rest are checked without waiting.
+			select {
+			case <-stopped:
+			default:
+				failed = append(failed, service)
+			}
+		}
+	}
+	sort.Strings(failed)
+	return failed
+}
+
+// Shutdown drains the kernel and exits.  It is safe to call from any of
+// the shutdown triggers, only the first drains.
+func (pH *PluginHandler) Shutdown(driverConfig *config.DriverConfig, reason string) {
+	kernelShutdownOnce.Do(func() {
+		driverConfig.CoreConfig.Log.Printf("Kernel draining for shutdown: %s\n", reason)
+		failed := pH.Drain(driverConfig, KernelDrainTimeout)
+		if len(failed) > 0 {
+			driverConfig.CoreConfig.Log.Printf("Plugins failed to stop cleanly: %s\n", strings.Join(failed, ","))
+		} else {
+			driverConfig.CoreConfig.Log.Println("All plugins stopped cleanly.")
+		}
+		driverConfig.CoreConfig.Log.Println("Shutting down kernel...")
+		kernelShutdownLock.Lock()
+		hooks := append([]func(){}, kernelShutdownHooks...)
+		kernelShutdownLock.Unlock()
+		for _, hook := range hooks {
+			hook()
+		}
+		kernelExit(0)
+	})
+	// Another trigger is already draining.
+	select {}
+}
@@ -0,0 +1,130 @@
+package hive
+
+import (
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/trimble-oss/tierceron-core/v2/core"
+	tccore "github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+)
+
+func testDriverConfig() *config.DriverConfig {
+	return &config.DriverConfig{CoreConfig: &tccore.CoreConfig{Log: log.New(io.Discard, "", 0)}}
+}
+
+// testPlugin starts a plugin handler whose plugin acknowledges a stop if
+// responsive.
+func testPlugin(driverConfig *config.DriverConfig, name string, responsive bool) *PluginHandler {
+	sender := make(chan core.KernelCmd)
+	receiver := make(chan core.KernelCmd)
+	errorChan := make(chan error, 1)
+	dfsChan := make(chan *core.TTDINode)
+	pluginHandler := &PluginHandler{
+		Name: name,
+		ConfigContext: &core.ConfigContext{
+			CmdSenderChan:   &sender,
+			CmdReceiverChan: &receiver,
+			ErrorChan:       &errorChan,
+			DfsChan:         &dfsChan,
+		},
+	}
+	pluginHandler.startReceiver(driverConfig)
+	go func() {
+		for range dfsChan {
+		}
+	}()
+	go func() {
+		for cmd := range sender {
+			if cmd.Command == core.PLUGIN_EVENT_STOP && responsive {
+				receiver <- cmd
+				return
+			}
+		}
+	}()
+	receiver <- core.KernelCmd{PluginName: name, Command: core.PLUGIN_EVENT_START}
+	for pluginHandler.GetState() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	return pluginHandler
+}
+
+func TestDrain(t *testing.T) {
+	defer kernelDraining.Store(false)
+	driverConfig := testDriverConfig()
+	services := map[string]*PluginHandler{
+		"responsive":  testPlugin(driverConfig, "responsive", true),
+		"stuck":       testPlugin(driverConfig, "stuck", false),
+		"stuckagain":  testPlugin(driverConfig, "stuckagain", false),
+		"initialized": {Name: "initialized"},
+		"unstarted":   {Name: "unstarted", State: 1},
+	}
+	kernel := &PluginHandler{Name: "Kernel", Services: &services}
+
+	start := time.Now()
+	failed := kernel.Drain(driverConfig, 50*time.Millisecond)
+	if !reflect.DeepEqual(failed, []string{"stuck", "stuckagain"}) {
+		t.Fatalf("Expected the stuck plugins to fail, got %v", failed)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the drain to give up at its timeout, took %s", elapsed)
+	}
+	if !IsKernelDraining() {
+		t.Fatal("Expected the kernel draining")
+	}
+	if services["responsive"].GetState() != 0 || services["stuck"].GetState() != 1 {
+		t.Fatal("Unexpected plugin states")
+	}
+}
+
+func TestDrainQuery(t *testing.T) {
+	defer kernelDraining.Store(false)
+	defer func(exit func(int), hooks []func()) {
+		kernelExit = exit
+		kernelShutdownHooks = hooks
+	}(kernelExit, kernelShutdownHooks)
+	defer func(senders map[string]bool) { kernelDrainSenders = senders }(kernelDrainSenders)
+	kernelDrainSenders = map[string]bool{}
+	exited := make(chan int, 1)
+	kernelExit = func(code int) { exited <- code }
+	hooked := false
+	OnKernelShutdown(func() { hooked = true })
+
+	driverConfig := testDriverConfig()
+	services := map[string]*PluginHandler{"responsive": testPlugin(driverConfig, "responsive", true)}
+	kernel := InitKernel("1")
+	kernel.Services = &services
+	chatReceiver := make(chan *core.ChatMsg)
+	kernel.ConfigContext.ChatReceiverChan = &chatReceiver
+	go kernel.Handle_Chat(driverConfig)
+
+	// Only plugins allowed to may drain the kernel.
+	query := []string{DRAIN_QUERY}
+	sender, unknown := "responsive", "unknown"
+	chatReceiver <- &core.ChatMsg{Query: &query}
+	chatReceiver <- &core.ChatMsg{Name: &sender, Query: &query}
+	AllowKernelDrain("unknown")
+	chatReceiver <- &core.ChatMsg{Name: &unknown, Query: &query}
+	// Handled once the next message is received.
+	chatReceiver <- &core.ChatMsg{Query: &[]string{"unknown"}}
+	if IsKernelDraining() {
+		t.Fatal("Expected the drain refused")
+	}
+
+	AllowKernelDrain("responsive")
+	chatReceiver <- &core.ChatMsg{Name: &sender, Query: &query}
+	select {
+	case code := <-exited:
+		if code != 0 || !hooked {
+			t.Fatalf("Expected a clean exit after the shutdown hooks, got %d %v", code, hooked)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the kernel to exit after draining")
+	}
+	if services["responsive"].GetState() != 0 || !IsKernelDraining() {
+		t.Fatal("Expected the plugin drained")
+	}
+}
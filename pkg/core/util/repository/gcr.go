@@ -4,27 +4,22 @@
 package repository
 
 import (
-	"errors"
-
-	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
 )
 
-func getImageSHA(pluginToolConfig map[string]interface{}) error {
-	// TODO: implement
-	return nil
-}
-
 // Return url to the image to be used for download.
 func GetImageDownloadUrl(pluginToolConfig map[string]interface{}) (string, error) {
-	// TODO: implement
 	return "", nil
 }
 
-func GetImageAndShaFromDownload(driverConfig *eUtils.DriverConfig, pluginToolConfig map[string]interface{}) error {
-	return errors.New("Not defined")
+// Defines the keys: "rawImageFile", and "imagesha256" in the map pluginToolConfig.
+// GCR and Artifact Registry implement the OCI distribution api, so use the OCI
+// client with "ociregistry" (e.g. us-docker.pkg.dev) and an access token in "ocitoken".
+func GetImageAndShaFromDownload(driverConfig *config.DriverConfig, pluginToolConfig map[string]interface{}) error {
+	return GetImageAndShaFromOCI(driverConfig, pluginToolConfig)
 }
 
 // Pushes image to docker registry from: "rawImageFile", and "pluginname" in the map pluginToolConfig.
-func PushImage(driverConfig *eUtils.DriverConfig, pluginToolConfig map[string]interface{}) error {
-	return errors.New("Not defined")
+func PushImage(driverConfig *config.DriverConfig, pluginToolConfig map[string]interface{}) error {
+	return PushImageToOCI(driverConfig, pluginToolConfig)
 }
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+)
+
+// IsOCIRegistryConfigured reports whether the plugin tool configuration names
+// an OCI registry.  When it does, the OCI client is used in place of the
+// registry specific implementation selected by build tags.
+func IsOCIRegistryConfigured(pluginToolConfig map[string]interface{}) bool {
+	registry, ok := pluginToolConfig["ociregistry"].(string)
+	return ok && len(registry) > 0
+}
+
+// NewOCIRegistryFromConfig builds a registry client from the keys:
+// "ociregistry", and optionally "ocitoken" or "ociuser" and "ocipassword".
+func NewOCIRegistryFromConfig(pluginToolConfig map[string]interface{}) (*OCIRegistry, error) {
+	if !IsOCIRegistryConfigured(pluginToolConfig) {
+		return nil, errors.New("undefined oci registry")
+	}
+	auth := OCIRegistryAuth{}
+	if token, ok := pluginToolConfig["ocitoken"].(string); ok {
+		auth.Token = token
+	}
+	if user, ok := pluginToolConfig["ociuser"].(string); ok {
+		auth.Username = user
+	}
+	if password, ok := pluginToolConfig["ocipassword"].(string); ok {
+		auth.Password = password
+	}
+	return NewOCIRegistry(pluginToolConfig["ociregistry"].(string), auth, nil), nil
+}
+
+func ociRepositoryName(pluginToolConfig map[string]interface{}) (string, error) {
+	plugin, ok := pluginToolConfig["trcplugin"].(string)
+	if !ok || len(plugin) == 0 {
+		return "", errors.New("missing required plugin configuration trcplugin")
+	}
+	if namespace, ok := pluginToolConfig["ocinamespace"].(string); ok && len(namespace) > 0 {
+		return strings.Trim(namespace, "/") + "/" + plugin, nil
+	}
+	return plugin, nil
+}
+
+// ociReference picks what to pull: a certified digest ("trcocidigest") pins
+// the exact manifest, else "ocireference", else latest.  Certification drops
+// the pinned digest so the new image is resolved by tag.
+func ociReference(pluginToolConfig map[string]interface{}) string {
+	if pinned, ok := pluginToolConfig["trcocidigest"].(string); ok && len(pinned) > 0 {
+		return pinned
+	}
+	if reference, ok := pluginToolConfig["ocireference"].(string); ok && len(reference) > 0 {
+		return reference
+	}
+	return DEFAULT_OCI_REFERENCE
+}
+
+// Defines the keys: "rawImageFile", "imagesha256" and "ocidigest" in the map
+// pluginToolConfig using the configured OCI registry.
+func GetImageAndShaFromOCI(driverConfig *config.DriverConfig, pluginToolConfig map[string]interface{}) error {
+	registry, err := NewOCIRegistryFromConfig(pluginToolConfig)
+	if err != nil {
+		return err
+	}
+	repo, err := ociRepositoryName(pluginToolConfig)
+	if err != nil {
+		return err
+	}
+	platform, _ := pluginToolConfig["ociplatform"].(string)
+	wantSha, _ := pluginToolConfig["trcsha256"].(string)
+	reference := ociReference(pluginToolConfig)
+
+	pluginImage, err := PullPlugin(context.Background(), registry, repo, reference, platform, wantSha)
+	if err != nil {
+		driverConfig.CoreConfig.Log.Printf("Failed to pull %s:%s from oci registry: %v\n", repo, reference, err)
+		return err
+	}
+	pluginToolConfig["rawImageFile"] = pluginImage.Data
+	pluginToolConfig["imagesha256"] = pluginImage.Sha256
+	pluginToolConfig["ocidigest"] = pluginImage.ManifestDigest
+	return nil
+}
+
+// Pushes "rawImageFile" as a plugin artifact tagged with the "pushAliasPtr" tags
+// (or latest) and defines "ocidigest" in the map pluginToolConfig.
+func PushImageToOCI(driverConfig *config.DriverConfig, pluginToolConfig map[string]interface{}) error {
+	registry, err := NewOCIRegistryFromConfig(pluginToolConfig)
+	if err != nil {
+		return err
+	}
+	repo, err := ociRepositoryName(pluginToolConfig)
+	if err != nil {
+		return err
+	}
+	data, ok := pluginToolConfig["rawImageFile"].([]byte)
+	if !ok || len(data) == 0 {
+		return errors.New("missing rawImageFile to push")
+	}
+	manifestDigest, err := PushPlugin(context.Background(), registry, repo, ociPushTags(pluginToolConfig), data)
+	if err != nil {
+		driverConfig.CoreConfig.Log.Printf("Failed to push %s to oci registry: %v\n", repo, err)
+		return err
+	}
+	pluginToolConfig["ocidigest"] = manifestDigest
+	driverConfig.CoreConfig.Log.Printf("Plugin %s pushed to oci registry as %s.\n", repo, manifestDigest)
+	return nil
+}
+
+// ociPushTags returns the tags of the "pushAliasPtr" aliases, or latest.
+func ociPushTags(pluginToolConfig map[string]interface{}) []string {
+	tags := []string{DEFAULT_OCI_REFERENCE}
+	if aliases, ok := pluginToolConfig["pushAliasPtr"].(string); ok && len(aliases) > 0 {
+		tags = []string{}
+		for _, alias := range strings.Split(aliases, ",") {
+			// Aliases are name:tag - only the tag applies to the plugin repository.
+			if _, tag, found := strings.Cut(alias, ":"); found {
+				alias = tag
+			}
+			tags = append(tags, alias)
+		}
+	}
+	return tags
+}
+
+// Pushes the image built at layoutPath by the daemonless builder, tagged with
+// the "pushAliasPtr" tags (or latest), and defines "ocidigest" in the map
+// pluginToolConfig.
+func PushImageLayoutToOCI(driverConfig *config.DriverConfig, pluginToolConfig map[string]interface{}, layoutPath string) error {
+	registry, err := NewOCIRegistryFromConfig(pluginToolConfig)
+	if err != nil {
+		return err
+	}
+	repo, err := ociRepositoryName(pluginToolConfig)
+	if err != nil {
+		return err
+	}
+	manifestDigest, err := PushImageLayout(context.Background(), registry, repo, ociPushTags(pluginToolConfig), layoutPath)
+	if err != nil {
+		driverConfig.CoreConfig.Log.Printf("Failed to push %s to oci registry: %v\n", repo, err)
+		return err
+	}
+	pluginToolConfig["ocidigest"] = manifestDigest
+	driverConfig.CoreConfig.Log.Printf("Image %s pushed to oci registry as %s.\n", repo, manifestDigest)
+	return nil
+}
+
+// GetPluginImageAndSha downloads the plugin image using the OCI registry when
+// configured, falling back to the registry selected at build time.
+func GetPluginImageAndSha(driverConfig *config.DriverConfig, pluginToolConfig map[string]interface{}) error {
+	if IsOCIRegistryConfigured(pluginToolConfig) {
+		return GetImageAndShaFromOCI(driverConfig, pluginToolConfig)
+	}
+	return GetImageAndShaFromDownload(driverConfig, pluginToolConfig)
+}
@@ -0,0 +1,631 @@
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	OCI_PLUGIN_ARTIFACT_TYPE    = "application/vnd.trimble.tierceron.plugin.v1"
+	OCI_PLUGIN_LAYER_MEDIA_TYPE = "application/vnd.trimble.tierceron.plugin.layer.v1"
+
+	DOCKER_MANIFEST_V2_MEDIA_TYPE   = "application/vnd.docker.distribution.manifest.v2+json"
+	DOCKER_MANIFEST_LIST_MEDIA_TYPE = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	DEFAULT_OCI_REFERENCE = "latest"
+)
+
+var manifestAcceptTypes = []string{
+	ocispec.MediaTypeImageManifest,
+	ocispec.MediaTypeImageIndex,
+	DOCKER_MANIFEST_V2_MEDIA_TYPE,
+	DOCKER_MANIFEST_LIST_MEDIA_TYPE,
+}
+
+// RegistryClient is the subset of the OCI distribution api used to certify
+// and deploy plugins.
+type RegistryClient interface {
+	// FetchManifest returns the manifest descriptor and content for a tag or digest.
+	FetchManifest(ctx context.Context, repo string, reference string) (ocispec.Descriptor, []byte, error)
+	// FetchBlob returns verified blob content.
+	FetchBlob(ctx context.Context, repo string, desc ocispec.Descriptor) ([]byte, error)
+	// PushBlob uploads content unless the registry already has it.
+	PushBlob(ctx context.Context, repo string, mediaType string, data []byte) (ocispec.Descriptor, error)
+	// PushManifest uploads a manifest under reference (tag or digest).
+	PushManifest(ctx context.Context, repo string, reference string, mediaType string, manifest []byte) (ocispec.Descriptor, error)
+}
+
+// OCIRegistryAuth holds registry credentials.  Token is used as a static
+// bearer token.  Username and Password are used for basic auth or to obtain
+// bearer tokens from the registry's token service.
+type OCIRegistryAuth struct {
+	Username string
+	Password string
+	Token    string
+}
+
+// OCIRegistry is a RegistryClient for any registry implementing the OCI
+// distribution spec (ACR, ECR, GCR/Artifact Registry, Docker Hub, Harbor...).
+type OCIRegistry struct {
+	BaseUrl string
+	Auth    OCIRegistryAuth
+	Client  *http.Client
+
+	tokenLock sync.Mutex
+	tokens    map[string]string // scope -> bearer token
+}
+
+// NewOCIRegistry creates a registry client.  registry may omit the scheme,
+// in which case https is used.
+func NewOCIRegistry(registry string, auth OCIRegistryAuth, client *http.Client) *OCIRegistry {
+	if !strings.HasPrefix(registry, "https://") && !strings.HasPrefix(registry, "http://") {
+		registry = "https://" + registry
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &OCIRegistry{
+		BaseUrl: strings.TrimSuffix(registry, "/"),
+		Auth:    auth,
+		Client:  client,
+		tokens:  map[string]string{},
+	}
+}
+
+func (r *OCIRegistry) authorize(req *http.Request, scope string) {
+	if len(r.Auth.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+r.Auth.Token)
+		return
+	}
+	r.tokenLock.Lock()
+	token, ok := r.tokens[scope]
+	r.tokenLock.Unlock()
+	if ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if len(r.Auth.Username) > 0 {
+		req.SetBasicAuth(r.Auth.Username, r.Auth.Password)
+	}
+}
+
+// parseChallenge parses a WWW-Authenticate header into its scheme and params.
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	for len(rest) > 0 {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, "\"") {
+			value, rest, _ = strings.Cut(rest[1:], "\"")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if len(key) > 0 {
+			params[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return strings.ToLower(scheme), params
+}
+
+// fetchToken exchanges credentials for a bearer token with the realm named
+// in a Bearer challenge.
+func (r *OCIRegistry) fetchToken(ctx context.Context, params map[string]string, scope string) (string, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", errors.New("bearer challenge missing realm")
+	}
+	tokenUrl, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	query := tokenUrl.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	if challengeScope, ok := params["scope"]; ok {
+		query.Set("scope", challengeScope)
+	} else if len(scope) > 0 {
+		query.Set("scope", scope)
+	}
+	tokenUrl.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenUrl.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if len(r.Auth.Username) > 0 {
+		req.SetBasicAuth(r.Auth.Username, r.Auth.Password)
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	var tokenResponse struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return "", err
+	}
+	if len(tokenResponse.Token) > 0 {
+		return tokenResponse.Token, nil
+	}
+	if len(tokenResponse.AccessToken) > 0 {
+		return tokenResponse.AccessToken, nil
+	}
+	return "", errors.New("token service returned no token")
+}
+
+// do issues a request against the registry, answering one auth challenge.
+func (r *OCIRegistry) do(ctx context.Context, method string, target string, scope string, body []byte, header http.Header) (*http.Response, error) {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = r.BaseUrl + target
+	}
+	newRequest := func() (*http.Request, error) {
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, target, reader)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+	r.authorize(req, scope)
+	resp, err := r.Client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || len(r.Auth.Token) > 0 {
+		return resp, err
+	}
+
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	resp.Body.Close()
+	req, err = newRequest()
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case "bearer":
+		token, err := r.fetchToken(ctx, params, scope)
+		if err != nil {
+			return nil, err
+		}
+		r.tokenLock.Lock()
+		r.tokens[scope] = token
+		r.tokenLock.Unlock()
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		if len(r.Auth.Username) == 0 {
+			return nil, errors.New("registry requires basic auth but no credentials were provided")
+		}
+		req.SetBasicAuth(r.Auth.Username, r.Auth.Password)
+	default:
+		return nil, fmt.Errorf("unsupported registry auth challenge: %s", scheme)
+	}
+	return r.Client.Do(req)
+}
+
+func responseError(resp *http.Response, action string) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s failed: %s %s", action, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+func pullScope(repo string) string {
+	return fmt.Sprintf("repository:%s:pull", repo)
+}
+
+func pushScope(repo string) string {
+	return fmt.Sprintf("repository:%s:pull,push", repo)
+}
+
+func (r *OCIRegistry) FetchManifest(ctx context.Context, repo string, reference string) (ocispec.Descriptor, []byte, error) {
+	header := http.Header{"Accept": []string{strings.Join(manifestAcceptTypes, ", ")}}
+	resp, err := r.do(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), pullScope(repo), nil, header)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ocispec.Descriptor{}, nil, responseError(resp, "manifest fetch")
+	}
+	manifest, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	manifestDigest := digest.FromBytes(manifest)
+	if pinned, err := digest.Parse(reference); err == nil {
+		// Digest pinned reference - content must match exactly.
+		if pinned != manifestDigest {
+			return ocispec.Descriptor{}, nil, fmt.Errorf("manifest digest mismatch: wanted %s got %s", pinned, manifestDigest)
+		}
+	} else if headerDigest := resp.Header.Get("Docker-Content-Digest"); len(headerDigest) > 0 && headerDigest != manifestDigest.String() {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("manifest digest mismatch: registry reported %s got %s", headerDigest, manifestDigest)
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if len(mediaType) == 0 || mediaType == "application/json" {
+		var probe struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(manifest, &probe)
+		mediaType = probe.MediaType
+	}
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    manifestDigest,
+		Size:      int64(len(manifest)),
+	}, manifest, nil
+}
+
+func (r *OCIRegistry) FetchBlob(ctx context.Context, repo string, desc ocispec.Descriptor) ([]byte, error) {
+	resp, err := r.do(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", repo, desc.Digest), pullScope(repo), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, "blob fetch")
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return nil, err
+	}
+	verifier := desc.Digest.Verifier()
+	verifier.Write(data)
+	if !verifier.Verified() {
+		return nil, fmt.Errorf("blob digest mismatch for %s", desc.Digest)
+	}
+	return data, nil
+}
+
+func (r *OCIRegistry) PushBlob(ctx context.Context, repo string, mediaType string, data []byte) (ocispec.Descriptor, error) {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	resp, err := r.do(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/blobs/%s", repo, desc.Digest), pushScope(repo), nil, nil)
+	if err != nil {
+		return desc, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return desc, nil
+	}
+
+	resp, err = r.do(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", repo), pushScope(repo), nil, nil)
+	if err != nil {
+		return desc, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return desc, responseError(resp, "blob upload start")
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return desc, err
+	}
+	query := location.Query()
+	query.Set("digest", desc.Digest.String())
+	location.RawQuery = query.Encode()
+
+	header := http.Header{"Content-Type": []string{"application/octet-stream"}}
+	resp, err = r.do(ctx, http.MethodPut, location.String(), pushScope(repo), data, header)
+	if err != nil {
+		return desc, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return desc, responseError(resp, "blob upload")
+	}
+	return desc, nil
+}
+
+func (r *OCIRegistry) PushManifest(ctx context.Context, repo string, reference string, mediaType string, manifest []byte) (ocispec.Descriptor, error) {
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(manifest),
+		Size:      int64(len(manifest)),
+	}
+	header := http.Header{"Content-Type": []string{mediaType}}
+	resp, err := r.do(ctx, http.MethodPut, fmt.Sprintf("/v2/%s/manifests/%s", repo, reference), pushScope(repo), manifest, header)
+	if err != nil {
+		return desc, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return desc, responseError(resp, "manifest push")
+	}
+	return desc, nil
+}
+
+// PluginImage is a plugin binary resolved from a registry.
+type PluginImage struct {
+	Data           []byte
+	Sha256         string
+	ManifestDigest string
+	Platform       string
+}
+
+// DefaultPlatform is the os/arch used to select from multi-arch indexes.
+func DefaultPlatform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// selectPlatform picks the manifest matching platform (os/arch[/variant])
+// from an image index or docker manifest list.
+func selectPlatform(index ocispec.Index, platform string) (ocispec.Descriptor, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 {
+		return ocispec.Descriptor{}, fmt.Errorf("invalid platform %s", platform)
+	}
+	for _, manifest := range index.Manifests {
+		if manifest.Platform == nil {
+			continue
+		}
+		if manifest.Platform.OS != parts[0] || manifest.Platform.Architecture != parts[1] {
+			continue
+		}
+		if len(parts) > 2 && manifest.Platform.Variant != parts[2] {
+			continue
+		}
+		return manifest, nil
+	}
+	return ocispec.Descriptor{}, fmt.Errorf("no manifest for platform %s", platform)
+}
+
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == ocispec.MediaTypeImageIndex || mediaType == DOCKER_MANIFEST_LIST_MEDIA_TYPE
+}
+
+// PullPlugin resolves reference (tag or digest) to a plugin binary.  Multi-arch
+// indexes are narrowed to platform.  Plugins pushed as OCI artifacts are
+// returned as-is once they match wantSha256; for container images each layer
+// is unpacked from the top down until one matches wantSha256, or the top
+// layer when wantSha256 is empty.
+func PullPlugin(ctx context.Context, client RegistryClient, repo string, reference string, platform string, wantSha256 string) (*PluginImage, error) {
+	if len(platform) == 0 {
+		platform = DefaultPlatform()
+	}
+	desc, manifestBytes, err := client.FetchManifest(ctx, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	pluginImage := &PluginImage{ManifestDigest: desc.Digest.String()}
+
+	if isIndexMediaType(desc.MediaType) {
+		var index ocispec.Index
+		if err := json.Unmarshal(manifestBytes, &index); err != nil {
+			return nil, err
+		}
+		platformDesc, err := selectPlatform(index, platform)
+		if err != nil {
+			return nil, err
+		}
+		_, manifestBytes, err = client.FetchManifest(ctx, repo, platformDesc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		pluginImage.Platform = platform
+	}
+
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, err
+	}
+	if len(manifest.Layers) == 0 {
+		return nil, errors.New("manifest has no layers")
+	}
+
+	if manifest.ArtifactType == OCI_PLUGIN_ARTIFACT_TYPE || manifest.Config.MediaType == OCI_PLUGIN_ARTIFACT_TYPE {
+		for _, layer := range manifest.Layers {
+			if layer.MediaType != OCI_PLUGIN_LAYER_MEDIA_TYPE {
+				continue
+			}
+			data, err := client.FetchBlob(ctx, repo, layer)
+			if err != nil {
+				return nil, err
+			}
+			sha := digest.SHA256.FromBytes(data).Encoded()
+			if len(wantSha256) > 0 && sha != wantSha256 {
+				return nil, fmt.Errorf("plugin sha %s does not match certified sha %s", sha, wantSha256)
+			}
+			pluginImage.Data = data
+			pluginImage.Sha256 = sha
+			return pluginImage, nil
+		}
+		return nil, errors.New("plugin artifact has no plugin layer")
+	}
+
+	for i := len(manifest.Layers) - 1; i >= 0; i-- {
+		layerData, err := client.FetchBlob(ctx, repo, manifest.Layers[i])
+		if err != nil {
+			return nil, err
+		}
+		tarredData, err := gUnZipData(&layerData)
+		if err != nil {
+			return nil, errors.New("gunzip failed")
+		}
+		data, err := untarData(&tarredData)
+		if err != nil {
+			return nil, errors.New("untarring failed")
+		}
+		sha := digest.SHA256.FromBytes(data).Encoded()
+		if len(wantSha256) == 0 || sha == wantSha256 {
+			pluginImage.Data = data
+			pluginImage.Sha256 = sha
+			return pluginImage, nil
+		}
+	}
+	return nil, fmt.Errorf("no layer matching sha %s", wantSha256)
+}
+
+// PushPlugin pushes a plugin binary as an OCI artifact and tags it with each
+// of tags.  The returned digest may be used to pin deployments.
+func PushPlugin(ctx context.Context, client RegistryClient, repo string, tags []string, data []byte) (string, error) {
+	configDesc, err := client.PushBlob(ctx, repo, ocispec.MediaTypeEmptyJSON, ocispec.DescriptorEmptyJSON.Data)
+	if err != nil {
+		return "", err
+	}
+	layerDesc, err := client.PushBlob(ctx, repo, OCI_PLUGIN_LAYER_MEDIA_TYPE, data)
+	if err != nil {
+		return "", err
+	}
+	manifest := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: OCI_PLUGIN_ARTIFACT_TYPE,
+		Config:       configDesc,
+		Layers:       []ocispec.Descriptor{layerDesc},
+	}
+	manifest.SchemaVersion = 2
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	manifestDigest := digest.FromBytes(manifestBytes)
+	if len(tags) == 0 {
+		tags = []string{manifestDigest.String()}
+	}
+	for _, tag := range tags {
+		if _, err := client.PushManifest(ctx, repo, tag, ocispec.MediaTypeImageManifest, manifestBytes); err != nil {
+			return "", err
+		}
+	}
+	return manifestDigest.String(), nil
+}
+
+// readImageLayout returns a reader of the files of an OCI image layout, a
+// directory or a tarball of one.
+func readImageLayout(layoutPath string) (func(name string) ([]byte, error), error) {
+	info, err := os.Stat(layoutPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return func(name string) ([]byte, error) {
+			return os.ReadFile(filepath.Join(layoutPath, filepath.FromSlash(name)))
+		}, nil
+	}
+	f, err := os.Open(layoutPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files := map[string][]byte{}
+	tarReader := tar.NewReader(f)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return nil, err
+		}
+		files[path.Clean(strings.TrimPrefix(header.Name, "./"))] = data
+	}
+	return func(name string) ([]byte, error) {
+		data, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("%s not in image layout %s", name, layoutPath)
+		}
+		return data, nil
+	}, nil
+}
+
+// PushImageLayout pushes the image of an OCI image layout, as built by
+// trcplgtool -buildImage -builder=oci, and tags it with each of tags.  The
+// manifest is pushed unchanged, so the returned digest is the one built.
+func PushImageLayout(ctx context.Context, client RegistryClient, repo string, tags []string, layoutPath string) (string, error) {
+	readLayout, err := readImageLayout(layoutPath)
+	if err != nil {
+		return "", err
+	}
+	indexBytes, err := readLayout(ocispec.ImageIndexFile)
+	if err != nil {
+		return "", err
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return "", err
+	}
+	if len(index.Manifests) != 1 {
+		return "", fmt.Errorf("image layout %s must hold one image, found %d", layoutPath, len(index.Manifests))
+	}
+	readBlob := func(desc ocispec.Descriptor) ([]byte, error) {
+		if err := desc.Digest.Validate(); err != nil {
+			return nil, err
+		}
+		data, err := readLayout(path.Join(ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+		if err != nil {
+			return nil, err
+		}
+		if digest.FromBytes(data) != desc.Digest {
+			return nil, fmt.Errorf("blob digest mismatch for %s", desc.Digest)
+		}
+		return data, nil
+	}
+
+	manifestDesc := index.Manifests[0]
+	manifestBytes, err := readBlob(manifestDesc)
+	if err != nil {
+		return "", err
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return "", err
+	}
+	for _, desc := range append([]ocispec.Descriptor{manifest.Config}, manifest.Layers...) {
+		data, err := readBlob(desc)
+		if err != nil {
+			return "", err
+		}
+		if _, err := client.PushBlob(ctx, repo, desc.MediaType, data); err != nil {
+			return "", err
+		}
+	}
+	if len(tags) == 0 {
+		tags = []string{manifestDesc.Digest.String()}
+	}
+	for _, tag := range tags {
+		if _, err := client.PushManifest(ctx, repo, tag, manifestDesc.MediaType, manifestBytes); err != nil {
+			return "", err
+		}
+	}
+	return manifestDesc.Digest.String(), nil
+}
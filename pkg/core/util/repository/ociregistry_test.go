@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/trimble-oss/tierceron/pkg/core/util/docker"
+)
+
+// testRegistry is a minimal in-process OCI distribution registry with
+// token auth.
+type testRegistry struct {
+	lock      sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte // repo:reference -> content
+	types     map[string]string
+	uploads   int
+	user      string
+	password  string
+	token     string
+	server    *httptest.Server
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		blobs:     map[string][]byte{},
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+		user:      "plugin",
+		password:  "secret",
+		token:     "issued-token",
+	}
+	r.server = httptest.NewTLSServer(r)
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if user, password, ok := req.BasicAuth(); !ok || user != r.user || password != r.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+r.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		if req.Method == http.MethodPost {
+			r.uploads++
+			w.Header().Set("Location", fmt.Sprintf("/v2/%supload-%d", path, r.uploads))
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		data, _ := io.ReadAll(req.Body)
+		d := req.URL.Query().Get("digest")
+		if digest.FromBytes(data).String() != d {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.blobs[d] = data
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(path, "/blobs/"):
+		d := path[strings.LastIndex(path, "/")+1:]
+		data, ok := r.blobs[d]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if req.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case strings.Contains(path, "/manifests/"):
+		parts := strings.SplitN(path, "/manifests/", 2)
+		key := parts[0] + ":" + parts[1]
+		if req.Method == http.MethodPut {
+			data, _ := io.ReadAll(req.Body)
+			d := digest.FromBytes(data).String()
+			for _, k := range []string{key, parts[0] + ":" + d} {
+				r.manifests[k] = data
+				r.types[k] = req.Header.Get("Content-Type")
+			}
+			w.Header().Set("Docker-Content-Digest", d)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		data, ok := r.manifests[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[key])
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (r *testRegistry) client() *OCIRegistry {
+	return NewOCIRegistry(r.server.URL, OCIRegistryAuth{Username: r.user, Password: r.password}, r.server.Client())
+}
+
+func tarGzLayer(t *testing.T, content []byte) []byte {
+	var tarred bytes.Buffer
+	tw := tar.NewWriter(&tarred)
+	tw.WriteHeader(&tar.Header{Name: "plugin.so", Mode: 0700, Size: int64(len(content))})
+	tw.Write(content)
+	tw.Close()
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	zw.Write(tarred.Bytes())
+	zw.Close()
+	return zipped.Bytes()
+}
+
+func TestOCIPluginArtifactRoundTrip(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := registry.client()
+	ctx := context.Background()
+	plugin := []byte("plugin binary")
+
+	manifestDigest, err := PushPlugin(ctx, client, "team/trcplugin", []string{"latest", "v1"}, plugin)
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+
+	wantSha := fmt.Sprintf("%x", sha256.Sum256(plugin))
+	for _, reference := range []string{"v1", manifestDigest} {
+		pluginImage, err := PullPlugin(ctx, client, "team/trcplugin", reference, "", "")
+		if err != nil {
+			t.Fatalf("pull %s failed: %v", reference, err)
+		}
+		if !bytes.Equal(pluginImage.Data, plugin) || pluginImage.Sha256 != wantSha {
+			t.Fatalf("pull %s returned wrong plugin", reference)
+		}
+		if pluginImage.ManifestDigest != manifestDigest {
+			t.Fatalf("expected digest %s got %s", manifestDigest, pluginImage.ManifestDigest)
+		}
+	}
+
+	if _, err := PullPlugin(ctx, client, "team/trcplugin", "v1", "", wantSha); err != nil {
+		t.Fatalf("pull of certified sha failed: %v", err)
+	}
+	if _, err := PullPlugin(ctx, client, "team/trcplugin", "v1", "", fmt.Sprintf("%x", sha256.Sum256([]byte("other")))); err == nil {
+		t.Fatal("expected the certified sha verified")
+	}
+
+	// A pinned digest must not resolve to different content.
+	otherDigest := digest.FromString("other").String()
+	registry.manifests["team/trcplugin:"+otherDigest] = registry.manifests["team/trcplugin:v1"]
+	if _, err := PullPlugin(ctx, client, "team/trcplugin", otherDigest, "", ""); err == nil {
+		t.Fatal("expected digest mismatch for pinned reference")
+	}
+}
+
+func TestOCIMultiArchImage(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := registry.client()
+	ctx := context.Background()
+
+	platforms := map[string][]byte{
+		"linux/amd64": []byte("amd64 plugin"),
+		"linux/arm64": []byte("arm64 plugin"),
+	}
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex}
+	index.SchemaVersion = 2
+	for platform, content := range platforms {
+		configDesc, err := client.PushBlob(ctx, "trcplugin", ocispec.MediaTypeImageConfig, []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		layerDesc, err := client.PushBlob(ctx, "trcplugin", ocispec.MediaTypeImageLayerGzip, tarGzLayer(t, content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest := ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: configDesc, Layers: []ocispec.Descriptor{layerDesc}}
+		manifest.SchemaVersion = 2
+		manifestBytes, _ := json.Marshal(manifest)
+		desc, err := client.PushManifest(ctx, "trcplugin", digest.FromBytes(manifestBytes).String(), ocispec.MediaTypeImageManifest, manifestBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		osArch := strings.Split(platform, "/")
+		desc.Platform = &ocispec.Platform{OS: osArch[0], Architecture: osArch[1]}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	indexBytes, _ := json.Marshal(index)
+	if _, err := client.PushManifest(ctx, "trcplugin", "latest", ocispec.MediaTypeImageIndex, indexBytes); err != nil {
+		t.Fatal(err)
+	}
+
+	for platform, content := range platforms {
+		pluginImage, err := PullPlugin(ctx, client, "trcplugin", "latest", platform, "")
+		if err != nil {
+			t.Fatalf("pull %s failed: %v", platform, err)
+		}
+		if !bytes.Equal(pluginImage.Data, content) {
+			t.Fatalf("pull %s returned %s", platform, pluginImage.Data)
+		}
+	}
+	if _, err := PullPlugin(ctx, client, "trcplugin", "latest", "windows/amd64", ""); err == nil {
+		t.Fatal("expected missing platform error")
+	}
+}
+
+func TestOCIRegistryRejectsBadCredentials(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := NewOCIRegistry(registry.server.URL, OCIRegistryAuth{Username: "plugin", Password: "wrong"}, registry.server.Client())
+	if _, _, err := client.FetchManifest(context.Background(), "trcplugin", "latest"); err == nil {
+		t.Fatal("expected auth failure")
+	}
+}
+
+func TestPushImageLayout(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := registry.client()
+	ctx := context.Background()
+	plugin := []byte("plugin binary")
+
+	contextDir := t.TempDir()
+	os.WriteFile(filepath.Join(contextDir, "Dockerfile"), []byte("FROM scratch\nCOPY trcplugin /plugins/\n"), 0644)
+	os.WriteFile(filepath.Join(contextDir, "trcplugin"), plugin, 0755)
+	image, err := docker.BuildOCIImage(nil, &docker.OCIBuildOptions{ContextDir: contextDir, Dockerfile: "Dockerfile", ImageName: "trcplugin:v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layoutPath := range []string{filepath.Join(t.TempDir(), "trcplugin.oci"), filepath.Join(t.TempDir(), "trcplugin.tar")} {
+		if err := docker.WriteOCILayout(image, "trcplugin:v1", layoutPath); err != nil {
+			t.Fatal(err)
+		}
+		manifestDigest, err := PushImageLayout(ctx, client, "team/trcplugin", []string{"v1"}, layoutPath)
+		if err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+		// The digest and sha certified at build are the ones verified on deploy.
+		pluginImage, err := PullPlugin(ctx, client, "team/trcplugin", manifestDigest, "", image.PluginSha256)
+		if err != nil {
+			t.Fatalf("pull failed: %v", err)
+		}
+		if manifestDigest != image.ManifestDigest.String() || pluginImage.ManifestDigest != manifestDigest || pluginImage.Sha256 != image.PluginSha256 || !bytes.Equal(pluginImage.Data, plugin) {
+			t.Fatalf("Expected the built image, got %s %s", pluginImage.ManifestDigest, pluginImage.Sha256)
+		}
+	}
+}
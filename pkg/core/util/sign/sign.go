@@ -0,0 +1,173 @@
+package sign
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const PLUGIN_SIGNATURE_TYPE = "tierceron plugin signature"
+
+// PluginSignaturePayload is the cosign style "simple signing" document that
+// release engineering signs.  It binds the certified sha256 to the plugin's
+// name and type so a signature for one plugin can't certify another.
+type PluginSignaturePayload struct {
+	Critical struct {
+		Identity struct {
+			Plugin string `json:"plugin"`
+		} `json:"identity"`
+		Image struct {
+			Digest string `json:"digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional struct {
+		PluginType string `json:"pluginType,omitempty"`
+	} `json:"optional"`
+}
+
+// NewPluginSignaturePayload returns the canonical bytes to sign for a plugin.
+func NewPluginSignaturePayload(pluginName string, pluginType string, sha256Hex string) ([]byte, error) {
+	if len(pluginName) == 0 || len(sha256Hex) == 0 {
+		return nil, errors.New("plugin name and sha256 required for signature")
+	}
+	payload := PluginSignaturePayload{}
+	payload.Critical.Identity.Plugin = pluginName
+	payload.Critical.Image.Digest = "sha256:" + strings.ToLower(sha256Hex)
+	payload.Critical.Type = PLUGIN_SIGNATURE_TYPE
+	payload.Optional.PluginType = pluginType
+	return json.Marshal(payload)
+}
+
+// Sign creates a base64 detached signature over payload with a PEM encoded
+// PKCS8, EC or PKCS1 private key.
+func Sign(privateKeyPEM []byte, payload []byte) (string, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return "", errors.New("invalid signing key")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256(payload)
+	var signature []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		signature, err = ecdsa.SignASN1(rand.Reader, k, digest[:])
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, payload)
+	default:
+		return "", fmt.Errorf("unsupported signing key type %T", key)
+	}
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// ParsePublicKeys parses a bundle of PEM encoded PKIX public keys.
+func ParsePublicKeys(publicKeysPEM []byte) ([]crypto.PublicKey, error) {
+	keys := []crypto.PublicKey{}
+	for {
+		var block *pem.Block
+		block, publicKeysPEM = pem.Decode(publicKeysPEM)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func verifyWithKey(key crypto.PublicKey, payload []byte, signature []byte) bool {
+	digest := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, signature)
+	}
+	return false
+}
+
+// Verify checks a base64 detached signature over payload against any key in
+// the PEM public key bundle.
+func Verify(publicKeysPEM []byte, payload []byte, signatureB64 string) error {
+	keys, err := ParsePublicKeys(publicKeysPEM)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no plugin signing keys configured")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signatureB64))
+	if err != nil || len(signature) == 0 {
+		return errors.New("malformed plugin signature")
+	}
+	for _, key := range keys {
+		if verifyWithKey(key, payload, signature) {
+			return nil
+		}
+	}
+	return errors.New("plugin signature not valid for any signing key")
+}
+
+// SigningRequired reports whether a signing key set is configured in the
+// plugin tool configuration ("trcsigningkeys", the PEM bundle of release
+// engineering public keys rendered as signing-keys by the PluginTool
+// templates).  Once keys are configured, every certification must carry a
+// valid signature.
+func SigningRequired(pluginToolConfig map[string]interface{}) bool {
+	keys, ok := pluginToolConfig["trcsigningkeys"].(string)
+	return ok && strings.Contains(keys, "PUBLIC KEY")
+}
+
+// VerifyPluginCertification verifies "trcsignature" over "trcplugin",
+// "trctype" and "trcsha256" in pluginToolConfig using "trcsigningkeys".
+func VerifyPluginCertification(pluginToolConfig map[string]interface{}) error {
+	signature, ok := pluginToolConfig["trcsignature"].(string)
+	if !ok || len(signature) == 0 {
+		return errors.New("plugin certification is not signed")
+	}
+	pluginName, _ := pluginToolConfig["trcplugin"].(string)
+	pluginType, _ := pluginToolConfig["trctype"].(string)
+	sha, _ := pluginToolConfig["trcsha256"].(string)
+	payload, err := NewPluginSignaturePayload(pluginName, pluginType, sha)
+	if err != nil {
+		return err
+	}
+	keys, _ := pluginToolConfig["trcsigningkeys"].(string)
+	return Verify([]byte(keys), payload, signature)
+}
@@ -0,0 +1,62 @@
+package sign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestPluginCertificationSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDer, _ := x509.MarshalPKCS8PrivateKey(key)
+	publicDer, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})
+
+	sha := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	payload, err := NewPluginSignaturePayload("trcshtalk", "trcshpluginservice", sha)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := Sign(privatePEM, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certification := map[string]interface{}{
+		"trcplugin":      "trcshtalk",
+		"trctype":        "trcshpluginservice",
+		"trcsha256":      sha,
+		"trcsignature":   signature,
+		"trcsigningkeys": string(publicPEM),
+	}
+	if !SigningRequired(certification) {
+		t.Fatal("expected signing to be required")
+	}
+	if err := VerifyPluginCertification(certification); err != nil {
+		t.Fatalf("expected valid signature: %v", err)
+	}
+
+	// Re-certifying a different binary or plugin with the same signature must fail.
+	for field, value := range map[string]string{"trcsha256": "ff" + sha[2:], "trcplugin": "trcshcurator"} {
+		tampered := map[string]interface{}{}
+		for k, v := range certification {
+			tampered[k] = v
+		}
+		tampered[field] = value
+		if err := VerifyPluginCertification(tampered); err == nil {
+			t.Fatalf("expected tampered %s to fail verification", field)
+		}
+	}
+
+	delete(certification, "trcsignature")
+	if err := VerifyPluginCertification(certification); err == nil {
+		t.Fatal("expected unsigned certification to fail verification")
+	}
+}
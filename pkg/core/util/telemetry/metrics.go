@@ -0,0 +1,122 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// lockedWriter serializes the span and metric exporters sharing a file.
+type lockedWriter struct {
+	lock   sync.Mutex
+	writer io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.writer.Write(p)
+}
+
+// FileMetricExporter writes each export as a line of json.
+type FileMetricExporter struct {
+	writer io.Writer
+}
+
+// NewFileMetricExporter returns an exporter writing to writer.
+func NewFileMetricExporter(writer io.Writer) *FileMetricExporter {
+	return &FileMetricExporter{writer: writer}
+}
+
+func (e *FileMetricExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	return sdkmetric.DefaultTemporalitySelector(kind)
+}
+
+func (e *FileMetricExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *FileMetricExporter) Export(_ context.Context, resourceMetrics *metricdata.ResourceMetrics) error {
+	encoded, err := json.Marshal(resourceMetrics)
+	if err != nil {
+		return err
+	}
+	_, err = e.writer.Write(append(encoded, '\n'))
+	return err
+}
+
+func (e *FileMetricExporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+func (e *FileMetricExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+var dataFlowInstrumentsOnce sync.Once
+var dataFlowStatisticCounter metric.Int64Counter
+var dataFlowStateDuration metric.Float64Histogram
+
+func dataFlowInstruments() {
+	dataFlowInstrumentsOnce.Do(func() {
+		meter := otel.Meter(INSTRUMENTATION_NAME)
+		// The global meter forwards to the provider installed later by Init.
+		dataFlowStatisticCounter, _ = meter.Int64Counter("trc.dataflow.statistics",
+			metric.WithDescription("Data flow statistics delivered, one per flow state reached."))
+		dataFlowStateDuration, _ = meter.Float64Histogram("trc.dataflow.state.duration",
+			metric.WithDescription("Time taken to reach a data flow state."),
+			metric.WithUnit("s"))
+	})
+}
+
+// DataFlowStatisticAttributes returns the attributes describing a statistic
+// map as produced by FinishStatistic.
+func DataFlowStatisticAttributes(statMap map[string]interface{}) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{}
+	for _, field := range []string{"flowGroup", "flowName", "stateCode", "stateName"} {
+		if value, ok := statMap[field].(string); ok {
+			attrs = append(attrs, attribute.String("trc.dataflow."+field, value))
+		}
+	}
+	if mode, ok := statMap["mode"]; ok {
+		if modeInt, err := strconv.Atoi(fmt.Sprintf("%v", mode)); err == nil {
+			attrs = append(attrs, attribute.Int("trc.dataflow.mode", modeInt))
+		}
+	}
+	return attrs
+}
+
+// RecordDataFlowStatistic records a data flow statistic as metrics and as
+// an event on the span in ctx.
+func RecordDataFlowStatistic(ctx context.Context, statMap map[string]interface{}) {
+	if statMap == nil {
+		return
+	}
+	dataFlowInstruments()
+	attrs := DataFlowStatisticAttributes(statMap)
+	attrSet := metric.WithAttributes(attrs...)
+	if dataFlowStatisticCounter != nil {
+		dataFlowStatisticCounter.Add(ctx, 1, attrSet)
+	}
+	if timeSplit, ok := statMap["timeSplit"].(string); ok && dataFlowStateDuration != nil {
+		if elapsed, err := time.ParseDuration(strings.ReplaceAll(timeSplit, " seconds", "s")); err == nil {
+			dataFlowStateDuration.Record(ctx, elapsed.Seconds(), attrSet)
+		}
+	}
+	if lastTestedDate, ok := statMap["lastTestedDate"].(string); ok && len(lastTestedDate) > 0 {
+		attrs = append(attrs, attribute.String("trc.dataflow.lastTestedDate", lastTestedDate))
+	}
+	trace.SpanFromContext(ctx).AddEvent("dataflow.statistic", trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,240 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// INSTRUMENTATION_NAME names the tracer and meter of every tierceron tool.
+const INSTRUMENTATION_NAME = "github.com/trimble-oss/tierceron"
+
+// Environment variables configuring telemetry, the OTLP exporters also
+// honour the standard OTEL_EXPORTER_OTLP_* variables.
+const (
+	ENV_OTEL_ENDPOINT = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	ENV_OTEL_INSECURE = "OTEL_EXPORTER_OTLP_INSECURE"
+	ENV_OTEL_FILE     = "TRC_OTEL_FILE"
+)
+
+// DefaultMetricInterval is how often metrics are exported.
+const DefaultMetricInterval = 60 * time.Second
+
+// TelemetryConfig says where telemetry goes.  Telemetry is disabled unless
+// an endpoint or file is set.
+type TelemetryConfig struct {
+	Endpoint       string            // OTLP/HTTP collector url such as https://collector:4318.
+	Insecure       bool              // Collector is plain http.
+	Headers        map[string]string // Sent with every export, such as api keys.
+	File           string            // Spans and metrics are written here as json, for tests.
+	MetricInterval time.Duration
+}
+
+// Enabled returns true if telemetry is exported anywhere.
+func (c *TelemetryConfig) Enabled() bool {
+	return c != nil && (len(c.Endpoint) > 0 || len(c.File) > 0)
+}
+
+// NewTelemetryConfig reads telemetry settings, such as a trcsh config.yml
+// or plugin certification: otel_endpoint, otel_insecure, otel_headers
+// (k=v,k=v), otel_file and otel_metric_interval (seconds).  Unset settings
+// fall back to the environment.
+func NewTelemetryConfig(settings map[string]interface{}) (*TelemetryConfig, error) {
+	telemetryConfig := ConfigFromEnv()
+	if endpoint, ok := settings["otel_endpoint"].(string); ok && len(endpoint) > 0 {
+		telemetryConfig.Endpoint = endpoint
+	}
+	if insecure, ok := settings["otel_insecure"]; ok {
+		telemetryConfig.Insecure, _ = strconv.ParseBool(fmt.Sprintf("%v", insecure))
+	}
+	if headers, ok := settings["otel_headers"].(string); ok && len(headers) > 0 {
+		for _, header := range strings.Split(headers, ",") {
+			key, value, found := strings.Cut(header, "=")
+			if !found || len(strings.TrimSpace(key)) == 0 {
+				return nil, fmt.Errorf("invalid otel_headers: %s", headers)
+			}
+			telemetryConfig.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if file, ok := settings["otel_file"].(string); ok && len(file) > 0 {
+		telemetryConfig.File = file
+	}
+	if interval, ok := settings["otel_metric_interval"]; ok {
+		seconds, err := strconv.Atoi(fmt.Sprintf("%v", interval))
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid otel_metric_interval: %v", interval)
+		}
+		telemetryConfig.MetricInterval = time.Duration(seconds) * time.Second
+	}
+	return telemetryConfig, nil
+}
+
+// ConfigFromEnv reads telemetry settings from the environment.
+func ConfigFromEnv() *TelemetryConfig {
+	telemetryConfig := &TelemetryConfig{
+		Endpoint:       os.Getenv(ENV_OTEL_ENDPOINT),
+		File:           os.Getenv(ENV_OTEL_FILE),
+		Headers:        map[string]string{},
+		MetricInterval: DefaultMetricInterval,
+	}
+	telemetryConfig.Insecure, _ = strconv.ParseBool(os.Getenv(ENV_OTEL_INSECURE))
+	return telemetryConfig
+}
+
+var initLock sync.Mutex
+var initialized bool
+
+// Init installs the global tracer and meter providers for a tool.  Only
+// the first call in a process installs them, so tools run from trcsh
+// report under trcsh.  The returned shutdown flushes what's pending.
+func Init(serviceName string, telemetryConfig *TelemetryConfig, logger *log.Logger) (func(), error) {
+	initLock.Lock()
+	defer initLock.Unlock()
+	if initialized || !telemetryConfig.Enabled() {
+		return func() {}, nil
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	ctx := context.Background()
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+	))
+	if err != nil {
+		return func() {}, err
+	}
+
+	traceOptions := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	metricOptions := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	interval := telemetryConfig.MetricInterval
+	if interval <= 0 {
+		interval = DefaultMetricInterval
+	}
+	closers := []func() error{}
+
+	if len(telemetryConfig.Endpoint) > 0 {
+		endpoint := strings.TrimSuffix(telemetryConfig.Endpoint, "/")
+		traceExporterOptions := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint + "/v1/traces")}
+		metricExporterOptions := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(endpoint + "/v1/metrics")}
+		if telemetryConfig.Insecure {
+			traceExporterOptions = append(traceExporterOptions, otlptracehttp.WithInsecure())
+			metricExporterOptions = append(metricExporterOptions, otlpmetrichttp.WithInsecure())
+		}
+		if len(telemetryConfig.Headers) > 0 {
+			traceExporterOptions = append(traceExporterOptions, otlptracehttp.WithHeaders(telemetryConfig.Headers))
+			metricExporterOptions = append(metricExporterOptions, otlpmetrichttp.WithHeaders(telemetryConfig.Headers))
+		}
+		traceExporter, err := otlptracehttp.New(ctx, traceExporterOptions...)
+		if err != nil {
+			return func() {}, err
+		}
+		metricExporter, err := otlpmetrichttp.New(ctx, metricExporterOptions...)
+		if err != nil {
+			return func() {}, err
+		}
+		traceOptions = append(traceOptions, sdktrace.WithBatcher(traceExporter))
+		metricOptions = append(metricOptions, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(interval))))
+	}
+
+	if len(telemetryConfig.File) > 0 {
+		file, err := os.OpenFile(telemetryConfig.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return func() {}, err
+		}
+		closers = append(closers, file.Close)
+		fileWriter := &lockedWriter{writer: file}
+		traceExporter, err := stdouttrace.New(stdouttrace.WithWriter(fileWriter))
+		if err != nil {
+			file.Close()
+			return func() {}, err
+		}
+		// Spans are written as they end so a crashed run still leaves them.
+		traceOptions = append(traceOptions, sdktrace.WithSyncer(traceExporter))
+		metricOptions = append(metricOptions, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(NewFileMetricExporter(fileWriter), sdkmetric.WithInterval(interval))))
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(traceOptions...)
+	meterProvider := sdkmetric.NewMeterProvider(metricOptions...)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Printf("Telemetry export failure: %v\n", err)
+	}))
+	initialized = true
+
+	var shutdownOnce sync.Once
+	return func() {
+		shutdownOnce.Do(func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := errors.Join(tracerProvider.Shutdown(shutdownCtx), meterProvider.Shutdown(shutdownCtx))
+			for _, closer := range closers {
+				err = errors.Join(err, closer())
+			}
+			if err != nil {
+				logger.Printf("Telemetry shutdown failure: %v\n", err)
+			}
+		})
+	}, nil
+}
+
+// InitFromEnv is Init configured from the environment.  Failures are
+// logged and the tool continues without telemetry.
+func InitFromEnv(serviceName string, logger *log.Logger) func() {
+	shutdown, err := Init(serviceName, ConfigFromEnv(), logger)
+	if err != nil {
+		if logger == nil {
+			logger = log.Default()
+		}
+		logger.Printf("Continuing without telemetry: %v\n", err)
+	}
+	return shutdown
+}
+
+// Tracer returns the tierceron tracer.
+func Tracer() trace.Tracer {
+	return otel.Tracer(INSTRUMENTATION_NAME)
+}
+
+// Start starts a span, a child of the span in ctx if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StartRun starts the span of a tool run, such as trcconfig, within the
+// run in ctx if any.  Runs nest by passing on the returned context, so
+// trcconfig run from trcsh is part of the trcsh run.
+func StartRun(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Start(ctx, name, attrs...)
+}
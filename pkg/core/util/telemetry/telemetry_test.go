@@ -0,0 +1,59 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileExport(t *testing.T) {
+	if _, err := NewTelemetryConfig(map[string]interface{}{"otel_headers": "novalue"}); err == nil {
+		t.Fatal("Expected invalid headers to fail")
+	}
+	file := filepath.Join(t.TempDir(), "otel.json")
+	telemetryConfig, err := NewTelemetryConfig(map[string]interface{}{"otel_file": file})
+	if err != nil || !telemetryConfig.Enabled() {
+		t.Fatalf("Unexpected config %v %v", telemetryConfig, err)
+	}
+	// Only the file, whatever the environment.
+	telemetryConfig.Endpoint = ""
+	shutdown, err := Init("trctest", telemetryConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runCtx, runSpan := StartRun(context.Background(), "trctest.run")
+	_, stepSpan := StartRun(runCtx, "trctest.step")
+	_, vaultSpan := Start(runCtx, "vault.read")
+	if stepSpan.SpanContext().TraceID() != runSpan.SpanContext().TraceID() || vaultSpan.SpanContext().TraceID() != runSpan.SpanContext().TraceID() {
+		t.Fatal("Expected the step and vault call part of the run")
+	}
+	if _, otherSpan := Start(context.Background(), "vault.read"); otherSpan.SpanContext().TraceID() == runSpan.SpanContext().TraceID() {
+		t.Fatal("Expected calls outside the run to start their own trace")
+	}
+	stepSpan.End()
+	End(vaultSpan, errors.New("permission denied"))
+	RecordDataFlowStatistic(runCtx, map[string]interface{}{
+		"flowGroup": "System",
+		"flowName":  "login-abc",
+		"stateCode": "1",
+		"stateName": "Loading",
+		"mode":      2,
+		"timeSplit": "1.5 seconds",
+	})
+	runSpan.End()
+	shutdown()
+
+	exported, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"trctest.run", "vault.read", "permission denied", "dataflow.statistic", "trc.dataflow.statistics", "trc.dataflow.state.duration", "login-abc"} {
+		if !strings.Contains(string(exported), expected) {
+			t.Fatalf("Expected %s in exported telemetry", expected)
+		}
+	}
+}
@@ -332,6 +332,10 @@ func GetPluginToolConfig(driverConfig *config.DriverConfig, mod *helperkv.Modifi
 		indexFound = true
 
 		for k, v := range ptc1 {
+			if k == "trcsigningkeys" {
+				// Signing keys only come from the restricted PluginTool configuration.
+				continue
+			}
 			if _, okStr := v.(string); okStr {
 				v2 := strings.Clone(v.(string))
 				memprotectopts.MemProtect(nil, &v2)
@@ -54,7 +54,7 @@ func GetTemplateParam(driverConfig *config.DriverConfig, mod *helperkv.Modifier,
 
 	templateStr := string(templateBytes)
 
-	t := template.New("template")
+	t := template.New("template").Funcs(vcutils.TemplateFuncs(nil))
 	t, err = t.Parse(templateStr)
 	if err != nil {
 		return "", err
@@ -79,7 +79,7 @@ func ToSeed(driverConfig *config.DriverConfig, mod *helperkv.Modifier,
 	}
 
 	// Parse template
-	t := template.New("template")
+	t := template.New("template").Funcs(vcutils.TemplateFuncs(nil))
 	theTemplate, err := t.Parse(newTemplate)
 	if err != nil {
 		return nil, nil, nil, 0, eUtils.LogAndSafeExit(driverConfig.CoreConfig, err.Error(), -1)
@@ -88,15 +88,24 @@ func ToSeed(driverConfig *config.DriverConfig, mod *helperkv.Modifier,
 
 	for _, node := range commandList.Nodes {
 		if node.Type() == parse.NodeAction {
-			var args []string
+			var cmds [][]string
 			fields := node.(*parse.ActionNode).Pipe
-			for _, arg := range fields.Cmds[0].Args {
-				templateParameter := strings.ReplaceAll(arg.String(), "\\\"", "\"")
-				if strings.Contains(templateParameter, "~") {
-					eUtils.LogInfo(driverConfig.CoreConfig, "Unsupported parameter name character ~: "+templateParameter)
-					return nil, nil, nil, 0, errors.New("Unsupported parameter name character ~: " + templateParameter)
+			for _, cmd := range fields.Cmds {
+				var args []string
+				for _, arg := range cmd.Args {
+					templateParameter := strings.ReplaceAll(arg.String(), "\\\"", "\"")
+					if strings.Contains(templateParameter, "~") {
+						eUtils.LogInfo(driverConfig.CoreConfig, "Unsupported parameter name character ~: "+templateParameter)
+						return nil, nil, nil, 0, errors.New("Unsupported parameter name character ~: " + templateParameter)
+					}
+					args = append(args, templateParameter)
 				}
-				args = append(args, templateParameter)
+				cmds = append(cmds, args)
+			}
+			args := SeedArgs(cmds)
+			if args == nil {
+				// Nothing of this template to seed, such as a lookup.
+				continue
 			}
 
 			// Gets the parsed file line
@@ -120,6 +129,45 @@ func ToSeed(driverConfig *config.DriverConfig, mod *helperkv.Modifier,
 	return interfaceTemplateSection, valueSection, secretSection, templateDepth, nil
 }
 
+// SeedArgs reduces the commands of a template action to the or .key "default"
+// or .key forms Parse seeds from.  Template functions are stripped down to the
+// key they act on, with default "value" .key and .key | default "value"
+// seeding a value.  Returns nil when there is no key of this template.
+func SeedArgs(cmds [][]string) []string {
+	if len(cmds) == 0 || len(cmds[0]) == 0 {
+		return nil
+	}
+	templateFuncs := vcutils.TemplateFuncs(nil)
+	if _, isFunc := templateFuncs[cmds[0][0]]; !isFunc {
+		if cmds[0][0] == "." {
+			// The whole of the data, such as {{.}}, is no key.
+			return nil
+		}
+		if len(cmds[0]) == 1 {
+			for _, cmd := range cmds[1:] {
+				if len(cmd) == 2 && cmd[0] == "default" && strings.HasPrefix(cmd[1], "\"") {
+					return []string{"or", cmds[0][0], cmd[1]}
+				}
+			}
+		}
+		return cmds[0]
+	}
+
+	keys := []string{}
+	for _, arg := range cmds[0][1:] {
+		if strings.HasPrefix(arg, ".") && arg != "." {
+			keys = append(keys, arg)
+		}
+	}
+	if len(keys) != 1 {
+		return nil
+	}
+	if cmds[0][0] == "default" && len(cmds[0]) == 3 && strings.HasPrefix(cmds[0][1], "\"") {
+		return []string{"or", keys[0], cmds[0][1]}
+	}
+	return keys
+}
+
 // GetInitialTemplateStructure Initializes the structure of the template section using the template directory path
 // Input:
 //   - A slice of the template file path delimited by "/"
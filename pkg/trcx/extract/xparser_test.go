@@ -0,0 +1,58 @@
+package extract
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+	"text/template/parse"
+
+	vcutils "github.com/trimble-oss/tierceron/pkg/cli/trcconfigbase/utils"
+)
+
+// templateCmds splits the first action of templateText into commands the
+// way ToSeed does.
+func templateCmds(t *testing.T, templateText string) [][]string {
+	tmpl, err := template.New("template").Funcs(vcutils.TemplateFuncs(nil)).Parse(templateText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmds [][]string
+	for _, node := range tmpl.Tree.Root.Nodes {
+		if action, ok := node.(*parse.ActionNode); ok {
+			for _, cmd := range action.Pipe.Cmds {
+				var args []string
+				for _, arg := range cmd.Args {
+					args = append(args, strings.ReplaceAll(arg.String(), "\\\"", "\""))
+				}
+				cmds = append(cmds, args)
+			}
+			break
+		}
+	}
+	return cmds
+}
+
+func TestSeedArgs(t *testing.T) {
+	for templateText, expected := range map[string][]string{
+		`{{.host}}`:                             {".host"},
+		`{{or .host "localhost"}}`:              {"or", ".host", `"localhost"`},
+		`{{.host | default "localhost"}}`:       {"or", ".host", `"localhost"`},
+		`{{default "localhost" .host}}`:         {"or", ".host", `"localhost"`},
+		`{{.host | b64enc}}`:                    {".host"},
+		`{{required "host is required" .host}}`: {".host"},
+		`{{toJson .hosts}}`:                     {".hosts"},
+		`{{lookup "Project/Service" "key"}}`:    nil,
+		`{{toToml .}}`:                          nil,
+		`{{.}}`:                                 nil,
+		`{{join "," .hosts .names}}`:            nil,
+	} {
+		if args := SeedArgs(templateCmds(t, templateText)); !reflect.DeepEqual(args, expected) {
+			t.Fatalf("Expected %s to seed %v, got %v", templateText, expected, args)
+		}
+	}
+
+	if args := SeedArgs(nil); args != nil {
+		t.Fatalf("Expected no commands to seed nothing, got %v", args)
+	}
+}
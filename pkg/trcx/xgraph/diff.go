@@ -0,0 +1,80 @@
+package xgraph
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// seedSections are the sections of a seed holding values.
+var seedSections = []string{"values", "super-secrets"}
+
+// ChangedKeys compares the values and super-secrets of two seeds as trcx
+// generates them, returning the ids of the keys added, removed or changed,
+// such as values/Service.port.
+func ChangedKeys(seedA string, seedB string) ([]string, error) {
+	keysA, err := seedKeys(seedA)
+	if err != nil {
+		return nil, err
+	}
+	keysB, err := seedKeys(seedB)
+	if err != nil {
+		return nil, err
+	}
+	changed := []string{}
+	for keyID, valueA := range keysA {
+		if valueB, ok := keysB[keyID]; !ok || valueA != valueB {
+			changed = append(changed, keyID)
+		}
+	}
+	for keyID := range keysB {
+		if _, ok := keysA[keyID]; !ok {
+			changed = append(changed, keyID)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+func seedKeys(seed string) (map[string]string, error) {
+	var seedData map[interface{}]interface{}
+	if err := yaml.Unmarshal([]byte(seed), &seedData); err != nil {
+		return nil, err
+	}
+	keys := map[string]string{}
+	for _, section := range seedSections {
+		if sectionData, ok := seedData[section].(map[interface{}]interface{}); ok {
+			flattenSeed(section, sectionData, keys)
+		}
+	}
+	return keys, nil
+}
+
+// flattenSeed collects the leaves of a seed section, keyed by the vault
+// path holding them and their key.
+func flattenSeed(path string, data map[interface{}]interface{}, keys map[string]string) {
+	for key, value := range data {
+		if sub, ok := value.(map[interface{}]interface{}); ok {
+			flattenSeed(fmt.Sprintf("%s/%v", path, key), sub, keys)
+		} else {
+			keys[fmt.Sprintf("%s.%v", path, key)] = fmt.Sprint(value)
+		}
+	}
+}
+
+// AffectedServices returns the services depending on any of keys.
+func (g *Graph) AffectedServices(keys []string) []*Node {
+	services := map[string]*Node{}
+	for _, key := range keys {
+		for _, service := range g.Impact(key).Services {
+			services[service.ID] = service
+		}
+	}
+	affected := make([]*Node, 0, len(services))
+	for _, service := range services {
+		affected = append(affected, service)
+	}
+	sortNodes(affected)
+	return affected
+}
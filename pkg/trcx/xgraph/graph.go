@@ -0,0 +1,319 @@
+package xgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Node kinds.
+const (
+	NODE_SERVICE  = "service"
+	NODE_TEMPLATE = "template"
+	NODE_CERT     = "cert"
+	NODE_KEY      = "key"
+	NODE_PATH     = "path"
+)
+
+// Edge kinds.  Edges point from the dependent to what it depends on.
+const (
+	EDGE_RENDERS    = "renders"    // service -> template
+	EDGE_USES       = "uses"       // template -> key
+	EDGE_LOOKUP     = "lookup"     // template -> key of another service
+	EDGE_STORED     = "stored"     // key -> vault path
+	EDGE_REFERENCES = "references" // template -> cert named in it
+	EDGE_BUNDLES    = "bundles"    // service -> cert of its Project/Service or referenced by its templates
+)
+
+// Node is a service, template, cert, key or vault path.  Keys are
+// identified as <vault path>.<key>, such as values/Service.port.
+type Node struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`
+	Output   string `json:"output,omitempty"`   // Rendered file of a template.
+	Deployed bool   `json:"deployed,omitempty"` // Service has deploy templates.
+}
+
+// Edge is a dependency of From on To.
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+// Graph is the dependency graph of templates, keys and vault paths.
+type Graph struct {
+	Nodes map[string]*Node
+	Edges []Edge
+
+	edgeSet  map[Edge]bool
+	incoming map[string][]Edge
+}
+
+// NewGraph returns an empty graph.
+func NewGraph() *Graph {
+	return &Graph{
+		Nodes:    map[string]*Node{},
+		edgeSet:  map[Edge]bool{},
+		incoming: map[string][]Edge{},
+	}
+}
+
+// AddNode adds a node, returning the existing one if already present.
+func (g *Graph) AddNode(id string, kind string) *Node {
+	if node, ok := g.Nodes[id]; ok {
+		return node
+	}
+	node := &Node{ID: id, Kind: kind}
+	g.Nodes[id] = node
+	return node
+}
+
+// AddEdge adds a dependency of from on to.
+func (g *Graph) AddEdge(from string, to string, kind string) {
+	edge := Edge{From: from, To: to, Kind: kind}
+	if g.edgeSet[edge] {
+		return
+	}
+	g.edgeSet[edge] = true
+	g.Edges = append(g.Edges, edge)
+	g.incoming[to] = append(g.incoming[to], edge)
+}
+
+// AddKey adds the key stored at vaultPath, such as values/Service.
+func (g *Graph) AddKey(vaultPath string, key string) string {
+	keyID := vaultPath + "." + key
+	g.AddNode(keyID, NODE_KEY)
+	g.AddNode(vaultPath, NODE_PATH)
+	g.AddEdge(keyID, vaultPath, EDGE_STORED)
+	return keyID
+}
+
+// Impact is what changes when a key, vault path, template or cert changes.
+type Impact struct {
+	Target    string   `json:"target"`
+	Matched   []string `json:"matched"`
+	Templates []*Node  `json:"templates"`
+	Services  []*Node  `json:"services"`
+}
+
+// Resolve finds the nodes a query names.  Queries are a node id, such as
+// values/Service.key, Project/Service/config.yml.tmpl or Project/Service,
+// or a key of a template as Project/Service/config.key or
+// values/Project/Service/config.key.
+func (g *Graph) Resolve(query string) []string {
+	if _, ok := g.Nodes[query]; ok {
+		return []string{query}
+	}
+	matched := []string{}
+	dot := strings.LastIndex(query, ".")
+	if dot <= 0 {
+		return matched
+	}
+	path, key := query[:dot], query[dot+1:]
+	bucket := ""
+	for _, prefix := range []string{"values/", "super-secrets/"} {
+		if strings.HasPrefix(path, prefix) {
+			bucket, path = strings.TrimSuffix(prefix, "/"), strings.TrimPrefix(path, prefix)
+		}
+	}
+
+	// Keys of a template.
+	for _, template := range g.sortedNodes(NODE_TEMPLATE, NODE_CERT) {
+		if trimTemplateID(template.ID) != path {
+			continue
+		}
+		for _, edge := range g.Edges {
+			if edge.From == template.ID && (edge.Kind == EDGE_USES || edge.Kind == EDGE_LOOKUP) &&
+				strings.HasSuffix(edge.To, "."+key) &&
+				(bucket == "" || strings.HasPrefix(edge.To, bucket+"/")) {
+				matched = append(matched, edge.To)
+			}
+		}
+	}
+	if len(matched) > 0 || bucket == "" {
+		return dedupe(matched)
+	}
+
+	// Keys stored under any service named in the path.
+	for _, segment := range strings.Split(path, "/") {
+		if _, ok := g.Nodes[bucket+"/"+segment+"."+key]; ok {
+			matched = append(matched, bucket+"/"+segment+"."+key)
+		}
+	}
+	return dedupe(matched)
+}
+
+// Impact walks back from what the query names to the templates and
+// services depending on it.
+func (g *Graph) Impact(query string) *Impact {
+	impact := &Impact{Target: query, Matched: g.Resolve(query), Templates: []*Node{}, Services: []*Node{}}
+	visited := map[string]bool{}
+	pending := append([]string{}, impact.Matched...)
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		if node, ok := g.Nodes[id]; ok {
+			switch node.Kind {
+			case NODE_TEMPLATE, NODE_CERT:
+				impact.Templates = append(impact.Templates, node)
+			case NODE_SERVICE:
+				impact.Services = append(impact.Services, node)
+			}
+		}
+		for _, edge := range g.incoming[id] {
+			pending = append(pending, edge.From)
+		}
+	}
+	sortNodes(impact.Templates)
+	sortNodes(impact.Services)
+	return impact
+}
+
+// String renders the impact for the command line.
+func (impact *Impact) String() string {
+	var b strings.Builder
+	if len(impact.Matched) == 0 {
+		fmt.Fprintf(&b, "Nothing found for %s\n", impact.Target)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "Impact of %s\n", impact.Target)
+	b.WriteString("Templates:\n")
+	for _, template := range impact.Templates {
+		fmt.Fprintf(&b, "  %s -> %s\n", template.ID, template.Output)
+	}
+	b.WriteString("Services:\n")
+	for _, service := range impact.Services {
+		deployed := ""
+		if service.Deployed {
+			deployed = " (deployed)"
+		}
+		fmt.Fprintf(&b, "  %s%s\n", service.ID, deployed)
+	}
+	return b.String()
+}
+
+// WriteJSON writes the graph as json nodes and edges.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	nodes := g.sortedNodes()
+	edges := g.sortedEdges()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Nodes []*Node `json:"nodes"`
+		Edges []Edge  `json:"edges"`
+	}{nodes, edges})
+}
+
+var dotShapes = map[string]string{
+	NODE_SERVICE:  "box3d",
+	NODE_TEMPLATE: "note",
+	NODE_CERT:     "component",
+	NODE_KEY:      "ellipse",
+	NODE_PATH:     "folder",
+}
+
+// WriteDOT writes the graph for graphviz.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph trc {\n\trankdir=LR;\n")
+	for _, node := range g.sortedNodes() {
+		fmt.Fprintf(&b, "\t%q [shape=%s];\n", node.ID, dotShapes[node.Kind])
+	}
+	for _, edge := range g.sortedEdges() {
+		fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", edge.From, edge.To, edge.Kind)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Export writes the graph to path, as DOT for .dot or .gv and json for
+// .json.
+func (g *Graph) Export(path string) error {
+	var write func(io.Writer) error
+	switch filepath.Ext(path) {
+	case ".dot", ".gv":
+		write = g.WriteDOT
+	case ".json":
+		write = g.WriteJSON
+	default:
+		return fmt.Errorf("unsupported graph format %s, expected .dot or .json", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (g *Graph) sortedNodes(kinds ...string) []*Node {
+	nodes := []*Node{}
+	for _, node := range g.Nodes {
+		if len(kinds) == 0 || contains(kinds, node.Kind) {
+			nodes = append(nodes, node)
+		}
+	}
+	sortNodes(nodes)
+	return nodes
+}
+
+func (g *Graph) sortedEdges() []Edge {
+	edges := append([]Edge{}, g.Edges...)
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		if edges[i].To != edges[j].To {
+			return edges[i].To < edges[j].To
+		}
+		return edges[i].Kind < edges[j].Kind
+	})
+	return edges
+}
+
+func sortNodes(nodes []*Node) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+}
+
+// trimTemplateID strips the extensions of a template id, leaving
+// Project/Service/config as vault names it.
+func trimTemplateID(id string) string {
+	file := id[strings.LastIndex(id, "/")+1:]
+	if dot := strings.Index(file, "."); dot > 0 {
+		return id[:len(id)-len(file)+dot]
+	}
+	return id
+}
+
+func contains(list []string, entry string) bool {
+	for _, listEntry := range list {
+		if listEntry == entry {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupe(list []string) []string {
+	sort.Strings(list)
+	deduped := []string{}
+	for i, entry := range list {
+		if i == 0 || list[i-1] != entry {
+			deduped = append(deduped, entry)
+		}
+	}
+	return deduped
+}
@@ -0,0 +1,136 @@
+package xgraph
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	startDir := t.TempDir()
+	for name, content := range files {
+		file := filepath.Join(startDir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return startDir
+}
+
+func serviceIDs(nodes []*Node) string {
+	ids := []string{}
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+type testVault struct {
+	lists map[string][]interface{}
+	data  map[string]map[string]interface{}
+}
+
+func (v *testVault) List(path string, logger *log.Logger) (*api.Secret, error) {
+	if keys, ok := v.lists[path]; ok {
+		return &api.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+	}
+	return nil, nil
+}
+
+func (v *testVault) ReadData(path string) (map[string]interface{}, error) {
+	return v.data[path], nil
+}
+
+func TestImpact(t *testing.T) {
+	startDir := writeTemplates(t, map[string]string{
+		"Hive/Talk/config.yml.tmpl":        "port: {{or .port \"12345\"}}\nname: {{.name}}\n{{if .tls}}cert: local_config/hive.crt{{end}}\n",
+		"Hive/Talk/deploy/deploy.trc.tmpl": "trcplgtool -env={{default \"dev\" .deploy_ENVIRONMENT}}\n",
+		"Hive/Api/config.yml.tmpl":         "talkPort: {{lookup \"Hive/Talk\" \"port\"}}\nuser: {{.user | default \"api\"}}\n",
+		"Common/HiveCert.crt.mf.tmpl":      "{{.certData}}\n{{or .certSourcePath \"certs/hive.crt\"}}\n{{or .certDestPath \"local_config/hive.crt\"}}\n",
+		"Hive/Api/api.crt.mf.tmpl":         "{{.certData}}\n",
+	})
+	graph := NewGraph()
+	if err := IndexTemplates(graph, startDir); err != nil {
+		t.Fatal(err)
+	}
+
+	impact := graph.Impact("values/Talk.port")
+	if serviceIDs(impact.Services) != "Hive/Api,Hive/Talk" || len(impact.Templates) != 2 {
+		t.Fatalf("Unexpected impact %v", impact)
+	}
+	if !graph.Nodes["Hive/Talk"].Deployed || graph.Nodes["Hive/Api"].Deployed {
+		t.Fatalf("Expected only Hive/Talk deployed")
+	}
+	if matched := graph.Resolve("Hive/Talk/config.name"); len(matched) != 1 || matched[0] != "super-secrets/Talk.name" {
+		t.Fatalf("Unexpected keys of template %v", matched)
+	}
+	if matched := graph.Resolve("values/Hive/Api/config.user"); len(matched) != 1 || matched[0] != "values/Api.user" {
+		t.Fatalf("Unexpected keys of template %v", matched)
+	}
+	if impact := graph.Impact("Common/HiveCert.crt.mf.tmpl"); serviceIDs(impact.Services) != "Hive/Talk" {
+		t.Fatalf("Expected the cert bundled with only the service referencing it, got %v", impact.Services)
+	}
+	if impact := graph.Impact("Hive/Api/api.crt.mf.tmpl"); serviceIDs(impact.Services) != "Hive/Api" {
+		t.Fatalf("Expected the cert bundled with its own service, got %v", impact.Services)
+	}
+	found := false
+	for _, edge := range graph.Edges {
+		found = found || (edge == Edge{"Hive/Talk/config.yml.tmpl", "Common/HiveCert.crt.mf.tmpl", EDGE_REFERENCES})
+	}
+	if !found || graph.Nodes["Common/HiveCert.crt.mf.tmpl"].Output != "local_config/hive.crt" {
+		t.Fatalf("Expected config.yml to reference the cert")
+	}
+	if impact := graph.Impact("values/Talk.missing"); !strings.Contains(impact.String(), "Nothing found") {
+		t.Fatalf("Unexpected impact %s", impact)
+	}
+
+	// Vault links are authoritative.
+	vault := &testVault{
+		lists: map[string][]interface{}{
+			"templates/":                  {"Hive/"},
+			"templates/Hive/":             {"Talk/"},
+			"templates/Hive/Talk/":        {"config/"},
+			"templates/Hive/Talk/config/": {"template-file"},
+		},
+		data: map[string]map[string]interface{}{
+			"templates/Hive/Talk/config": {
+				"port": []interface{}{"values/Talk", "port"},
+				"name": []interface{}{"super-secrets/Common", "name"},
+			},
+		},
+	}
+	if err := IndexVault(graph, vault, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := graph.Nodes["super-secrets/Talk.name"]; ok {
+		t.Fatalf("Expected the guessed key replaced")
+	}
+	if impact := graph.Impact("super-secrets/Common.name"); serviceIDs(impact.Services) != "Hive/Talk" {
+		t.Fatalf("Unexpected impact %v", impact.Services)
+	}
+
+	var dot bytes.Buffer
+	if err := graph.WriteDOT(&dot); err != nil || !strings.Contains(dot.String(), `"Hive/Talk" -> "Hive/Talk/config.yml.tmpl" [label="renders"]`) {
+		t.Fatalf("Unexpected dot %s %v", dot.String(), err)
+	}
+	if err := graph.Export(filepath.Join(t.TempDir(), "graph.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := ChangedKeys("values:\n  Talk:\n    port: \"1\"\nsuper-secrets:\n  Common:\n    name: a\n",
+		"values:\n  Talk:\n    port: \"2\"\nsuper-secrets:\n  Common:\n    name: a\n")
+	if err != nil || strings.Join(changed, ",") != "values/Talk.port" {
+		t.Fatalf("Unexpected changes %v %v", changed, err)
+	}
+	if affected := graph.AffectedServices(changed); serviceIDs(affected) != "Hive/Api,Hive/Talk" {
+		t.Fatalf("Unexpected affected services %v", affected)
+	}
+}
@@ -0,0 +1,352 @@
+package xgraph
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/hashicorp/vault/api"
+	vcutils "github.com/trimble-oss/tierceron/pkg/cli/trcconfigbase/utils"
+	"github.com/trimble-oss/tierceron/pkg/trcx/extract"
+)
+
+// certKeys are the keys naming where a cert template's cert is found and
+// rendered.
+var certKeys = []string{"certSourcePath", "certDestPath"}
+
+type templateLookup struct {
+	templateID string
+	service    string
+	key        string
+}
+
+// IndexTemplates adds the .tmpl files under startDir, a trc_templates
+// directory laid out as Project/Service/..., to the graph.  Keys follow the
+// seeding convention of trcx: or .key "default" is stored in values/Service
+// and .key in super-secrets/Service.  Templates reference the certs they
+// name, and a service bundles the certs under its own Project/Service and
+// those its templates reference.
+func IndexTemplates(g *Graph, startDir string) error {
+	templatePaths := []string{}
+	err := filepath.WalkDir(startDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ".tmpl") {
+			templatePaths = append(templatePaths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	services := []string{}
+	certNames := map[string][]string{}
+	certServices := map[string]string{}
+	templateServices := map[string]string{}
+	templateText := map[string]string{}
+	lookups := []templateLookup{}
+	for _, templatePath := range templatePaths {
+		relPath, err := filepath.Rel(startDir, templatePath)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(relPath), "/")
+		if len(parts) < 2 {
+			continue
+		}
+		project, service, file := parts[0], parts[1], strings.Join(parts[2:], "/")
+		if len(parts) == 2 {
+			// Such as Common/cert.crt.mf.tmpl
+			file = parts[1]
+			if dot := strings.Index(service, "."); dot > 0 {
+				service = service[:dot]
+			}
+		}
+		templateBytes, err := os.ReadFile(templatePath)
+		if err != nil {
+			return err
+		}
+		templateID := filepath.ToSlash(relPath)
+		templateText[templateID] = string(templateBytes)
+
+		tmpl, err := template.New("template").Funcs(vcutils.TemplateFuncs(nil)).Parse(string(templateBytes))
+		if err != nil {
+			return fmt.Errorf("%s: %v", templatePath, err)
+		}
+		templateNode := g.AddNode(templateID, NODE_TEMPLATE)
+		templateNode.Output = strings.TrimSuffix(strings.TrimSuffix(file, ".tmpl"), ".mf")
+		defaults := map[string]string{}
+		walkPipes(tmpl.Tree.Root, func(cmds [][]string) {
+			for _, cmd := range cmds {
+				if len(cmd) == 3 && cmd[0] == "lookup" {
+					lookupParts := strings.Split(unquote(cmd[1]), "/")
+					if len(lookupParts) >= 2 {
+						lookups = append(lookups, templateLookup{templateID, lookupParts[1], unquote(cmd[2])})
+					}
+				}
+			}
+			args := extract.SeedArgs(cmds)
+			switch {
+			case len(args) == 3 && strings.HasPrefix(args[1], "."):
+				key := args[1][1:]
+				defaults[key] = unquote(args[2])
+				g.AddEdge(templateID, g.AddKey("values/"+service, key), EDGE_USES)
+			case len(args) == 1 && strings.HasPrefix(args[0], "."):
+				key := args[0][1:]
+				if key == "certData" {
+					templateNode.Kind = NODE_CERT
+				}
+				g.AddEdge(templateID, g.AddKey("super-secrets/"+service, key), EDGE_USES)
+			}
+		})
+
+		if templateNode.Kind == NODE_CERT {
+			names := []string{templateNode.Output}
+			for _, certKey := range certKeys {
+				if len(defaults[certKey]) > 0 {
+					names = append(names, defaults[certKey])
+				}
+			}
+			if len(defaults["certDestPath"]) > 0 {
+				templateNode.Output = defaults["certDestPath"]
+			}
+			certNames[templateID] = names
+			certServices[templateID] = project + "/" + service
+			continue
+		}
+		serviceID := project + "/" + service
+		templateServices[templateID] = serviceID
+		serviceNode := g.AddNode(serviceID, NODE_SERVICE)
+		if strings.HasPrefix(file, "deploy/") {
+			serviceNode.Deployed = true
+		}
+		g.AddEdge(serviceID, templateID, EDGE_RENDERS)
+		if !contains(services, serviceID) {
+			services = append(services, serviceID)
+		}
+	}
+
+	certIDs := make([]string, 0, len(certNames))
+	for certID := range certNames {
+		certIDs = append(certIDs, certID)
+	}
+	sort.Strings(certIDs)
+	for _, certID := range certIDs {
+		bundles := map[string]bool{certServices[certID]: true}
+		for templateID, text := range templateText {
+			if templateID == certID || g.Nodes[templateID].Kind == NODE_CERT {
+				continue
+			}
+			for _, name := range certNames[certID] {
+				if strings.Contains(text, name) {
+					g.AddEdge(templateID, certID, EDGE_REFERENCES)
+					bundles[templateServices[templateID]] = true
+					break
+				}
+			}
+		}
+		for _, serviceID := range services {
+			if bundles[serviceID] {
+				g.AddEdge(serviceID, certID, EDGE_BUNDLES)
+			}
+		}
+	}
+
+	for _, lookup := range lookups {
+		g.addLookup(lookup)
+	}
+	return nil
+}
+
+// addLookup links a lookup to the keys of the other service, wherever they
+// are stored.
+func (g *Graph) addLookup(lookup templateLookup) {
+	found := false
+	for _, bucket := range []string{"values/", "super-secrets/"} {
+		keyID := bucket + lookup.service + "." + lookup.key
+		if _, ok := g.Nodes[keyID]; ok {
+			g.AddEdge(lookup.templateID, keyID, EDGE_LOOKUP)
+			found = true
+		}
+	}
+	if !found {
+		g.AddEdge(lookup.templateID, g.AddKey("values/"+lookup.service, lookup.key), EDGE_LOOKUP)
+	}
+}
+
+// walkPipes visits the commands of each action evaluated against the
+// template's values.  The bodies of range and with are skipped as they move
+// dot elsewhere.
+func walkPipes(node parse.Node, visit func(cmds [][]string)) {
+	visitPipe := func(pipe *parse.PipeNode) {
+		if pipe == nil {
+			return
+		}
+		cmds := [][]string{}
+		for _, cmd := range pipe.Cmds {
+			args := []string{}
+			for _, arg := range cmd.Args {
+				args = append(args, strings.ReplaceAll(arg.String(), "\\\"", "\""))
+			}
+			cmds = append(cmds, args)
+		}
+		visit(cmds)
+	}
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkPipes(child, visit)
+		}
+	case *parse.ActionNode:
+		visitPipe(n.Pipe)
+	case *parse.IfNode:
+		visitPipe(n.Pipe)
+		walkPipes(n.List, visit)
+		walkPipes(n.ElseList, visit)
+	case *parse.RangeNode:
+		visitPipe(n.Pipe)
+	case *parse.WithNode:
+		visitPipe(n.Pipe)
+	}
+}
+
+func unquote(arg string) string {
+	if len(arg) >= 2 && strings.HasPrefix(arg, "\"") && strings.HasSuffix(arg, "\"") {
+		return arg[1 : len(arg)-1]
+	}
+	return arg
+}
+
+// VaultTemplates lists and reads template metadata, as *kv.Modifier does.
+type VaultTemplates interface {
+	List(path string, logger *log.Logger) (*api.Secret, error)
+	ReadData(path string) (map[string]interface{}, error)
+}
+
+// IndexVault adds the keys each template in vault links to.  Vault records
+// where every key of a template is stored, such as super-secrets/Common for
+// shared values, so these replace the keys guessed from the .tmpl files.
+func IndexVault(g *Graph, mod VaultTemplates, logger *log.Logger) error {
+	templatePaths, err := listTemplates(mod, "templates/", logger)
+	if err != nil {
+		return err
+	}
+	for _, templatePath := range templatePaths {
+		vaultID := strings.TrimSuffix(strings.TrimPrefix(templatePath, "templates/"), "/")
+		parts := strings.Split(vaultID, "/")
+		if len(parts) < 2 {
+			continue
+		}
+		links, err := mod.ReadData(strings.TrimSuffix(templatePath, "/"))
+		if err != nil {
+			return err
+		}
+
+		templateIDs := []string{}
+		for _, node := range g.sortedNodes(NODE_TEMPLATE, NODE_CERT) {
+			if trimTemplateID(node.ID) == vaultID {
+				templateIDs = append(templateIDs, node.ID)
+			}
+		}
+		if len(templateIDs) == 0 {
+			// Only in vault.
+			templateIDs = append(templateIDs, vaultID)
+			g.AddNode(vaultID, NODE_TEMPLATE).Output = parts[len(parts)-1]
+			if len(parts) >= 3 {
+				serviceID := parts[0] + "/" + parts[1]
+				g.AddNode(serviceID, NODE_SERVICE)
+				g.AddEdge(serviceID, vaultID, EDGE_RENDERS)
+			}
+		}
+
+		for _, templateID := range templateIDs {
+			g.removeEdges(templateID, EDGE_USES)
+			for key, link := range links {
+				linkParts, ok := link.([]interface{})
+				if !ok || len(linkParts) < 2 {
+					continue
+				}
+				vaultPath, pathOk := linkParts[0].(string)
+				linkKey, keyOk := linkParts[1].(string)
+				if !pathOk || !keyOk {
+					return fmt.Errorf("%s: unexpected link for %s", templatePath, key)
+				}
+				g.AddEdge(templateID, g.AddKey(vaultPath, linkKey), EDGE_USES)
+			}
+		}
+	}
+	g.prune()
+	return nil
+}
+
+func listTemplates(mod VaultTemplates, path string, logger *log.Logger) ([]string, error) {
+	secret, err := mod.List(path, logger)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	templatePaths := []string{}
+	for _, keyInterface := range keys {
+		key, _ := keyInterface.(string)
+		switch {
+		case key == "template-file":
+			templatePaths = append(templatePaths, path)
+		case strings.HasSuffix(key, "/"):
+			subPaths, err := listTemplates(mod, path+key, logger)
+			if err != nil {
+				return nil, err
+			}
+			templatePaths = append(templatePaths, subPaths...)
+		}
+	}
+	return templatePaths, nil
+}
+
+// removeEdges drops the edges of kind leaving from.
+func (g *Graph) removeEdges(from string, kind string) {
+	edges := g.Edges[:0]
+	for _, edge := range g.Edges {
+		if edge.From == from && edge.Kind == kind {
+			delete(g.edgeSet, edge)
+			incoming := g.incoming[edge.To][:0]
+			for _, in := range g.incoming[edge.To] {
+				if in != edge {
+					incoming = append(incoming, in)
+				}
+			}
+			g.incoming[edge.To] = incoming
+			continue
+		}
+		edges = append(edges, edge)
+	}
+	g.Edges = edges
+}
+
+// prune drops the keys nothing uses any longer, and vault paths left
+// without keys.
+func (g *Graph) prune() {
+	for _, kind := range []string{NODE_KEY, NODE_PATH} {
+		for _, node := range g.sortedNodes(kind) {
+			if len(g.incoming[node.ID]) > 0 {
+				continue
+			}
+			g.removeEdges(node.ID, EDGE_STORED)
+			delete(g.Nodes, node.ID)
+			delete(g.incoming, node.ID)
+		}
+	}
+}
@@ -163,6 +163,49 @@ func AutoAuth(driverConfig *config.DriverConfig,
 		return nil
 	}
 	var err error
+	var fromProfile bool
+	// The active context profile supplies credentials for its own vault.
+	if !driverConfig.CoreConfig.IsShell &&
+		!driverConfig.IsShellSubProcess &&
+		!RefEquals(appRoleConfigPtr, "deployauth") &&
+		!RefEquals(appRoleConfigPtr, "hivekernel") {
+		profile, profileErr := ActiveContextProfile()
+		if profileErr != nil {
+			return profileErr
+		}
+		if profile != nil && addrPtr != nil && profile.ProvidesAddr(*addrPtr) {
+			if len(*addrPtr) == 0 {
+				*addrPtr = profile.VaultHost
+			}
+			switch profile.Auth {
+			case CONTEXT_AUTH_APPROLE:
+				// Other approle configs (pub, deploy) keep their own credentials.
+				if (RefLength(appRoleConfigPtr) == 0 || RefEquals(appRoleConfigPtr, "config.yml")) &&
+					RefLength(secretIDPtr) == 0 && RefLength(appRoleIDPtr) == 0 &&
+					secretIDPtr != nil && appRoleIDPtr != nil {
+					*secretIDPtr = profile.SecretID
+					*appRoleIDPtr = profile.ApproleID
+					fromProfile = true
+				}
+			case CONTEXT_AUTH_TOKEN, CONTEXT_AUTH_OIDC, CONTEXT_AUTH_EXEC:
+				if len(*addrPtr) == 0 {
+					return fmt.Errorf("context %s has no vaultHost", profile.Name)
+				}
+				LogInfo(driverConfig.CoreConfig, fmt.Sprintf("Obtaining auth credentials from context %s.", profile.Name))
+				profileTokenPtr, tokenErr := profile.VaultToken(driverConfig, *addrPtr, *envPtr)
+				if tokenErr != nil {
+					return tokenErr
+				}
+				if RefLength(wantedTokenNamePtr) > 0 {
+					driverConfig.CoreConfig.CurrentTokenNamePtr = wantedTokenNamePtr
+					driverConfig.CoreConfig.TokenCache.AddToken(*wantedTokenNamePtr, profileTokenPtr)
+				}
+				*tokenProvidedPtr = profileTokenPtr
+				LogInfo(driverConfig.CoreConfig, "Auth credentials obtained.")
+				return nil
+			}
+		}
+	}
 	// Get current user's home directory
 	userHome, err := userHome(driverConfig.CoreConfig.Log)
 	if err != nil {
@@ -309,7 +352,7 @@ func AutoAuth(driverConfig *config.DriverConfig,
 
 			dump = []byte(certConfigData)
 		} else if (override && !exists) || RefEquals(appRoleConfigPtr, "deployauth") || RefEquals(appRoleConfigPtr, "hivekernel") {
-			if !driverConfig.CoreConfig.IsShell {
+			if !driverConfig.CoreConfig.IsShell && !fromProfile {
 				LogInfo(driverConfig.CoreConfig, "No approle file exists, continuing without saving config IDs")
 			}
 		} else {
@@ -326,7 +369,7 @@ func AutoAuth(driverConfig *config.DriverConfig,
 		}
 
 		// Do not save IDs if overriding and no approle file exists
-		if !isProd && (!override || exists) && !RefEquals(appRoleConfigPtr, "deployauth") && !RefEquals(appRoleConfigPtr, "hivekernel") {
+		if !isProd && !fromProfile && (!override || exists) && !RefEquals(appRoleConfigPtr, "deployauth") && !RefEquals(appRoleConfigPtr, "hivekernel") {
 
 			// Create hidden folder
 			if _, err := os.Stat(userHome + "/.tierceron"); os.IsNotExist(err) {
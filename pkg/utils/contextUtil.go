@@ -0,0 +1,462 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/utils/config"
+	sys "github.com/trimble-oss/tierceron/pkg/vaulthelper/system"
+
+	"gopkg.in/yaml.v2"
+)
+
+const contextsConfig = "/.tierceron/contexts.yml"
+const contextTokensDir = "/.tierceron/tokens/"
+
+// CONTEXT_ENV_VAR names a context profile to use instead of the current one.
+const CONTEXT_ENV_VAR = "TRC_CONTEXT"
+
+// Context profile auth methods.
+const (
+	CONTEXT_AUTH_TOKEN   = "token"
+	CONTEXT_AUTH_APPROLE = "approle"
+	CONTEXT_AUTH_OIDC    = "oidc"
+	CONTEXT_AUTH_EXEC    = "exec"
+)
+
+const defaultJWTMount = "jwt"
+const credentialHelperTimeout = 2 * time.Minute
+
+// ContextProfile is a named set of defaults for the command line tools, much
+// like a kubeconfig context.  Flags given on the command line always win over
+// the profile.
+type ContextProfile struct {
+	Name      string `yaml:"-"`
+	VaultHost string `yaml:"vaultHost,omitempty"`
+	Env       string `yaml:"env,omitempty"`
+	Region    string `yaml:"region,omitempty"`
+	Auth      string `yaml:"auth,omitempty"` // token, approle, oidc or exec
+
+	Token     string `yaml:"token,omitempty"`
+	ApproleID string `yaml:"approleID,omitempty"`
+	SecretID  string `yaml:"secretID,omitempty"`
+
+	// OIDC device flow, exchanged for a vault token at the jwt auth mount.
+	OIDCIssuer   string `yaml:"oidcIssuer,omitempty"`
+	OIDCClientID string `yaml:"oidcClientID,omitempty"`
+	OIDCScopes   string `yaml:"oidcScopes,omitempty"`
+	OIDCRole     string `yaml:"oidcRole,omitempty"`
+	JWTMount     string `yaml:"jwtMount,omitempty"`
+
+	// Credential helper printing a vault token, or {"token": "..."}, to stdout.
+	Command []string `yaml:"command,omitempty"`
+
+	Project string `yaml:"project,omitempty"`
+	Service string `yaml:"service,omitempty"`
+}
+
+// ContextProfiles holds the profiles stored in ~/.tierceron/contexts.yml.
+type ContextProfiles struct {
+	Current  string                     `yaml:"current,omitempty"`
+	Contexts map[string]*ContextProfile `yaml:"contexts,omitempty"`
+}
+
+func contextsPath() (string, error) {
+	dirname, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return dirname + contextsConfig, nil
+}
+
+// LoadContextProfiles reads the user's context profiles.  A missing profile
+// file yields no profiles.
+func LoadContextProfiles() (*ContextProfiles, error) {
+	profiles := &ContextProfiles{Contexts: map[string]*ContextProfile{}}
+	path, err := contextsPath()
+	if err != nil {
+		return nil, err
+	}
+	profileData, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return profiles, nil
+		}
+		return nil, err
+	}
+	if err := yaml.Unmarshal(profileData, profiles); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", path, err)
+	}
+	if profiles.Contexts == nil {
+		profiles.Contexts = map[string]*ContextProfile{}
+	}
+	for name, profile := range profiles.Contexts {
+		if profile == nil {
+			profile = &ContextProfile{}
+			profiles.Contexts[name] = profile
+		}
+		profile.Name = name
+	}
+	return profiles, nil
+}
+
+// Save writes the profiles back to the user's home directory.
+func (cp *ContextProfiles) Save() error {
+	path, err := contextsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path[:strings.LastIndex(path, "/")], 0700); err != nil {
+		return err
+	}
+	profileData, err := yaml.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, profileData, 0600)
+}
+
+// Names returns the profile names in order.
+func (cp *ContextProfiles) Names() []string {
+	names := make([]string, 0, len(cp.Contexts))
+	for name := range cp.Contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set adds or replaces a profile.
+func (cp *ContextProfiles) Set(profile *ContextProfile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+	cp.Contexts[profile.Name] = profile
+	return nil
+}
+
+// Use makes the named profile the current one.
+func (cp *ContextProfiles) Use(name string) error {
+	if _, ok := cp.Contexts[name]; !ok {
+		return fmt.Errorf("no context named %s", name)
+	}
+	cp.Current = name
+	return nil
+}
+
+// Delete removes the named profile.
+func (cp *ContextProfiles) Delete(name string) error {
+	if _, ok := cp.Contexts[name]; !ok {
+		return fmt.Errorf("no context named %s", name)
+	}
+	delete(cp.Contexts, name)
+	if cp.Current == name {
+		cp.Current = ""
+	}
+	if path, err := contextTokenPath(name); err == nil {
+		os.Remove(path)
+	}
+	return nil
+}
+
+// Validate checks the profile has what its auth method needs.
+func (p *ContextProfile) Validate() error {
+	if len(p.Name) == 0 || strings.ContainsAny(p.Name, "/\\ ") {
+		return fmt.Errorf("invalid context name '%s'", p.Name)
+	}
+	if len(p.VaultHost) > 0 && !strings.HasPrefix(p.VaultHost, "https://") {
+		return fmt.Errorf("context %s vaultHost must be an https address", p.Name)
+	}
+	switch p.Auth {
+	case "":
+	case CONTEXT_AUTH_TOKEN:
+		if len(p.Token) == 0 {
+			return fmt.Errorf("context %s uses token auth but has no token", p.Name)
+		}
+	case CONTEXT_AUTH_APPROLE:
+		if len(p.ApproleID) == 0 || len(p.SecretID) == 0 {
+			return fmt.Errorf("context %s uses approle auth but is missing approleID or secretID", p.Name)
+		}
+	case CONTEXT_AUTH_OIDC:
+		if len(p.OIDCIssuer) == 0 || len(p.OIDCClientID) == 0 || len(p.OIDCRole) == 0 {
+			return fmt.Errorf("context %s uses oidc auth but is missing oidcIssuer, oidcClientID or oidcRole", p.Name)
+		}
+	case CONTEXT_AUTH_EXEC:
+		if len(p.Command) == 0 {
+			return fmt.Errorf("context %s uses exec auth but has no command", p.Name)
+		}
+	default:
+		return fmt.Errorf("context %s has unsupported auth '%s' (expected token, approle, oidc or exec)", p.Name, p.Auth)
+	}
+	return nil
+}
+
+// ActiveContextProfile returns the profile named by TRC_CONTEXT, otherwise the
+// current profile.  It returns nil if neither is set.
+func ActiveContextProfile() (*ContextProfile, error) {
+	profiles, err := LoadContextProfiles()
+	if err != nil {
+		return nil, err
+	}
+	name := os.Getenv(CONTEXT_ENV_VAR)
+	if len(name) == 0 {
+		name = profiles.Current
+	}
+	if len(name) == 0 {
+		return nil, nil
+	}
+	profile, ok := profiles.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("no context named %s", name)
+	}
+	return profile, nil
+}
+
+// ApplyContextProfile fills in the flags the active profile has values for,
+// unless they were given on the command line.  Flags the tool does not define
+// are left alone.
+func ApplyContextProfile(flagset *flag.FlagSet) error {
+	profile, err := ActiveContextProfile()
+	if err != nil || profile == nil {
+		return err
+	}
+	explicit := map[string]bool{}
+	flagset.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for name, value := range map[string]string{
+		"addr":           profile.VaultHost,
+		"env":            profile.Env,
+		"region":         profile.Region,
+		"templateFilter": profile.Project,
+		"servicesWanted": profile.Service,
+	} {
+		if len(value) > 0 && !explicit[name] && flagset.Lookup(name) != nil {
+			flagset.Set(name, value)
+		}
+	}
+	return nil
+}
+
+// ProvidesAddr reports whether the profile's credentials are for addr.
+func (p *ContextProfile) ProvidesAddr(addr string) bool {
+	return len(addr) == 0 || len(p.VaultHost) == 0 ||
+		strings.EqualFold(strings.TrimSuffix(addr, "/"), strings.TrimSuffix(p.VaultHost, "/"))
+}
+
+// VaultToken obtains a vault token using the profile's token, oidc or exec
+// auth.
+func (p *ContextProfile) VaultToken(driverConfig *config.DriverConfig, addr string, env string) (*string, error) {
+	switch p.Auth {
+	case CONTEXT_AUTH_TOKEN:
+		token := p.Token
+		return &token, nil
+	case CONTEXT_AUTH_EXEC:
+		return p.execToken(addr, env)
+	case CONTEXT_AUTH_OIDC:
+		return p.oidcToken(driverConfig, addr, env)
+	}
+	return nil, fmt.Errorf("context %s auth '%s' does not provide a token", p.Name, p.Auth)
+}
+
+func (p *ContextProfile) execToken(addr string, env string) (*string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Env = append(os.Environ(), CONTEXT_ENV_VAR+"="+p.Name, "VAULT_ADDR="+addr, "TRC_ENV="+env)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential helper for context %s failed: %v", p.Name, err)
+	}
+	token := strings.TrimSpace(string(out))
+	if strings.HasPrefix(token, "{") {
+		var helperOutput struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal([]byte(token), &helperOutput); err != nil {
+			return nil, fmt.Errorf("credential helper for context %s returned invalid json: %v", p.Name, err)
+		}
+		token = helperOutput.Token
+	}
+	if len(token) == 0 {
+		return nil, fmt.Errorf("credential helper for context %s returned no token", p.Name)
+	}
+	return &token, nil
+}
+
+type contextToken struct {
+	Token   string    `yaml:"token"`
+	Expires time.Time `yaml:"expires"`
+}
+
+func contextTokenPath(name string) (string, error) {
+	dirname, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return dirname + contextTokensDir + name + ".yml", nil
+}
+
+// oidcToken returns the vault token from the last device flow login if it is
+// still good, otherwise it logs in again.
+func (p *ContextProfile) oidcToken(driverConfig *config.DriverConfig, addr string, env string) (*string, error) {
+	tokenPath, err := contextTokenPath(p.Name)
+	if err != nil {
+		return nil, err
+	}
+	if tokenData, err := os.ReadFile(tokenPath); err == nil {
+		var cached contextToken
+		if yaml.Unmarshal(tokenData, &cached) == nil && len(cached.Token) > 0 && time.Now().Add(time.Minute).Before(cached.Expires) {
+			return &cached.Token, nil
+		}
+	}
+
+	idToken, err := p.deviceFlow()
+	if err != nil {
+		return nil, err
+	}
+	v, err := sys.NewVault(driverConfig.CoreConfig.Insecure, &addr, env, false, false, false, driverConfig.CoreConfig.Log)
+	if v != nil {
+		defer v.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	mount := p.JWTMount
+	if len(mount) == 0 {
+		mount = defaultJWTMount
+	}
+	token, ttl, err := v.JWTLogin(mount, p.OIDCRole, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl > 0 {
+		if err := os.MkdirAll(tokenPath[:strings.LastIndex(tokenPath, "/")], 0700); err == nil {
+			if tokenData, err := yaml.Marshal(contextToken{Token: *token, Expires: time.Now().Add(ttl)}); err == nil {
+				os.WriteFile(tokenPath, tokenData, 0600)
+			}
+		}
+	}
+	return token, nil
+}
+
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+	Error                   string `json:"error"`
+}
+
+type deviceToken struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// deviceFlow signs the user in with the OAuth 2.0 device authorization grant
+// (RFC 8628) and returns their id token.
+func (p *ContextProfile) deviceFlow() (string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	var discovery struct {
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+		TokenEndpoint               string `json:"token_endpoint"`
+	}
+	response, err := client.Get(strings.TrimSuffix(p.OIDCIssuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	err = json.NewDecoder(response.Body).Decode(&discovery)
+	response.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("unable to read openid configuration for %s: %v", p.OIDCIssuer, err)
+	}
+	if len(discovery.DeviceAuthorizationEndpoint) == 0 || len(discovery.TokenEndpoint) == 0 {
+		return "", fmt.Errorf("%s does not support the device authorization flow", p.OIDCIssuer)
+	}
+
+	scopes := "openid"
+	if len(p.OIDCScopes) > 0 {
+		scopes = scopes + " " + strings.Join(strings.FieldsFunc(p.OIDCScopes, func(r rune) bool { return r == ',' || r == ' ' }), " ")
+	}
+	var authorization deviceAuthorization
+	if err := postForm(client, discovery.DeviceAuthorizationEndpoint, url.Values{
+		"client_id": {p.OIDCClientID},
+		"scope":     {scopes},
+	}, &authorization); err != nil {
+		return "", err
+	}
+	if len(authorization.DeviceCode) == 0 {
+		return "", fmt.Errorf("device authorization failed: %s", authorization.Error)
+	}
+
+	verificationURI := authorization.VerificationURIComplete
+	if len(verificationURI) == 0 {
+		verificationURI = authorization.VerificationURI
+	}
+	fmt.Fprintf(os.Stderr, "To sign in for context %s, open %s and enter code %s\n", p.Name, verificationURI, authorization.UserCode)
+
+	interval := time.Duration(authorization.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiresIn := time.Duration(authorization.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 10 * time.Minute
+	}
+	deadline := time.Now().Add(expiresIn)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		var token deviceToken
+		if err := postForm(client, discovery.TokenEndpoint, url.Values{
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {authorization.DeviceCode},
+			"client_id":   {p.OIDCClientID},
+		}, &token); err != nil {
+			return "", err
+		}
+		switch token.Error {
+		case "":
+			if len(token.IDToken) == 0 {
+				return "", errors.New("identity provider did not return an id token")
+			}
+			return token.IDToken, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval = interval + 5*time.Second
+		default:
+			return "", fmt.Errorf("device authorization failed: %s %s", token.Error, token.ErrorDescription)
+		}
+	}
+	return "", errors.New("device authorization expired")
+}
+
+// postForm posts the form and decodes the json response, which carries the
+// oauth error when the request is refused.
+func postForm(client *http.Client, endpoint string, form url.Values, out interface{}) error {
+	response, err := client.PostForm(endpoint, form)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if err := json.NewDecoder(response.Body).Decode(out); err != nil {
+		return fmt.Errorf("unexpected response from %s (%s): %v", endpoint, response.Status, err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestContextProfiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(CONTEXT_ENV_VAR, "")
+
+	profiles, err := LoadContextProfiles()
+	if err != nil || len(profiles.Contexts) != 0 {
+		t.Fatalf("Expected no profiles, got %v %v", profiles, err)
+	}
+	if err := profiles.Set(&ContextProfile{Name: "dev", Auth: CONTEXT_AUTH_TOKEN}); err == nil {
+		t.Fatal("Expected token auth without a token to be rejected")
+	}
+	if err := profiles.Set(&ContextProfile{Name: "dev", VaultHost: "https://vault.dev:8200", Env: "dev", Project: "Common,Spectrum", Auth: CONTEXT_AUTH_EXEC, Command: []string{"echo", `{"token": "hvs.dev"}`}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := profiles.Set(&ContextProfile{Name: "qa", VaultHost: "https://vault.qa:8200", Env: "QA"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := profiles.Use("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err := profiles.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	flagset := flag.NewFlagSet("test", flag.ContinueOnError)
+	envPtr := flagset.String("env", "dev", "")
+	addrPtr := flagset.String("addr", "", "")
+	filterPtr := flagset.String("templateFilter", "", "")
+	flagset.Parse([]string{"-env=RQA"})
+	if err := ApplyContextProfile(flagset); err != nil {
+		t.Fatal(err)
+	}
+	if *envPtr != "RQA" || *addrPtr != "https://vault.dev:8200" || *filterPtr != "Common,Spectrum" {
+		t.Fatalf("Unexpected flags env=%s addr=%s templateFilter=%s", *envPtr, *addrPtr, *filterPtr)
+	}
+
+	t.Setenv(CONTEXT_ENV_VAR, "qa")
+	profile, err := ActiveContextProfile()
+	if err != nil || profile.Name != "qa" || !profile.ProvidesAddr("https://vault.qa:8200/") || profile.ProvidesAddr("https://vault.dev:8200") {
+		t.Fatalf("Expected the qa profile, got %v %v", profile, err)
+	}
+	t.Setenv(CONTEXT_ENV_VAR, "")
+
+	profiles, _ = LoadContextProfiles()
+	token, err := profiles.Contexts["dev"].VaultToken(nil, *addrPtr, *envPtr)
+	if err != nil || *token != "hvs.dev" {
+		t.Fatalf("Expected token from credential helper, got %v", err)
+	}
+	if err := profiles.Delete("dev"); err != nil || profiles.Current != "" {
+		t.Fatalf("Expected dev to be deleted and no longer current, got %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// StartRun starts a telemetry run within coreConfig's run, which the vault
+// calls of modifiers made from coreConfig join until end is called.  Each
+// config carries its own run, so concurrent deployments don't share one.
+func StartRun(coreConfig *core.CoreConfig, name string, attrs ...attribute.KeyValue) (end func(err error)) {
+	parentCtx := coreConfig.RunCtx
+	runCtx, runSpan := telemetry.StartRun(parentCtx, name, attrs...)
+	coreConfig.RunCtx = runCtx
+	return func(err error) {
+		coreConfig.RunCtx = parentCtx
+		telemetry.End(runSpan, err)
+	}
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestStartRun(t *testing.T) {
+	shutdown, err := telemetry.Init("trctest", &telemetry.TelemetryConfig{File: t.TempDir() + "/otel.json"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	// Concurrent deployments each keep their own run.
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			coreConfig := &core.CoreConfig{}
+			endRun := StartRun(coreConfig, "trcsh")
+			runCtx := coreConfig.RunCtx
+			for step := 0; step < 10; step++ {
+				endStep := StartRun(coreConfig, "trcsh.deploy.step")
+				if trace.SpanContextFromContext(coreConfig.RunCtx).TraceID() != trace.SpanContextFromContext(runCtx).TraceID() {
+					t.Error("Expected the step part of its own deployment's run")
+				}
+				endStep(nil)
+				if coreConfig.RunCtx != runCtx {
+					t.Error("Expected the run restored after the step")
+				}
+			}
+			endRun(nil)
+			if coreConfig.RunCtx != nil {
+				t.Error("Expected no run once ended")
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,196 @@
+package validator
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+	pkcs "golang.org/x/crypto/pkcs12"
+)
+
+const jksMagic = 0xfeedfeed
+
+var ErrNoCertificates = errors.New("no certificates found")
+
+// CertificateInfo describes a single certificate of a cert bundle.
+type CertificateInfo struct {
+	Subject    string    `json:"subject" yaml:"subject"`
+	Issuer     string    `json:"issuer" yaml:"issuer"`
+	SANs       []string  `json:"sans,omitempty" yaml:"sans,omitempty"`
+	Serial     string    `json:"serial" yaml:"serial"`
+	NotBefore  time.Time `json:"notBefore" yaml:"notBefore"`
+	NotAfter   time.Time `json:"notAfter" yaml:"notAfter"`
+	IsCA       bool      `json:"isCA,omitempty" yaml:"isCA,omitempty"`
+	ChainValid bool      `json:"chainValid" yaml:"chainValid"`
+	ChainError string    `json:"chainError,omitempty" yaml:"chainError,omitempty"`
+}
+
+// ParseCertificates returns every certificate found in a pem, der, pfx or jks
+// bundle.  Private keys are ignored.  Trusted entries of a jks are readable
+// without its password, private key entries are only read if the password is
+// correct.
+func ParseCertificates(certBytes []byte, password string) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(certBytes); block != nil {
+		return parsePemCertificates(certBytes)
+	}
+	if len(certBytes) > 4 && binary.BigEndian.Uint32(certBytes[:4]) == jksMagic {
+		return parseJksCertificates(certBytes, password)
+	}
+	if certs, err := x509.ParseCertificates(certBytes); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	if isPfx, _ := IsPfxRfc7292(certBytes); isPfx {
+		pemBlocks, err := pkcs.ToPEM(certBytes, password)
+		if err != nil {
+			return nil, errors.New("failed to parse pfx: " + err.Error())
+		}
+		certs := []*x509.Certificate{}
+		for _, pemBlock := range pemBlocks {
+			if pemBlock.Type != certificateType {
+				continue
+			}
+			cert, err := x509.ParseCertificate(pemBlock.Bytes)
+			if err != nil {
+				return nil, errors.New("failed to parse certificate: " + err.Error())
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			return nil, ErrNoCertificates
+		}
+		return certs, nil
+	}
+	return nil, errors.New("unrecognized certificate format")
+}
+
+func parsePemCertificates(certBytes []byte) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	rest := certBytes
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != certificateType {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.New("failed to parse certificate: " + err.Error())
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, ErrNoCertificates
+	}
+	return certs, nil
+}
+
+func parseJksCertificates(certBytes []byte, password string) ([]*x509.Certificate, error) {
+	ks := keystore.New()
+	// Entries are loaded before the digest is checked, so a wrong password
+	// still leaves the trusted certificates readable.
+	loadErr := ks.Load(bytes.NewReader(certBytes), []byte(password))
+	aliases := ks.Aliases()
+	if loadErr != nil && len(aliases) == 0 {
+		return nil, errors.New("failed to load keystore: " + loadErr.Error())
+	}
+	certs := []*x509.Certificate{}
+	for _, alias := range aliases {
+		ksCerts := []keystore.Certificate{}
+		if ks.IsTrustedCertificateEntry(alias) {
+			entry, err := ks.GetTrustedCertificateEntry(alias)
+			if err != nil {
+				continue
+			}
+			ksCerts = append(ksCerts, entry.Certificate)
+		} else if ks.IsPrivateKeyEntry(alias) {
+			entry, err := ks.GetPrivateKeyEntry(alias, []byte(password))
+			if err != nil {
+				continue
+			}
+			ksCerts = append(ksCerts, entry.CertificateChain...)
+		}
+		for _, ksCert := range ksCerts {
+			cert, err := x509.ParseCertificate(ksCert.Content)
+			if err != nil {
+				return nil, errors.New("failed to parse certificate: " + err.Error())
+			}
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		if loadErr != nil {
+			return nil, errors.New("failed to load keystore: " + loadErr.Error())
+		}
+		return nil, ErrNoCertificates
+	}
+	return certs, nil
+}
+
+// DescribeCertificates describes each certificate of a bundle.  The chain of
+// each certificate is verified against the system roots, using the rest of the
+// bundle as intermediates and any self signed certificates of the bundle as
+// additional roots.
+func DescribeCertificates(certs []*x509.Certificate) []CertificateInfo {
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs {
+		if isSelfSigned(cert) {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	infos := []CertificateInfo{}
+	for _, cert := range certs {
+		info := CertificateInfo{
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			SANs:      certificateSANs(cert),
+			Serial:    cert.SerialNumber.Text(16),
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			IsCA:      cert.IsCA,
+		}
+		_, verifyErr := cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   time.Now(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if verifyErr != nil {
+			info.ChainError = verifyErr.Error()
+		} else {
+			info.ChainValid = true
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func certificateSANs(cert *x509.Certificate) []string {
+	sans := []string{}
+	sans = append(sans, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
@@ -0,0 +1,92 @@
+package validator
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pavlo-v-chernykh/keystore-go/v4"
+)
+
+func newTestCert(t *testing.T, cn string, serial int64, notAfter time.Time, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.DNSNames = []string{cn}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestParseAndDescribeCertificates(t *testing.T) {
+	ca, caKey := newTestCert(t, "Test CA", 1, time.Now().Add(365*24*time.Hour), nil, nil)
+	leaf, _ := newTestCert(t, "service.example.com", 2, time.Now().Add(10*24*time.Hour), ca, caKey)
+
+	bundle := pem.EncodeToMemory(&pem.Block{Type: certificateType, Bytes: leaf.Raw})
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: certificateType, Bytes: ca.Raw})...)
+	certs, err := ParseCertificates(bundle, "")
+	if err != nil || len(certs) != 2 {
+		t.Fatalf("Expected 2 certificates from pem, got %d %v", len(certs), err)
+	}
+	infos := DescribeCertificates(certs)
+	if infos[0].Subject != "CN=service.example.com" || infos[0].Issuer != "CN=Test CA" || len(infos[0].SANs) != 1 || !infos[0].ChainValid {
+		t.Fatalf("Unexpected leaf description %+v", infos[0])
+	}
+	if !infos[1].IsCA || !infos[1].NotAfter.Equal(ca.NotAfter) {
+		t.Fatalf("Unexpected ca description %+v", infos[1])
+	}
+	if infos := DescribeCertificates([]*x509.Certificate{leaf}); infos[0].ChainValid {
+		t.Fatal("Expected chain without its issuer to be invalid")
+	}
+
+	if certs, err := ParseCertificates(leaf.Raw, ""); err != nil || len(certs) != 1 {
+		t.Fatalf("Expected der certificate, got %v", err)
+	}
+	keyOnly := pem.EncodeToMemory(&pem.Block{Type: privateKeyType, Bytes: []byte("not a cert")})
+	if _, err := ParseCertificates(keyOnly, ""); err != ErrNoCertificates {
+		t.Fatalf("Expected no certificates in key pem, got %v", err)
+	}
+
+	ks := keystore.New()
+	if err := ks.SetTrustedCertificateEntry("ca", keystore.TrustedCertificateEntry{
+		CreationTime: time.Now(),
+		Certificate:  keystore.Certificate{Type: "X509", Content: ca.Raw},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var jks bytes.Buffer
+	if err := ks.Store(&jks, []byte("changeit")); err != nil {
+		t.Fatal(err)
+	}
+	// Trusted entries are readable even without the keystore password.
+	if certs, err := ParseCertificates(jks.Bytes(), ""); err != nil || len(certs) != 1 || certs[0].Subject.CommonName != "Test CA" {
+		t.Fatalf("Expected ca from jks, got %v", err)
+	}
+}
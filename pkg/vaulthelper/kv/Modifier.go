@@ -20,8 +20,11 @@ import (
 	"github.com/trimble-oss/tierceron/buildopts/memonly"
 	"github.com/trimble-oss/tierceron/buildopts/memprotectopts"
 	"github.com/trimble-oss/tierceron/pkg/core"
+	"github.com/trimble-oss/tierceron/pkg/core/util/telemetry"
 
 	"github.com/hashicorp/vault/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -48,17 +51,20 @@ type Modifier struct {
 
 	Env             string // Environment (local/dev/QA; Initialized to secrets)
 	EnvBasis        string
-	Regions         []string // Supported regions
-	Version         string   // Version for data
-	VersionFilter   []string // Used to filter vault paths
-	TemplatePath    string   // Path to template we are processing.
-	ProjectIndex    []string // Which projects are indexed.
-	SectionKey      string   // The section key: Index or Restricted.
-	SectionName     string   // The name of the actual section.
-	SubSectionName  string   // The name of the actual subsection.
-	SubSectionValue string   // The actual value for the sub section.
-	SectionPath     string   // The path to the Index (both seed and vault)
-	Stale           bool     // If client is no longer usable, this will be true..
+	Regions         []string        // Supported regions
+	Version         string          // Version for data
+	VersionFilter   []string        // Used to filter vault paths
+	TemplatePath    string          // Path to template we are processing.
+	ProjectIndex    []string        // Which projects are indexed.
+	SectionKey      string          // The section key: Index or Restricted.
+	SectionName     string          // The name of the actual section.
+	SubSectionName  string          // The name of the actual subsection.
+	SubSectionValue string          // The actual value for the sub section.
+	SectionPath     string          // The path to the Index (both seed and vault)
+	Stale           bool            // If client is no longer usable, this will be true..
+	AuditActor      string          // Actor recorded in audit records, overriding the process default.
+	AuditReason     string          // Change reason recorded in audit records.
+	RunCtx          context.Context // Telemetry run this modifier's vault calls are part of.
 }
 
 type modCache struct {
@@ -97,9 +103,14 @@ func PreCheckEnvironment(environment string) (string, string, bool, error) {
 //
 //	Any errors generated in creating the client
 func NewModifierFromCoreConfig(coreConfig *core.CoreConfig, tokenName string, env string, useCache bool) (*Modifier, error) {
-	return NewModifier(coreConfig.Insecure,
+	mod, err := NewModifier(coreConfig.Insecure,
 		coreConfig.TokenCache.GetToken(tokenName),
 		coreConfig.VaultAddressPtr, env, coreConfig.Regions, useCache, coreConfig.Log)
+	if err != nil {
+		return nil, err
+	}
+	mod.RunCtx = coreConfig.RunCtx
+	return mod, nil
 }
 
 // NewModifier Constructs a new modifier struct and connects to the vault
@@ -192,6 +203,9 @@ func cachedModifierHelper(env string, addr string) (*Modifier, error) {
 
 // Release - releases the modifier back to the cache.
 func (m *Modifier) Release() {
+	m.AuditActor = ""
+	m.AuditReason = ""
+	m.RunCtx = nil
 	if m.Stale {
 		m.httpClient.CloseIdleConnections()
 		return
@@ -342,8 +356,35 @@ func (m *Modifier) ValidateEnvironment(environment string, init bool, policySuff
 //
 //	errors generated by writing
 func (m *Modifier) Write(path string, data map[string]interface{}, logger *log.Logger) ([]string, error) {
+	span := m.traceVault("write", path)
+	warnings, err := m.writeHelper(path, data, nil, true, logger)
+	telemetry.End(span, err)
+	return warnings, err
+}
+
+// WriteCAS writes data only if the path is still at the given version (check
+// and set).  A version of 0 only writes if nothing is there yet.
+func (m *Modifier) WriteCAS(path string, data map[string]interface{}, version int64, logger *log.Logger) ([]string, error) {
+	span := m.traceVault("write", path, attribute.Int64("vault.cas", version))
+	warnings, err := m.writeHelper(path, data, map[string]interface{}{"cas": version}, true, logger)
+	telemetry.End(span, err)
+	return warnings, err
+}
+
+// traceVault starts the span of a vault call, part of the modifier's tool run.
+func (m *Modifier) traceVault(operation string, path string, attrs ...attribute.KeyValue) trace.Span {
+	attrs = append(attrs, attribute.String("vault.path", path), attribute.String("vault.env", m.Env))
+	_, span := telemetry.Start(m.RunCtx, "vault."+operation, attrs...)
+	return span
+}
+
+// writeHelper writes data to path, auditing the change when audited.
+func (m *Modifier) writeHelper(path string, data map[string]interface{}, options map[string]interface{}, audited bool, logger *log.Logger) ([]string, error) {
 	// Wrap data and send
 	sendData := map[string]interface{}{"data": data}
+	if options != nil {
+		sendData["options"] = options
+	}
 
 	// Create full path
 	pathBlocks := strings.SplitAfterN(path, "/", 2)
@@ -392,6 +433,14 @@ retryQuery:
 	if Secret == nil { // No warnings
 		return nil, err
 	}
+	if err == nil && audited && auditEnabled() {
+		version := versionNumber(Secret.Data["version"])
+		previousVersion := version - 1
+		if previousVersion < 0 {
+			previousVersion = 0
+		}
+		m.audit(AUDIT_WRITE, fullPath, auditKeys(data), previousVersion, version)
+	}
 	return Secret.Warnings, err
 }
 
@@ -400,6 +449,13 @@ retryQuery:
 //
 //	errors generated from reading
 func (m *Modifier) ReadData(path string) (map[string]interface{}, error) {
+	span := m.traceVault("read", path)
+	data, err := m.readData(path)
+	telemetry.End(span, err)
+	return data, err
+}
+
+func (m *Modifier) readData(path string) (map[string]interface{}, error) {
 	bucket := path
 	// Create full path
 	if len(m.SectionPath) > 0 && !strings.HasPrefix(path, "templates") && !strings.HasPrefix(path, "value-metrics") { //Template paths are not indexed -> values & super-secrets are
@@ -532,6 +588,59 @@ retryVaultAccess:
 	return nil, errors.New("could not get data from vault response")
 }
 
+// ReadDataVersion reads the most recent data at the path, without regard to
+// SectionPath, along with its version for use with WriteCAS.  A path with no
+// data is returned as empty at version 0.
+func (m *Modifier) ReadDataVersion(path string) (map[string]interface{}, int64, error) {
+	span := m.traceVault("read", path)
+	data, version, err := m.readDataVersion(path)
+	telemetry.End(span, err)
+	return data, version, err
+}
+
+func (m *Modifier) readDataVersion(path string) (map[string]interface{}, int64, error) {
+	pathBlocks := strings.SplitAfterN(path, "/", 2)
+	fullPath := pathBlocks[0] + "data/"
+	if !noEnvironments[pathBlocks[0]] {
+		fullPath += m.Env + "/"
+	} else if strings.HasPrefix(m.Env, "local") {
+		fullPath += m.Env + "/"
+	}
+	if len(pathBlocks) > 1 {
+		fullPath += pathBlocks[1]
+	}
+	retries := 0
+retryQuery:
+	secret, err := m.logical.Read(fullPath)
+	if netErr, netErrOk := err.(*url.Error); netErrOk && netErr.Unwrap().Error() == "EOF" {
+		if retries < 3 {
+			retries = retries + 1
+			goto retryQuery
+		}
+	} else if err == context.DeadlineExceeded || os.IsTimeout(err) {
+		if retries < 3 {
+			retries = retries + 1
+			goto retryQuery
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if secret == nil {
+		return map[string]interface{}{}, 0, nil
+	}
+	var version int64
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		version = versionNumber(metadata["version"])
+	}
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		// Deleted, but not destroyed.
+		data = map[string]interface{}{}
+	}
+	return data, version, nil
+}
+
 // ReadMapValue takes a valueMap, path, and a key and returns the corresponding value from the vault
 func (m *Modifier) ReadMapValue(valueMap map[string]interface{}, path string, key string) (string, error) {
 	//return value corresponding to the key
@@ -639,6 +748,13 @@ retryQuery:
 
 // List lists the paths underneath this one
 func (m *Modifier) List(path string, logger *log.Logger) (*api.Secret, error) {
+	span := m.traceVault("list", path)
+	secret, err := m.list(path, logger)
+	telemetry.End(span, err)
+	return secret, err
+}
+
+func (m *Modifier) list(path string, logger *log.Logger) (*api.Secret, error) {
 	pathBlocks := strings.SplitAfterN(path, "/", 2)
 	if len(pathBlocks) == 1 {
 		pathBlocks[0] += "/"
@@ -1077,6 +1193,13 @@ indexFound:
 }
 
 func (m *Modifier) SoftDelete(path string, logger *log.Logger) (map[string]interface{}, error) {
+	span := m.traceVault("softdelete", path)
+	data, err := m.softDelete(path, logger)
+	telemetry.End(span, err)
+	return data, err
+}
+
+func (m *Modifier) softDelete(path string, logger *log.Logger) (map[string]interface{}, error) {
 
 	if !strings.HasPrefix(path, "super-secrets") && !strings.HasPrefix(path, "values") {
 		path = "super-secrets/" + path
@@ -1088,6 +1211,12 @@ func (m *Modifier) SoftDelete(path string, logger *log.Logger) (map[string]inter
 		fullDataPath += m.Env + "/"
 	}
 	fullDataPath += pathBlocks[1]
+	auditing := auditEnabled()
+	var previousVersion int64
+	var keys []string
+	if auditing {
+		previousVersion, keys = m.currentData(fullDataPath)
+	}
 	retries := 0
 retryQuery:
 	secret, err := m.logical.Delete(fullDataPath)
@@ -1107,12 +1236,22 @@ retryQuery:
 	}
 
 	if secret == nil && err == nil {
+		if auditing {
+			m.audit(AUDIT_SOFT_DELETE, fullDataPath, keys, previousVersion, previousVersion)
+		}
 		return nil, nil
 	}
 	return nil, errors.New("could not get metadata from vault response")
 }
 
 func (m *Modifier) HardDelete(path string, logger *log.Logger) (map[string]interface{}, error) {
+	span := m.traceVault("harddelete", path)
+	data, err := m.hardDelete(path, logger)
+	telemetry.End(span, err)
+	return data, err
+}
+
+func (m *Modifier) hardDelete(path string, logger *log.Logger) (map[string]interface{}, error) {
 	if !strings.HasPrefix(path, "super-secrets") && !strings.HasPrefix(path, "values") {
 		path = "super-secrets/" + path
 	}
@@ -1127,6 +1266,12 @@ func (m *Modifier) HardDelete(path string, logger *log.Logger) (map[string]inter
 	}
 	fullDataPath += pathBlocks[1]
 	fullMetadataPath += pathBlocks[1]
+	auditing := auditEnabled()
+	var previousVersion int64
+	var keys []string
+	if auditing {
+		previousVersion, keys = m.currentData(fullDataPath)
+	}
 	retries := 0
 retryQuery:
 	secret, err := m.logical.Delete(fullDataPath)
@@ -1163,6 +1308,9 @@ retryQuery:
 		}
 
 		if metadataSecret == nil && err == nil {
+			if auditing {
+				m.audit(AUDIT_HARD_DELETE, fullMetadataPath, keys, previousVersion, 0)
+			}
 			return nil, err
 		} else {
 			logger.Printf("Unable to delete metadata %d retries.\n", retries)
@@ -0,0 +1,434 @@
+package kv
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit operations.
+const (
+	AUDIT_WRITE       = "write"
+	AUDIT_SOFT_DELETE = "softdelete"
+	AUDIT_HARD_DELETE = "harddelete"
+)
+
+// AuditRecord records who changed which keys at a vault path.  Values are
+// never recorded.  Each record carries the hash of the one before it so
+// removing or altering a record breaks the chain.
+type AuditRecord struct {
+	Time            string   `json:"time"`
+	Actor           string   `json:"actor"`
+	Tool            string   `json:"tool"`
+	Operation       string   `json:"operation"`
+	Env             string   `json:"env"`
+	Path            string   `json:"path"`
+	Keys            []string `json:"keys,omitempty"`
+	PreviousVersion int64    `json:"previousVersion"`
+	Version         int64    `json:"version"`
+	Reason          string   `json:"reason,omitempty"`
+	PrevHash        string   `json:"prevHash"`
+	Hash            string   `json:"hash"`
+}
+
+// AuditSink ships audit records.  mod is the modifier that made the change.
+type AuditSink interface {
+	Emit(mod *Modifier, record *AuditRecord) error
+}
+
+// AuditChainResumer is implemented by sinks that can continue the hash chain
+// from records they already hold.
+type AuditChainResumer interface {
+	LastHash() (string, error)
+}
+
+type auditState struct {
+	mu       sync.Mutex
+	initOnce sync.Once
+	sinks    []AuditSink
+	lastHash string
+	actor    string
+	tool     string
+	reason   string
+	// Records chained but not yet emitted, in chain order.  emitMu is held
+	// while emitting so sinks receive them in that order.
+	pending []auditEmit
+	emitMu  sync.Mutex
+}
+
+type auditEmit struct {
+	mod    *Modifier
+	record *AuditRecord
+}
+
+var auditor auditState
+
+// AuditHash computes the chained hash of record.
+func AuditHash(record *AuditRecord) string {
+	unhashed := *record
+	unhashed.Hash = ""
+	recordBytes, _ := json.Marshal(unhashed)
+	sum := sha256.Sum256(recordBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyAuditChain checks every record hashes correctly and links to the one
+// before it.
+func VerifyAuditChain(records []*AuditRecord) error {
+	for i, record := range records {
+		if AuditHash(record) != record.Hash {
+			return fmt.Errorf("audit record %d has been altered", i)
+		}
+		if i > 0 && record.PrevHash != records[i-1].Hash {
+			return fmt.Errorf("audit chain broken before record %d", i)
+		}
+	}
+	return nil
+}
+
+// AddAuditSink registers a sink.  If no records have been chained yet the
+// chain resumes from the sink.
+func AddAuditSink(sink AuditSink) error {
+	lastHash := ""
+	if resumer, ok := sink.(AuditChainResumer); ok {
+		var err error
+		if lastHash, err = resumer.LastHash(); err != nil {
+			return err
+		}
+	}
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	if len(auditor.lastHash) == 0 {
+		auditor.lastHash = lastHash
+	}
+	auditor.sinks = append(auditor.sinks, sink)
+	return nil
+}
+
+// SetAuditIdentity sets the default actor, tool and change reason recorded
+// for changes made by this process.  Empty values are left unchanged.
+func SetAuditIdentity(actor string, tool string, reason string) {
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	if len(actor) > 0 {
+		auditor.actor = actor
+	}
+	if len(tool) > 0 {
+		auditor.tool = tool
+	}
+	if len(reason) > 0 {
+		auditor.reason = reason
+	}
+}
+
+// initAuditFromEnv configures sinks from the environment so every tool
+// audits without code changes:
+//
+//	TRC_AUDIT_FILE        append records to this file
+//	TRC_AUDIT_VAULT_PATH  write records under this vault path
+//	TRC_AUDIT_VAULT_TOKEN token, only allowed to write audit records, writing them
+//	TRC_AUDIT_VAULT_ADDR  vault the records are written to (defaults to VAULT_ADDR)
+//	TRC_AUDIT_VAULT_ENV   env the records are written under (defaults to audit)
+//	TRC_AUDIT_SYSLOG      send records to syslog ("local" or network:address)
+//	TRC_AUDIT_ACTOR       actor recorded (defaults to the os user)
+//	TRC_AUDIT_REASON      change reason recorded
+func initAuditFromEnv() {
+	actor := os.Getenv("TRC_AUDIT_ACTOR")
+	if len(actor) == 0 {
+		if current, err := user.Current(); err == nil {
+			actor = current.Username
+		}
+	}
+	SetAuditIdentity(actor, filepath.Base(os.Args[0]), os.Getenv("TRC_AUDIT_REASON"))
+
+	if auditFile := os.Getenv("TRC_AUDIT_FILE"); len(auditFile) > 0 {
+		if sink, err := NewFileAuditSink(auditFile); err == nil {
+			AddAuditSink(sink)
+		} else {
+			fmt.Fprintf(os.Stderr, "Unable to open audit file %s: %v\n", auditFile, err)
+		}
+	}
+	if auditPath := os.Getenv("TRC_AUDIT_VAULT_PATH"); len(auditPath) > 0 {
+		if auditMod, err := newAuditModifier(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to audit to vault %s: %v\n", auditPath, err)
+		} else if err := AddAuditSink(&VaultAuditSink{Path: auditPath, Mod: auditMod}); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to resume the audit chain at %s: %v\n", auditPath, err)
+		}
+	}
+	if auditSyslog := os.Getenv("TRC_AUDIT_SYSLOG"); len(auditSyslog) > 0 {
+		network, address, _ := strings.Cut(auditSyslog, ":")
+		if network == "local" {
+			network, address = "", ""
+		}
+		if sink, err := NewSyslogAuditSink(network, address); err == nil {
+			AddAuditSink(sink)
+		} else {
+			fmt.Fprintf(os.Stderr, "Unable to connect to syslog: %v\n", err)
+		}
+	}
+}
+
+// newAuditModifier connects to vault with the audit-only token.
+func newAuditModifier() (*Modifier, error) {
+	token := os.Getenv("TRC_AUDIT_VAULT_TOKEN")
+	if len(token) == 0 {
+		return nil, errors.New("TRC_AUDIT_VAULT_TOKEN is required to write audit records")
+	}
+	address := os.Getenv("TRC_AUDIT_VAULT_ADDR")
+	if len(address) == 0 {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	env := os.Getenv("TRC_AUDIT_VAULT_ENV")
+	if len(env) == 0 {
+		env = "audit"
+	}
+	mod, err := NewModifier(false, &token, &address, env, nil, false, log.New(io.Discard, "", 0))
+	if err != nil {
+		return nil, err
+	}
+	mod.Env = env
+	return mod, nil
+}
+
+func auditEnabled() bool {
+	auditor.initOnce.Do(initAuditFromEnv)
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	return len(auditor.sinks) > 0
+}
+
+func auditKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// currentData returns the current kv version and keys at fullDataPath.
+func (m *Modifier) currentData(fullDataPath string) (int64, []string) {
+	secret, err := m.logical.Read(fullDataPath)
+	if err != nil || secret == nil {
+		return 0, nil
+	}
+	var keys []string
+	if data, ok := secret.Data["data"].(map[string]interface{}); ok {
+		keys = auditKeys(data)
+	}
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		return versionNumber(metadata["version"]), keys
+	}
+	return 0, keys
+}
+
+func versionNumber(version interface{}) int64 {
+	switch v := version.(type) {
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	}
+	return 0
+}
+
+// audit chains and emits a record of a change made through this modifier.
+func (m *Modifier) audit(operation string, path string, keys []string, previousVersion int64, version int64) {
+	auditor.mu.Lock()
+	record := &AuditRecord{
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		Actor:           auditor.actor,
+		Tool:            auditor.tool,
+		Operation:       operation,
+		Env:             m.Env,
+		Path:            path,
+		Keys:            keys,
+		PreviousVersion: previousVersion,
+		Version:         version,
+		Reason:          auditor.reason,
+		PrevHash:        auditor.lastHash,
+	}
+	if len(m.AuditActor) > 0 {
+		record.Actor = m.AuditActor
+	}
+	if len(m.AuditReason) > 0 {
+		record.Reason = m.AuditReason
+	}
+	record.Hash = AuditHash(record)
+	auditor.lastHash = record.Hash
+	auditor.pending = append(auditor.pending, auditEmit{m, record})
+	auditor.mu.Unlock()
+
+	// Whoever holds emitMu emits every pending record, so records chained
+	// while another is being emitted still go out in chain order.
+	auditor.emitMu.Lock()
+	defer auditor.emitMu.Unlock()
+	auditor.mu.Lock()
+	pending, sinks := auditor.pending, auditor.sinks
+	auditor.pending = nil
+	auditor.mu.Unlock()
+	for _, emit := range pending {
+		for _, sink := range sinks {
+			if err := sink.Emit(emit.mod, emit.record); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to emit audit record for %s: %v\n", emit.record.Path, err)
+			}
+		}
+	}
+}
+
+// FileAuditSink appends records as json lines to a local file.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileAuditSink opens (or creates) an audit file for append.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{path: path, file: file}, nil
+}
+
+// Emit implements AuditSink.
+func (f *FileAuditSink) Emit(mod *Modifier, record *AuditRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.file.Write(append(recordBytes, '\n')); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// LastHash implements AuditChainResumer.
+func (f *FileAuditSink) LastHash() (string, error) {
+	records, err := ReadAuditRecords(f.path)
+	if err != nil || len(records) == 0 {
+		return "", err
+	}
+	return records[len(records)-1].Hash, nil
+}
+
+// Close closes the audit file.
+func (f *FileAuditSink) Close() error {
+	return f.file.Close()
+}
+
+// ReadAuditRecords reads the records of an audit file.
+func ReadAuditRecords(path string) ([]*AuditRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return DecodeAuditRecords(file)
+}
+
+// DecodeAuditRecords decodes json lines audit records.
+func DecodeAuditRecords(r io.Reader) ([]*AuditRecord, error) {
+	records := []*AuditRecord{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		record := &AuditRecord{}
+		if err := json.Unmarshal([]byte(line), record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// VaultAuditSink writes each record to its own key under Path with Mod, a
+// modifier whose token may only write audit records, so the actors audited
+// can't rewrite their own records.
+type VaultAuditSink struct {
+	Path string
+	Mod  *Modifier
+}
+
+// Emit implements AuditSink.
+func (v *VaultAuditSink) Emit(mod *Modifier, record *AuditRecord) error {
+	if v.Mod == nil {
+		return errors.New("vault audit sink requires an audit-only modifier")
+	}
+	if v.Mod == mod {
+		return errors.New("vault audit sink can't write with the modifier it audits")
+	}
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	recordMap := map[string]interface{}{}
+	json.Unmarshal(recordBytes, &recordMap)
+	recordMap["keys"] = strings.Join(record.Keys, ",")
+
+	// The record itself is not audited, which would recurse.
+	_, err = v.Mod.writeHelper(strings.TrimSuffix(v.Path, "/")+"/"+auditRecordKey(record), recordMap, nil, false, log.New(io.Discard, "", 0))
+	return err
+}
+
+// LastHash implements AuditChainResumer, resuming from the newest record.
+func (v *VaultAuditSink) LastHash() (string, error) {
+	if v.Mod == nil {
+		return "", errors.New("vault audit sink requires an audit-only modifier")
+	}
+	path := strings.TrimSuffix(v.Path, "/")
+	secret, err := v.Mod.List(path, log.New(io.Discard, "", 0))
+	if err != nil || secret == nil {
+		return "", err
+	}
+	keys, _ := secret.Data["keys"].([]interface{})
+	lastKey := ""
+	for _, key := range keys {
+		if recordKey, ok := key.(string); ok && !strings.HasSuffix(recordKey, "/") && recordKey > lastKey {
+			lastKey = recordKey
+		}
+	}
+	if len(lastKey) == 0 {
+		return "", nil
+	}
+	record, err := v.Mod.ReadData(path + "/" + lastKey)
+	if err != nil {
+		return "", err
+	}
+	lastHash, _ := record["hash"].(string)
+	return lastHash, nil
+}
+
+// auditRecordKey names a record by its time, fixed width so keys sort in
+// time order, and its hash so keys are unique.
+func auditRecordKey(record *AuditRecord) string {
+	recordTime, err := time.Parse(time.RFC3339Nano, record.Time)
+	if err != nil {
+		recordTime = time.Now()
+	}
+	return strings.Replace(recordTime.UTC().Format("20060102T150405.000000000"), ".", "", 1) + "Z-" + record.Hash[:12]
+}
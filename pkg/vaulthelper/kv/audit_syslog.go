@@ -0,0 +1,33 @@
+//go:build !windows
+
+package kv
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogAuditSink sends records as json to syslog.  Syslog can't be read
+// back, so on its own the chain restarts in each process.  Pair it with a
+// file or vault sink to chain records across runs.
+type SyslogAuditSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogAuditSink connects to syslog, the local daemon when network is empty.
+func NewSyslogAuditSink(network string, address string) (*SyslogAuditSink, error) {
+	writer, err := syslog.Dial(network, address, syslog.LOG_NOTICE|syslog.LOG_AUTH, "tierceron-audit")
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogAuditSink{writer: writer}, nil
+}
+
+// Emit implements AuditSink.
+func (s *SyslogAuditSink) Emit(mod *Modifier, record *AuditRecord) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.writer.Notice(string(recordBytes))
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+
+package kv
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogAuditSink(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer listener.Close()
+	sink, err := NewSyslogAuditSink("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Emit(nil, &AuditRecord{Actor: "jdoe", Operation: AUDIT_WRITE, Path: "super-secrets/data/dev/Billing/config"}); err != nil {
+		t.Fatal(err)
+	}
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	message := make([]byte, 4096)
+	n, _, err := listener.ReadFrom(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(message[:n]), "tierceron-audit") || !strings.Contains(string(message[:n]), `"actor":"jdoe"`) {
+		t.Fatalf("Unexpected syslog message %s", message[:n])
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package kv
+
+import "errors"
+
+// SyslogAuditSink is unavailable on windows.
+type SyslogAuditSink struct{}
+
+// NewSyslogAuditSink is unavailable on windows.
+func NewSyslogAuditSink(network string, address string) (*SyslogAuditSink, error) {
+	return nil, errors.New("syslog audit sink not supported on windows")
+}
+
+// Emit implements AuditSink.
+func (s *SyslogAuditSink) Emit(mod *Modifier, record *AuditRecord) error {
+	return errors.New("syslog audit sink not supported on windows")
+}
@@ -0,0 +1,48 @@
+package kv
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAuditChain(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileAuditSink(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ResetAuditor(t, sink)
+	SetAuditIdentity("jdoe", "trcx", "")
+
+	mod := &Modifier{Env: "dev", AuditReason: "rotate"}
+	mod.audit(AUDIT_WRITE, "super-secrets/data/dev/Billing/Invoice/config", []string{"dbpassword"}, 1, 2)
+	mod.audit(AUDIT_SOFT_DELETE, "super-secrets/data/dev/Billing/Invoice/config", []string{"dbpassword"}, 2, 2)
+	sink.Close()
+
+	// A new process resumes the chain from the file.
+	sink, err = NewFileAuditSink(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ResetAuditor(t, sink)
+	(&Modifier{Env: "dev"}).audit(AUDIT_HARD_DELETE, "super-secrets/metadata/dev/Billing/Invoice/config", nil, 2, 0)
+	sink.Close()
+
+	records, err := ReadAuditRecords(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].Actor != "jdoe" || records[0].Reason != "rotate" || records[2].PrevHash != records[1].Hash {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if err := VerifyAuditChain(records); err != nil {
+		t.Fatal(err)
+	}
+	if VerifyAuditChain([]*AuditRecord{records[0], records[2]}) == nil {
+		t.Fatal("expected removed record to fail verification")
+	}
+	records[1].Keys = []string{"other"}
+	if VerifyAuditChain(records) == nil {
+		t.Fatal("expected altered record to fail verification")
+	}
+}
@@ -0,0 +1,115 @@
+package kv_test
+
+import (
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv/kvtest"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	records []*kv.AuditRecord
+}
+
+func (s *recordingSink) Emit(mod *kv.Modifier, record *kv.AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestModifierAuditHook(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	mod := kvtest.NewModifier(t, vault, "dev")
+	mod.AuditActor = "jdoe"
+	sink := &recordingSink{}
+	kv.ResetAuditor(t, sink)
+	logger := log.New(io.Discard, "", 0)
+
+	if _, err := mod.Write("super-secrets/Billing/config", map[string]interface{}{"b": "2", "a": "1"}, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mod.Write("super-secrets/Billing/config", map[string]interface{}{"a": "3"}, logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mod.SoftDelete("super-secrets/Billing/config", logger); err != nil {
+		t.Fatal(err)
+	}
+	vault.SetFailPath("super-secrets/")
+	mod.Write("super-secrets/Billing/config", map[string]interface{}{"a": "4"}, logger)
+
+	if len(sink.records) != 3 {
+		t.Fatalf("Expected only successful changes audited, got %d", len(sink.records))
+	}
+	first, second, deleted := sink.records[0], sink.records[1], sink.records[2]
+	if first.Operation != kv.AUDIT_WRITE || first.Path != "super-secrets/data/dev/Billing/config" || strings.Join(first.Keys, ",") != "a,b" || first.Version != 1 || first.Actor != "jdoe" {
+		t.Fatalf("Unexpected write record %+v", first)
+	}
+	if second.PreviousVersion != 1 || second.Version != 2 {
+		t.Fatalf("Unexpected versions %+v", second)
+	}
+	if deleted.Operation != kv.AUDIT_SOFT_DELETE || deleted.PreviousVersion != 2 || strings.Join(deleted.Keys, ",") != "a" {
+		t.Fatalf("Unexpected delete record %+v", deleted)
+	}
+	if err := kv.VerifyAuditChain(sink.records); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVaultAuditSink(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	mod := kvtest.NewModifier(t, vault, "dev")
+	auditMod := kvtest.NewModifier(t, vault, "audit")
+	logger := log.New(io.Discard, "", 0)
+
+	// Records are only written with a modifier of their own.
+	kv.ResetAuditor(t)
+	if err := kv.AddAuditSink(&kv.VaultAuditSink{Path: "super-secrets/Audit/"}); err == nil {
+		t.Fatal("Expected an error without an audit modifier")
+	}
+	if err := (&kv.VaultAuditSink{Path: "super-secrets/Audit", Mod: auditMod}).Emit(auditMod, &kv.AuditRecord{Hash: "0123456789abcdef"}); err == nil {
+		t.Fatal("Expected an error writing with the audited modifier")
+	}
+
+	// Writers sharing a modifier are each audited, in chain order.
+	kv.ResetAuditor(t, &kv.VaultAuditSink{Path: "super-secrets/Audit/", Mod: auditMod})
+	var wg sync.WaitGroup
+	for _, service := range []string{"Invoice", "Payroll", "Shipping"} {
+		wg.Add(1)
+		go func(service string) {
+			defer wg.Done()
+			if _, err := mod.Write("super-secrets/Billing/"+service, map[string]interface{}{"port": "1"}, logger); err != nil {
+				t.Error(err)
+			}
+		}(service)
+	}
+	wg.Wait()
+	records := vault.Paths("super-secrets/data/audit/Audit/")
+	if len(records) != 3 || len(vault.Paths("super-secrets/data/dev/")) != 3 {
+		t.Fatalf("Expected one record per write apart from the changes, got %v", records)
+	}
+	record := vault.Get(records[0])
+	if record["keys"] != "port" || record["operation"] != kv.AUDIT_WRITE || len(record["hash"].(string)) == 0 {
+		t.Fatalf("Unexpected audit record %v", record)
+	}
+	for i := 1; i < len(records); i++ {
+		if vault.Get(records[i])["prevHash"] != vault.Get(records[i-1])["hash"] {
+			t.Fatalf("Expected record %d chained to the one before it", i)
+		}
+	}
+
+	// A new process resumes the chain from the newest record.
+	kv.ResetAuditor(t, &kv.VaultAuditSink{Path: "super-secrets/Audit", Mod: auditMod})
+	if _, err := mod.Write("super-secrets/Billing/Invoice", map[string]interface{}{"port": "2"}, logger); err != nil {
+		t.Fatal(err)
+	}
+	resumed := vault.Paths("super-secrets/data/audit/Audit/")
+	if len(resumed) != 4 || vault.Get(resumed[3])["prevHash"] != vault.Get(records[2])["hash"] {
+		t.Fatalf("Expected the chain resumed, got %v", resumed)
+	}
+}
@@ -0,0 +1,16 @@
+package kv
+
+import "testing"
+
+// ResetAuditor audits to sinks alone, as a new process would, until the test
+// ends.
+func ResetAuditor(t *testing.T, sinks ...AuditSink) {
+	auditor = auditState{}
+	auditor.initOnce.Do(func() {})
+	for _, sink := range sinks {
+		if err := AddAuditSink(sink); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { auditor = auditState{} })
+}
@@ -0,0 +1,172 @@
+// Package kvtest serves an in-memory kv v2 engine for tests of code reading
+// and writing vault through a kv.Modifier.
+package kvtest
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+)
+
+// Vault is a kv v2 engine keeping the latest data and version of each path,
+// enforcing check and set writes.  Paths are full engine paths such as
+// values/data/dev/Project/Service/file.
+type Vault struct {
+	mu       sync.Mutex
+	Data     map[string]map[string]interface{}
+	Versions map[string]int64
+	// Lists holds LIST results by metadata path.  Paths without one list
+	// what is stored under them.
+	Lists map[string][]interface{}
+	// FailPath fails every request under it with permission denied.
+	FailPath string
+	Requests map[string]int
+	Address  string
+}
+
+// NewVault starts a vault, stopped when the test ends.
+func NewVault(t testing.TB) *Vault {
+	vault := &Vault{
+		Data:     map[string]map[string]interface{}{},
+		Versions: map[string]int64{},
+		Lists:    map[string][]interface{}{},
+		Requests: map[string]int{},
+	}
+	server := httptest.NewServer(vault)
+	t.Cleanup(server.Close)
+	vault.Address = server.URL
+	return vault
+}
+
+// NewModifier returns a modifier for env reading and writing vault.
+func NewModifier(t testing.TB, vault *Vault, env string) *kv.Modifier {
+	token := "token"
+	address := vault.Address
+	mod, err := kv.NewModifier(true, &token, &address, env, nil, false, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod.Env = env
+	return mod
+}
+
+// Put stores data as the next version of path.
+func (v *Vault) Put(path string, data map[string]interface{}) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.Data[path] = data
+	v.Versions[path]++
+}
+
+// Get returns the data stored at path.
+func (v *Vault) Get(path string) map[string]interface{} {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.Data[path]
+}
+
+// Paths returns the paths holding data under prefix.
+func (v *Vault) Paths(prefix string) []string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	paths := []string{}
+	for path := range v.Data {
+		if strings.HasPrefix(path, prefix) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// SetFailPath fails the requests under path, or none when path is empty.
+func (v *Vault) SetFailPath(path string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.FailPath = path
+}
+
+func (v *Vault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+	v.Requests[path]++
+	switch {
+	case len(v.FailPath) > 0 && strings.HasPrefix(path, v.FailPath):
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+	case r.Method == "LIST" || r.URL.Query().Get("list") == "true":
+		keys, ok := v.Lists[path]
+		if !ok {
+			keys = v.list(path)
+		}
+		if len(keys) == 0 {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case r.Method == http.MethodGet:
+		data, ok := v.Data[path]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"data":     data,
+			"metadata": map[string]interface{}{"version": v.Versions[path]},
+		}})
+	case r.Method == http.MethodDelete:
+		dataPath := strings.Replace(path, "/metadata/", "/data/", 1)
+		delete(v.Data, dataPath)
+		if dataPath != path {
+			delete(v.Versions, dataPath)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		body := struct {
+			Data    map[string]interface{} `json:"data"`
+			Options map[string]interface{} `json:"options"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		if cas, ok := body.Options["cas"].(float64); ok && int64(cas) != v.Versions[path] {
+			http.Error(w, `{"errors":["check-and-set parameter did not match the current version"]}`, http.StatusBadRequest)
+			return
+		}
+		v.Versions[path]++
+		v.Data[path] = body.Data
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"version": v.Versions[path]}})
+	}
+}
+
+// list returns the keys stored under the metadata path, with the ones
+// holding paths of their own ending in /.
+func (v *Vault) list(path string) []interface{} {
+	prefix := strings.Replace(path+"/", "/metadata/", "/data/", 1)
+	keySet := map[string]bool{}
+	for dataPath := range v.Data {
+		if rest, ok := strings.CutPrefix(dataPath, prefix); ok {
+			if key, _, nested := strings.Cut(rest, "/"); nested {
+				keySet[key+"/"] = true
+			} else {
+				keySet[key] = true
+			}
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	listed := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		listed = append(listed, key)
+	}
+	return listed
+}
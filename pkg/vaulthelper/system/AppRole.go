@@ -3,6 +3,7 @@ package system
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 )
@@ -217,3 +218,46 @@ func (v *Vault) AppRoleLogin(roleID string, secretID string) (*string, error) {
 
 	return nil, fmt.Errorf("error parsing response for key 'auth'")
 }
+
+// JWTLogin logs in with a JWT (such as an OIDC id token) at the given jwt auth
+// mount and returns the client token along with its lease duration.
+func (v *Vault) JWTLogin(mount string, role string, jwt string) (*string, time.Duration, error) {
+	r := v.client.NewRequest("POST", "/v1/auth/"+mount+"/login")
+
+	payload := map[string]interface{}{
+		"role": role,
+		"jwt":  jwt,
+	}
+
+	if err := r.SetJSONBody(payload); err != nil {
+		return nil, 0, err
+	}
+
+	response, err := v.client.RawRequest(r)
+	if response != nil && response.Body != nil {
+		defer response.Body.Close()
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var jsonData map[string]interface{}
+
+	if err = response.DecodeJSON(&jsonData); err != nil {
+		return nil, 0, err
+	}
+
+	if authData, ok := jsonData["auth"].(map[string]interface{}); ok {
+		if token, ok := authData["client_token"].(string); ok {
+			var leaseDuration int64
+			if lease, ok := authData["lease_duration"].(json.Number); ok {
+				leaseDuration, _ = lease.Int64()
+			}
+			return &token, time.Duration(leaseDuration) * time.Second, nil
+		}
+		return nil, 0, fmt.Errorf("error parsing response for key 'auth.client_token'")
+	}
+
+	return nil, 0, fmt.Errorf("error parsing response for key 'auth'")
+}
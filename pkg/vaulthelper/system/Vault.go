@@ -130,6 +130,15 @@ func (v *Vault) GetTokenInfo(tokenName string) (map[string]interface{}, error) {
 	return token.Data, err
 }
 
+// GetTokenSelfInfo fetches data regarding the token in use by this vault
+func (v *Vault) GetTokenSelfInfo() (map[string]interface{}, error) {
+	token, err := v.client.Auth().Token().LookupSelf()
+	if token == nil {
+		return nil, err
+	}
+	return token.Data, err
+}
+
 // RevokeToken If proper access given, revokes access of a token and all children
 func (v *Vault) RevokeToken(token string) error {
 	return v.client.Auth().Token().RevokeTree(token)
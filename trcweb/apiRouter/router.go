@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/trimble-oss/tierceron/pkg/utils/config"
@@ -15,10 +17,10 @@ import (
 	"github.com/trimble-oss/tierceron/pkg/core"
 	"github.com/trimble-oss/tierceron/pkg/core/util"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	trcauth "github.com/trimble-oss/tierceron/trcweb/auth"
 	twp "github.com/trimble-oss/tierceron/trcweb/rpc/apinator"
 	"github.com/trimble-oss/tierceron/trcweb/server"
 
-	jwt "github.com/golang-jwt/jwt"
 	rtr "github.com/julienschmidt/httprouter"
 	"github.com/rs/cors"
 )
@@ -38,8 +40,16 @@ var noAuthRoutes = map[string]bool{
 }
 
 // Handle auth tokens through POST request and route without auth through GET request
-func authrouter(restHandler http.Handler, isAuth bool) *rtr.Router {
+func authrouter(restHandler http.Handler, isAuth bool, authenticator trcauth.Authenticator) *rtr.Router {
 	router := rtr.New()
+	twirpHandler := restHandler
+	restHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/graphql/subscribe" {
+			gqlSubscribe(w, r)
+			return
+		}
+		twirpHandler.ServeHTTP(w, r)
+	})
 	// Simply route
 	noauth := func(w http.ResponseWriter, r *http.Request, ps rtr.Params) {
 		s.Log.SetPrefix("[INFO]")
@@ -49,8 +59,10 @@ func authrouter(restHandler http.Handler, isAuth bool) *rtr.Router {
 		restHandler.ServeHTTP(w, r)
 	}
 	auth := func(w http.ResponseWriter, r *http.Request, ps rtr.Params) {
-		// Switch to noauth if this is a login request
-		if noAuthRoutes[ps.ByName("method")] {
+		method := path.Base(r.URL.Path)
+		// Switch to noauth if this is a login request, or any bootstrap request
+		// the access policy doesn't require a token for
+		if noAuthRoutes[method] && !s.RequiresToken(method) {
 			noauth(w, r, ps)
 			return
 		}
@@ -66,53 +78,25 @@ func authrouter(restHandler http.Handler, isAuth bool) *rtr.Router {
 		if len(authString) > 0 { // Ensure a token was actually sent
 			splitAuth := strings.SplitN(authString, " ", 2)
 			if splitAuth[0] == "Bearer" {
-				token, err := jwt.Parse(splitAuth[1], func(token *jwt.Token) (interface{}, error) { // Parse token and verify formatting
-					if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
-						return s.TrcAPITokenSecret, nil
-					}
-					parseErr := fmt.Errorf("Unexpected singing method %v", token.Header["alg"])
-					s.Log.Println(parseErr)
-					return nil, parseErr
-				})
-				if err == nil { // Continue if token parsed without error
-					if claims, ok := token.Claims.(jwt.MapClaims); ok { // Verify token claim formatting
-						err = claims.Valid()
-						if err == nil { // Verify that token had valid issuing time/date
-							if claims["iss"] != "Viewpoint, Inc." { // Verify issuer
-								errMsg = fmt.Sprintf("Invalid token issuer: %s", util.Sanitize(claims["iss"]))
-								http.Error(w, errMsg, http.StatusUnauthorized)
-								s.Log.Println(errMsg)
-								return
-							} else if claims["aud"] != "Viewpoint Vault WebAPI" { // Verify audience
-								errMsg = fmt.Sprintf("Token issued for different audience: %s", util.Sanitize(claims["aud"]))
-								http.Error(w, errMsg, http.StatusUnauthorized)
-								s.Log.Println(errMsg)
-								return
-							}
-							// Output token info and pass request to twirp server
-							s.Log.SetPrefix("[INFO]")
-							s.Log.Printf("Request authorized for %v with ID %v\n", util.Sanitize(claims["name"]), util.Sanitize(claims["sub"]))
-							ctx := r.Context()
-							restHandler.ServeHTTP(w, r.WithContext(context.WithValue(ctx, "user", claims["sub"])))
-							return
-						}
-						// Before issue time, after expiration, or before validity time
-						http.Error(w, err.Error(), http.StatusUnauthorized)
-						s.Log.Printf("%d: %s", http.StatusUnauthorized, err.Error())
+				identity, err := authenticator.Authenticate(r.Context(), splitAuth[1])
+				if err == nil {
+					// Output token info and pass request to twirp server
+					s.Log.SetPrefix("[INFO]")
+					s.Log.Printf("Request authorized for %v with ID %v from %v\n", util.Sanitize(identity.Name), util.Sanitize(identity.Subject), util.Sanitize(identity.Issuer))
+					ctx := context.WithValue(r.Context(), "user", identity.Subject)
+					ctx = context.WithValue(ctx, "roles", identity.Roles)
+					if err := s.AuthorizeMethod(ctx, method); err != nil {
+						http.Error(w, err.Error(), http.StatusForbidden)
+						s.Log.Print(eUtils.SanitizeForLogging(fmt.Sprintf("%d: %s", http.StatusForbidden, err.Error())))
 						return
 					}
-					// Token claims not in json format
-					errMsg = "Format error with auth token claims"
-					http.Error(w, errMsg, http.StatusUnauthorized)
-
-					errMsg = eUtils.SanitizeForLogging(errMsg)
-					s.Log.Printf("%d: %s", http.StatusUnauthorized, errMsg)
+					restHandler.ServeHTTP(w, r.WithContext(ctx))
 					return
 				}
-				// Error when parsing token. Pass back a generalized error for formatting
+				// Error when validating token. Pass back a generalized error for formatting
 				errMsg = "Invalid token: " + err.Error()
 				http.Error(w, errMsg, http.StatusUnauthorized)
-				s.Log.Printf("%d: %s\n", http.StatusUnauthorized, errMsg)
+				s.Log.Print(eUtils.SanitizeForLogging(fmt.Sprintf("%d: %s", http.StatusUnauthorized, errMsg)))
 				return
 			}
 			// Auth method passed but is not a bearer token
@@ -157,13 +141,18 @@ func authrouter(restHandler http.Handler, isAuth bool) *rtr.Router {
 		router.POST("/twirp/:service/:method", noauth)
 	}
 	router.GET("/graphql", gql)
+	if isAuth {
+		router.GET("/graphql/subscribe", auth)
+	} else {
+		router.GET("/graphql/subscribe", noauth)
+	}
 	router.GET("/auth", auth)
 
 	uiEndpoint := func(w http.ResponseWriter, r *http.Request, ps rtr.Params) {
 		http.ServeFile(w, r, "public/index.html")
 	}
 	gqlEndpoint := func(w http.ResponseWriter, r *http.Request, ps rtr.Params) {
-		s.InitGQL()
+		s.InvalidateGQL()
 		http.ServeFile(w, r, "public/index.html")
 	}
 	router.GET("/", uiEndpoint)
@@ -175,6 +164,76 @@ func authrouter(restHandler http.Handler, isAuth bool) *rtr.Router {
 	return router
 }
 
+// newAuthenticator accepts api login (HMAC) tokens and, when issuers are
+// configured, tokens from OIDC providers such as corporate SSO.
+func newAuthenticator(driverConfig *config.DriverConfig, oidcIssuers string, oidcAudiences string, roleMap string) trcauth.Authenticator {
+	roleMappings, err := trcauth.ParseRoleMappings(roleMap)
+	eUtils.CheckError(driverConfig.CoreConfig, err, true)
+
+	authenticators := trcauth.Chain{trcauth.NewHMACAuthenticator(func() []byte { return s.TrcAPITokenSecret }, roleMappings)}
+	if len(oidcIssuers) > 0 {
+		oidcConfig := trcauth.OIDCConfig{
+			Issuers:      strings.Split(oidcIssuers, ","),
+			RoleMappings: roleMappings,
+		}
+		if len(oidcAudiences) > 0 {
+			oidcConfig.Audiences = strings.Split(oidcAudiences, ",")
+		}
+		oidcAuthenticator, err := trcauth.NewOIDCAuthenticator(oidcConfig)
+		eUtils.CheckError(driverConfig.CoreConfig, err, true)
+		authenticators = append(authenticators, oidcAuthenticator)
+	}
+	return authenticators
+}
+
+// gqlSubscribe streams the results of a GraphQL subscription as server sent events
+func gqlSubscribe(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	variables := map[string]interface{}{}
+	if vars := r.URL.Query().Get("variables"); len(vars) > 0 {
+		if err := json.Unmarshal([]byte(vars), &variables); err != nil {
+			http.Error(w, "Invalid variables", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := r.Context()
+	results := s.GraphQLSubscribe(ctx, r.URL.Query().Get("query"), variables)
+	defer func() {
+		// Drain so the subscription can exit once the client is gone.
+		go func() {
+			for range results {
+			}
+		}()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case result, more := <-results:
+			if !more {
+				return
+			}
+			data, err := json.Marshal(result)
+			if err != nil {
+				s.Log.Println(err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
 // declare global variale for local hosting
 var localHost bool
 
@@ -192,6 +251,9 @@ func main() {
 	authPtr := flag.Bool("auth", true, "Run with auth enabled?")
 	localPtr := flag.Bool("local", false, "Run locally")
 	prodPtr := flag.Bool("production", false, "Run in production mode")
+	oidcIssuersPtr := flag.String("oidcIssuers", "", "Comma separated OIDC issuers trusted in addition to api login tokens")
+	oidcAudiencesPtr := flag.String("oidcAudiences", "", "Comma separated audiences accepted from OIDC issuers (required with oidcIssuers)")
+	roleMapPtr := flag.String("roleMap", "", "Comma separated token claim to role mappings of the form claim:value=role")
 
 	flag.Parse()
 
@@ -225,7 +287,7 @@ func main() {
 
 	twirpHandler := twp.NewEnterpriseServiceBrokerServer(s, nil)
 	//twirpHandler.
-	router := authrouter(twirpHandler, *authPtr)
+	router := authrouter(twirpHandler, *authPtr, newAuthenticator(driverConfig, *oidcIssuersPtr, *oidcAudiencesPtr, *roleMapPtr))
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"GET", "POST", "DELETE", "PUT", "OPTIONS"},
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// Issuer and audience of tokens issued by the trcweb APILogin.
+const (
+	DEFAULT_HMAC_ISSUER   = "Viewpoint, Inc."
+	DEFAULT_HMAC_AUDIENCE = "Viewpoint Vault WebAPI"
+)
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Subject string
+	Name    string
+	Issuer  string
+	Roles   []string
+	Claims  jwt.MapClaims
+}
+
+// Authenticator validates a bearer token and returns the caller's identity.
+type Authenticator interface {
+	Authenticate(ctx context.Context, rawToken string) (*Identity, error)
+}
+
+// Chain tries each authenticator in order, returning the first identity.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(ctx context.Context, rawToken string) (*Identity, error) {
+	if len(c) == 0 {
+		return nil, errors.New("no authenticators configured")
+	}
+	var lastErr error
+	for _, authenticator := range c {
+		identity, err := authenticator.Authenticate(ctx, rawToken)
+		if err == nil {
+			return identity, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// RoleMapping maps values of a claim (such as groups) to roles.
+type RoleMapping struct {
+	Claim string
+	Value string
+	Role  string
+}
+
+// ParseRoleMappings parses a comma separated list of claim:value=role.
+func ParseRoleMappings(mappings string) ([]RoleMapping, error) {
+	roleMappings := []RoleMapping{}
+	for _, mapping := range strings.Split(mappings, ",") {
+		mapping = strings.TrimSpace(mapping)
+		if len(mapping) == 0 {
+			continue
+		}
+		claimValue, role, roleFound := strings.Cut(mapping, "=")
+		claim, value, valueFound := strings.Cut(claimValue, ":")
+		if !roleFound || !valueFound || len(claim) == 0 || len(role) == 0 {
+			return nil, fmt.Errorf("invalid role mapping %s, expected claim:value=role", mapping)
+		}
+		roleMappings = append(roleMappings, RoleMapping{Claim: claim, Value: value, Role: role})
+	}
+	return roleMappings, nil
+}
+
+// MapRoles returns the roles granted by claims.  A "*" value matches any value.
+func MapRoles(claims jwt.MapClaims, roleMappings []RoleMapping) []string {
+	roles := []string{}
+	granted := map[string]bool{}
+	for _, mapping := range roleMappings {
+		if granted[mapping.Role] {
+			continue
+		}
+		for _, value := range claimValues(claims[mapping.Claim]) {
+			if mapping.Value == "*" || mapping.Value == value {
+				granted[mapping.Role] = true
+				roles = append(roles, mapping.Role)
+				break
+			}
+		}
+	}
+	return roles
+}
+
+func claimValues(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	case []string:
+		return v
+	}
+	return nil
+}
+
+// verifyClaims checks token times and that the issuer and audience are accepted.
+func verifyClaims(claims jwt.MapClaims, issuers []string, audiences []string) error {
+	if err := claims.Valid(); err != nil {
+		return err
+	}
+	issuer, _ := claims["iss"].(string)
+	if !contains(issuers, issuer) {
+		return fmt.Errorf("invalid token issuer: %s", issuer)
+	}
+	if len(audiences) > 0 {
+		for _, audience := range audiences {
+			if claims.VerifyAudience(audience, true) {
+				return nil
+			}
+		}
+		return fmt.Errorf("token issued for different audience: %v", claims["aud"])
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func identityFromClaims(claims jwt.MapClaims, roleMappings []RoleMapping) *Identity {
+	identity := &Identity{Claims: claims, Roles: MapRoles(claims, roleMappings)}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Issuer, _ = claims["iss"].(string)
+	if name, ok := claims["name"].(string); ok {
+		identity.Name = name
+	} else if name, ok := claims["preferred_username"].(string); ok {
+		identity.Name = name
+	}
+	return identity
+}
+
+// HMACAuthenticator validates HMAC signed tokens issued by APILogin.
+type HMACAuthenticator struct {
+	Secret       func() []byte
+	Issuers      []string
+	Audiences    []string
+	RoleMappings []RoleMapping
+}
+
+// NewHMACAuthenticator returns an authenticator accepting the APILogin issuer and audience.
+func NewHMACAuthenticator(secret func() []byte, roleMappings []RoleMapping) *HMACAuthenticator {
+	return &HMACAuthenticator{
+		Secret:       secret,
+		Issuers:      []string{DEFAULT_HMAC_ISSUER},
+		Audiences:    []string{DEFAULT_HMAC_AUDIENCE},
+		RoleMappings: roleMappings,
+	}
+}
+
+// Authenticate implements Authenticator.
+func (h *HMACAuthenticator) Authenticate(ctx context.Context, rawToken string) (*Identity, error) {
+	token, err := jwt.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		secret := h.Secret()
+		if len(secret) == 0 {
+			return nil, errors.New("api token secret not initialized")
+		}
+		return secret, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("format error with auth token claims")
+	}
+	if err := verifyClaims(claims, h.Issuers, h.Audiences); err != nil {
+		return nil, err
+	}
+	return identityFromClaims(claims, h.RoleMappings), nil
+}
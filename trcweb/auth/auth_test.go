@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// fakeIssuer serves OIDC discovery and a JWKS with one RSA and one EC key.
+func fakeIssuer(t *testing.T) (*httptest.Server, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+		}})
+	})
+	return server, rsaKey, ecKey
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if len(kid) > 0 {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	server, rsaKey, ecKey := fakeIssuer(t)
+	defer server.Close()
+
+	authenticator, err := NewOIDCAuthenticator(OIDCConfig{
+		Issuers:      []string{server.URL},
+		Audiences:    []string{"trcweb"},
+		RoleMappings: []RoleMapping{{Claim: "groups", Value: "trc-admins", Role: "admin"}},
+		HTTPClient:   server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":    server.URL,
+			"aud":    []string{"trcweb", "other"},
+			"sub":    "jdoe",
+			"groups": []string{"trc-admins"},
+			"iat":    now.Unix(),
+			"exp":    now.Add(time.Minute).Unix(),
+		}
+	}
+
+	ctx := context.Background()
+	identity, err := authenticator.Authenticate(ctx, signToken(t, jwt.SigningMethodRS256, "rsa1", rsaKey, claims()))
+	if err != nil {
+		t.Fatalf("expected RS256 token to authenticate: %v", err)
+	}
+	if identity.Subject != "jdoe" || len(identity.Roles) != 1 || identity.Roles[0] != "admin" {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+	if _, err := authenticator.Authenticate(ctx, signToken(t, jwt.SigningMethodES256, "ec1", ecKey, claims())); err != nil {
+		t.Fatalf("expected ES256 token to authenticate: %v", err)
+	}
+
+	rejected := map[string]string{}
+	wrongAudience := claims()
+	wrongAudience["aud"] = "someone-else"
+	rejected["audience"] = signToken(t, jwt.SigningMethodRS256, "rsa1", rsaKey, wrongAudience)
+	expired := claims()
+	expired["exp"] = now.Add(-time.Minute).Unix()
+	rejected["expired"] = signToken(t, jwt.SigningMethodRS256, "rsa1", rsaKey, expired)
+	untrusted := claims()
+	untrusted["iss"] = "https://untrusted.example.com"
+	rejected["issuer"] = signToken(t, jwt.SigningMethodRS256, "rsa1", rsaKey, untrusted)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rejected["signature"] = signToken(t, jwt.SigningMethodRS256, "rsa1", otherKey, claims())
+	rejected["hmac"] = signToken(t, jwt.SigningMethodHS256, "rsa1", []byte("secret"), claims())
+	for reason, token := range rejected {
+		if _, err := authenticator.Authenticate(ctx, token); err == nil {
+			t.Errorf("expected token with bad %s to be rejected", reason)
+		}
+	}
+}
+
+func TestChainKeepsHMAC(t *testing.T) {
+	server, rsaKey, _ := fakeIssuer(t)
+	defer server.Close()
+
+	secret := []byte("apitokensecret")
+	oidc, err := NewOIDCAuthenticator(OIDCConfig{Issuers: []string{server.URL}, Audiences: []string{"trcweb"}, HTTPClient: server.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := Chain{NewHMACAuthenticator(func() []byte { return secret }, nil), oidc}
+
+	now := time.Now()
+	hmacToken := signToken(t, jwt.SigningMethodHS256, "", secret, jwt.MapClaims{
+		"iss": DEFAULT_HMAC_ISSUER,
+		"aud": DEFAULT_HMAC_AUDIENCE,
+		"sub": "apiuser",
+		"exp": now.Add(time.Minute).Unix(),
+	})
+	if identity, err := chain.Authenticate(context.Background(), hmacToken); err != nil || identity.Subject != "apiuser" {
+		t.Fatalf("expected hmac token to authenticate: %v", err)
+	}
+	oidcToken := signToken(t, jwt.SigningMethodRS256, "rsa1", rsaKey, jwt.MapClaims{
+		"iss": server.URL,
+		"aud": "trcweb",
+		"sub": "jdoe",
+		"exp": now.Add(time.Minute).Unix(),
+	})
+	if identity, err := chain.Authenticate(context.Background(), oidcToken); err != nil || identity.Subject != "jdoe" {
+		t.Fatalf("expected oidc token to authenticate: %v", err)
+	}
+}
+
+func TestParseRoleMappings(t *testing.T) {
+	mappings, err := ParseRoleMappings("groups:trc-admins=admin, roles:*=reader")
+	if err != nil || len(mappings) != 2 || mappings[1].Value != "*" {
+		t.Fatalf("unexpected mappings %+v %v", mappings, err)
+	}
+	if _, err := ParseRoleMappings("groups=admin"); err == nil {
+		t.Fatal("expected mapping without value to fail")
+	}
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt"
+)
+
+// DEFAULT_JWKS_REFRESH How long signing keys are cached before being refetched.
+const DEFAULT_JWKS_REFRESH = time.Hour
+
+// MIN_JWKS_REFRESH Minimum time between refetches triggered by unknown key ids.
+const MIN_JWKS_REFRESH = 30 * time.Second
+
+// Signing algorithms accepted from an OIDC provider.
+var oidcAlgorithms = map[string]bool{
+	"RS256": true,
+	"RS384": true,
+	"RS512": true,
+	"ES256": true,
+	"ES384": true,
+	"ES512": true,
+}
+
+// OIDCConfig configures trust of one or more OIDC issuers.
+type OIDCConfig struct {
+	Issuers      []string
+	Audiences    []string
+	RoleMappings []RoleMapping
+	HTTPClient   *http.Client
+	JWKSRefresh  time.Duration
+}
+
+// OIDCAuthenticator validates tokens signed by a trusted OIDC issuer using
+// keys from the issuer's discovered JWKS.
+type OIDCAuthenticator struct {
+	config    OIDCConfig
+	providers map[string]*oidcProvider
+}
+
+type oidcProvider struct {
+	issuer     string
+	httpClient *http.Client
+	refresh    time.Duration
+
+	mu        sync.Mutex
+	jwksURI   string
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewOIDCAuthenticator returns an authenticator for the configured issuers.
+// Discovery happens on first use so an unavailable issuer doesn't prevent
+// startup.
+func NewOIDCAuthenticator(config OIDCConfig) (*OIDCAuthenticator, error) {
+	if len(config.Issuers) == 0 {
+		return nil, errors.New("at least one oidc issuer is required")
+	}
+	if len(config.Audiences) == 0 {
+		// Without an audience any token the issuer signs for other clients would be accepted.
+		return nil, errors.New("at least one oidc audience is required")
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.JWKSRefresh == 0 {
+		config.JWKSRefresh = DEFAULT_JWKS_REFRESH
+	}
+	o := &OIDCAuthenticator{config: config, providers: map[string]*oidcProvider{}}
+	for _, issuer := range config.Issuers {
+		issuer = strings.TrimSuffix(issuer, "/")
+		o.providers[issuer] = &oidcProvider{issuer: issuer, httpClient: config.HTTPClient, refresh: config.JWKSRefresh}
+	}
+	return o, nil
+}
+
+// Authenticate implements Authenticator.
+func (o *OIDCAuthenticator) Authenticate(ctx context.Context, rawToken string) (*Identity, error) {
+	var provider *oidcProvider
+	token, err := jwt.Parse(rawToken, func(token *jwt.Token) (interface{}, error) {
+		alg, _ := token.Header["alg"].(string)
+		if !oidcAlgorithms[alg] {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return nil, errors.New("format error with auth token claims")
+		}
+		issuer, _ := claims["iss"].(string)
+		provider = o.providers[strings.TrimSuffix(issuer, "/")]
+		if provider == nil {
+			return nil, fmt.Errorf("invalid token issuer: %s", issuer)
+		}
+		kid, _ := token.Header["kid"].(string)
+		return provider.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if err := verifyClaims(claims, []string{provider.issuer, provider.issuer + "/"}, o.config.Audiences); err != nil {
+		return nil, err
+	}
+	return identityFromClaims(claims, o.config.RoleMappings), nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover reads jwks_uri from the issuer's openid configuration.
+func (p *oidcProvider) discover(ctx context.Context) error {
+	discovery := struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return fmt.Errorf("discovered issuer %s does not match %s", discovery.Issuer, p.issuer)
+	}
+	if !strings.HasPrefix(discovery.JWKSURI, "https://") {
+		return fmt.Errorf("jwks_uri must be https: %s", discovery.JWKSURI)
+	}
+	p.jwksURI = discovery.JWKSURI
+	return nil
+}
+
+func (p *oidcProvider) fetchKeys(ctx context.Context) error {
+	if len(p.jwksURI) == 0 {
+		if err := p.discover(ctx); err != nil {
+			return err
+		}
+	}
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := p.getJSON(ctx, p.jwksURI, &jwks); err != nil {
+		return err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no usable signing keys from %s", p.jwksURI)
+	}
+	p.keys = keys
+	p.fetchedAt = time.Now()
+	return nil
+}
+
+// key returns the signing key kid, refetching the JWKS when stale or when the
+// key is unknown (the issuer may have rotated keys).
+func (p *oidcProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	age := time.Since(p.fetchedAt)
+	_, known := p.keys[kid]
+	if p.keys == nil || age > p.refresh || (!known && age > MIN_JWKS_REFRESH) {
+		if err := p.fetchKeys(ctx); err != nil && p.keys == nil {
+			return nil, err
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if len(kid) == 0 && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %s", kid)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid ec key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
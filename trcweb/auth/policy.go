@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Permissions granted by a Policy.
+const (
+	PERMISSION_READ_VALUES  = "readValues"
+	PERMISSION_WRITE_VALUES = "writeValues"
+	PERMISSION_READ_SECRETS = "readSecrets"
+	PERMISSION_ROLL_TOKENS  = "rollTokens"
+	PERMISSION_UNSEAL       = "unseal"
+	PERMISSION_INIT         = "init"
+	PERMISSION_ADMIN        = "admin"
+)
+
+var knownPermissions = map[string]bool{
+	PERMISSION_READ_VALUES:  true,
+	PERMISSION_WRITE_VALUES: true,
+	PERMISSION_READ_SECRETS: true,
+	PERMISSION_ROLL_TOKENS:  true,
+	PERMISSION_UNSEAL:       true,
+	PERMISSION_INIT:         true,
+	PERMISSION_ADMIN:        true,
+}
+
+// MethodPermissions is the permission required for each EnterpriseServiceBroker method.
+// Methods not listed need no permission beyond authentication.
+var MethodPermissions = map[string]string{
+	"GetValues":            PERMISSION_READ_VALUES,
+	"GetTemplate":          PERMISSION_READ_VALUES,
+	"ListServiceTemplates": PERMISSION_READ_VALUES,
+	"GraphQL":              PERMISSION_READ_VALUES,
+	"subscribe":            PERMISSION_READ_VALUES,
+	"Validate":             PERMISSION_READ_SECRETS,
+	"GetVaultTokens":       PERMISSION_READ_SECRETS,
+	"RollTokens":           PERMISSION_ROLL_TOKENS,
+	"Unseal":               PERMISSION_UNSEAL,
+	"InitVault":            PERMISSION_INIT,
+	"ResetServer":          PERMISSION_ADMIN,
+	"UpdateAPI":            PERMISSION_ADMIN,
+}
+
+// Methods callable without a token under any policy.
+var publicMethods = map[string]bool{
+	"APILogin":        true,
+	"CheckConnection": true,
+	"GetStatus":       true,
+	"Environments":    true,
+}
+
+// Principal is who a permission is evaluated for.
+type Principal struct {
+	Subject string
+	Roles   []string
+}
+
+// Grant gives subjects or roles permissions within a scope.  An empty scope
+// list matches everything; patterns may end in * to match a prefix.
+type Grant struct {
+	Subjects    []string `json:"subjects"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	Envs        []string `json:"envs"`
+	Projects    []string `json:"projects"`
+	Services    []string `json:"services"`
+}
+
+// Policy maps principals to scoped permissions.
+type Policy struct {
+	Grants []Grant `json:"grants"`
+	// Methods that remain callable without a token (for example GetVaultTokens
+	// from build agents authenticating with app role credentials).
+	Unauthenticated []string `json:"unauthenticated"`
+}
+
+// ParsePolicy parses and validates a json policy.
+func ParsePolicy(policyJSON []byte) (*Policy, error) {
+	policy := &Policy{}
+	if err := json.Unmarshal(policyJSON, policy); err != nil {
+		return nil, err
+	}
+	if len(policy.Grants) == 0 {
+		return nil, errors.New("policy has no grants")
+	}
+	for i, grant := range policy.Grants {
+		if len(grant.Subjects) == 0 && len(grant.Roles) == 0 {
+			return nil, fmt.Errorf("grant %d has no subjects or roles", i)
+		}
+		for _, permission := range grant.Permissions {
+			if !knownPermissions[permission] {
+				return nil, fmt.Errorf("grant %d has unknown permission %s", i, permission)
+			}
+		}
+	}
+	return policy, nil
+}
+
+func matchesPattern(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == value || pattern == "*" {
+			return true
+		}
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(value, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (grant Grant) appliesTo(principal Principal) bool {
+	if len(principal.Subject) > 0 && contains(grant.Subjects, principal.Subject) {
+		return true
+	}
+	for _, role := range principal.Roles {
+		if contains(grant.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (grant Grant) grants(permission string) bool {
+	return contains(grant.Permissions, permission) || contains(grant.Permissions, PERMISSION_ADMIN)
+}
+
+// Allowed reports whether principal has permission in env/project/service.
+// Empty project or service are treated as "any within the parent scope" so
+// callers can check whether to descend into an env or project at all.
+func (p *Policy) Allowed(principal Principal, permission string, env string, project string, service string) bool {
+	for _, grant := range p.Grants {
+		if !grant.appliesTo(principal) || !grant.grants(permission) {
+			continue
+		}
+		if !matchesPattern(grant.Envs, env) {
+			continue
+		}
+		if len(project) > 0 && !matchesPattern(grant.Projects, project) {
+			continue
+		}
+		if len(service) > 0 && !matchesPattern(grant.Services, service) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// AllowedMethod reports whether principal may call method at all, in any
+// environment.  Scoped methods are further filtered by the method itself
+// against the environment, project and service they act on.
+func (p *Policy) AllowedMethod(principal Principal, method string) bool {
+	permission, ok := MethodPermissions[method]
+	if !ok {
+		return true
+	}
+	for _, grant := range p.Grants {
+		if grant.appliesTo(principal) && grant.grants(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresToken reports whether method needs an authenticated caller.
+func (p *Policy) RequiresToken(method string) bool {
+	return !publicMethods[method] && !contains(p.Unauthenticated, method)
+}
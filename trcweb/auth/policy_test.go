@@ -0,0 +1,56 @@
+package auth
+
+import "testing"
+
+func TestPolicy(t *testing.T) {
+	policy, err := ParsePolicy([]byte(`{
+		"grants": [
+			{"roles": ["developer"], "permissions": ["readValues", "writeValues"], "envs": ["dev", "QA", "local/*"]},
+			{"roles": ["billing"], "permissions": ["readValues", "readSecrets"], "envs": ["staging"], "projects": ["Billing"], "services": ["Invoice*"]},
+			{"subjects": ["opsadmin"], "permissions": ["admin"]}
+		],
+		"unauthenticated": ["GetVaultTokens"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	developer := Principal{Subject: "jdoe", Roles: []string{"developer"}}
+	billing := Principal{Subject: "asmith", Roles: []string{"billing"}}
+	admin := Principal{Subject: "opsadmin"}
+
+	checks := []struct {
+		principal  Principal
+		permission string
+		env        string
+		project    string
+		service    string
+		allowed    bool
+	}{
+		{developer, PERMISSION_READ_VALUES, "dev", "Billing", "InvoiceService", true},
+		{developer, PERMISSION_WRITE_VALUES, "local/jdoe", "Billing", "InvoiceService", true},
+		{developer, PERMISSION_READ_VALUES, "staging", "", "", false},
+		{developer, PERMISSION_READ_SECRETS, "dev", "", "", false},
+		{billing, PERMISSION_READ_SECRETS, "staging", "Billing", "InvoiceService", true},
+		{billing, PERMISSION_READ_VALUES, "staging", "Billing", "", true},
+		{billing, PERMISSION_READ_VALUES, "staging", "Billing", "Payroll", false},
+		{billing, PERMISSION_READ_VALUES, "staging", "Shipping", "", false},
+		{billing, PERMISSION_WRITE_VALUES, "staging", "Billing", "InvoiceService", false},
+		{admin, PERMISSION_UNSEAL, "prod", "", "", true},
+		{Principal{Subject: "nobody"}, PERMISSION_READ_VALUES, "dev", "", "", false},
+	}
+	for _, check := range checks {
+		if got := policy.Allowed(check.principal, check.permission, check.env, check.project, check.service); got != check.allowed {
+			t.Errorf("%s %s in %s/%s/%s: got %v", check.principal.Subject, check.permission, check.env, check.project, check.service, got)
+		}
+	}
+
+	if policy.AllowedMethod(developer, "Unseal") || !policy.AllowedMethod(admin, "Unseal") || !policy.AllowedMethod(developer, "GetValues") {
+		t.Error("unexpected method permissions")
+	}
+	if policy.RequiresToken("GetVaultTokens") || policy.RequiresToken("APILogin") || !policy.RequiresToken("InitVault") {
+		t.Error("unexpected unauthenticated methods")
+	}
+	if _, err := ParsePolicy([]byte(`{"grants": [{"roles": ["x"], "permissions": ["readEverything"]}]}`)); err == nil {
+		t.Error("expected unknown permission to be rejected")
+	}
+}
@@ -23,6 +23,9 @@ const templatePath string = "template_files"
 
 // InitVault Takes init request and inits/seeds vault with contained file data
 func (s *Server) InitVault(ctx context.Context, req *pb.InitReq) (*pb.InitResp, error) {
+	if err := s.AuthorizeEnv(ctx, "InitVault", req.Env); err != nil {
+		return &pb.InitResp{Success: false}, err
+	}
 	logBuffer := new(bytes.Buffer)
 	logger := log.New(logBuffer, "[INIT]", log.LstdFlags)
 
@@ -243,6 +246,9 @@ func (s *Server) GetStatus(ctx context.Context, req *pb.NoParams) (*pb.VaultStat
 
 // Unseal passes the unseal key to the vault and tries to unseal the vault
 func (s *Server) Unseal(ctx context.Context, req *pb.UnsealReq) (*pb.UnsealResp, error) {
+	if err := s.AuthorizeEnv(ctx, "Unseal", s.serverEnv()); err != nil {
+		return nil, err
+	}
 	v, err := sys.NewVault(false, s.VaultAddrPtr, "nonprod", false, false, false, s.Log)
 	coreConfig := &core.CoreConfig{ExitOnFailure: false, Log: s.Log}
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/trimble-oss/tierceron/pkg/core"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	trcauth "github.com/trimble-oss/tierceron/trcweb/auth"
 	pb "github.com/trimble-oss/tierceron/trcweb/rpc/apinator"
 
 	"github.com/graphql-go/graphql"
@@ -84,10 +85,15 @@ type Provider struct {
 
 // GraphQL Accepts a GraphQL query and creates a response
 func (s *Server) GraphQL(ctx context.Context, req *pb.GraphQLQuery) (*pb.GraphQLResp, error) {
+	schema := s.schema()
+	vaultQL, err := s.vaultVals()
+	if err != nil {
+		return nil, err
+	}
 	rawResult := graphql.Do(graphql.Params{
-		Schema:        s.GQLSchema,
+		Schema:        schema,
 		RequestString: req.Query,
-		Context:       ctx,
+		Context:       context.WithValue(ctx, vaultQLKey{}, vaultQL),
 	})
 
 	result := &pb.GraphQLResp{}
@@ -97,15 +103,15 @@ func (s *Server) GraphQL(ctx context.Context, req *pb.GraphQLQuery) (*pb.GraphQL
 	return result, nil
 }
 
-// InitGQL Initializes the GQL schema
-func (s *Server) InitGQL() {
-	s.Log.Println("InitGQL")
+// loadVaultVals Reads values, templates and sessions from vault and merges
+// them into the nested structure served by GraphQL.
+func (s *Server) loadVaultVals() (*VaultVals, error) {
 	makeVaultReq := &pb.GetValuesReq{}
 	integrationSessions := map[string][]map[string]interface{}{} //
 	vaultSessions := map[string][]map[string]interface{}{}       //
 
 	// Fetch template keys and values
-	vault, err := s.GetValues(context.Background(), makeVaultReq)
+	vault, err := s.GetValues(internalContext(), makeVaultReq)
 	config := &core.CoreConfig{
 		ExitOnFailure: false,
 		Log:           s.Log,
@@ -114,7 +120,7 @@ func (s *Server) InitGQL() {
 	if err != nil {
 		eUtils.LogErrorObject(config, err, false)
 		eUtils.LogWarningsObject(config, []string{"GraphQL MAY not initialized (values not added)"}, false)
-		return
+		return nil, err
 	}
 
 	// Fetch secret keys and verification info
@@ -122,7 +128,7 @@ func (s *Server) InitGQL() {
 	if err != nil {
 		eUtils.LogErrorObject(config, err, false)
 		eUtils.LogWarningsObject(config, []string{"GraphQL MAY not initialized (secrets not added)"}, false)
-		return
+		return nil, err
 	}
 
 	envStrings := SelectedEnvironment
@@ -240,7 +246,32 @@ func (s *Server) InitGQL() {
 		}
 
 	}
-	vaultQL := VaultVals{Envs: envList}
+	return &VaultVals{Envs: envList}, nil
+}
+
+// InitGQL Initializes the GQL schema.  Data is resolved lazily per query
+// from a short lived snapshot of vault (see vaultVals).
+func (s *Server) InitGQL() {
+	s.gqlSchemaLock.Lock()
+	defer s.gqlSchemaLock.Unlock()
+	s.initGQL()
+}
+
+// schema Returns the GQL schema, building it on first use.
+func (s *Server) schema() graphql.Schema {
+	s.gqlSchemaLock.Lock()
+	defer s.gqlSchemaLock.Unlock()
+	if s.GQLSchema.QueryType() == nil {
+		s.initGQL()
+	}
+	return s.GQLSchema
+}
+
+// initGQL Builds the GQL schema, with gqlSchemaLock held.
+func (s *Server) initGQL() {
+	s.Log.Println("InitGQL")
+	s.InvalidateGQL()
+
 	// Convert data to a nested structure
 	var ValueObject = graphql.NewObject(
 		graphql.ObjectConfig{
@@ -271,7 +302,7 @@ func (s *Server) InitGQL() {
 						serv := params.Source.(Value).ServID
 						proj := params.Source.(Value).ProjID
 						env := params.Source.(Value).EnvID
-						return vaultQL.Envs[env].Projects[proj].Services[serv].Files[file].Values[val].Key, nil
+						return vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files[file].Values[val].Key, nil
 					},
 				},
 				"value": &graphql.Field{
@@ -284,7 +315,7 @@ func (s *Server) InitGQL() {
 						serv := params.Source.(Value).ServID
 						proj := params.Source.(Value).ProjID
 						env := params.Source.(Value).EnvID
-						return vaultQL.Envs[env].Projects[proj].Services[serv].Files[file].Values[val].Value, nil
+						return vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files[file].Values[val].Value, nil
 					},
 				},
 				"source": &graphql.Field{
@@ -297,7 +328,7 @@ func (s *Server) InitGQL() {
 						serv := params.Source.(Value).ServID
 						proj := params.Source.(Value).ProjID
 						env := params.Source.(Value).EnvID
-						return vaultQL.Envs[env].Projects[proj].Services[serv].Files[file].Values[val].Source, nil
+						return vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files[file].Values[val].Source, nil
 					},
 				},
 			},
@@ -327,7 +358,7 @@ func (s *Server) InitGQL() {
 						serv := params.Source.(File).ServID
 						proj := params.Source.(File).ProjID
 						env := params.Source.(File).EnvID
-						return vaultQL.Envs[env].Projects[proj].Services[serv].Files[file].Name, nil
+						return vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files[file].Name, nil
 					},
 				},
 				"values": &graphql.Field{
@@ -358,15 +389,16 @@ func (s *Server) InitGQL() {
 						if keyOK {
 							// Construct a regular expression based on the search
 							regex := regexp.MustCompile(`(?i).*` + keyStr + `.*`)
-							for i, v := range vaultQL.Envs[env].Projects[proj].Services[serv].Files[file].Values {
+							for i, v := range vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files[file].Values {
 								if regex.MatchString(v.Key) {
-									values = append(values, vaultQL.Envs[env].Projects[proj].Services[serv].Files[file].Values[i])
+									values = append(values, vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files[file].Values[i])
 								}
 							}
 						} else {
-							values = vaultQL.Envs[env].Projects[proj].Services[serv].Files[file].Values
+							values = vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files[file].Values
 						}
 
+						values = s.visibleValues(params.Context, vaultQLFrom(params), env, proj, serv, values)
 						if sourceOK {
 							filteredValues := []Value{}
 							for _, value := range values {
@@ -408,7 +440,7 @@ func (s *Server) InitGQL() {
 						serv := params.Source.(Service).ID
 						proj := params.Source.(Service).ProjID
 						env := params.Source.(Service).EnvID
-						return vaultQL.Envs[env].Projects[proj].Services[serv].Name, nil
+						return vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Name, nil
 					},
 				},
 				"files": &graphql.Field{
@@ -424,14 +456,14 @@ func (s *Server) InitGQL() {
 						proj := params.Source.(Service).ProjID
 						env := params.Source.(Service).EnvID
 						if isOK {
-							for i, f := range vaultQL.Envs[env].Projects[proj].Services[serv].Files {
+							for i, f := range vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files {
 								if f.Name == fileStr {
-									return []File{vaultQL.Envs[env].Projects[proj].Services[serv].Files[i]}, nil
+									return []File{vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files[i]}, nil
 								}
 							}
-							return vaultQL.Envs[env].Projects[proj].Services[serv].Files, errors.New("fileName not found")
+							return vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files, errors.New("fileName not found")
 						}
-						return vaultQL.Envs[env].Projects[proj].Services[serv].Files, nil
+						return vaultQLFrom(params).Envs[env].Projects[proj].Services[serv].Files, nil
 					},
 				},
 			},
@@ -451,7 +483,7 @@ func (s *Server) InitGQL() {
 					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 						proj := params.Source.(Project).ID
 						env := params.Source.(Project).EnvID
-						return vaultQL.Envs[env].Projects[proj].Name, nil
+						return vaultQLFrom(params).Envs[env].Projects[proj].Name, nil
 					},
 				},
 				"services": &graphql.Field{
@@ -465,15 +497,16 @@ func (s *Server) InitGQL() {
 						servStr, isOK := params.Args["servName"].(string)
 						proj := params.Source.(Project).ID
 						env := params.Source.(Project).EnvID
+						services := s.visibleServices(params.Context, vaultQLFrom(params), env, proj)
 						if isOK {
-							for i, p := range vaultQL.Envs[env].Projects[proj].Services {
+							for i, p := range services {
 								if p.Name == servStr {
-									return []Service{vaultQL.Envs[env].Projects[proj].Services[i]}, nil
+									return []Service{services[i]}, nil
 								}
 							}
-							return vaultQL.Envs[env].Projects[proj].Services, errors.New("servName not found")
+							return services, errors.New("servName not found")
 						}
-						return vaultQL.Envs[env].Projects[proj].Services, nil
+						return services, nil
 					},
 				},
 			},
@@ -498,7 +531,7 @@ func (s *Server) InitGQL() {
 						eID := params.Source.(map[string]interface{})["EnvID"].(int)
 						pID := params.Source.(map[string]interface{})["IntegrationID"].(int)
 						sID := params.Source.(map[string]interface{})["ID"].(int)
-						return vaultQL.Envs[eID].Providers[pID].Sessions[sID]["User"].(string), nil
+						return vaultQLFrom(params).Envs[eID].Providers[pID].Sessions[sID]["User"].(string), nil
 					},
 				},
 				"LastLogIn": &graphql.Field{
@@ -507,7 +540,7 @@ func (s *Server) InitGQL() {
 						eID := params.Source.(map[string]interface{})["EnvID"].(int)
 						pID := params.Source.(map[string]interface{})["IntegrationID"].(int)
 						sID := params.Source.(map[string]interface{})["ID"].(int)
-						return vaultQL.Envs[eID].Providers[pID].Sessions[sID]["LastLogIn"].(int64), nil
+						return vaultQLFrom(params).Envs[eID].Providers[pID].Sessions[sID]["LastLogIn"].(int64), nil
 					},
 				},
 			},
@@ -529,7 +562,7 @@ func (s *Server) InitGQL() {
 					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 						eid := params.Source.(Provider).EnvID
 						pid := params.Source.(Provider).ID
-						return vaultQL.Envs[eid].Providers[pid].Name, nil
+						return vaultQLFrom(params).Envs[eid].Providers[pid].Name, nil
 					},
 				},
 				"sessions": &graphql.Field{
@@ -546,7 +579,7 @@ func (s *Server) InitGQL() {
 						if userName, ok := params.Args["userName"].(string); ok {
 							regex := regexp.MustCompile(`(?i).*` + userName + `.*`)
 							sessions := []map[string]interface{}{}
-							for _, s := range vaultQL.Envs[eid].Providers[pid].Sessions {
+							for _, s := range vaultQLFrom(params).Envs[eid].Providers[pid].Sessions {
 								if regex.MatchString(s["User"].(string)) {
 									sessions = append(sessions, s)
 								}
@@ -554,7 +587,7 @@ func (s *Server) InitGQL() {
 							return sessions, nil
 						}
 
-						return vaultQL.Envs[eid].Providers[pid].Sessions, nil
+						return vaultQLFrom(params).Envs[eid].Providers[pid].Sessions, nil
 					},
 				},
 			},
@@ -572,7 +605,7 @@ func (s *Server) InitGQL() {
 					Type: graphql.NewNonNull(graphql.String),
 					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 						env := params.Source.(Env).ID
-						return vaultQL.Envs[env].Name, nil
+						return vaultQLFrom(params).Envs[env].Name, nil
 					},
 				},
 				"projects": &graphql.Field{
@@ -586,15 +619,16 @@ func (s *Server) InitGQL() {
 					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 
 						env := params.Source.(Env).ID
+						projects := s.visibleProjects(params.Context, vaultQLFrom(params), env)
 						if projStr, ok := params.Args["projName"].(string); ok {
-							for i, s := range vaultQL.Envs[env].Projects {
-								if s.Name == projStr {
-									return []Project{vaultQL.Envs[env].Projects[i]}, nil
+							for i, p := range projects {
+								if p.Name == projStr {
+									return []Project{projects[i]}, nil
 								}
 							}
-							return vaultQL.Envs[env].Projects, errors.New("projName not found")
+							return projects, errors.New("projName not found")
 						}
-						return vaultQL.Envs[env].Projects, nil
+						return projects, nil
 
 					},
 				},
@@ -607,18 +641,21 @@ func (s *Server) InitGQL() {
 					},
 					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 						eid := params.Source.(Env).ID
+						if !s.allowed(params.Context, trcauth.PERMISSION_ADMIN, vaultQLFrom(params).Envs[eid].Name, "", "") {
+							return []Provider{}, errors.New("providers not permitted")
+						}
 						if provName, ok := params.Args["provName"].(string); ok {
-							for _, p := range vaultQL.Envs[eid].Providers {
+							for _, p := range vaultQLFrom(params).Envs[eid].Providers {
 								if p.Name == provName {
 									return []Provider{p}, nil
 								}
 							}
-							return vaultQL.Envs[eid].Providers, errors.New("provName not found")
+							return vaultQLFrom(params).Envs[eid].Providers, errors.New("provName not found")
 						}
-						if len(vaultQL.Envs[eid].Providers) == 0 {
-							return vaultQL.Envs[eid].Providers, errors.New("no providers under environnment")
+						if len(vaultQLFrom(params).Envs[eid].Providers) == 0 {
+							return vaultQLFrom(params).Envs[eid].Providers, errors.New("no providers under environnment")
 						}
-						return vaultQL.Envs[eid].Providers, nil
+						return vaultQLFrom(params).Envs[eid].Providers, nil
 					},
 				},
 			},
@@ -637,8 +674,11 @@ func (s *Server) InitGQL() {
 					},
 					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
 						envs := []Env{}
-						for _, e := range vaultQL.Envs {
-							if e.Name == "dev" || e.Name == "QA" || e.Name == "RQA" || e.Name == "auto" || e.Name == "performance" || e.Name == "itdev" || e.Name == "servicepack" || e.Name == "staging" {
+						for _, e := range vaultQLFrom(params).Envs {
+							if !s.allowed(params.Context, trcauth.PERMISSION_READ_VALUES, e.Name, "", "") {
+								continue
+							}
+							if gqlSharedEnvironments[e.Name] {
 								envs = append(envs, e)
 							} else if e.Name == "local/"+params.Context.Value("user").(string) {
 								nameBlocks := strings.Split(params.Context.Value("user").(string), "/")
@@ -661,8 +701,15 @@ func (s *Server) InitGQL() {
 				},
 			},
 		})
-	s.GQLSchema, _ = graphql.NewSchema(graphql.SchemaConfig{
-		Query: VaultValObject,
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:        VaultValObject,
+		Mutation:     s.gqlMutationObject(),
+		Subscription: s.gqlSubscriptionObject(),
 	})
+	if err != nil {
+		eUtils.LogErrorObject(&core.CoreConfig{ExitOnFailure: false, Log: s.Log}, err, false)
+		return
+	}
+	s.GQLSchema = schema
 
 }
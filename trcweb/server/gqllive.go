@@ -0,0 +1,626 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/core"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+	trcauth "github.com/trimble-oss/tierceron/trcweb/auth"
+
+	"github.com/graphql-go/graphql"
+)
+
+// GQL_CACHE_TTL How long a snapshot of vault is served before queries reload it.
+const GQL_CACHE_TTL = 15 * time.Second
+
+// gqlPollInterval How often the snapshot is reloaded while there are subscribers.
+var gqlPollInterval = GQL_CACHE_TTL
+
+// GQL_SUBSCRIBER_BUFFER Changes buffered per subscriber before changes are dropped.
+const GQL_SUBSCRIBER_BUFFER = 64
+
+// Environments visible to every authenticated user.
+var gqlSharedEnvironments = map[string]bool{
+	"dev":         true,
+	"QA":          true,
+	"RQA":         true,
+	"auto":        true,
+	"performance": true,
+	"itdev":       true,
+	"servicepack": true,
+	"staging":     true,
+}
+
+// ValueChange describes a change to a value, pushed to subscribers.
+type ValueChange struct {
+	Env     string `json:"env"`
+	Project string `json:"project"`
+	Service string `json:"service"`
+	File    string `json:"file"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Action  string `json:"action"`
+	User    string `json:"user"`
+}
+
+type vaultQLKey struct{}
+
+// gqlSnapshot caches the merged vault data for GQL_CACHE_TTL.
+type gqlSnapshot struct {
+	mu       sync.Mutex
+	vals     *VaultVals
+	loadedAt time.Time
+	load     func() (*VaultVals, error) // Defaults to loadVaultVals.
+}
+
+// gqlSubscriber is a subscription of user to changes matching filters.
+type gqlSubscriber struct {
+	user    string
+	filters map[string]string
+	allowed func(ValueChange) bool
+}
+
+// gqlBroker fans value changes out to subscriptions.
+type gqlBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan interface{}]gqlSubscriber
+	polling     bool
+}
+
+func vaultQLFrom(params graphql.ResolveParams) *VaultVals {
+	return params.Context.Value(vaultQLKey{}).(*VaultVals)
+}
+
+func gqlUser(ctx context.Context) string {
+	if user, ok := ctx.Value("user").(string); ok {
+		return user
+	}
+	return ""
+}
+
+// gqlEnvironment maps an environment name as presented over GraphQL to the
+// vault environment, limited to what user may see.
+func gqlEnvironment(envName string, user string) (string, bool) {
+	if gqlSharedEnvironments[envName] {
+		return envName, true
+	}
+	if len(user) > 0 && envName == "local-"+strings.Split(user, "/")[0] {
+		return "local/" + user, true
+	}
+	return "", false
+}
+
+// gqlPresentedEnvironment is the reverse of gqlEnvironment.
+func gqlPresentedEnvironment(env string, user string) (string, bool) {
+	if gqlSharedEnvironments[env] {
+		return env, true
+	}
+	if len(user) > 0 && env == "local/"+user {
+		return "local-" + strings.Split(user, "/")[0], true
+	}
+	return "", false
+}
+
+// InvalidateGQL Forces the next query to reload data from vault.
+func (s *Server) InvalidateGQL() {
+	s.gqlCache.mu.Lock()
+	s.gqlCache.loadedAt = time.Time{}
+	s.gqlCache.mu.Unlock()
+}
+
+// vaultVals Returns the cached snapshot of vault, reloading it when stale.
+func (s *Server) vaultVals() (*VaultVals, error) {
+	return s.refreshVaultVals(false)
+}
+
+// refreshVaultVals Reloads the snapshot when stale or forced.  Changes made
+// outside of GraphQL are published to subscribers on reload.
+func (s *Server) refreshVaultVals(force bool) (*VaultVals, error) {
+	s.gqlCache.mu.Lock()
+	defer s.gqlCache.mu.Unlock()
+	if !force && s.gqlCache.vals != nil && time.Since(s.gqlCache.loadedAt) < GQL_CACHE_TTL {
+		return s.gqlCache.vals, nil
+	}
+	load := s.gqlCache.load
+	if load == nil {
+		load = s.loadVaultVals
+	}
+	vals, err := load()
+	if err != nil {
+		if s.gqlCache.vals != nil {
+			// Serve stale data rather than nothing while vault is unavailable.
+			return s.gqlCache.vals, nil
+		}
+		return nil, err
+	}
+	if s.gqlCache.vals != nil {
+		for _, change := range diffVaultVals(s.gqlCache.vals, vals) {
+			s.gqlBroker.publish(change)
+		}
+	}
+	s.gqlCache.vals = vals
+	s.gqlCache.loadedAt = time.Now()
+	return vals, nil
+}
+
+func flattenVaultVals(vals *VaultVals) map[ValueChange]string {
+	flat := map[ValueChange]string{}
+	for _, env := range vals.Envs {
+		for _, project := range env.Projects {
+			for _, service := range project.Services {
+				for _, file := range service.Files {
+					for _, value := range file.Values {
+						if value.Source != "value" {
+							continue
+						}
+						flat[ValueChange{Env: env.Name, Project: project.Name, Service: service.Name, File: file.Name, Key: value.Key}] = value.Value
+					}
+				}
+			}
+		}
+	}
+	return flat
+}
+
+func diffVaultVals(previous *VaultVals, current *VaultVals) []ValueChange {
+	changes := []ValueChange{}
+	before := flattenVaultVals(previous)
+	after := flattenVaultVals(current)
+	for location, value := range after {
+		if old, ok := before[location]; !ok || old != value {
+			change := location
+			change.Value = value
+			change.Action = "set"
+			changes = append(changes, change)
+		}
+	}
+	for location := range before {
+		if _, ok := after[location]; !ok {
+			change := location
+			change.Action = "delete"
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+func (subscriber gqlSubscriber) matches(change ValueChange) bool {
+	for arg, field := range map[string]string{"envName": change.Env, "projName": change.Project, "servName": change.Service} {
+		if filter, ok := subscriber.filters[arg]; ok && filter != field {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribe Adds a subscriber until ctx is done.  Reports whether polling
+// for changes needs to start.
+func (b *gqlBroker) subscribe(ctx context.Context, subscriber gqlSubscriber) (chan interface{}, bool) {
+	sub := make(chan interface{}, GQL_SUBSCRIBER_BUFFER)
+	b.mu.Lock()
+	if b.subscribers == nil {
+		b.subscribers = map[chan interface{}]gqlSubscriber{}
+	}
+	b.subscribers[sub] = subscriber
+	startPolling := !b.polling
+	b.polling = true
+	b.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subscribers, sub)
+		close(sub)
+		b.mu.Unlock()
+	}()
+	return sub, startPolling
+}
+
+// keepPolling Reports whether anyone is still subscribed, stopping polling
+// once no one is.
+func (b *gqlBroker) keepPolling() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.subscribers) == 0 {
+		b.polling = false
+	}
+	return b.polling
+}
+
+// pollGQL Reloads the snapshot every gqlPollInterval while there are
+// subscribers, so changes made outside of GraphQL reach them.
+func (s *Server) pollGQL() {
+	if _, err := s.vaultVals(); err != nil {
+		eUtils.LogErrorObject(&core.CoreConfig{ExitOnFailure: false, Log: s.Log}, err, false)
+	}
+	ticker := time.NewTicker(gqlPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !s.gqlBroker.keepPolling() {
+			return
+		}
+		if _, err := s.refreshVaultVals(true); err != nil {
+			eUtils.LogErrorObject(&core.CoreConfig{ExitOnFailure: false, Log: s.Log}, err, false)
+		}
+	}
+}
+
+// publish delivers change to matching subscribers that can see its
+// environment, dropping it for subscribers that aren't keeping up.
+func (b *gqlBroker) publish(change ValueChange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub, subscriber := range b.subscribers {
+		presented, ok := gqlPresentedEnvironment(change.Env, subscriber.user)
+		if !ok || !subscriber.allowed(change) {
+			continue
+		}
+		userChange := change
+		userChange.Env = presented
+		if !subscriber.matches(userChange) {
+			continue
+		}
+		select {
+		case sub <- userChange:
+		default:
+		}
+	}
+}
+
+// GraphQLSubscribe Runs a GraphQL subscription, returning a channel of results
+// that is closed when ctx is done.
+func (s *Server) GraphQLSubscribe(ctx context.Context, query string, variables map[string]interface{}) chan *graphql.Result {
+	return graphql.Subscribe(graphql.Params{
+		Schema:         s.schema(),
+		RequestString:  query,
+		VariableValues: variables,
+		Context:        ctx,
+	})
+}
+
+// validPathSegment guards against values paths escaping their project or service.
+func validPathSegment(segment string, allowSlash bool) bool {
+	if len(segment) == 0 || strings.HasPrefix(segment, "/") || strings.Contains(segment, "..") {
+		return false
+	}
+	return allowSlash || !strings.Contains(segment, "/")
+}
+
+// gqlMutationModifier Authorizes a mutation and returns a modifier for its environment.
+func (s *Server) gqlMutationModifier(params graphql.ResolveParams) (*helperkv.Modifier, string, error) {
+	user := gqlUser(params.Context)
+	if len(user) == 0 {
+		return nil, "", errors.New("mutations require an authenticated user")
+	}
+	envName, _ := params.Args["envName"].(string)
+	env, ok := gqlEnvironment(envName, user)
+	if !ok {
+		return nil, "", fmt.Errorf("envName not found: %s", envName)
+	}
+	for _, arg := range []string{"projName", "servName"} {
+		if name, _ := params.Args[arg].(string); !validPathSegment(name, false) {
+			return nil, "", fmt.Errorf("invalid %s", arg)
+		}
+	}
+	project, service := params.Args["projName"].(string), params.Args["servName"].(string)
+	if !s.allowed(params.Context, trcauth.PERMISSION_WRITE_VALUES, env, project, service) {
+		return nil, "", fmt.Errorf("writing %s/%s in %s not permitted", project, service, envName)
+	}
+	if fileName, ok := params.Args["fileName"].(string); ok && !validPathSegment(fileName, true) {
+		return nil, "", errors.New("invalid fileName")
+	}
+
+	mod, err := helperkv.NewModifier(false, s.VaultTokenPtr, s.VaultAddrPtr, "nonprod", nil, true, s.Log)
+	if err != nil {
+		eUtils.LogErrorObject(&core.CoreConfig{ExitOnFailure: false, Log: s.Log}, err, false)
+		return nil, "", err
+	}
+	mod.Env = env
+	mod.AuditActor = user
+	mod.AuditReason = "trcweb graphql mutation"
+	return mod, user, nil
+}
+
+func (s *Server) logMutation(user string, action string, env string, path string) {
+	s.Log.SetPrefix("[INFO]")
+	s.Log.Print(eUtils.SanitizeForLogging(fmt.Sprintf("GraphQL %s of %s in %s by %s", action, path, env, user)))
+}
+
+// changed Publishes a change made through GraphQL and expires the snapshot so
+// following queries see it.
+func (s *Server) changed(change ValueChange) {
+	s.InvalidateGQL()
+	s.gqlBroker.publish(change)
+}
+
+func (s *Server) setValue(params graphql.ResolveParams) (interface{}, error) {
+	mod, user, err := s.gqlMutationModifier(params)
+	if err != nil {
+		return nil, err
+	}
+	defer mod.Release()
+	change := ValueChange{
+		Env:     mod.Env,
+		Project: params.Args["projName"].(string),
+		Service: params.Args["servName"].(string),
+		File:    params.Args["fileName"].(string),
+		Key:     params.Args["keyName"].(string),
+		Value:   params.Args["value"].(string),
+		Action:  "set",
+		User:    user,
+	}
+	valuePath := "values/" + change.Project + "/" + change.Service + "/" + change.File
+
+	if err := setValueCAS(mod, valuePath, change.Key, change.Value, s.Log); err != nil {
+		eUtils.LogErrorObject(&core.CoreConfig{ExitOnFailure: false, Log: s.Log}, err, false)
+		return nil, err
+	}
+	s.logMutation(user, "set "+change.Key, mod.Env, valuePath)
+	s.changed(change)
+	change.Env, _ = gqlPresentedEnvironment(change.Env, user)
+	return change, nil
+}
+
+// setValueCAS Sets key in the values at valuePath with check and set writes,
+// so a concurrent change to another key of the file is kept rather than
+// overwritten.
+func setValueCAS(mod *helperkv.Modifier, valuePath string, key string, value string, logger *log.Logger) error {
+	for retries := 0; retries < 5; retries++ {
+		data, version, err := mod.ReadDataVersion(valuePath)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			data = map[string]interface{}{}
+		}
+		data[key] = value
+		_, err = mod.WriteCAS(valuePath, data, version, logger)
+		if err == nil || !strings.Contains(err.Error(), "check-and-set") {
+			return err
+		}
+	}
+	return fmt.Errorf("%s keeps changing, please retry", valuePath)
+}
+
+// createService Seeds the values of a service from its templates, as trcinit
+// would.  Defaults may be passed as a JSON object of key to value.
+func (s *Server) createService(params graphql.ResolveParams) (interface{}, error) {
+	mod, user, err := s.gqlMutationModifier(params)
+	if err != nil {
+		return nil, err
+	}
+	defer mod.Release()
+	config := &core.CoreConfig{ExitOnFailure: false, Log: s.Log}
+	project := params.Args["projName"].(string)
+	service := params.Args["servName"].(string)
+	defaults := map[string]interface{}{}
+	if defaultsJSON, ok := params.Args["values"].(string); ok && len(defaultsJSON) > 0 {
+		if err := json.Unmarshal([]byte(defaultsJSON), &defaults); err != nil {
+			return nil, fmt.Errorf("values must be a json object: %v", err)
+		}
+	}
+
+	filePaths, err := s.getTemplateFilePaths(config, mod, "templates/"+project+"/"+service+"/")
+	if err != nil {
+		return nil, err
+	}
+	// Collect the keys each values path needs from the service templates.
+	valuePaths := map[string]map[string]interface{}{}
+	for _, filePath := range filePaths {
+		if filePath[len(filePath)-1] == '/' {
+			continue
+		}
+		kvs, err := mod.ReadData(filePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range kvs {
+			ref, ok := v.([]interface{})
+			if !ok || len(ref) < 2 {
+				continue
+			}
+			fullPath, pathOk := ref[0].(string)
+			key, keyOk := ref[1].(string)
+			if !pathOk || !keyOk || !strings.HasPrefix(fullPath, "values/"+project+"/"+service+"/") {
+				continue
+			}
+			if valuePaths[fullPath] == nil {
+				valuePaths[fullPath] = map[string]interface{}{}
+			}
+			if defaultValue, ok := defaults[key]; ok {
+				valuePaths[fullPath][key] = fmt.Sprint(defaultValue)
+			} else {
+				valuePaths[fullPath][key] = ""
+			}
+		}
+	}
+	if len(valuePaths) == 0 {
+		return nil, fmt.Errorf("no templates found for %s/%s", project, service)
+	}
+
+	created := []string{}
+	for valuePath, data := range valuePaths {
+		existing, version, err := mod.ReadDataVersion(valuePath)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			return nil, fmt.Errorf("service %s/%s already has values in %s", project, service, params.Args["envName"])
+		}
+		if _, err := mod.WriteCAS(valuePath, data, version, s.Log); err != nil {
+			eUtils.LogErrorObject(config, err, false)
+			return created, err
+		}
+		s.logMutation(user, "create", mod.Env, valuePath)
+		created = append(created, valuePath)
+		for key, value := range data {
+			s.changed(ValueChange{
+				Env:     mod.Env,
+				Project: project,
+				Service: service,
+				File:    strings.TrimPrefix(valuePath, "values/"+project+"/"+service+"/"),
+				Key:     key,
+				Value:   value.(string),
+				Action:  "set",
+				User:    user,
+			})
+		}
+	}
+	return created, nil
+}
+
+// deletePath Soft deletes a file of values, or every file of a service when
+// no fileName is given.  Deleted versions remain recoverable in vault.
+func (s *Server) deletePath(params graphql.ResolveParams) (interface{}, error) {
+	mod, user, err := s.gqlMutationModifier(params)
+	if err != nil {
+		return nil, err
+	}
+	defer mod.Release()
+	config := &core.CoreConfig{ExitOnFailure: false, Log: s.Log}
+	project := params.Args["projName"].(string)
+	service := params.Args["servName"].(string)
+	servicePath := "values/" + project + "/" + service + "/"
+
+	valuePaths := []string{}
+	if fileName, ok := params.Args["fileName"].(string); ok {
+		valuePaths = append(valuePaths, servicePath+fileName)
+	} else {
+		paths, err := s.getPaths(config, mod, servicePath)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			if !strings.HasSuffix(path, "/") {
+				valuePaths = append(valuePaths, path)
+			}
+		}
+	}
+
+	deleted := []string{}
+	for _, valuePath := range valuePaths {
+		data, _ := mod.ReadData(valuePath)
+		if _, err := mod.SoftDelete(valuePath, s.Log); err != nil {
+			eUtils.LogErrorObject(config, err, false)
+			return deleted, err
+		}
+		s.logMutation(user, "delete", mod.Env, valuePath)
+		deleted = append(deleted, valuePath)
+		for key := range data {
+			s.changed(ValueChange{
+				Env:     mod.Env,
+				Project: project,
+				Service: service,
+				File:    strings.TrimPrefix(valuePath, servicePath),
+				Key:     key,
+				Action:  "delete",
+				User:    user,
+			})
+		}
+	}
+	return deleted, nil
+}
+
+var valueChangeObject = graphql.NewObject(
+	graphql.ObjectConfig{
+		Name: "ValueChange",
+		Fields: graphql.Fields{
+			"env":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"project": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"service": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"file":    &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"key":     &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"value":   &graphql.Field{Type: graphql.String},
+			"action":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"user":    &graphql.Field{Type: graphql.String},
+		},
+	})
+
+func (s *Server) gqlMutationObject() *graphql.Object {
+	requiredString := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}
+	return graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Mutation",
+			Fields: graphql.Fields{
+				"setValue": &graphql.Field{
+					Type: valueChangeObject,
+					Args: graphql.FieldConfigArgument{
+						"envName":  requiredString,
+						"projName": requiredString,
+						"servName": requiredString,
+						"fileName": requiredString,
+						"keyName":  requiredString,
+						"value":    requiredString,
+					},
+					Resolve: s.setValue,
+				},
+				"createService": &graphql.Field{
+					Type: graphql.NewList(graphql.String),
+					Args: graphql.FieldConfigArgument{
+						"envName":  requiredString,
+						"projName": requiredString,
+						"servName": requiredString,
+						"values":   &graphql.ArgumentConfig{Type: graphql.String},
+					},
+					Resolve: s.createService,
+				},
+				"deletePath": &graphql.Field{
+					Type: graphql.NewList(graphql.String),
+					Args: graphql.FieldConfigArgument{
+						"envName":  requiredString,
+						"projName": requiredString,
+						"servName": requiredString,
+						"fileName": &graphql.ArgumentConfig{Type: graphql.String},
+					},
+					Resolve: s.deletePath,
+				},
+			},
+		})
+}
+
+func (s *Server) gqlSubscriptionObject() *graphql.Object {
+	return graphql.NewObject(
+		graphql.ObjectConfig{
+			Name: "Subscription",
+			Fields: graphql.Fields{
+				"valueChanged": &graphql.Field{
+					Type: valueChangeObject,
+					Args: graphql.FieldConfigArgument{
+						"envName":  &graphql.ArgumentConfig{Type: graphql.String},
+						"projName": &graphql.ArgumentConfig{Type: graphql.String},
+						"servName": &graphql.ArgumentConfig{Type: graphql.String},
+					},
+					Subscribe: func(params graphql.ResolveParams) (interface{}, error) {
+						user := gqlUser(params.Context)
+						if len(user) == 0 {
+							return nil, errors.New("subscriptions require an authenticated user")
+						}
+						ctx := params.Context
+						subscriber := gqlSubscriber{user: user, filters: map[string]string{}, allowed: func(change ValueChange) bool {
+							return s.allowed(ctx, trcauth.PERMISSION_READ_VALUES, change.Env, change.Project, change.Service)
+						}}
+						for arg, value := range params.Args {
+							if filter, ok := value.(string); ok {
+								subscriber.filters[arg] = filter
+							}
+						}
+						sub, startPolling := s.gqlBroker.subscribe(params.Context, subscriber)
+						if startPolling {
+							go s.pollGQL()
+						}
+						return sub, nil
+					},
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						return params.Source, nil
+					},
+				},
+			},
+		})
+}
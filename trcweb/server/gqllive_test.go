@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/vaulthelper/kv/kvtest"
+	trcauth "github.com/trimble-oss/tierceron/trcweb/auth"
+
+	"github.com/graphql-go/graphql"
+)
+
+func testVaultVals(env string, value string) *VaultVals {
+	return &VaultVals{Envs: []Env{{Name: env, Projects: []Project{{Name: "Billing", Services: []Service{{Name: "Invoice", Files: []File{{Name: "config", Values: []Value{
+		{Key: "port", Value: value, Source: "value"},
+		{Key: "password", Value: "verified", Source: "templates"},
+	}}}}}}}}}}
+}
+
+func testServer(t *testing.T, policyJSON string) *Server {
+	s := NewServer(nil, nil)
+	if len(policyJSON) > 0 {
+		policy, err := trcauth.ParsePolicy([]byte(policyJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.policyCache.policy = policy
+	}
+	s.InitGQL()
+	return s
+}
+
+func userContext(user string, roles ...string) context.Context {
+	return context.WithValue(context.WithValue(context.Background(), "user", user), "roles", roles)
+}
+
+func receiveChange(t *testing.T, sub chan interface{}) (ValueChange, bool) {
+	select {
+	case change := <-sub:
+		return change.(ValueChange), true
+	case <-time.After(100 * time.Millisecond):
+		return ValueChange{}, false
+	}
+}
+
+func TestGQLBroker(t *testing.T) {
+	broker := &gqlBroker{}
+	ctx, cancel := context.WithCancel(context.Background())
+	all := func(ValueChange) bool { return true }
+	devSub, startPolling := broker.subscribe(ctx, gqlSubscriber{user: "jdoe", filters: map[string]string{"envName": "dev", "projName": "Billing"}, allowed: all})
+	if !startPolling {
+		t.Fatal("Expected the first subscription to start polling")
+	}
+	localSub, startPolling := broker.subscribe(ctx, gqlSubscriber{user: "jdoe/laptop", filters: map[string]string{}, allowed: all})
+	if startPolling {
+		t.Fatal("Expected polling already started")
+	}
+	deniedSub, _ := broker.subscribe(ctx, gqlSubscriber{user: "asmith", filters: map[string]string{}, allowed: func(ValueChange) bool { return false }})
+
+	broker.publish(ValueChange{Env: "dev", Project: "Billing", Service: "Invoice", Key: "port"})
+	if change, ok := receiveChange(t, devSub); !ok || change.Key != "port" {
+		t.Fatalf("Expected the dev change, got %v", change)
+	}
+	broker.publish(ValueChange{Env: "dev", Project: "Shipping", Service: "Invoice", Key: "port"})
+	broker.publish(ValueChange{Env: "prod", Project: "Billing", Service: "Invoice", Key: "port"})
+	if change, ok := receiveChange(t, devSub); ok {
+		t.Fatalf("Expected other projects and environments filtered, got %v", change)
+	}
+
+	// Local environments are only seen by their user, under their presented name.
+	broker.publish(ValueChange{Env: "local/jdoe/laptop", Project: "Billing", Key: "port"})
+	for {
+		change, ok := receiveChange(t, localSub)
+		if !ok {
+			t.Fatal("Expected the local change")
+		}
+		if change.Env == "local-jdoe" {
+			break
+		}
+	}
+	if _, ok := receiveChange(t, deniedSub); ok {
+		t.Fatal("Expected nothing published to a subscriber without access")
+	}
+
+	cancel()
+	for _, sub := range []chan interface{}{devSub, localSub, deniedSub} {
+		for range sub {
+		}
+	}
+	if broker.keepPolling() {
+		t.Fatal("Expected polling to stop without subscribers")
+	}
+}
+
+func TestGQLSubscriptionPolling(t *testing.T) {
+	defer func(interval time.Duration) { gqlPollInterval = interval }(gqlPollInterval)
+	gqlPollInterval = 10 * time.Millisecond
+
+	s := testServer(t, "")
+	var lock sync.Mutex
+	vals := testVaultVals("dev", "8080")
+	s.gqlCache.load = func() (*VaultVals, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return vals, nil
+	}
+	ctx, cancel := context.WithCancel(userContext("jdoe"))
+	defer cancel()
+	results := s.GraphQLSubscribe(ctx, `subscription { valueChanged(envName: "dev", servName: "Invoice") { env service key value action } }`, nil)
+
+	// Changed outside of GraphQL, such as by trcinit.
+	time.Sleep(5 * gqlPollInterval)
+	lock.Lock()
+	vals = testVaultVals("dev", "9090")
+	lock.Unlock()
+	select {
+	case result := <-results:
+		if len(result.Errors) > 0 {
+			t.Fatal(result.Errors)
+		}
+		change := result.Data.(map[string]interface{})["valueChanged"].(map[string]interface{})
+		if change["key"] != "port" || change["value"] != "9090" || change["action"] != "set" {
+			t.Fatalf("Unexpected change %v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the polled change")
+	}
+}
+
+func TestGQLMutationAuthorization(t *testing.T) {
+	s := testServer(t, `{"grants": [{"roles": ["developer"], "permissions": ["readValues", "writeValues"], "envs": ["dev"]}]}`)
+	setValue := func(ctx context.Context, env string, project string) *graphql.Result {
+		return graphql.Do(graphql.Params{
+			Schema:        s.GQLSchema,
+			RequestString: `mutation { setValue(envName: "` + env + `", projName: "` + project + `", servName: "Invoice", fileName: "config", keyName: "port", value: "1") { key } }`,
+			Context:       ctx,
+		})
+	}
+	for _, rejected := range []struct {
+		ctx     context.Context
+		env     string
+		project string
+		reason  string
+	}{
+		{context.Background(), "dev", "Billing", "authenticated user"},
+		{userContext("jdoe", "developer"), "nowhere", "Billing", "envName not found"},
+		{userContext("jdoe", "developer"), "prod", "Billing", "envName not found"},
+		{userContext("jdoe", "developer"), "dev", "..", "invalid projName"},
+		{userContext("jdoe", "reader"), "dev", "Billing", "not permitted"},
+		{userContext("jdoe", "developer"), "staging", "Billing", "not permitted"},
+	} {
+		result := setValue(rejected.ctx, rejected.env, rejected.project)
+		if len(result.Errors) == 0 || !strings.Contains(result.Errors[0].Message, rejected.reason) {
+			t.Fatalf("Expected setValue in %s/%s rejected for %s, got %v", rejected.env, rejected.project, rejected.reason, result.Errors)
+		}
+	}
+
+	// Changes made through GraphQL reach subscribers and expire the snapshot.
+	s.gqlCache.vals = testVaultVals("dev", "8080")
+	s.gqlCache.loadedAt = time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, _ := s.gqlBroker.subscribe(ctx, gqlSubscriber{user: "jdoe", filters: map[string]string{}, allowed: func(ValueChange) bool { return true }})
+	s.changed(ValueChange{Env: "dev", Project: "Billing", Service: "Invoice", Key: "port", Value: "1", Action: "set"})
+	if change, ok := receiveChange(t, sub); !ok || change.Value != "1" {
+		t.Fatalf("Expected the mutation published, got %v", change)
+	}
+	if !s.gqlCache.loadedAt.IsZero() {
+		t.Fatal("Expected the snapshot expired")
+	}
+}
+
+func TestSetValueCAS(t *testing.T) {
+	vault := kvtest.NewVault(t)
+	vault.Put("values/data/dev/Billing/Invoice/config", map[string]interface{}{"host": "localhost"})
+	logger := log.New(io.Discard, "", 0)
+
+	// Concurrent sets of different keys of a file all land.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			mod := kvtest.NewModifier(t, vault, "dev")
+			if err := setValueCAS(mod, "values/Billing/Invoice/config", key, "1", logger); err != nil {
+				t.Errorf("Expected %s set, got %v", key, err)
+			}
+		}(fmt.Sprintf("key%d", i))
+	}
+	wg.Wait()
+	data := vault.Get("values/data/dev/Billing/Invoice/config")
+	if len(data) != 6 || data["host"] != "localhost" {
+		t.Fatalf("Expected every key kept, got %v", data)
+	}
+}
+
+func TestDiffVaultVals(t *testing.T) {
+	changes := diffVaultVals(testVaultVals("dev", "8080"), testVaultVals("dev", "9090"))
+	if len(changes) != 1 || changes[0].Key != "port" || changes[0].Value != "9090" || changes[0].Action != "set" {
+		t.Fatalf("Unexpected changes %v", changes)
+	}
+	changes = diffVaultVals(testVaultVals("dev", "8080"), &VaultVals{})
+	if len(changes) != 1 || changes[0].Action != "delete" {
+		t.Fatalf("Expected values dropped from vault deleted, got %v", changes)
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trimble-oss/tierceron/pkg/core"
+	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
+	trcauth "github.com/trimble-oss/tierceron/trcweb/auth"
+)
+
+// POLICY_REFRESH How often the access policy is reread from vault.
+const POLICY_REFRESH = time.Minute
+
+type internalKey struct{}
+
+// policyCache holds the access policy read from trcAPIPolicy in apiLogins/meta.
+// Without a policy every authenticated caller may call every method.
+type policyCache struct {
+	mu       sync.Mutex
+	env      string
+	policy   *trcauth.Policy
+	loadedAt time.Time
+}
+
+// internalContext marks server initiated reads, which are not filtered by policy.
+func internalContext() context.Context {
+	return context.WithValue(context.Background(), internalKey{}, true)
+}
+
+func principalFrom(ctx context.Context) (trcauth.Principal, bool) {
+	user, ok := ctx.Value("user").(string)
+	if !ok || len(user) == 0 {
+		return trcauth.Principal{}, false
+	}
+	roles, _ := ctx.Value("roles").([]string)
+	return trcauth.Principal{Subject: user, Roles: roles}, true
+}
+
+// loadPolicy reads the access policy from connInfo.
+func (s *Server) loadPolicy(config *core.CoreConfig, env string, connInfo map[string]interface{}) {
+	s.policyCache.mu.Lock()
+	defer s.policyCache.mu.Unlock()
+	s.policyCache.env = env
+	s.policyCache.loadedAt = time.Now()
+
+	policyJSON, ok := connInfo["trcAPIPolicy"].(string)
+	if !ok || len(policyJSON) == 0 {
+		if s.policyCache.policy != nil {
+			eUtils.LogWarningsObject(config, []string{"trcAPIPolicy removed, access policy no longer enforced"}, false)
+		}
+		s.policyCache.policy = nil
+		return
+	}
+	policy, err := trcauth.ParsePolicy([]byte(policyJSON))
+	if err != nil {
+		// Keep enforcing the last good policy rather than opening access.
+		eUtils.LogErrorObject(config, fmt.Errorf("invalid trcAPIPolicy: %v", err), false)
+		return
+	}
+	s.policyCache.policy = policy
+}
+
+// Policy Returns the access policy, rereading it from vault when stale.
+func (s *Server) Policy() *trcauth.Policy {
+	s.policyCache.mu.Lock()
+	env := s.policyCache.env
+	stale := len(env) > 0 && time.Since(s.policyCache.loadedAt) > POLICY_REFRESH
+	policy := s.policyCache.policy
+	s.policyCache.mu.Unlock()
+	if stale {
+		config := &core.CoreConfig{ExitOnFailure: false, Log: s.Log}
+		connInfo, err := s.GetConfig(env, "apiLogins/meta")
+		if err != nil {
+			eUtils.LogErrorObject(config, err, false)
+			s.policyCache.mu.Lock()
+			s.policyCache.loadedAt = time.Now()
+			s.policyCache.mu.Unlock()
+			return policy
+		}
+		s.loadPolicy(config, env, connInfo)
+		s.policyCache.mu.Lock()
+		policy = s.policyCache.policy
+		s.policyCache.mu.Unlock()
+	}
+	return policy
+}
+
+// RequiresToken Reports whether method needs an authenticated caller.  Only
+// enforced once a policy is configured so vaults can still be initialized
+// and unsealed before one exists.
+func (s *Server) RequiresToken(method string) bool {
+	policy := s.Policy()
+	return policy != nil && policy.RequiresToken(method)
+}
+
+// AuthorizeMethod Checks that the caller in ctx may call method.
+func (s *Server) AuthorizeMethod(ctx context.Context, method string) error {
+	policy := s.Policy()
+	if policy == nil {
+		return nil
+	}
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		if policy.RequiresToken(method) {
+			return fmt.Errorf("%s requires an authenticated user", method)
+		}
+		return nil
+	}
+	if !policy.AllowedMethod(principal, method) {
+		return fmt.Errorf("%s not permitted for %s", method, principal.Subject)
+	}
+	return nil
+}
+
+// AuthorizeEnv Checks that the caller in ctx may call method against env.
+// AuthorizeMethod only checks that some grant gives the method's permission,
+// so methods acting on an environment check it here.
+func (s *Server) AuthorizeEnv(ctx context.Context, method string, env string) error {
+	policy := s.Policy()
+	if policy == nil {
+		return nil
+	}
+	permission, ok := trcauth.MethodPermissions[method]
+	if !ok {
+		return nil
+	}
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		if policy.RequiresToken(method) {
+			return fmt.Errorf("%s requires an authenticated user", method)
+		}
+		return nil
+	}
+	if !s.allowed(ctx, permission, env, "", "") {
+		return fmt.Errorf("%s in %s not permitted for %s", method, env, principal.Subject)
+	}
+	return nil
+}
+
+// serverEnv The environment the server was configured for.
+func (s *Server) serverEnv() string {
+	s.policyCache.mu.Lock()
+	defer s.policyCache.mu.Unlock()
+	return s.policyCache.env
+}
+
+// allowed Reports whether the caller in ctx has permission in the scope.
+func (s *Server) allowed(ctx context.Context, permission string, env string, project string, service string) bool {
+	if internal, _ := ctx.Value(internalKey{}).(bool); internal {
+		return true
+	}
+	policy := s.Policy()
+	if policy == nil {
+		return true
+	}
+	principal, ok := principalFrom(ctx)
+	if !ok {
+		return false
+	}
+	return policy.Allowed(principal, permission, env, project, service)
+}
+
+// visibleProjects Filters the projects of env to those the caller may read.
+func (s *Server) visibleProjects(ctx context.Context, vals *VaultVals, env int) []Project {
+	projects := []Project{}
+	for _, project := range vals.Envs[env].Projects {
+		if s.allowed(ctx, trcauth.PERMISSION_READ_VALUES, vals.Envs[env].Name, project.Name, "") {
+			projects = append(projects, project)
+		}
+	}
+	return projects
+}
+
+// visibleServices Filters the services of a project to those the caller may read.
+func (s *Server) visibleServices(ctx context.Context, vals *VaultVals, env int, proj int) []Service {
+	services := []Service{}
+	project := vals.Envs[env].Projects[proj]
+	for _, service := range project.Services {
+		if s.allowed(ctx, trcauth.PERMISSION_READ_VALUES, vals.Envs[env].Name, project.Name, service.Name) {
+			services = append(services, service)
+		}
+	}
+	return services
+}
+
+// visibleValues Drops secret verification results the caller may not read.
+func (s *Server) visibleValues(ctx context.Context, vals *VaultVals, env int, proj int, serv int, values []Value) []Value {
+	project := vals.Envs[env].Projects[proj]
+	if s.allowed(ctx, trcauth.PERMISSION_READ_SECRETS, vals.Envs[env].Name, project.Name, project.Services[serv].Name) {
+		return values
+	}
+	visible := []Value{}
+	for _, value := range values {
+		if value.Source != "templates" {
+			visible = append(visible, value)
+		}
+	}
+	return visible
+}
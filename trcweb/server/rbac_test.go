@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/trimble-oss/tierceron/trcweb/rpc/apinator"
+)
+
+func TestAuthorizeEnv(t *testing.T) {
+	s := testServer(t, `{
+		"grants": [
+			{"roles": ["developer"], "permissions": ["readSecrets", "rollTokens", "unseal", "init"], "envs": ["dev"]},
+			{"subjects": ["opsadmin"], "permissions": ["admin"]}
+		],
+		"unauthenticated": ["GetVaultTokens"]
+	}`)
+	s.policyCache.env = "prod"
+	s.policyCache.loadedAt = time.Now()
+	developer := userContext("jdoe", "developer")
+	admin := userContext("opsadmin")
+
+	// The method is granted, but only in dev.
+	for _, method := range []string{"GetVaultTokens", "RollTokens", "Unseal", "InitVault"} {
+		if err := s.AuthorizeMethod(developer, method); err != nil {
+			t.Fatalf("Expected %s granted %v", method, err)
+		}
+		if err := s.AuthorizeEnv(developer, method, "dev"); err != nil {
+			t.Fatalf("Expected %s allowed in dev %v", method, err)
+		}
+		if err := s.AuthorizeEnv(developer, method, "prod"); err == nil {
+			t.Fatalf("Expected %s denied in prod", method)
+		}
+		if err := s.AuthorizeEnv(admin, method, "prod"); err != nil {
+			t.Fatalf("Expected %s allowed for admin %v", method, err)
+		}
+	}
+	if err := s.AuthorizeEnv(context.Background(), "GetVaultTokens", "prod"); err != nil {
+		t.Fatalf("Expected unauthenticated GetVaultTokens allowed %v", err)
+	}
+	if err := s.AuthorizeEnv(context.Background(), "Unseal", "prod"); err == nil {
+		t.Fatal("Expected unauthenticated Unseal denied")
+	}
+
+	// The RPCs check the environment they act on before touching vault.
+	if _, err := s.Unseal(developer, &pb.UnsealReq{}); err == nil || !strings.Contains(err.Error(), "Unseal in prod not permitted") {
+		t.Fatalf("Expected Unseal denied in prod, got %v", err)
+	}
+	if _, err := s.RollTokens(developer, &pb.NoParams{}); err == nil {
+		t.Fatal("Expected RollTokens denied in prod")
+	}
+	if _, err := s.GetVaultTokens(developer, &pb.TokensReq{}); err == nil || !strings.Contains(err.Error(), "not permitted") {
+		t.Fatalf("Expected GetVaultTokens denied in prod, got %v", err)
+	}
+	if resp, err := s.InitVault(developer, &pb.InitReq{Env: "prod"}); err == nil || resp.Success {
+		t.Fatalf("Expected InitVault denied in prod, got %v", err)
+	}
+}
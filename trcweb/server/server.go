@@ -8,10 +8,12 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 
 	"github.com/trimble-oss/tierceron/pkg/core"
 	eUtils "github.com/trimble-oss/tierceron/pkg/utils"
 	helperkv "github.com/trimble-oss/tierceron/pkg/vaulthelper/kv"
+	trcauth "github.com/trimble-oss/tierceron/trcweb/auth"
 	pb "github.com/trimble-oss/tierceron/trcweb/rpc/apinator"
 
 	gql "github.com/graphql-go/graphql"
@@ -29,7 +31,11 @@ type Server struct {
 	VaultAddrPtr      *string
 	TrcAPITokenSecret []byte
 	GQLSchema         gql.Schema
+	gqlSchemaLock     sync.Mutex
 	Log               *log.Logger
+	gqlCache          gqlSnapshot
+	gqlBroker         gqlBroker
+	policyCache       policyCache
 }
 
 // NewServer Creates a new server struct and initializes the GraphQL schema
@@ -58,6 +64,7 @@ func (s *Server) InitConfig(config *core.CoreConfig, env string) error {
 	}
 
 	s.TrcAPITokenSecret = []byte(trcAPITokenSecretString)
+	s.loadPolicy(config, env, connInfo)
 	return nil
 }
 
@@ -197,6 +204,9 @@ func (s *Server) GetValues(ctx context.Context, req *pb.GetValuesReq) (*pb.Value
 			eUtils.LogErrorObject(config, err, false)
 			return nil, err
 		}
+		if !s.allowed(ctx, trcauth.PERMISSION_READ_VALUES, environment, "", "") {
+			continue
+		}
 		mod.Env = environment
 		projects := []*pb.ValuesRes_Env_Project{}
 		//get a list of projects under values
@@ -207,6 +217,9 @@ func (s *Server) GetValues(ctx context.Context, req *pb.GetValuesReq) (*pb.Value
 		}
 
 		for _, projectPath := range projectPaths {
+			if !s.allowed(ctx, trcauth.PERMISSION_READ_VALUES, environment, getPathEnd(projectPath), "") {
+				continue
+			}
 			services := []*pb.ValuesRes_Env_Project_Service{}
 			//get a list of files under project
 			servicePaths, err := s.getPaths(config, mod, projectPath)
@@ -218,6 +231,9 @@ func (s *Server) GetValues(ctx context.Context, req *pb.GetValuesReq) (*pb.Value
 			}
 
 			for _, servicePath := range servicePaths {
+				if !s.allowed(ctx, trcauth.PERMISSION_READ_VALUES, environment, getPathEnd(projectPath), getPathEnd(servicePath)) {
+					continue
+				}
 				files := []*pb.ValuesRes_Env_Project_Service_File{}
 				//get a list of files under project
 				filePaths, err := s.getPaths(config, mod, servicePath)
@@ -48,6 +48,9 @@ func (s *Server) generateJWT(user string, id string, mod *helperkv.Modifier) (st
 
 // GetVaultTokens takes app role credentials and attempts to fetch names tokens from the vault
 func (s *Server) GetVaultTokens(ctx context.Context, req *pb.TokensReq) (*pb.TokensResp, error) {
+	if err := s.AuthorizeEnv(ctx, "GetVaultTokens", s.serverEnv()); err != nil {
+		return nil, err
+	}
 	// Create 2 vault connections, one for checking/rolling tokens, the other for accessing the AWS user cubbyhole
 	v, err := sys.NewVault(false, s.VaultAddrPtr, "nonprod", false, false, false, s.Log)
 	config := &core.CoreConfig{ExitOnFailure: false, Log: s.Log}
@@ -120,5 +123,8 @@ func (s *Server) GetVaultTokens(ctx context.Context, req *pb.TokensReq) (*pb.Tok
 
 // RollTokens checks the validity of tokens in super-secrets/bamboo/tokens and rerolls them
 func (s *Server) RollTokens(ctx context.Context, req *pb.NoParams) (*pb.NoParams, error) {
+	if err := s.AuthorizeEnv(ctx, "RollTokens", s.serverEnv()); err != nil {
+		return nil, err
+	}
 	return &pb.NoParams{}, nil
 }
@@ -0,0 +1,178 @@
+import com.sun.jna.*;
+import java.util.*;
+import java.util.concurrent.CountDownLatch;
+
+/**
+ * Renders tierceron templates through libzeroconfig using JNA.
+ *
+ * Build the library and its header with `make xlib` (nc.so and nc.h) or:
+ *   go build -buildmode=c-shared -o libzeroconfig.so ./zeroconfiglib
+ *
+ * Run with:
+ *   java -cp jna.jar Client.java ./libzeroconfig.so https://vault:8200 $VAULT_TOKEN dev Project Service \
+ *       trc_templates/Project/Service/config.yml.tmpl [more templates...]
+ *
+ * Set ZC_INSECURE=1 to skip tls verification against a local vault.  Set ZC_WATCH=N to
+ * watch the first template and print its first N renders (the initial render, then one
+ * per change) as a service hot reloading its config would receive them.
+ */
+public class Client {
+  static final int ZC_API_VERSION = 1;
+  static final int ZC_OK = 0;
+
+  public interface ZeroConfig extends Library {
+    @Structure.FieldOrder({"token", "address", "env", "insecure"})
+    class ZcConfig extends Structure {
+      public String token;
+      public String address;
+      public String env;
+      public int insecure;
+    }
+
+    @Structure.FieldOrder({"templatePath", "project", "service", "wantCert"})
+    class ZcRequest extends Structure {
+      public String templatePath;
+      public String project;
+      public String service;
+      public int wantCert;
+    }
+
+    @Structure.FieldOrder({"code", "error", "data", "length"})
+    class ZcResult extends Structure {
+      public int code;
+      public Pointer error;
+      public Pointer data;
+      public NativeLong length;
+
+      public ZcResult() {}
+
+      public ZcResult(Pointer p) {
+        super(p);
+        read();
+      }
+    }
+
+    @Structure.FieldOrder({"intervalMs", "debounceMs"})
+    class ZcWatchOptions extends Structure {
+      public int intervalMs;
+      public int debounceMs;
+    }
+
+    interface ZcWatchCallback extends Callback {
+      // result is only valid during the call; on failure data is the last good render.
+      void invoke(long watchId, ZcResult result, Pointer userData);
+    }
+
+    int ZcVersion();
+
+    // requests is the first element of a contiguous ZcRequest array.
+    Pointer ZcConfigTemplates(ZcConfig config, ZcRequest requests, int count);
+
+    void ZcFree(Pointer results, int count);
+
+    long ZcWatch(ZcConfig config, ZcRequest request, ZcWatchOptions options, ZcWatchCallback callback, Pointer userData);
+
+    int ZcUnwatch(long watchId);
+  }
+
+  public static void main(String[] argv) throws InterruptedException {
+    if (argv.length < 7) {
+      System.err.println("usage: Client <lib> <address> <token> <env> <project> <service> <template>...");
+      System.exit(2);
+    }
+    ZeroConfig zc = Native.load(argv[0], ZeroConfig.class);
+    if (zc.ZcVersion() != ZC_API_VERSION) {
+      throw new IllegalStateException("unsupported zeroconfig api version " + zc.ZcVersion());
+    }
+
+    ZeroConfig.ZcConfig config = new ZeroConfig.ZcConfig();
+    config.address = argv[1];
+    config.token = argv[2];
+    config.env = argv[3];
+    config.insecure = "1".equals(System.getenv("ZC_INSECURE")) ? 1 : 0;
+
+    String watch = System.getenv("ZC_WATCH");
+    if (watch != null) {
+      watch(zc, config, argv[4], argv[5], argv[6], Integer.parseInt(watch));
+      return;
+    }
+
+    int count = argv.length - 6;
+    ZeroConfig.ZcRequest[] requests = (ZeroConfig.ZcRequest[]) new ZeroConfig.ZcRequest().toArray(count);
+    for (int i = 0; i < count; i++) {
+      requests[i].project = argv[4];
+      requests[i].service = argv[5];
+      requests[i].templatePath = argv[6 + i];
+      requests[i].wantCert = 0;
+      requests[i].write();
+    }
+
+    Pointer results = zc.ZcConfigTemplates(config, requests[0], count);
+    boolean failed = false;
+    try {
+      int size = new ZeroConfig.ZcResult().size();
+      for (int i = 0; i < count; i++) {
+        ZeroConfig.ZcResult result = new ZeroConfig.ZcResult(results.share((long) i * size));
+        failed |= result.code != ZC_OK;
+        print(result);
+      }
+    } finally {
+      zc.ZcFree(results, count);
+    }
+    System.exit(failed ? 1 : 0);
+  }
+
+  static void watch(ZeroConfig zc, ZeroConfig.ZcConfig config, String project, String service, String template, int renders)
+      throws InterruptedException {
+    ZeroConfig.ZcRequest request = new ZeroConfig.ZcRequest();
+    request.project = project;
+    request.service = service;
+    request.templatePath = template;
+    ZeroConfig.ZcWatchOptions options = new ZeroConfig.ZcWatchOptions();
+    options.intervalMs = Integer.parseInt(System.getenv().getOrDefault("ZC_INTERVAL_MS", "0"));
+    options.debounceMs = Integer.parseInt(System.getenv().getOrDefault("ZC_DEBOUNCE_MS", "0"));
+
+    CountDownLatch latch = new CountDownLatch(renders);
+    // Held in a local for the life of the watch so it isn't garbage collected.
+    ZeroConfig.ZcWatchCallback callback = (watchId, result, userData) -> {
+      if (latch.getCount() > 0) {
+        print(result);
+        latch.countDown();
+      }
+    };
+    long watchId = zc.ZcWatch(config, request, options, callback, null);
+    if (watchId < 0) {
+      throw new IllegalStateException("ZcWatch failed with code " + -watchId);
+    }
+    latch.await();
+    zc.ZcUnwatch(watchId);
+  }
+
+  static void print(ZeroConfig.ZcResult result) {
+    String data = result.data == null ? "null"
+        : quote(new String(result.data.getByteArray(0, result.length.intValue()), java.nio.charset.StandardCharsets.UTF_8));
+    String error = result.error == null ? "null" : quote(result.error.getString(0));
+    System.out.printf("{\"code\": %d, \"error\": %s, \"data\": %s}%n", result.code, error, data);
+    System.out.flush();
+  }
+
+  static String quote(String s) {
+    StringBuilder quoted = new StringBuilder("\"");
+    for (char c : s.toCharArray()) {
+      switch (c) {
+        case '"': quoted.append("\\\""); break;
+        case '\\': quoted.append("\\\\"); break;
+        case '\n': quoted.append("\\n"); break;
+        case '\r': quoted.append("\\r"); break;
+        case '\t': quoted.append("\\t"); break;
+        default:
+          if (c < 0x20) {
+            quoted.append(String.format("\\u%04x", (int) c));
+          } else {
+            quoted.append(c);
+          }
+      }
+    }
+    return quoted.append('"').toString();
+  }
+}
@@ -0,0 +1,165 @@
+"""Renders tierceron templates through libzeroconfig using ctypes.
+
+Build the library and its header with `make xlib` (nc.so and nc.h) or:
+
+    go build -buildmode=c-shared -o libzeroconfig.so ./zeroconfiglib
+
+Usage:
+
+    python3 zeroconfig.py --lib ./libzeroconfig.so --address https://vault:8200 \
+        --token $VAULT_TOKEN --env dev --project Project --service Service \
+        trc_templates/Project/Service/config.yml.tmpl [more templates...]
+
+Each result is printed as a json line with code, error and data.  With
+--watch N the first template is watched and the first N renders are printed
+as they arrive (the initial render, then one per change).
+"""
+
+import argparse
+import ctypes
+import json
+import sys
+import threading
+
+ZC_API_VERSION = 1
+ZC_OK = 0
+
+
+class ZcConfig(ctypes.Structure):
+    _fields_ = [
+        ("token", ctypes.c_char_p),
+        ("address", ctypes.c_char_p),
+        ("env", ctypes.c_char_p),
+        ("insecure", ctypes.c_int),
+    ]
+
+
+class ZcRequest(ctypes.Structure):
+    _fields_ = [
+        ("templatePath", ctypes.c_char_p),
+        ("project", ctypes.c_char_p),
+        ("service", ctypes.c_char_p),
+        ("wantCert", ctypes.c_int),
+    ]
+
+
+class ZcResult(ctypes.Structure):
+    _fields_ = [
+        ("code", ctypes.c_int),
+        ("error", ctypes.c_void_p),
+        ("data", ctypes.c_void_p),
+        ("length", ctypes.c_size_t),
+    ]
+
+
+class ZcWatchOptions(ctypes.Structure):
+    _fields_ = [
+        ("intervalMs", ctypes.c_int),
+        ("debounceMs", ctypes.c_int),
+    ]
+
+
+ZcWatchCallback = ctypes.CFUNCTYPE(None, ctypes.c_longlong, ctypes.POINTER(ZcResult), ctypes.c_void_p)
+
+
+def _result(result):
+    error = ctypes.string_at(result.error).decode() if result.error else None
+    data = ctypes.string_at(result.data, result.length) if result.data else None
+    return result.code, error, data
+
+
+class ZeroConfig:
+    def __init__(self, path):
+        self.lib = ctypes.CDLL(path)
+        self.lib.ZcVersion.restype = ctypes.c_int
+        self.lib.ZcConfigTemplates.argtypes = [ctypes.POINTER(ZcConfig), ctypes.POINTER(ZcRequest), ctypes.c_int]
+        self.lib.ZcConfigTemplates.restype = ctypes.POINTER(ZcResult)
+        self.lib.ZcFree.argtypes = [ctypes.POINTER(ZcResult), ctypes.c_int]
+        self.lib.ZcFree.restype = None
+        self.lib.ZcWatch.argtypes = [ctypes.POINTER(ZcConfig), ctypes.POINTER(ZcRequest), ctypes.POINTER(ZcWatchOptions),
+                                     ZcWatchCallback, ctypes.c_void_p]
+        self.lib.ZcWatch.restype = ctypes.c_longlong
+        self.lib.ZcUnwatch.argtypes = [ctypes.c_longlong]
+        self.lib.ZcUnwatch.restype = ctypes.c_int
+        self._callbacks = {}
+        version = self.lib.ZcVersion()
+        if version != ZC_API_VERSION:
+            raise RuntimeError("unsupported zeroconfig api version %d" % version)
+
+    def render(self, token, address, env, project, service, templates, want_cert=False, insecure=False):
+        """Renders templates in one vault session, returning (code, error, bytes) per template."""
+        config = ZcConfig(token.encode(), address.encode(), env.encode(), int(insecure))
+        requests = (ZcRequest * len(templates))(
+            *[ZcRequest(t.encode(), project.encode(), service.encode(), int(want_cert)) for t in templates])
+        results = self.lib.ZcConfigTemplates(ctypes.byref(config), requests, len(templates))
+        try:
+            return [_result(results[i]) for i in range(len(templates))]
+        finally:
+            self.lib.ZcFree(results, len(templates))
+
+    def watch(self, token, address, env, project, service, template, callback, interval_ms=0, debounce_ms=0,
+              want_cert=False, insecure=False):
+        """Calls callback(code, error, bytes) with each render of template.  On failure
+        bytes is the last good render.  Returns a watch id for unwatch."""
+        config = ZcConfig(token.encode(), address.encode(), env.encode(), int(insecure))
+        request = ZcRequest(template.encode(), project.encode(), service.encode(), int(want_cert))
+        options = ZcWatchOptions(interval_ms, debounce_ms)
+        native_callback = ZcWatchCallback(lambda watch_id, result, user_data: callback(*_result(result.contents)))
+        watch_id = self.lib.ZcWatch(ctypes.byref(config), ctypes.byref(request), ctypes.byref(options),
+                                    native_callback, None)
+        if watch_id < 0:
+            raise RuntimeError("ZcWatch failed with code %d" % -watch_id)
+        # The callback must outlive the watch.
+        self._callbacks[watch_id] = native_callback
+        return watch_id
+
+    def unwatch(self, watch_id):
+        self.lib.ZcUnwatch(watch_id)
+        self._callbacks.pop(watch_id, None)
+
+
+def main():
+    parser = argparse.ArgumentParser(description=__doc__.splitlines()[0])
+    parser.add_argument("--lib", default="./libzeroconfig.so")
+    parser.add_argument("--address", required=True)
+    parser.add_argument("--token", required=True)
+    parser.add_argument("--env", required=True)
+    parser.add_argument("--project", required=True)
+    parser.add_argument("--service", required=True)
+    parser.add_argument("--cert", action="store_true", help="templates describe certs")
+    parser.add_argument("--insecure", action="store_true", help="skip tls verification (local vaults only)")
+    parser.add_argument("--watch", type=int, default=0, metavar="N", help="print the first N renders of the first template")
+    parser.add_argument("--interval-ms", type=int, default=0)
+    parser.add_argument("--debounce-ms", type=int, default=0)
+    parser.add_argument("templates", nargs="+")
+    args = parser.parse_args()
+
+    zc = ZeroConfig(args.lib)
+    if args.watch > 0:
+        renders = []
+        done = threading.Event()
+
+        def on_render(code, error, data):
+            print(json.dumps({"code": code, "error": error,
+                              "data": data.decode("utf-8", "replace") if data is not None else None}), flush=True)
+            renders.append(code)
+            if len(renders) >= args.watch:
+                done.set()
+
+        watch_id = zc.watch(args.token, args.address, args.env, args.project, args.service, args.templates[0],
+                            on_render, args.interval_ms, args.debounce_ms, args.cert, args.insecure)
+        done.wait()
+        zc.unwatch(watch_id)
+        sys.exit(0 if all(code == ZC_OK for code in renders) else 1)
+
+    failed = False
+    for code, error, data in zc.render(args.token, args.address, args.env, args.project, args.service,
+                                       args.templates, args.cert, args.insecure):
+        failed = failed or code != ZC_OK
+        print(json.dumps({"code": code, "error": error,
+                          "data": data.decode("utf-8", "replace") if data is not None else None}))
+    sys.exit(1 if failed else 0)
+
+
+if __name__ == "__main__":
+    main()
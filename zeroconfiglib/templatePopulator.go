@@ -1,15 +1,344 @@
 package main
 
+/*
+#include <stdlib.h>
+#include <string.h>
+
+// Version of the zeroconfig C API.  Bumped on any incompatible change.
+#define ZC_API_VERSION 1
+
+// Result codes.
+#define ZC_OK                    0
+#define ZC_ERR_INVALID_ARGUMENT  1
+#define ZC_ERR_CONNECT           2
+#define ZC_ERR_TLS               3
+#define ZC_ERR_RENDER            4
+#define ZC_ERR_INTERNAL          5
+
+// ZcConfig identifies the vault to render from.  TLS certificates are
+// verified unless insecure is non zero (honored for local addresses only).
+typedef struct {
+	const char* token;
+	const char* address;
+	const char* env;
+	int insecure;
+} ZcConfig;
+
+// ZcRequest is one template to render.  When wantCert is non zero the
+// template describes a cert and the cert bytes are returned.
+typedef struct {
+	const char* templatePath;
+	const char* project;
+	const char* service;
+	int wantCert;
+} ZcRequest;
+
+// ZcResult is owned by the caller and must be released with ZcFree.  data is
+// NUL terminated for convenience but may contain NUL bytes (certs); use length.
+typedef struct {
+	int code;
+	char* error;
+	char* data;
+	size_t length;
+} ZcResult;
+
+// ZcWatchOptions controls polling for ZcWatch.  Zero values use the defaults
+// (30 second interval, no debounce).
+typedef struct {
+	int intervalMs;
+	int debounceMs;
+} ZcWatchOptions;
+
+// ZcWatchCallback receives each render of a watched template.  result is
+// owned by the library and only valid during the call.  On failure code is
+// set and data holds the last good render, if any.
+typedef void (*ZcWatchCallback)(long long watchId, const ZcResult* result, void* userData);
+
+static inline void zcInvokeWatchCallback(ZcWatchCallback callback, long long watchId, const ZcResult* result, void* userData) {
+	callback(watchId, result, userData);
+}
+*/
+import "C"
+
 import (
-	"C"
-)
-import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
 
+	"github.com/trimble-oss/tierceron/buildopts"
+	"github.com/trimble-oss/tierceron/buildopts/coreopts"
+	"github.com/trimble-oss/tierceron/buildopts/deployopts"
+	"github.com/trimble-oss/tierceron/buildopts/memonly"
+	"github.com/trimble-oss/tierceron/buildopts/memprotectopts"
+	"github.com/trimble-oss/tierceron/buildopts/tcopts"
+	"github.com/trimble-oss/tierceron/buildopts/xencryptopts"
 	"github.com/trimble-oss/tierceron/zeroconfiglib/zccommon"
 )
 
+// main never runs in a shared library, so options are loaded on init.
+func init() {
+	if memonly.IsMemonly() {
+		memprotectopts.MemProtectInit(nil)
+	}
+	buildopts.NewOptionsBuilder(buildopts.LoadOptions())
+	coreopts.NewOptionsBuilder(coreopts.LoadOptions())
+	deployopts.NewOptionsBuilder(deployopts.LoadOptions())
+	tcopts.NewOptionsBuilder(tcopts.LoadOptions())
+	xencryptopts.NewOptionsBuilder(xencryptopts.LoadOptions())
+}
+
+func goString(s *C.char) string {
+	if s == nil {
+		return ""
+	}
+	return C.GoString(s)
+}
+
+func sessionConfig(config *C.ZcConfig) zccommon.SessionConfig {
+	return zccommon.SessionConfig{
+		Token:    goString(config.token),
+		Address:  goString(config.address),
+		Env:      goString(config.env),
+		Insecure: config.insecure != 0,
+	}
+}
+
+func templateRequest(request *C.ZcRequest) zccommon.TemplateRequest {
+	return zccommon.TemplateRequest{
+		TemplatePath: goString(request.templatePath),
+		Project:      goString(request.project),
+		Service:      goString(request.service),
+		WantCert:     request.wantCert != 0,
+	}
+}
+
+var errInternal = errors.New("internal error")
+
+// errorCode classifies err for callers that cannot inspect go errors.
+func errorCode(err error, connecting bool) C.int {
+	if errors.Is(err, zccommon.ErrInvalidArgument) {
+		return C.ZC_ERR_INVALID_ARGUMENT
+	}
+	if errors.Is(err, errInternal) {
+		return C.ZC_ERR_INTERNAL
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) ||
+		errors.As(err, &verification) || strings.Contains(err.Error(), "x509:") {
+		return C.ZC_ERR_TLS
+	}
+	if connecting {
+		return C.ZC_ERR_CONNECT
+	}
+	return C.ZC_ERR_RENDER
+}
+
+// setResult fills result, copying data into C memory.
+func setResult(result *C.ZcResult, data []byte, code C.int, err error) {
+	result.code = code
+	result.error = nil
+	result.data = nil
+	result.length = 0
+	if err != nil {
+		result.error = C.CString(err.Error())
+		return
+	}
+	setData(result, data)
+}
+
+func setData(result *C.ZcResult, data []byte) {
+	result.data = (*C.char)(C.malloc(C.size_t(len(data) + 1)))
+	if len(data) > 0 {
+		C.memcpy(unsafe.Pointer(result.data), unsafe.Pointer(&data[0]), C.size_t(len(data)))
+	}
+	*(*C.char)(unsafe.Add(unsafe.Pointer(result.data), len(data))) = 0
+	result.length = C.size_t(len(data))
+}
+
+func allocResults(count int) []C.ZcResult {
+	results := (*C.ZcResult)(C.calloc(C.size_t(count), C.size_t(unsafe.Sizeof(C.ZcResult{}))))
+	return unsafe.Slice(results, count)
+}
+
+// renderAll renders every request in one vault session.
+func renderAll(config *C.ZcConfig, requests []zccommon.TemplateRequest, results []C.ZcResult) {
+	if config == nil {
+		for i := range results {
+			setResult(&results[i], nil, C.ZC_ERR_INVALID_ARGUMENT, errors.New("missing config"))
+		}
+		return
+	}
+	var session *zccommon.Session
+	err := recoverInternal(func() (err error) {
+		session, err = zccommon.NewSession(sessionConfig(config))
+		return err
+	})
+	if err != nil {
+		for i := range results {
+			setResult(&results[i], nil, errorCode(err, true), err)
+		}
+		return
+	}
+	defer session.Close()
+
+	for i, request := range requests {
+		var rendered []byte
+		err := recoverInternal(func() (err error) {
+			rendered, err = session.Render(request)
+			return err
+		})
+		if err != nil {
+			setResult(&results[i], nil, errorCode(err, false), err)
+			continue
+		}
+		setResult(&results[i], rendered, C.ZC_OK, nil)
+	}
+}
+
+// recoverInternal reports panics as errInternal rather than taking down the
+// host process.
+func recoverInternal(call func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", errInternal, r)
+		}
+	}()
+	return call()
+}
+
+// ZcVersion Returns ZC_API_VERSION of the library.
+//
+//export ZcVersion
+func ZcVersion() C.int {
+	return C.ZC_API_VERSION
+}
+
+// ZcConfigTemplate Renders a single template.  Release the result with ZcFree(result, 1).
+//
+//export ZcConfigTemplate
+func ZcConfigTemplate(config *C.ZcConfig, request *C.ZcRequest) *C.ZcResult {
+	results := allocResults(1)
+	if request == nil {
+		setResult(&results[0], nil, C.ZC_ERR_INVALID_ARGUMENT, errors.New("missing request"))
+		return &results[0]
+	}
+	renderAll(config, []zccommon.TemplateRequest{templateRequest(request)}, results)
+	return &results[0]
+}
+
+// ZcConfigTemplates Renders count templates in one vault session, returning
+// count results in request order.  Release them with ZcFree(results, count).
+//
+//export ZcConfigTemplates
+func ZcConfigTemplates(config *C.ZcConfig, requests *C.ZcRequest, count C.int) *C.ZcResult {
+	if count <= 0 {
+		return nil
+	}
+	results := allocResults(int(count))
+	if requests == nil {
+		for i := range results {
+			setResult(&results[i], nil, C.ZC_ERR_INVALID_ARGUMENT, errors.New("missing requests"))
+		}
+		return &results[0]
+	}
+	templateRequests := []zccommon.TemplateRequest{}
+	for _, request := range unsafe.Slice(requests, int(count)) {
+		templateRequests = append(templateRequests, templateRequest(&request))
+	}
+	renderAll(config, templateRequests, results)
+	return &results[0]
+}
+
+// ZcFree Releases count results returned by this library.
+//
+//export ZcFree
+func ZcFree(results *C.ZcResult, count C.int) {
+	if results == nil {
+		return
+	}
+	for _, result := range unsafe.Slice(results, int(count)) {
+		C.free(unsafe.Pointer(result.error))
+		C.free(unsafe.Pointer(result.data))
+	}
+	C.free(unsafe.Pointer(results))
+}
+
+var watches = struct {
+	sync.Mutex
+	nextId   int64
+	watchers map[int64]*zccommon.Watcher
+}{watchers: map[int64]*zccommon.Watcher{}}
+
+// ZcWatch Renders a template and calls callback with it, then again whenever
+// a value it depends on changes.  Returns a watch id for ZcUnwatch, or a
+// negative ZC_ERR code.
+//
+//export ZcWatch
+func ZcWatch(config *C.ZcConfig, request *C.ZcRequest, options *C.ZcWatchOptions, callback C.ZcWatchCallback, userData unsafe.Pointer) C.longlong {
+	if config == nil || request == nil || callback == nil {
+		return -C.ZC_ERR_INVALID_ARGUMENT
+	}
+	watchOptions := zccommon.WatchOptions{}
+	if options != nil {
+		watchOptions.Interval = time.Duration(options.intervalMs) * time.Millisecond
+		watchOptions.Debounce = time.Duration(options.debounceMs) * time.Millisecond
+	}
+
+	watches.Lock()
+	defer watches.Unlock()
+	watches.nextId++
+	watchId := watches.nextId
+	watcher, err := zccommon.Watch(sessionConfig(config), templateRequest(request), watchOptions, func(rendered []byte, err error) {
+		results := allocResults(1)
+		defer ZcFree(&results[0], 1)
+		if err != nil {
+			setResult(&results[0], nil, errorCode(err, false), err)
+			if rendered != nil {
+				setData(&results[0], rendered)
+			}
+		} else {
+			setResult(&results[0], rendered, C.ZC_OK, nil)
+		}
+		C.zcInvokeWatchCallback(callback, C.longlong(watchId), &results[0], userData)
+	})
+	if err != nil {
+		return -C.longlong(errorCode(err, false))
+	}
+	watches.watchers[watchId] = watcher
+	return C.longlong(watchId)
+}
+
+// ZcUnwatch Stops a watch, waiting for any callback in progress.  Must not be
+// called from that watch's callback.
+//
+//export ZcUnwatch
+func ZcUnwatch(watchId C.longlong) C.int {
+	watches.Lock()
+	watcher, ok := watches.watchers[int64(watchId)]
+	delete(watches.watchers, int64(watchId))
+	watches.Unlock()
+	if !ok {
+		return C.ZC_ERR_INVALID_ARGUMENT
+	}
+	watcher.Stop()
+	return C.ZC_OK
+}
+
+// ConfigTemplateLib renders a template, returning "" on any error.  The
+// returned string must be released with free().
+//
+// Deprecated: use ZcConfigTemplate.
+//
 //export ConfigTemplateLib
 func ConfigTemplateLib(token string, address string, env string, templatePath string, configuredFilePath string, project string, service string) *C.char {
 	logger := log.New(os.Stdout, "[ConfigTemplateLib]", log.LstdFlags)
@@ -29,11 +358,16 @@ func ConfigTemplateLib(token string, address string, env string, templatePath st
 	return C.CString(configuredTemplate)
 }
 
+// ConfigCertLib renders a base64 encoded cert, returning "" on any error.
+// The returned string must be released with free().
+//
+// Deprecated: use ZcConfigTemplate with wantCert set.
+//
 //export ConfigCertLib
 func ConfigCertLib(token string, address string, env string, templatePath string, configuredFilePath string, project string, service string) *C.char {
 	logger := log.New(os.Stdout, "[ConfigCertLib]", log.LstdFlags)
 	logger.Println("NCLib Version: " + "1.21")
-	certBase64, _, err := zccommon.ConfigCertLibHelper(token,
+	_, certBase64, err := zccommon.ConfigCertLibHelper(token,
 		address,
 		env,
 		templatePath,
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trimble-oss/tierceron/zeroconfiglib/zccommon"
+)
+
+type exampleResult struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+	Data  string `json:"data"`
+}
+
+// fakeVault serves kv v2 reads and lists.  Each set bumps a secret's version.
+type fakeVault struct {
+	*httptest.Server
+	mu       sync.Mutex
+	secrets  map[string]map[string]interface{}
+	versions map[string]int
+	failing  bool
+}
+
+func newFakeVault(secrets map[string]map[string]interface{}) *fakeVault {
+	vault := &fakeVault{secrets: secrets, versions: map[string]int{}}
+	vault.Server = httptest.NewTLSServer(http.HandlerFunc(vault.serve))
+	return vault
+}
+
+func (vault *fakeVault) set(secretPath string, data map[string]interface{}) {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	vault.secrets[secretPath] = data
+	vault.versions[secretPath]++
+}
+
+func (vault *fakeVault) fail(failing bool) {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	vault.failing = failing
+}
+
+func (vault *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	vault.mu.Lock()
+	defer vault.mu.Unlock()
+	if vault.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	// /v1/<mount>/(data|metadata)/<path>
+	mount, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+	_, secretPath, _ := strings.Cut(rest, "/")
+	secretPath = strings.TrimSuffix(mount+"/"+secretPath, "/")
+
+	if r.Method == "LIST" || r.URL.Query().Get("list") == "true" {
+		children := map[string]bool{}
+		for key := range vault.secrets {
+			if child, ok := strings.CutPrefix(key, secretPath+"/"); ok {
+				if i := strings.Index(child, "/"); i >= 0 {
+					child = child[:i+1]
+				}
+				children[child] = true
+			}
+		}
+		if len(children) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		keys := []string{}
+		for child := range children {
+			keys = append(keys, child)
+		}
+		sort.Strings(keys)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+		return
+	}
+	data, ok := vault.secrets[secretPath]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+		"data":     data,
+		"metadata": map[string]interface{}{"version": vault.versions[secretPath] + 1, "created_time": "2024-01-01T00:00:00Z"},
+	}})
+}
+
+func billingVault() *fakeVault {
+	return newFakeVault(map[string]map[string]interface{}{
+		"templates/Billing/Invoice/app/template-file":  {"data": base64.StdEncoding.EncodeToString([]byte("host: {{.host}}\n"))},
+		"templates/Billing/Invoice/app":                {"host": []interface{}{"values/Billing/Invoice/app", "host"}},
+		"templates/Billing/Invoice/port/template-file": {"data": base64.StdEncoding.EncodeToString([]byte("port={{.port}}"))},
+		"templates/Billing/Invoice/port":               {"port": []interface{}{"values/Billing/Invoice/port", "port"}},
+		"values/dev/Billing/Invoice/app":               {"host": "db.example.com"},
+		"values/dev/Billing/Invoice/port":              {"port": "5432"},
+	})
+}
+
+// buildLibrary builds libzeroconfig and its header.
+func buildLibrary(t *testing.T) string {
+	if testing.Short() {
+		t.Skip("skipping shared library build in short mode")
+	}
+	if _, err := exec.LookPath("cc"); err != nil {
+		t.Skip("no c compiler for cgo")
+	}
+	libDir := t.TempDir()
+	lib := filepath.Join(libDir, "libzeroconfig.so")
+	build := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "build", "-buildmode=c-shared", "-o", lib, ".")
+	build.Env = append(os.Environ(), "CGO_ENABLED=1")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building library: %v\n%s", err, out)
+	}
+	header, err := os.ReadFile(filepath.Join(libDir, "libzeroconfig.h"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, declaration := range []string{
+		"#define ZC_API_VERSION 1",
+		"extern int ZcVersion(void);",
+		"extern ZcResult* ZcConfigTemplate(ZcConfig* config, ZcRequest* request);",
+		"extern ZcResult* ZcConfigTemplates(ZcConfig* config, ZcRequest* requests, int count);",
+		"extern void ZcFree(ZcResult* results, int count);",
+		"extern long long int ZcWatch(ZcConfig* config, ZcRequest* request, ZcWatchOptions* options, ZcWatchCallback callback, void* userData);",
+		"extern int ZcUnwatch(long long int watchId);",
+	} {
+		if !bytes.Contains(header, []byte(declaration)) {
+			t.Errorf("header missing %q", declaration)
+		}
+	}
+	return lib
+}
+
+func runExample(t *testing.T, command *exec.Cmd) []exampleResult {
+	out, err := command.Output()
+	if _, exitErr := err.(*exec.ExitError); err != nil && !exitErr {
+		t.Fatal(err)
+	}
+	results := []exampleResult{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		result := exampleResult{}
+		if json.Unmarshal(scanner.Bytes(), &result) == nil {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+func TestExamples(t *testing.T) {
+	lib := buildLibrary(t)
+	vault := billingVault()
+	defer vault.Close()
+	templates := []string{"trc_templates/Billing/Invoice/app.yml.tmpl", "trc_templates/Billing/Invoice/port.properties.tmpl"}
+
+	type example struct {
+		name    string
+		command func(insecure bool, token string) *exec.Cmd
+		watch   func(renders int) *exec.Cmd
+	}
+	examples := []example{}
+	if python, err := exec.LookPath("python3"); err == nil {
+		examples = append(examples, example{"python", func(insecure bool, token string) *exec.Cmd {
+			args := []string{"examples/zeroconfig.py", "--lib", lib, "--address", vault.URL, "--token", token, "--env", "dev", "--project", "Billing", "--service", "Invoice"}
+			if insecure {
+				args = append(args, "--insecure")
+			}
+			return exec.Command(python, append(args, templates...)...)
+		}, func(renders int) *exec.Cmd {
+			return exec.Command(python, "examples/zeroconfig.py", "--lib", lib, "--address", vault.URL, "--token", "token", "--env", "dev",
+				"--project", "Billing", "--service", "Invoice", "--insecure", "--interval-ms", "20", "--watch", strconv.Itoa(renders), templates[1])
+		}})
+	}
+	if java, err := exec.LookPath("java"); err == nil && len(os.Getenv("JNA_JAR")) > 0 {
+		examples = append(examples, example{"jna", func(insecure bool, token string) *exec.Cmd {
+			command := exec.Command(java, append([]string{"-cp", os.Getenv("JNA_JAR"), "examples/Client.java", lib, vault.URL, token, "dev", "Billing", "Invoice"}, templates...)...)
+			command.Env = os.Environ()
+			if insecure {
+				command.Env = append(command.Env, "ZC_INSECURE=1")
+			}
+			return command
+		}, func(renders int) *exec.Cmd {
+			command := exec.Command(java, "-cp", os.Getenv("JNA_JAR"), "examples/Client.java", lib, vault.URL, "token", "dev", "Billing", "Invoice", templates[1])
+			command.Env = append(os.Environ(), "ZC_INSECURE=1", "ZC_INTERVAL_MS=20", "ZC_WATCH="+strconv.Itoa(renders))
+			return command
+		}})
+	}
+	if len(examples) == 0 {
+		t.Skip("neither python3 nor java with JNA_JAR available")
+	}
+
+	for _, ex := range examples {
+		t.Run(ex.name, func(t *testing.T) {
+			// The fake vault's certificate is self signed, so only an explicit
+			// insecure request against the local address may render.
+			results := runExample(t, ex.command(true, "token"))
+			if len(results) != 2 || results[0].Code != 0 || results[0].Data != "host: db.example.com\n" ||
+				results[1].Code != 0 || results[1].Data != "port=5432" {
+				t.Fatalf("unexpected batch results %+v", results)
+			}
+
+			results = runExample(t, ex.command(false, "token"))
+			if len(results) != 2 || results[0].Code != 3 || !strings.Contains(results[0].Error, "x509") {
+				t.Fatalf("expected tls verification failure, got %+v", results)
+			}
+
+			results = runExample(t, ex.command(true, ""))
+			if len(results) != 2 || results[1].Code != 1 || !strings.Contains(results[1].Error, "missing token") {
+				t.Fatalf("expected invalid argument, got %+v", results)
+			}
+
+			// A service watching its config sees the rotated value without restarting.
+			watch := ex.watch(2)
+			stdout, err := watch.StdoutPipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := watch.Start(); err != nil {
+				t.Fatal(err)
+			}
+			renders := make(chan exampleResult)
+			go func() {
+				scanner := bufio.NewScanner(stdout)
+				for scanner.Scan() {
+					result := exampleResult{}
+					if json.Unmarshal(scanner.Bytes(), &result) == nil {
+						renders <- result
+					}
+				}
+				close(renders)
+			}()
+			if render := <-renders; render.Code != 0 || render.Data != "port=5432" {
+				t.Fatalf("unexpected initial render %+v", render)
+			}
+			vault.set("values/dev/Billing/Invoice/port", map[string]interface{}{"port": "6432"})
+			select {
+			case render := <-renders:
+				if render.Code != 0 || render.Data != "port=6432" {
+					t.Fatalf("unexpected watched render %+v", render)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for watched render")
+			}
+			if err := watch.Wait(); err != nil {
+				t.Fatal(err)
+			}
+			vault.set("values/dev/Billing/Invoice/port", map[string]interface{}{"port": "5432"})
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	vault := billingVault()
+	defer vault.Close()
+
+	type render struct {
+		data string
+		err  error
+	}
+	renders := make(chan render, 10)
+	watcher, err := zccommon.Watch(zccommon.SessionConfig{Token: "token", Address: vault.URL, Env: "dev", Insecure: true},
+		zccommon.TemplateRequest{TemplatePath: "trc_templates/Billing/Invoice/app.yml.tmpl", Project: "Billing", Service: "Invoice"},
+		zccommon.WatchOptions{Interval: 10 * time.Millisecond, Debounce: 150 * time.Millisecond},
+		func(rendered []byte, err error) { renders <- render{string(rendered), err} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+	next := func() render {
+		select {
+		case r := <-renders:
+			return r
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for render")
+		}
+		return render{}
+	}
+
+	if r := next(); r.err != nil || r.data != "host: db.example.com\n" {
+		t.Fatalf("unexpected initial render %+v", r)
+	}
+
+	// A rotation writing twice in quick succession is rendered once.
+	vault.set("values/dev/Billing/Invoice/app", map[string]interface{}{"host": "db2.example.com"})
+	time.Sleep(30 * time.Millisecond)
+	vault.set("values/dev/Billing/Invoice/app", map[string]interface{}{"host": "db3.example.com"})
+	if r := next(); r.err != nil || r.data != "host: db3.example.com\n" {
+		t.Fatalf("unexpected debounced render %+v", r)
+	}
+
+	// Vault failures are reported once, with the last good config.
+	vault.fail(true)
+	if r := next(); r.err == nil || r.data != "host: db3.example.com\n" {
+		t.Fatalf("expected failure with last good config, got %+v", r)
+	}
+	time.Sleep(50 * time.Millisecond)
+	vault.fail(false)
+	vault.set("values/dev/Billing/Invoice/app", map[string]interface{}{"host": "db4.example.com"})
+	if r := next(); r.err != nil || r.data != "host: db4.example.com\n" {
+		t.Fatalf("unexpected render after recovery %+v", r)
+	}
+	select {
+	case r := <-renders:
+		t.Fatalf("unexpected extra render %+v", r)
+	default:
+	}
+}
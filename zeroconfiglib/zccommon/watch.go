@@ -0,0 +1,231 @@
+package zccommon
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_WATCH_INTERVAL How often watched templates poll vault metadata.
+const DEFAULT_WATCH_INTERVAL = 30 * time.Second
+
+// WatchOptions controls polling.  A change is only rendered once the
+// dependencies have been quiet for Debounce, so a burst of writes during a
+// credential rotation produces a single callback.
+type WatchOptions struct {
+	Interval time.Duration
+	Debounce time.Duration
+}
+
+// WatchCallback receives a newly rendered template.  When rendering or
+// polling fails err is set and rendered is the last good render (nil if there
+// has not been one), so callers can keep running on it.
+type WatchCallback func(rendered []byte, err error)
+
+// Watcher re-renders a template whenever a value it depends on changes.
+type Watcher struct {
+	sessionConfig SessionConfig
+	request       TemplateRequest
+	options       WatchOptions
+	callback      WatchCallback
+	stop          chan struct{}
+	done          chan struct{}
+	stopOnce      sync.Once
+}
+
+// Watch renders request and then polls the kv metadata of the template and
+// every value it references, calling callback with the initial render and
+// each changed render.  Callbacks are made from a single goroutine.
+func Watch(sessionConfig SessionConfig, request TemplateRequest, options WatchOptions, callback WatchCallback) (*Watcher, error) {
+	if err := sessionConfig.validate(); err != nil {
+		return nil, err
+	}
+	if err := request.validate(); err != nil {
+		return nil, err
+	}
+	if callback == nil {
+		return nil, fmt.Errorf("%w: missing callback", ErrInvalidArgument)
+	}
+	if options.Interval <= 0 {
+		options.Interval = DEFAULT_WATCH_INTERVAL
+	}
+	w := &Watcher{
+		sessionConfig: sessionConfig,
+		request:       request,
+		options:       options,
+		callback:      callback,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Stop ends the watch and waits for any callback in progress.  It must not be
+// called from the watch's own callback.
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	var session *Session
+	defer func() {
+		if session != nil {
+			session.Close()
+		}
+	}()
+
+	var lastGood []byte
+	var rendered map[string]string // fingerprint of the last good render
+	var pending map[string]string  // fingerprint waiting out the debounce
+	var pendingSince time.Time
+	failing := false
+	fail := func(err error) {
+		// Report a failure once rather than on every poll.
+		if !failing {
+			failing = true
+			w.callback(lastGood, err)
+		}
+	}
+
+	for {
+		if session == nil {
+			var err error
+			if session, err = NewSession(w.sessionConfig); err != nil {
+				session = nil
+				fail(err)
+			}
+		}
+		if session != nil {
+			var current map[string]string
+			err := guard(func() (err error) {
+				current, err = session.fingerprint(w.request)
+				return err
+			})
+			if err != nil {
+				session.Close()
+				session = nil
+				fail(err)
+			} else if rendered == nil || !sameFingerprint(current, rendered) {
+				if pending == nil || !sameFingerprint(current, pending) {
+					pending = current
+					pendingSince = time.Now()
+				}
+				if rendered == nil || time.Since(pendingSince) >= w.options.Debounce {
+					var configured []byte
+					err := guard(func() (err error) {
+						configured, err = session.Render(w.request)
+						return err
+					})
+					if err != nil {
+						fail(err)
+					} else {
+						changed := lastGood == nil || !bytes.Equal(configured, lastGood)
+						lastGood = configured
+						rendered = current
+						pending = nil
+						failing = false
+						if changed {
+							w.callback(configured, nil)
+						}
+					}
+				}
+			} else {
+				failing = false
+			}
+		}
+
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(w.options.Interval):
+		}
+	}
+}
+
+// guard turns a panic in call into an error so a watch never takes down the
+// process hosting the library.
+func guard(call func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	return call()
+}
+
+// templateVaultPath maps trc_templates/Project/Service/file.ext.tmpl to the
+// vault path holding its template and value references.
+func templateVaultPath(templatePath string) string {
+	if i := strings.Index(templatePath, "_templates/"); i >= 0 {
+		templatePath = templatePath[i+len("_templates/"):]
+	}
+	templatePath = strings.TrimSuffix(templatePath, ".tmpl")
+	if strings.HasSuffix(templatePath, ".yml") {
+		templatePath = strings.TrimSuffix(templatePath, ".yml")
+	} else if i := strings.LastIndex(templatePath, "."); i > strings.LastIndex(templatePath, "/") {
+		templatePath = templatePath[:i]
+	}
+	return "templates/" + templatePath
+}
+
+// dependencies lists the template and every value path it references.
+func (s *Session) dependencies(request TemplateRequest) ([]string, error) {
+	templatePath := templateVaultPath(request.TemplatePath)
+	dependencies := []string{templatePath + "/template-file", templatePath}
+	references, err := s.mod.ReadData(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, reference := range references {
+		// Each reference is [valuePath, key].
+		if pathKey, ok := reference.([]interface{}); ok && len(pathKey) > 0 {
+			if valuePath, ok := pathKey[0].(string); ok && !seen[valuePath] {
+				seen[valuePath] = true
+				dependencies = append(dependencies, valuePath)
+			}
+		}
+	}
+	sort.Strings(dependencies[2:])
+	return dependencies, nil
+}
+
+// fingerprint records the current version of every dependency.
+func (s *Session) fingerprint(request TemplateRequest) (map[string]string, error) {
+	dependencies, err := s.dependencies(request)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := map[string]string{}
+	for _, dependency := range dependencies {
+		metadata, err := s.mod.ReadMetadata(dependency, s.logger)
+		if err != nil {
+			// Paths that don't exist (yet) have no metadata.
+			if err.Error() == "could not get metadata from vault response" {
+				fingerprint[dependency] = ""
+				continue
+			}
+			return nil, err
+		}
+		fingerprint[dependency] = fmt.Sprintf("%v@%v", metadata["version"], metadata["created_time"])
+	}
+	return fingerprint, nil
+}
+
+func sameFingerprint(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package zccommon
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -16,6 +17,119 @@ import (
 	"github.com/trimble-oss/tierceron/pkg/utils/config"
 )
 
+// ErrInvalidArgument is wrapped by errors for missing or malformed input.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// SessionConfig identifies the vault templates are rendered from.  TLS
+// certificates are verified unless Insecure is set, which only takes effect
+// for local addresses.
+type SessionConfig struct {
+	Token    string
+	Address  string
+	Env      string
+	Insecure bool
+}
+
+// TemplateRequest is one template (or cert) to render.
+type TemplateRequest struct {
+	TemplatePath string
+	Project      string
+	Service      string
+	WantCert     bool
+}
+
+// Session renders templates over a single vault connection.
+type Session struct {
+	config SessionConfig
+	mod    *helperkv.Modifier
+	logger *log.Logger
+}
+
+func (sessionConfig SessionConfig) validate() error {
+	if len(sessionConfig.Token) == 0 {
+		return fmt.Errorf("%w: missing token", ErrInvalidArgument)
+	}
+	if len(sessionConfig.Address) == 0 {
+		return fmt.Errorf("%w: missing address", ErrInvalidArgument)
+	}
+	if len(sessionConfig.Env) == 0 {
+		return fmt.Errorf("%w: missing env", ErrInvalidArgument)
+	}
+	return nil
+}
+
+func (request TemplateRequest) validate() error {
+	if len(request.TemplatePath) == 0 {
+		return fmt.Errorf("%w: missing templatePath", ErrInvalidArgument)
+	}
+	if len(request.Project) == 0 || len(request.Service) == 0 {
+		return fmt.Errorf("%w: missing project or service", ErrInvalidArgument)
+	}
+	return nil
+}
+
+// NewSession connects to vault.
+func NewSession(sessionConfig SessionConfig) (*Session, error) {
+	if err := sessionConfig.validate(); err != nil {
+		return nil, err
+	}
+	logger := log.New(os.Stdout, "[zeroconfig]", log.LstdFlags)
+	mod, err := helperkv.NewModifier(sessionConfig.Insecure, &sessionConfig.Token, &sessionConfig.Address, sessionConfig.Env, nil, true, logger)
+	if err != nil {
+		return nil, err
+	}
+	mod.Env = sessionConfig.Env
+	return &Session{config: sessionConfig, mod: mod, logger: logger}, nil
+}
+
+// Render renders a template, or for cert requests returns the raw cert bytes.
+func (s *Session) Render(request TemplateRequest) ([]byte, error) {
+	if err := request.validate(); err != nil {
+		return nil, err
+	}
+	driverConfig := &config.DriverConfig{
+		CoreConfig: &core.CoreConfig{
+			WantCerts:  request.WantCert,
+			TokenCache: cache.NewTokenCache(fmt.Sprintf("config_token_%s", s.config.Env), &s.config.Token),
+			Insecure:   s.config.Insecure,
+			Log:        s.logger,
+		},
+
+		ZeroConfig: true,
+		StartDir:   append([]string{}, "trc_templates"),
+	}
+	// Each template sets its own template and section paths on the modifier.
+	s.mod.TemplatePath = ""
+	s.mod.SectionPath = ""
+
+	serviceParts := strings.Split(request.Service, ".")
+	configTemplate, configuredCert, _, err := vcutils.ConfigTemplate(driverConfig, s.mod, request.TemplatePath, true, request.Project, serviceParts[0], request.WantCert, true)
+	if err != nil {
+		eUtils.LogErrorObject(driverConfig.CoreConfig, err, false)
+		return nil, err
+	}
+	if request.WantCert {
+		cert, ok := configuredCert[1]
+		if !ok || len(cert) == 0 {
+			return nil, errors.New("no cert configured for " + request.TemplatePath)
+		}
+		return []byte(cert), nil
+	}
+	return []byte(configTemplate), nil
+}
+
+// Close releases the vault connection.
+func (s *Session) Close() {
+	if s.mod != nil {
+		s.mod.Close()
+		s.mod = nil
+	}
+}
+
+// ConfigCertLibHelper renders a single template, or base64 encoded cert when
+// wantCerts is set.
+//
+// Deprecated: use NewSession and Render.
 func ConfigCertLibHelper(token string,
 	address string,
 	env string,
@@ -24,35 +138,19 @@ func ConfigCertLibHelper(token string,
 	project string,
 	service string,
 	wantCerts bool) (string, string, error) {
-	logger := log.New(os.Stdout, "[configCertLibHelper]", log.LstdFlags)
-	mod, err := helperkv.NewModifier(false, &token, &address, env, nil, true, logger)
-	mod.Env = env
-	driverConfig := &config.DriverConfig{
-		CoreConfig: &core.CoreConfig{
-			WantCerts:  wantCerts,
-			TokenCache: cache.NewTokenCache(fmt.Sprintf("config_token_%s", env), &token),
-			Insecure:   true,
-			Log:        logger,
-		},
-
-		ZeroConfig: true,
-		StartDir:   append([]string{}, "trc_templates"),
-	}
+	session, err := NewSession(SessionConfig{Token: token, Address: address, Env: env})
 	if err != nil {
-		eUtils.LogErrorMessage(driverConfig.CoreConfig, err.Error(), false)
 		return "", "", err
 	}
-	serviceParts := strings.Split(service, ".")
-	configTemplate, configuredCert, _, err := vcutils.ConfigTemplate(driverConfig, mod, templatePath, true, project, serviceParts[0], wantCerts, true)
+	defer session.Close()
+	rendered, err := session.Render(TemplateRequest{TemplatePath: templatePath, Project: project, Service: service, WantCert: wantCerts})
 	if err != nil {
-		eUtils.LogErrorObject(driverConfig.CoreConfig, err, false)
+		return "", "", err
 	}
 
-	mod.Close()
-
 	if wantCerts {
-		return "", base64.StdEncoding.EncodeToString([]byte(configuredCert[1])), err
+		return "", base64.StdEncoding.EncodeToString(rendered), nil
 	} else {
-		return configTemplate, "", err
+		return string(rendered), "", nil
 	}
 }